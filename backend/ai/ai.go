@@ -2,57 +2,111 @@ package ai
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"errors"
 	"os"
+	"strings"
 
 	"github.com/google/generative-ai-go/genai"
 	"github.com/joho/godotenv"
-	"google.golang.org/api/option"
 )
 
-func SendImageFromFile(filePath string) *genai.GenerateContentResponse {
-	if err := godotenv.Load(); err != nil {
-		log.Fatal("Error loading .env")
-	}
+var (
+	ErrNotConfigured = errors.New("GEMINI_API_KEY não configurada")
+	ErrNoImages      = errors.New("nenhuma imagem para analisar")
+)
 
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		log.Fatal("API_KEY not found in .env file")
-	}
+// Provider is the AI service behind fridge scans, recipe generation,
+// substitution suggestions and the cooking assistant.
+type Provider interface {
+	// ScanImages tells which ingredients are in photos of a fridge or
+	// pantry, as the comma separated answer of the model. The answer lists
+	// the ingredients of all the photos together.
+	ScanImages(ctx context.Context, req ScanRequest) (Answer, error)
+	// GenerateRecipe writes a recipe for req, as the JSON text described by
+	// RecipeSchema.
+	GenerateRecipe(ctx context.Context, req RecipeRequest) (Answer, error)
+	// SuggestSubstitutions proposes alternatives to an ingredient, as the
+	// JSON text described by SubstitutionSchema.
+	SuggestSubstitutions(ctx context.Context, req SubstitutionRequest) (Answer, error)
+	// Chat answers the message of req in a conversation about a recipe,
+	// passing the answer to stream as it is written. The Answer holds all
+	// of it.
+	Chat(ctx context.Context, req ChatRequest, stream StreamFunc) (Answer, error)
+}
 
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
-	if err != nil {
-		log.Fatal(err)
-	}
+// Answer is what a model said and which prompt version it was asked with.
+// Prompt is set even when the call fails, so failures count against the
+// version too.
+type Answer struct {
+	Text   string
+	Prompt string
+}
 
-	defer client.Close()
-	model := client.GenerativeModel("gemini-pro-vision")
+// NewFromEnv picks the provider from AI_PROVIDER: "gemini" (the default),
+// which needs GEMINI_API_KEY, or "fake", which answers offline for
+// development and tests. Prompts and model settings come from the
+// environment as well; see modelsFromEnv and promptsFromEnv.
+func NewFromEnv() (Provider, error) {
+	// .env is optional: the settings may come from the environment itself.
+	godotenv.Load()
 
-	img, err := os.ReadFile(filePath)
+	prompts, err := promptsFromEnv()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	prompt := []genai.Part{
-		genai.Text("Descreva oque está dentro dessa geladeira, onde cada item deve ser seguido de vírgulas, assim: leite,laranja,alface. Escreva apenas oque for ingrediente, sempre no singular e em português. Caso não dê para identificar alimentos, responda apenas: Não existem alimentos."),
-		genai.ImageData("jpeg", img),
+	switch os.Getenv("AI_PROVIDER") {
+	case "", "gemini":
+		models, err := modelsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewGemini(prompts, models), nil
+	case "fake":
+		return Fake{Prompts: prompts}, nil
+	default:
+		return nil, errors.New("AI_PROVIDER desconhecido: " + os.Getenv("AI_PROVIDER"))
 	}
+}
 
-	res, err := model.GenerateContent(ctx, prompt...)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return res
+// ScanRequest is a scan of one or more photos.
+type ScanRequest struct {
+	Images []Image
+	// Unit decides the prompt version when an experiment is running, e.g.
+	// the ID of the user asking. The same unit always gets the same one.
+	Unit string
+}
+
+// Image is a photo to scan. Format is its subtype, e.g. "jpeg" or "heic".
+type Image struct {
+	Format string
+	Data   []byte
 }
 
-func PrintResponse(resp *genai.GenerateContentResponse) {
-	for _, cand := range resp.Candidates {
-		if cand.Content != nil {
-			for _, part := range cand.Content.Parts {
-				fmt.Println(part)
-			}
+// noFoodAnswer is what the prompt asks the model to say when it sees no food.
+const noFoodAnswer = "não existem alimentos"
+
+// ParseIngredients turns the answer of ScanImages into ingredient names, in
+// lower case and without repetitions.
+func ParseIngredients(answer string) []string {
+	ingredients := []string{}
+	seen := map[string]bool{}
+	for _, name := range strings.FieldsFunc(answer, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		name = strings.ToLower(strings.Trim(name, " .\t\r*-"))
+		if name == "" || name == noFoodAnswer || seen[name] {
+			continue
 		}
+		seen[name] = true
+		ingredients = append(ingredients, name)
 	}
+	return ingredients
+}
+
+// IsPermanent tells whether retrying a request that failed with err is
+// pointless, e.g. because the content was blocked or no key is set.
+func IsPermanent(err error) bool {
+	var blocked *genai.BlockedError
+	return errors.As(err, &blocked) || errors.Is(err, ErrNotConfigured) || errors.Is(err, ErrNoImages)
 }
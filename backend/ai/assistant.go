@@ -0,0 +1,50 @@
+package ai
+
+import "strings"
+
+// Roles of the messages of a conversation.
+const (
+	ChatUser      = "user"
+	ChatAssistant = "assistant"
+)
+
+// ChatMessage is a turn of a conversation.
+type ChatMessage struct {
+	Role string
+	Text string
+}
+
+// ChatRecipe is the recipe a conversation is about.
+type ChatRecipe struct {
+	Name        string
+	Description string
+	Ingredients []string
+	// Restriction lists the restrictions the recipe does not meet.
+	Restriction []string
+}
+
+// ChatRequest is a question about a recipe, with what the assistant should
+// know to answer it.
+type ChatRequest struct {
+	Recipe       ChatRecipe
+	Pantry       []string
+	Restrictions []string
+	// Substitutions are the known alternatives to the ingredients of the
+	// recipe, one per line, e.g. "manteiga → Óleo (0.75 da quantidade)".
+	Substitutions []string
+	// History holds the previous messages, oldest first.
+	History []ChatMessage
+	Message string
+	// Unit is as in ScanRequest.
+	Unit string
+}
+
+// PlainDescription is the description of the recipe without its HTML line
+// breaks, for the prompt templates.
+func (r ChatRequest) PlainDescription() string {
+	return strings.ReplaceAll(r.Recipe.Description, "<br>", "\n")
+}
+
+// StreamFunc receives the parts of an answer as they arrive. Returning an
+// error stops the answer.
+type StreamFunc func(chunk string) error
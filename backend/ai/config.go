@@ -0,0 +1,152 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// ModelConfig is how a task calls its model.
+type ModelConfig struct {
+	Model string
+	// Temperature is left to the model when nil.
+	Temperature *float32
+	// Safety overrides the block thresholds of the model for some
+	// categories.
+	Safety  []*genai.SafetySetting
+	Timeout time.Duration
+}
+
+var defaultModels = map[string]string{
+	TaskScan:         "gemini-pro-vision",
+	TaskRecipe:       "gemini-pro",
+	TaskSubstitution: "gemini-pro",
+	TaskAssistant:    "gemini-pro",
+}
+
+const defaultModelTimeout = 45 * time.Second
+
+var harmCategories = map[string]genai.HarmCategory{
+	"harassment": genai.HarmCategoryHarassment,
+	"hate":       genai.HarmCategoryHateSpeech,
+	"sexual":     genai.HarmCategorySexuallyExplicit,
+	"dangerous":  genai.HarmCategoryDangerousContent,
+}
+
+var harmThresholds = map[string]genai.HarmBlockThreshold{
+	"none":   genai.HarmBlockNone,
+	"high":   genai.HarmBlockOnlyHigh,
+	"medium": genai.HarmBlockMediumAndAbove,
+	"low":    genai.HarmBlockLowAndAbove,
+}
+
+// taskEnv reads AI_<TASK>_<name>, falling back to AI_<name> when shared is
+// true.
+func taskEnv(task, name string, shared bool) string {
+	if value := os.Getenv("AI_" + strings.ToUpper(task) + "_" + name); value != "" || !shared {
+		return value
+	}
+	return os.Getenv("AI_" + name)
+}
+
+// modelsFromEnv reads the model settings of every task:
+//
+//	AI_<TASK>_MODEL                            model name
+//	AI_<TASK>_TEMPERATURE or AI_TEMPERATURE    0 to 2
+//	AI_<TASK>_SAFETY or AI_SAFETY              e.g. harassment=high,dangerous=medium
+//	AI_<TASK>_TIMEOUT or AI_TIMEOUT            per call, e.g. 30s
+func modelsFromEnv() (map[string]ModelConfig, error) {
+	models := map[string]ModelConfig{}
+	for _, task := range Tasks {
+		config := ModelConfig{
+			Model:   defaultModels[task],
+			Timeout: defaultModelTimeout,
+		}
+		if value := taskEnv(task, "MODEL", false); value != "" {
+			config.Model = value
+		}
+
+		if value := taskEnv(task, "TEMPERATURE", true); value != "" {
+			temperature, err := strconv.ParseFloat(value, 32)
+			if err != nil || temperature < 0 || temperature > 2 {
+				return nil, fmt.Errorf("temperatura inválida para %s: %s", task, value)
+			}
+			t := float32(temperature)
+			config.Temperature = &t
+		}
+
+		if value := taskEnv(task, "SAFETY", true); value != "" {
+			safety, err := parseSafety(value)
+			if err != nil {
+				return nil, fmt.Errorf("segurança inválida para %s: %w", task, err)
+			}
+			config.Safety = safety
+		}
+
+		if value := taskEnv(task, "TIMEOUT", true); value != "" {
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("tempo limite inválido para %s: %s", task, value)
+			}
+			config.Timeout = timeout
+		}
+		models[task] = config
+	}
+	return models, nil
+}
+
+// parseSafety reads category=threshold pairs separated by commas.
+// Categories are harassment, hate, sexual and dangerous; thresholds none,
+// high, medium and low, the lowest probability that gets blocked.
+func parseSafety(value string) ([]*genai.SafetySetting, error) {
+	var safety []*genai.SafetySetting
+	for _, pair := range strings.Split(value, ",") {
+		name, level, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		category, knownCategory := harmCategories[name]
+		threshold, knownThreshold := harmThresholds[level]
+		if !ok || !knownCategory || !knownThreshold {
+			return nil, errors.New("'" + pair + "' não é categoria=limite válido")
+		}
+		safety = append(safety, &genai.SafetySetting{Category: category, Threshold: threshold})
+	}
+	return safety, nil
+}
+
+// promptsFromEnv loads the prompts from AI_PROMPTS_DIR and picks the
+// versions:
+//
+//	AI_<TASK>_PROMPT        active version, v1 by default
+//	AI_<TASK>_EXPERIMENT    <version>:<percent> of the users that get it
+func promptsFromEnv() (*Prompts, error) {
+	prompts, err := LoadPrompts(os.Getenv("AI_PROMPTS_DIR"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, task := range Tasks {
+		version := taskEnv(task, "PROMPT", false)
+		if version == "" {
+			version = defaultPromptVersion
+		}
+
+		var experiment *Experiment
+		if value := taskEnv(task, "EXPERIMENT", false); value != "" {
+			variant, share, ok := strings.Cut(value, ":")
+			percent, err := strconv.Atoi(share)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("experimento inválido para %s: %s", task, value)
+			}
+			experiment = &Experiment{Variant: variant, Share: percent}
+		}
+
+		if err := prompts.Use(task, version, experiment); err != nil {
+			return nil, err
+		}
+	}
+	return prompts, nil
+}
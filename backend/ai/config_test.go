@@ -0,0 +1,89 @@
+package ai
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestModelsFromEnv(t *testing.T) {
+	t.Setenv("AI_RECIPE_MODEL", "gemini-1.5-pro")
+	t.Setenv("AI_TEMPERATURE", "0.4")
+	t.Setenv("AI_SCAN_TEMPERATURE", "0")
+	t.Setenv("AI_SAFETY", "harassment=high, dangerous=medium")
+	t.Setenv("AI_ASSISTANT_TIMEOUT", "10s")
+
+	models, err := modelsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		model       string
+		temperature float32
+		timeout     time.Duration
+	}{
+		TaskScan:         {"gemini-pro-vision", 0, defaultModelTimeout},
+		TaskRecipe:       {"gemini-1.5-pro", 0.4, defaultModelTimeout},
+		TaskSubstitution: {"gemini-pro", 0.4, defaultModelTimeout},
+		TaskAssistant:    {"gemini-pro", 0.4, 10 * time.Second},
+	}
+	for task, want := range tests {
+		config := models[task]
+		if config.Model != want.model || config.Temperature == nil || *config.Temperature != want.temperature || config.Timeout != want.timeout {
+			t.Errorf("%s = %+v, want %+v", task, config, want)
+		}
+		if len(config.Safety) != 2 ||
+			*config.Safety[0] != (genai.SafetySetting{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockOnlyHigh}) ||
+			*config.Safety[1] != (genai.SafetySetting{Category: genai.HarmCategoryDangerousContent, Threshold: genai.HarmBlockMediumAndAbove}) {
+			t.Errorf("%s safety = %v", task, config.Safety)
+		}
+	}
+}
+
+func TestModelsFromEnvRejectsInvalidEntries(t *testing.T) {
+	tests := []struct{ name, value string }{
+		{"AI_TEMPERATURE", "quente"},
+		{"AI_TEMPERATURE", "-0.1"},
+		{"AI_SCAN_TEMPERATURE", "2.5"},
+		{"AI_RECIPE_SAFETY", "harassment"},
+		{"AI_SAFETY", "violence=high"},
+		{"AI_ASSISTANT_SAFETY", "hate=always"},
+		{"AI_SAFETY", "hate=low,"},
+		{"AI_TIMEOUT", "0s"},
+		{"AI_SCAN_TIMEOUT", "logo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.name, tt.value)
+			if _, err := modelsFromEnv(); err == nil {
+				t.Errorf("%s=%s accepted", tt.name, tt.value)
+			}
+		})
+	}
+}
+
+func TestPromptsFromEnv(t *testing.T) {
+	t.Setenv("AI_SCAN_PROMPT", "v2")
+	prompts, err := promptsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := prompts.Version(TaskScan, "user-1"); version != "v2" {
+		t.Errorf("scan prompt = %s, want v2", version)
+	}
+
+	t.Setenv("AI_SCAN_PROMPT", "")
+	t.Setenv("AI_SCAN_EXPERIMENT", "v2:25")
+	if _, err := promptsFromEnv(); err != nil {
+		t.Errorf("experiment v2:25 rejected: %v", err)
+	}
+
+	for _, value := range []string{"v2", "v2:um quarto", "v9:25", "v2:0", "v1:25"} {
+		t.Setenv("AI_SCAN_EXPERIMENT", value)
+		if _, err := promptsFromEnv(); err == nil {
+			t.Errorf("experiment %q accepted", value)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Fake answers without calling any service, for development and tests. Its
+// answers only depend on what it is asked. It reports the prompt version a
+// real provider would have used, so experiments can be tried offline.
+type Fake struct {
+	Prompts *Prompts
+}
+
+func (f Fake) answer(task, unit, text string) Answer {
+	answer := Answer{Text: text}
+	if f.Prompts != nil {
+		answer.Prompt = f.Prompts.Version(task, unit)
+	}
+	return answer
+}
+
+// FakeScan is what Fake finds in every photo.
+const FakeScan = "leite,ovo,manteiga"
+
+func (f Fake) ScanImages(ctx context.Context, req ScanRequest) (Answer, error) {
+	if len(req.Images) == 0 {
+		return Answer{}, ErrNoImages
+	}
+	return f.answer(TaskScan, req.Unit, FakeScan), ctx.Err()
+}
+
+// GenerateRecipe uses up to four pantry ingredients, the first cuisine and
+// the lowest meal type code. It leaves the restrictions empty for the
+// caller to fill in.
+func (f Fake) GenerateRecipe(ctx context.Context, req RecipeRequest) (Answer, error) {
+	if err := ctx.Err(); err != nil {
+		return Answer{}, err
+	}
+
+	ingredients := req.Pantry
+	if len(ingredients) > 4 {
+		ingredients = ingredients[:4]
+	}
+	cuisine := ""
+	if len(req.Cuisines) > 0 {
+		cuisine = req.Cuisines[0]
+	}
+	codes := make([]int, 0, len(req.MealTypes))
+	for code := range req.MealTypes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	typeOf := 0
+	if len(codes) > 0 {
+		typeOf = codes[0]
+	}
+
+	recipe := GeneratedRecipe{
+		Name:        "Receita com " + strings.Join(ingredients, ", "),
+		Description: "Uma receita simples com o que você tem em casa.<br><br>Ingredientes:<br>" + strings.Join(ingredients, "<br>") + "<br><br>Modo de preparo:<br>Misture tudo e sirva.",
+		Cuisine:     cuisine,
+		TypeOf:      typeOf,
+		Ingredients: ingredients,
+		Difficulty:  "fácil",
+		Restriction: []string{},
+	}
+	answer, err := json.Marshal(recipe)
+	return f.answer(TaskRecipe, req.Unit, string(answer)), err
+}
+
+// SuggestSubstitutions proposes a single alternative named after the
+// ingredient, unless it is already known.
+func (f Fake) SuggestSubstitutions(ctx context.Context, req SubstitutionRequest) (Answer, error) {
+	if err := ctx.Err(); err != nil {
+		return Answer{}, err
+	}
+
+	alternatives := []SuggestedAlternative{}
+	name := "Substituto de " + strings.ToLower(req.Ingredient)
+	if !slices.ContainsFunc(req.Known, func(known string) bool { return strings.EqualFold(known, name) }) {
+		alternatives = append(alternatives, SuggestedAlternative{
+			Name:        name,
+			Ratio:       1,
+			Notes:       "Use a mesma quantidade.",
+			Restriction: []string{},
+		})
+	}
+	answer, err := json.Marshal(alternatives)
+	return f.answer(TaskSubstitution, req.Unit, string(answer)), err
+}
+
+// Chat answers word by word with the question, the recipe and how many
+// messages came before, so tests can follow the conversation.
+func (f Fake) Chat(ctx context.Context, req ChatRequest, stream StreamFunc) (Answer, error) {
+	text := fmt.Sprintf("Você perguntou \"%s\" sobre %s, que leva %s. Mensagens anteriores: %d.",
+		req.Message, req.Recipe.Name, strings.Join(req.Recipe.Ingredients, ", "), len(req.History))
+
+	words := strings.SplitAfter(text, " ")
+	for _, word := range words {
+		if err := ctx.Err(); err != nil {
+			return Answer{}, err
+		}
+		if err := stream(word); err != nil {
+			return Answer{}, err
+		}
+	}
+	return f.answer(TaskAssistant, req.Unit, text), nil
+}
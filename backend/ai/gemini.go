@@ -0,0 +1,161 @@
+package ai
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// Gemini is the Google Gemini provider.
+type Gemini struct {
+	prompts *Prompts
+	models  map[string]ModelConfig
+}
+
+func NewGemini(prompts *Prompts, models map[string]ModelConfig) *Gemini {
+	return &Gemini{prompts: prompts, models: models}
+}
+
+// generate renders the prompt of task for data, sends it to the model of the
+// task followed by parts and returns the text of its answer.
+func (g *Gemini) generate(ctx context.Context, task, unit string, data interface{}, parts ...genai.Part) (Answer, error) {
+	answer := Answer{Prompt: g.prompts.Version(task, unit)}
+	prompt, err := g.prompts.Render(task, answer.Prompt, data)
+	if err != nil {
+		return answer, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, g.models[task].Timeout)
+	defer cancel()
+	client, model, err := g.open(ctx, task)
+	if err != nil {
+		return answer, err
+	}
+	defer client.Close()
+
+	resp, err := model.GenerateContent(ctx, append([]genai.Part{genai.Text(prompt)}, parts...)...)
+	if err != nil {
+		return answer, err
+	}
+	answer.Text = responseText(resp)
+	return answer, nil
+}
+
+// open connects to Gemini and sets up the model of task. The client must be
+// closed.
+func (g *Gemini) open(ctx context.Context, task string) (*genai.Client, *genai.GenerativeModel, error) {
+	key := os.Getenv("GEMINI_API_KEY")
+	if key == "" {
+		return nil, nil, ErrNotConfigured
+	}
+
+	client, err := genai.NewClient(ctx, option.WithAPIKey(key))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := g.models[task]
+	model := client.GenerativeModel(config.Model)
+	if config.Temperature != nil {
+		model.SetTemperature(*config.Temperature)
+	}
+	model.SafetySettings = config.Safety
+	return client, model, nil
+}
+
+func responseText(resp *genai.GenerateContentResponse) string {
+	var text strings.Builder
+	for _, cand := range resp.Candidates {
+		if cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			if t, ok := part.(genai.Text); ok {
+				text.WriteString(string(t))
+			}
+		}
+		// Only the first candidate is used.
+		break
+	}
+	return text.String()
+}
+
+func (g *Gemini) ScanImages(ctx context.Context, req ScanRequest) (Answer, error) {
+	if len(req.Images) == 0 {
+		return Answer{}, ErrNoImages
+	}
+
+	images := make([]genai.Part, len(req.Images))
+	for i, img := range req.Images {
+		images[i] = genai.ImageData(img.Format, img.Data)
+	}
+	return g.generate(ctx, TaskScan, req.Unit, req, images...)
+}
+
+func (g *Gemini) GenerateRecipe(ctx context.Context, req RecipeRequest) (Answer, error) {
+	return g.generate(ctx, TaskRecipe, req.Unit, req)
+}
+
+func (g *Gemini) SuggestSubstitutions(ctx context.Context, req SubstitutionRequest) (Answer, error) {
+	return g.generate(ctx, TaskSubstitution, req.Unit, req)
+}
+
+// assistantAck is the reply of the model to the context it is given, which
+// goes in the first message as gemini-pro takes no system instructions.
+const assistantAck = "Entendido. Vou ajudar com esta receita."
+
+func (g *Gemini) Chat(ctx context.Context, req ChatRequest, stream StreamFunc) (Answer, error) {
+	answer := Answer{Prompt: g.prompts.Version(TaskAssistant, req.Unit)}
+	prompt, err := g.prompts.Render(TaskAssistant, answer.Prompt, req)
+	if err != nil {
+		return answer, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, g.models[TaskAssistant].Timeout)
+	defer cancel()
+	client, model, err := g.open(ctx, TaskAssistant)
+	if err != nil {
+		return answer, err
+	}
+	defer client.Close()
+
+	chat := model.StartChat()
+	chat.History = []*genai.Content{
+		{Role: "user", Parts: []genai.Part{genai.Text(prompt)}},
+		{Role: "model", Parts: []genai.Part{genai.Text(assistantAck)}},
+	}
+	for _, message := range req.History {
+		role := "user"
+		if message.Role == ChatAssistant {
+			role = "model"
+		}
+		chat.History = append(chat.History, &genai.Content{Role: role, Parts: []genai.Part{genai.Text(message.Text)}})
+	}
+
+	var text strings.Builder
+	iter := chat.SendMessageStream(ctx, genai.Text(req.Message))
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return answer, err
+		}
+
+		chunk := responseText(resp)
+		if chunk == "" {
+			continue
+		}
+		text.WriteString(chunk)
+		if err := stream(chunk); err != nil {
+			return answer, err
+		}
+	}
+	answer.Text = text.String()
+	return answer, nil
+}
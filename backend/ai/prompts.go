@@ -0,0 +1,161 @@
+package ai
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// Tasks the AI is used for. Each has its own prompts and model settings.
+const (
+	TaskScan         = "scan"
+	TaskRecipe       = "recipe"
+	TaskSubstitution = "substitution"
+	TaskAssistant    = "assistant"
+)
+
+var Tasks = []string{TaskScan, TaskRecipe, TaskSubstitution, TaskAssistant}
+
+// defaultPromptVersion is the version used when none is configured.
+const defaultPromptVersion = "v1"
+
+// Prompts are read from prompts/<task>/<version>.tmpl, as text/template
+// files. The ones shipped are embedded; AI_PROMPTS_DIR may add versions or
+// replace them without a new build.
+//
+//go:embed prompts
+var embeddedPrompts embed.FS
+
+var promptFuncs = template.FuncMap{"join": strings.Join}
+
+// Prompts holds the prompt versions of every task, which one is active and
+// the experiments running.
+type Prompts struct {
+	templates   map[string]map[string]*template.Template
+	active      map[string]string
+	experiments map[string]Experiment
+}
+
+// Experiment sends Share percent of the units of a task, e.g. users, to the
+// Variant version instead of the active one.
+type Experiment struct {
+	Variant string
+	Share   int
+}
+
+// LoadPrompts reads the embedded prompts and then those in dir, if any. All
+// tasks start on version v1 without experiments.
+func LoadPrompts(dir string) (*Prompts, error) {
+	p := &Prompts{
+		templates:   map[string]map[string]*template.Template{},
+		active:      map[string]string{},
+		experiments: map[string]Experiment{},
+	}
+
+	embedded, err := fs.Sub(embeddedPrompts, "prompts")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.load(embedded); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := p.load(os.DirFS(dir)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, task := range Tasks {
+		if err := p.Use(task, defaultPromptVersion, nil); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Prompts) load(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, "*/*.tmpl")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		tmpl, err := template.New(file).Funcs(promptFuncs).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("prompt %s: %w", file, err)
+		}
+
+		task := path.Dir(file)
+		if p.templates[task] == nil {
+			p.templates[task] = map[string]*template.Template{}
+		}
+		p.templates[task][strings.TrimSuffix(path.Base(file), ".tmpl")] = tmpl
+	}
+	return nil
+}
+
+// Use makes version the active prompt of task and replaces its experiment,
+// removing it when experiment is nil.
+func (p *Prompts) Use(task, version string, experiment *Experiment) error {
+	if p.templates[task][version] == nil {
+		return fmt.Errorf("prompt %s/%s não encontrado", task, version)
+	}
+	if experiment != nil {
+		switch {
+		case p.templates[task][experiment.Variant] == nil:
+			return fmt.Errorf("prompt %s/%s não encontrado", task, experiment.Variant)
+		case experiment.Variant == version:
+			return errors.New("o experimento de " + task + " compara " + version + " com ele mesmo")
+		case experiment.Share <= 0 || experiment.Share >= 100:
+			return errors.New("a parcela do experimento de " + task + " deve ser de 1 a 99")
+		}
+	}
+
+	p.active[task] = version
+	delete(p.experiments, task)
+	if experiment != nil {
+		p.experiments[task] = *experiment
+	}
+	return nil
+}
+
+// Version picks the prompt version of task for unit. A unit always gets the
+// same version while the experiment lasts; requests without one get the
+// active version.
+func (p *Prompts) Version(task, unit string) string {
+	experiment, ok := p.experiments[task]
+	if !ok || unit == "" {
+		return p.active[task]
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(task + ":" + unit))
+	if int(h.Sum32()%100) < experiment.Share {
+		return experiment.Variant
+	}
+	return p.active[task]
+}
+
+// Render writes the prompt of task in the given version for data.
+func (p *Prompts) Render(task, version string, data interface{}) (string, error) {
+	tmpl := p.templates[task][version]
+	if tmpl == nil {
+		return "", fmt.Errorf("prompt %s/%s não encontrado", task, version)
+	}
+
+	var prompt bytes.Buffer
+	if err := tmpl.Execute(&prompt, data); err != nil {
+		return "", fmt.Errorf("prompt %s/%s: %w", task, version, err)
+	}
+	return strings.TrimSpace(prompt.String()), nil
+}
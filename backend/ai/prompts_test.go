@@ -0,0 +1,155 @@
+package ai
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVersionIsStablePerUnit(t *testing.T) {
+	prompts, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prompts.Use(TaskScan, "v1", &Experiment{Variant: "v2", Share: 50}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		unit := fmt.Sprintf("user-%d", i)
+		first := prompts.Version(TaskScan, unit)
+		for j := 0; j < 3; j++ {
+			if again := prompts.Version(TaskScan, unit); again != first {
+				t.Fatalf("%s got %s, then %s", unit, first, again)
+			}
+		}
+	}
+	if version := prompts.Version(TaskScan, ""); version != "v1" {
+		t.Errorf("request without a unit got %s, want the active v1", version)
+	}
+	if version := prompts.Version(TaskRecipe, "user-1"); version != "v1" {
+		t.Errorf("task without an experiment got %s, want v1", version)
+	}
+}
+
+func TestVersionFollowsShare(t *testing.T) {
+	prompts, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const units = 10000
+	for _, share := range []int{1, 10, 50, 90, 99} {
+		if err := prompts.Use(TaskScan, "v1", &Experiment{Variant: "v2", Share: share}); err != nil {
+			t.Fatal(err)
+		}
+		variant := 0
+		for i := 0; i < units; i++ {
+			if prompts.Version(TaskScan, fmt.Sprintf("user-%d", i)) == "v2" {
+				variant++
+			}
+		}
+		if got := float64(variant) * 100 / units; got < float64(share)-2 || got > float64(share)+2 {
+			t.Errorf("share %d%%: %.1f%% of the units got the variant", share, got)
+		}
+	}
+
+	if err := prompts.Use(TaskScan, "v1", nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if version := prompts.Version(TaskScan, fmt.Sprintf("user-%d", i)); version != "v1" {
+			t.Fatalf("user-%d got %s after the experiment ended", i, version)
+		}
+	}
+}
+
+func TestUseRejectsInvalidVersions(t *testing.T) {
+	prompts, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		task, version string
+		experiment    *Experiment
+	}{
+		"unknown version":         {TaskScan, "v9", nil},
+		"version of another task": {TaskRecipe, "v2", nil},
+		"unknown variant":         {TaskScan, "v1", &Experiment{Variant: "v9", Share: 10}},
+		"variant is active":       {TaskScan, "v2", &Experiment{Variant: "v2", Share: 10}},
+		"share of 0":              {TaskScan, "v1", &Experiment{Variant: "v2", Share: 0}},
+		"share of 100":            {TaskScan, "v1", &Experiment{Variant: "v2", Share: 100}},
+		"negative share":          {TaskScan, "v1", &Experiment{Variant: "v2", Share: -5}},
+	}
+	for name, tt := range tests {
+		if err := prompts.Use(tt.task, tt.version, tt.experiment); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if version := prompts.Version(TaskScan, "user-1"); version != "v1" {
+		t.Errorf("rejected settings changed the version to %s", version)
+	}
+}
+
+func TestLoadPromptsOverrideDir(t *testing.T) {
+	dir := t.TempDir()
+	for file, text := range map[string]string{
+		"scan/v1.tmpl":   "Liste os alimentos das {{len .Images}} fotos.",
+		"recipe/v9.tmpl": "Receita com {{join .Ingredients \", \"}}.",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prompts, err := LoadPrompts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]any{"Images": make([]Image, 2), "Ingredients": []string{"ovo", "leite"}}
+
+	tests := []struct {
+		task, version, want string
+	}{
+		{TaskScan, "v1", "Liste os alimentos das 2 fotos."},
+		{TaskRecipe, "v9", "Receita com ovo, leite."},
+	}
+	for _, tt := range tests {
+		got, err := prompts.Render(tt.task, tt.version, data)
+		if err != nil || got != tt.want {
+			t.Errorf("%s/%s = %q (%v), want %q", tt.task, tt.version, got, err, tt.want)
+		}
+	}
+
+	// Versions the directory leaves alone are still the embedded ones.
+	embedded, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := embedded.Render(TaskScan, "v2", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := prompts.Render(TaskScan, "v2", data); err != nil || got != want {
+		t.Errorf("scan/v2 changed by the override directory: %q (%v)", got, err)
+	}
+}
+
+func TestLoadPromptsRejectsBrokenTemplates(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, TaskScan), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, TaskScan, "v3.tmpl"), []byte("{{if .Images}}sem fim"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPrompts(dir); err == nil || !strings.Contains(err.Error(), "scan/v3.tmpl") {
+		t.Errorf("broken template loaded: %v", err)
+	}
+}
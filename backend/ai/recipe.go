@@ -0,0 +1,102 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RecipeRequest is what a recipe is generated from.
+type RecipeRequest struct {
+	// Pantry holds the ingredients the recipe may use.
+	Pantry []string
+	// Restrictions the recipe must respect, e.g. "vegano".
+	Restrictions []string
+	// Cuisines are the slugs the recipe can be filed under, and MealTypes
+	// the meal type codes with their slugs.
+	Cuisines  []string
+	MealTypes map[int]string
+	// Feedback tells the model what was wrong with its previous answer.
+	Feedback string
+	// Unit is as in ScanRequest.
+	Unit string
+}
+
+// GeneratedRecipe is the JSON a model must answer with. It matches the
+// content fields of model.Recipe.
+type GeneratedRecipe struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Cuisine     string   `json:"cuisine"`
+	TypeOf      int      `json:"type_of"`
+	Ingredients []string `json:"ingredients"`
+	Difficulty  string   `json:"difficulty"`
+	Restriction []string `json:"restriction"`
+}
+
+// RecipeSchema describes GeneratedRecipe to the model.
+const RecipeSchema = `{
+  "name": "string, nome da receita",
+  "description": "string, descrição seguida de <br><br>Ingredientes:<br> com as quantidades, uma por linha separada por <br>, e <br><br>Modo de preparo:<br> com os passos separados por <br>",
+  "cuisine": "string, uma das culinárias permitidas",
+  "type_of": "número, um dos tipos de refeição permitidos",
+  "ingredients": ["string, apenas ingredientes da lista permitida, escritos como nela"],
+  "difficulty": "string, fácil, médio ou difícil",
+  "restriction": ["string, as restrições que a receita NÃO atende, entre vegano, vegetariano, laticinio e gluten"]
+}`
+
+var Difficulties = []string{"fácil", "médio", "difícil"}
+
+// Schema and MealTypeList are for the prompt templates.
+func (RecipeRequest) Schema() string {
+	return RecipeSchema
+}
+
+// MealTypeList writes the meal types as "1 (cafe-da-manha), 2 (almoco)".
+func (r RecipeRequest) MealTypeList() string {
+	codes := make([]int, 0, len(r.MealTypes))
+	for code := range r.MealTypes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	mealTypes := make([]string, len(codes))
+	for i, code := range codes {
+		mealTypes[i] = fmt.Sprintf("%d (%s)", code, r.MealTypes[code])
+	}
+	return strings.Join(mealTypes, ", ")
+}
+
+// ParseRecipe reads the answer of GenerateRecipe. Fields other than those of
+// GeneratedRecipe, or anything after the object, make it fail.
+func ParseRecipe(answer string) (*GeneratedRecipe, error) {
+	var recipe GeneratedRecipe
+	if err := decodeStrict(answer, &recipe); err != nil {
+		return nil, err
+	}
+	return &recipe, nil
+}
+
+// decodeStrict reads the JSON answer of a model into v, rejecting unknown
+// fields and anything after the value.
+func decodeStrict(answer string, v interface{}) error {
+	answer = strings.TrimSpace(answer)
+	// Models often wrap JSON in a markdown block despite being asked not to.
+	if strings.HasPrefix(answer, "```") {
+		answer = strings.TrimPrefix(answer, "```json")
+		answer = strings.TrimPrefix(answer, "```")
+		answer = strings.TrimSuffix(answer, "```")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(answer)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("JSON inválido: %w", err)
+	}
+	if decoder.More() {
+		return errors.New("JSON inválido: texto após o objeto")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package ai
+
+// SubstitutionRequest asks for alternatives to an ingredient.
+type SubstitutionRequest struct {
+	Ingredient string
+	// Restrictions the alternatives should help with, e.g. "vegano".
+	Restrictions []string
+	// Known are the alternatives already recorded, not to be repeated.
+	Known []string
+	// Unit is as in ScanRequest.
+	Unit string
+}
+
+// SuggestedAlternative is an item of the JSON list a model must answer with.
+// It matches model.Alternative.
+type SuggestedAlternative struct {
+	Name        string   `json:"name"`
+	Ratio       float64  `json:"ratio"`
+	Notes       string   `json:"notes"`
+	Restriction []string `json:"restriction"`
+}
+
+// SubstitutionSchema describes a list of SuggestedAlternative to the model.
+const SubstitutionSchema = `[
+  {
+    "name": "string, nome do ingrediente substituto, no singular",
+    "ratio": "número, quanto do substituto usar para cada medida do original, por exemplo 0.75",
+    "notes": "string, dica curta de uso",
+    "restriction": ["string, as restrições que o substituto NÃO atende, entre vegano, vegetariano, laticinio e gluten"]
+  }
+]`
+
+// Schema is for the prompt templates.
+func (SubstitutionRequest) Schema() string {
+	return SubstitutionSchema
+}
+
+// ParseSubstitutions reads the answer of SuggestSubstitutions as strictly as
+// ParseRecipe.
+func ParseSubstitutions(answer string) ([]SuggestedAlternative, error) {
+	var alternatives []SuggestedAlternative
+	if err := decodeStrict(answer, &alternatives); err != nil {
+		return nil, err
+	}
+	return alternatives, nil
+}
@@ -0,0 +1,166 @@
+// Package billing talks to the payment provider that charges premium
+// subscriptions. Payments are confirmed asynchronously through signed
+// webhooks.
+package billing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+type Plan struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	PriceCents     int    `json:"price_cents"`
+	Currency       string `json:"currency"`
+	IntervalMonths int    `json:"interval_months"`
+	TrialDays      int    `json:"trial_days"`
+}
+
+// PeriodEnd is when a billing period that starts at start ends.
+func (p Plan) PeriodEnd(start time.Time) time.Time {
+	return start.AddDate(0, p.IntervalMonths, 0)
+}
+
+// DiscountedCents is the price with percentOff taken off, rounded down to
+// whole cents.
+func (p Plan) DiscountedCents(percentOff int) int {
+	return p.PriceCents * (100 - percentOff) / 100
+}
+
+var plans = []Plan{
+	{ID: "monthly", Name: "Premium mensal", PriceCents: 1990, Currency: "BRL", IntervalMonths: 1, TrialDays: 7},
+	{ID: "yearly", Name: "Premium anual", PriceCents: 19900, Currency: "BRL", IntervalMonths: 12, TrialDays: 7},
+}
+
+func Plans() []Plan {
+	return append([]Plan(nil), plans...)
+}
+
+func PlanByID(id string) (Plan, bool) {
+	for _, p := range plans {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Plan{}, false
+}
+
+const (
+	EventPaymentSucceeded     = "payment.succeeded"
+	EventPaymentFailed        = "payment.failed"
+	EventSubscriptionCanceled = "subscription.canceled"
+)
+
+// Event is a webhook notification. PeriodStart and PeriodEnd are set on
+// successful payments and delimit the period that was paid for.
+type Event struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Created time.Time `json:"created"`
+	Data    EventData `json:"data"`
+}
+
+type EventData struct {
+	SubscriptionID string     `json:"subscription_id"`
+	ExternalID     string     `json:"external_id"`
+	PeriodStart    *time.Time `json:"period_start,omitempty"`
+	PeriodEnd      *time.Time `json:"period_end,omitempty"`
+}
+
+type CheckoutRequest struct {
+	SubscriptionID string
+	Email          string
+	Plan           Plan
+	// StartAt is when the first paid period begins: now, or the end of the
+	// trial.
+	StartAt time.Time
+	// PercentOff discounts the first paid period, from a coupon.
+	PercentOff int
+	SuccessURL string
+	CancelURL  string
+}
+
+type Checkout struct {
+	ExternalID string
+	URL        string
+}
+
+var (
+	ErrInvalidSignature = errors.New("assinatura do webhook inválida")
+	ErrNotConfigured    = errors.New("nenhum provedor de pagamento configurado")
+)
+
+type Provider interface {
+	// Checkout creates the subscription at the provider and returns the
+	// page where the user pays.
+	Checkout(ctx context.Context, req CheckoutRequest) (*Checkout, error)
+	// Cancel stops future charges. The paid period is not refunded.
+	Cancel(ctx context.Context, externalID string) error
+	// ParseWebhook checks the signature of a webhook delivery and decodes
+	// it.
+	ParseWebhook(signature string, payload []byte) (*Event, error)
+}
+
+// NewFromEnv picks the provider from BILLING_PROVIDER:
+//
+//   - "none" (the default outside production) turns subscriptions off.
+//   - "local" stands in for a real provider during development and tests.
+//     It approves every payment, so it is refused when profile is "prod".
+//
+// Production must name its provider explicitly, and every provider that
+// takes payments needs BILLING_WEBHOOK_SECRET.
+func NewFromEnv() (Provider, error) {
+	prod := os.Getenv("profile") == "prod"
+	secret := os.Getenv("BILLING_WEBHOOK_SECRET")
+
+	switch os.Getenv("BILLING_PROVIDER") {
+	case "":
+		if prod {
+			return nil, errors.New("BILLING_PROVIDER é obrigatório em produção (use \"none\" para desativar as assinaturas)")
+		}
+		return None{}, nil
+	case "none":
+		return None{}, nil
+	case "local":
+		if prod {
+			return nil, errors.New("o provedor de pagamento local aprova qualquer pagamento e não pode ser usado em produção")
+		}
+		if secret == "" {
+			return nil, errors.New("BILLING_WEBHOOK_SECRET é obrigatório")
+		}
+		webhookURL := os.Getenv("BILLING_WEBHOOK_URL")
+		if webhookURL == "" {
+			webhookURL = "http://localhost:8080/api/v1/billing/webhook"
+		}
+		local := NewLocal(secret, webhookURL)
+		local.AutoPay = true
+		return local, nil
+	default:
+		return nil, errors.New("BILLING_PROVIDER desconhecido: " + os.Getenv("BILLING_PROVIDER"))
+	}
+}
+
+// Enabled tells whether p takes payments at all.
+func Enabled(p Provider) bool {
+	_, off := p.(None)
+	return !off
+}
+
+// None is used when no payment provider is configured: checkouts fail and
+// every webhook is rejected.
+type None struct{}
+
+func (None) Checkout(ctx context.Context, req CheckoutRequest) (*Checkout, error) {
+	return nil, ErrNotConfigured
+}
+
+func (None) Cancel(ctx context.Context, externalID string) error {
+	return ErrNotConfigured
+}
+
+func (None) ParseWebhook(signature string, payload []byte) (*Event, error) {
+	return nil, ErrNotConfigured
+}
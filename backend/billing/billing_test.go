@@ -0,0 +1,39 @@
+package billing
+
+import "testing"
+
+func TestNewFromEnv(t *testing.T) {
+	tests := []struct {
+		profile, provider, secret string
+		want                      string // "none", "local" or "error"
+	}{
+		{profile: "", provider: "", want: "none"},
+		{profile: "", provider: "none", want: "none"},
+		{profile: "", provider: "local", secret: "s", want: "local"},
+		{profile: "", provider: "local", want: "error"},
+		{profile: "prod", provider: "", secret: "s", want: "error"},
+		{profile: "prod", provider: "local", secret: "s", want: "error"},
+		{profile: "prod", provider: "none", want: "none"},
+		{profile: "", provider: "stripe", want: "error"},
+	}
+	for _, tt := range tests {
+		t.Setenv("profile", tt.profile)
+		t.Setenv("BILLING_PROVIDER", tt.provider)
+		t.Setenv("BILLING_WEBHOOK_SECRET", tt.secret)
+
+		p, err := NewFromEnv()
+		got := "error"
+		switch p := p.(type) {
+		case None:
+			got = "none"
+		case *Local:
+			got = "local"
+			if p.Secret != tt.secret || !p.AutoPay {
+				t.Errorf("%+v: local provider %+v", tt, p)
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%+v: got %s (%v), want %s", tt, got, err, tt.want)
+		}
+	}
+}
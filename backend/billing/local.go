@@ -0,0 +1,119 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Local stands in for a payment provider during development and tests.
+// Webhooks are signed the same way a real delivery would be and sent to
+// WebhookURL by Deliver, e.g. renewals or failed payments in tests. With
+// AutoPay every checkout is paid at once: the payment.succeeded webhook is
+// delivered in the background.
+type Local struct {
+	Secret     string
+	WebhookURL string
+	Client     *http.Client
+	AutoPay    bool
+}
+
+func NewLocal(secret, webhookURL string) *Local {
+	return &Local{
+		Secret:     secret,
+		WebhookURL: webhookURL,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (l *Local) Checkout(ctx context.Context, req CheckoutRequest) (*Checkout, error) {
+	externalID := "local_sub_" + randomID()
+	if !l.AutoPay {
+		return &Checkout{ExternalID: externalID, URL: req.SuccessURL}, nil
+	}
+
+	event := PaymentSucceeded(req.SubscriptionID, externalID, req.StartAt, req.Plan.PeriodEnd(req.StartAt))
+	go func() {
+		if err := l.Deliver(context.Background(), event); err != nil {
+			log.Println("erro entregando webhook de pagamento local:", err)
+		}
+	}()
+
+	return &Checkout{ExternalID: externalID, URL: req.SuccessURL}, nil
+}
+
+// PaymentSucceeded is the event of a paid period of a subscription, as the
+// local provider sends it.
+func PaymentSucceeded(subscriptionID, externalID string, start, end time.Time) Event {
+	return Event{
+		ID:      "local_evt_" + randomID(),
+		Type:    EventPaymentSucceeded,
+		Created: time.Now().UTC(),
+		Data: EventData{
+			SubscriptionID: subscriptionID,
+			ExternalID:     externalID,
+			PeriodStart:    &start,
+			PeriodEnd:      &end,
+		},
+	}
+}
+
+func (l *Local) Cancel(ctx context.Context, externalID string) error {
+	return nil
+}
+
+// Deliver signs event and posts it to WebhookURL.
+func (l *Local) Deliver(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(l.Secret, payload, time.Now()))
+
+	resp, err := l.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook respondeu %s", resp.Status)
+	}
+	return nil
+}
+
+func (l *Local) ParseWebhook(signature string, payload []byte) (*Event, error) {
+	if err := Verify(l.Secret, signature, payload, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	if event.ID == "" || event.Type == "" {
+		return nil, errors.New("evento sem id ou tipo")
+	}
+	return &event, nil
+}
@@ -0,0 +1,63 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries "t=<unix time>,v1=<hex HMAC-SHA256>", where the
+// HMAC covers "<unix time>.<body>".
+const SignatureHeader = "Billing-Signature"
+
+// SignatureTolerance is how old a signed delivery may be, which bounds
+// replays of captured requests.
+const SignatureTolerance = 5 * time.Minute
+
+func signature(secret string, payload []byte, t time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(t.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the SignatureHeader value for payload sent at t.
+func Sign(secret string, payload []byte, t time.Time) string {
+	return "t=" + strconv.FormatInt(t.Unix(), 10) + ",v1=" + signature(secret, payload, t)
+}
+
+// Verify checks a SignatureHeader value against payload. Several v1
+// entries are accepted so the secret can be rotated.
+func Verify(secret, header string, payload []byte, now time.Time) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	t := time.Unix(timestamp, 0)
+	if now.Sub(t) > SignatureTolerance || t.Sub(now) > SignatureTolerance {
+		return ErrInvalidSignature
+	}
+
+	expected := signature(secret, payload, t)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
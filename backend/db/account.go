@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UserProfile holds the fields a user may edit. Nil fields are left as
+// they are.
+type UserProfile struct {
+	Name        *string
+	Restriction *[]string
+}
+
+func (m MongoDB) UpdateUserProfile(id string, profile UserProfile) error {
+	set := bson.M{}
+
+	if profile.Name != nil {
+		name := strings.TrimSpace(*profile.Name)
+		if name == "" {
+			return &ValidationError{Field: "name", Message: "O nome não pode ficar vazio"}
+		}
+		set["name"] = name
+	}
+
+	if profile.Restriction != nil {
+		restrictions := []string{}
+		seen := map[string]bool{}
+		for _, restriction := range *profile.Restriction {
+			if !validRestrictions[restriction] {
+				return &ValidationError{Field: "restriction", Message: "Restrição '" + restriction + "' inválida"}
+			}
+			if !seen[restriction] {
+				seen[restriction] = true
+				restrictions = append(restrictions, restriction)
+			}
+		}
+		set["restriction"] = restrictions
+	}
+
+	if len(set) == 0 {
+		return nil
+	}
+	return m.updateUser(id, set)
+}
+
+// ChangeUserEmail moves an account to a new, already verified e-mail. The
+// unique index on email makes the switch atomic: if someone else took the
+// address in the meantime the update fails and nothing changes.
+func (m MongoDB) ChangeUserEmail(id string, newEmail string) error {
+	filter, err := userFilter(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := m.userCollection.UpdateOne(context.Background(),
+		filter,
+		bson.M{"$set": bson.M{"email": newEmail, "email_verified": true}},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailInUse
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"cucinia/model"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditFilter narrows GetAuditEntries. Empty fields and zero times are
+// ignored.
+type AuditFilter struct {
+	ActorID  string
+	Entity   string
+	EntityID string
+	From     time.Time
+	To       time.Time
+	Limit    int64
+}
+
+func auditCollection(database *mongo.Database) *mongo.Collection {
+	// Snapshots hold arbitrary documents.
+	collection := database.Collection("audit_log", mapDocuments())
+
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "entity", Value: 1}, {Key: "entity_id", Value: 1}, {Key: "at", Value: -1}}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return collection
+}
+
+// RecordAudit appends an entry to the audit log. Entries are never deleted,
+// and only updated by EraseUser to drop what points to the erased person.
+func (m MongoDB) RecordAudit(entry *model.AuditEntry) error {
+	entry.ID = primitive.NewObjectID()
+	if entry.At.IsZero() {
+		entry.At = time.Now().UTC()
+	}
+
+	_, err := m.auditCollection.InsertOne(context.Background(), entry)
+	return err
+}
+
+func (m MongoDB) GetAuditEntries(filter AuditFilter) ([]*model.AuditEntry, error) {
+	query := bson.M{}
+	if filter.ActorID != "" {
+		query["actor_id"] = filter.ActorID
+	}
+	if filter.Entity != "" {
+		query["entity"] = filter.Entity
+	}
+	if filter.EntityID != "" {
+		query["entity_id"] = filter.EntityID
+	}
+
+	at := bson.M{}
+	if !filter.From.IsZero() {
+		at["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		at["$lt"] = filter.To
+	}
+	if len(at) > 0 {
+		query["at"] = at
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+
+	cursor, err := m.auditCollection.Find(context.TODO(), query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	entries := []*model.AuditEntry{}
+	if err := cursor.All(context.Background(), &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
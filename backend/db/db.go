@@ -5,7 +5,9 @@ import (
 	"cucinia/model"
 	"errors"
 	"log"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -27,35 +29,157 @@ type DB interface {
 	GetRecipesByIngredient(ingredient string) ([]*model.Recipe, error)
 
 	CreateRecipe(recipe *model.Recipe) error
-	UpdateRecipe(id string, recipe *model.Recipe) error
+	UpdateRecipe(id string, recipe *model.Recipe, expectedVersion int, author string) error
 	DeleteRecipe(id string) error
-	GetRecipesByMultipleCriteria(excludedRestriction []string, ingredient, typeOf, cuisine string, userPremium bool) ([]*model.Recipe, error)
+	GetRecipesByMultipleCriteria(excludedRestriction []string, ingredient, typeOf, cuisine string) ([]*model.Recipe, error)
+	SetRecipeImages(id string, image string, images map[string]string, author string) error
+
+	GetRecipeRevisions(recipeID string) ([]*model.RecipeRevision, error)
+	RestoreRecipeRevision(recipeID string, rev int, expectedVersion int, author string) error
+
+	CreateSubmission(recipe *model.Recipe) error
+	UpdateSubmission(id string, recipe *model.Recipe, expectedVersion int) error
+	SubmitRecipe(id string) error
+	ReviewRecipe(id string, approve bool, reviewer, comment string) error
+	GetRecipesByAuthor(authorID string) ([]*model.Recipe, error)
+	GetRecipesByStatus(status string) ([]*model.Recipe, error)
 
 	CreateUser(user *model.User) error
-	DeleteUser(email string) error
-	AddUserIngredient(email string, ingredient string) error
-	RemoveUserIngredient(email string, ingredient string) error
-	RemoveAllUserIngredients(email string) error
+	DeleteUser(id string) error
+	AddUserIngredient(id string, ingredient string) error
+	RemoveUserIngredient(id string, ingredient string) error
+	RemoveAllUserIngredients(id string) error
 
-	LikeRecipe(email string, recipeID string) error
-	UnlikeRecipe(email string, recipeID string) error
+	LikeRecipe(userID string, recipeID string) (bool, error)
+	UnlikeRecipe(userID string, recipeID string) (*model.Like, error)
 
 	GetAllUsers() ([]*model.User, error)
+	GetUserByID(id string) (*model.User, error)
 	GetUserByEmail(email string) (*model.User, error)
 
-	SetUserPremium(email string, premium bool) error
+	SetUserPremium(id string, premium bool) error
+	SetUserEmailVerified(id string) error
+	SetUserPassword(id string, hash string) error
+	EnableUserTOTP(id string, secret string, recoveryCodes []string) error
+	DisableUserTOTP(id string) error
+	SetUserRecoveryCodes(id string, recoveryCodes []string) error
+	UseUserRecoveryCode(id string, recoveryCode string) error
+	GetUserByIdentity(provider string, subject string) (*model.User, error)
+	LinkUserIdentity(id string, identity model.Identity) error
+	UpdateUserProfile(id string, profile UserProfile) error
+	ChangeUserEmail(id string, newEmail string) error
+
+	GetRecipesByReviewer(reviewerID string) ([]*model.Recipe, error)
+	GetRevisionsByAuthor(authorID string) ([]*model.RecipeRevision, error)
+	GetUserErasures(id string) ([]*model.Erasure, error)
+	ScheduleUserErasure(id string, requestedBy string, at time.Time) (*model.Erasure, error)
+	CancelUserErasure(id string) error
+	GetDueErasures(now time.Time) ([]*model.Erasure, error)
+	EraseUser(id string) (*model.Erasure, error)
+
+	RecordAudit(entry *model.AuditEntry) error
+	GetAuditEntries(filter AuditFilter) ([]*model.AuditEntry, error)
+
+	CreateSubscription(subscription *model.Subscription) error
+	GetSubscriptionByID(id string) (*model.Subscription, error)
+	GetCurrentSubscription(userID string) (*model.Subscription, error)
+	GetUserSubscriptions(userID string) ([]*model.Subscription, error)
+	HasUsedTrial(userID string) (bool, error)
+	SetSubscriptionExternalID(id string, externalID string) error
+	ActivateSubscription(id string, externalID string, start, end time.Time) error
+	SetSubscriptionPastDue(id string) error
+	CancelSubscription(id string) (*model.Subscription, error)
+	GetDueSubscriptions(now time.Time, incompleteBefore time.Time) ([]*model.Subscription, error)
+	EndSubscription(id string, status string) error
+	RecordBillingEvent(eventID string) error
+	ForgetBillingEvent(eventID string) error
+
+	CreateCoupon(coupon *model.Coupon) error
+	GetCoupons() ([]*model.Coupon, error)
+	GetCouponByID(id string) (*model.Coupon, error)
+	GetCouponByCode(code string) (*model.Coupon, error)
+	UpdateCoupon(id string, update CouponUpdate) (*model.Coupon, error)
+	GetRedeemableCoupon(code string, userID string, plan string) (*model.Coupon, error)
+	RedeemCoupon(code string, userID string, plan string) (*model.Coupon, *model.CouponRedemption, error)
+	SetRedemptionSubscription(redemptionID string, subscriptionID string) error
+	ReleaseCouponRedemption(redemptionID string) error
+	GetCouponRedemptions(couponID string) ([]*model.CouponRedemption, error)
+	GetUserRedemptions(userID string) ([]*model.CouponRedemption, error)
+	SetUserReferralCode(id string, code string) error
+	GetUserByReferralCode(code string) (*model.User, error)
+	CreateReferral(referral *model.Referral) error
+	CountRewardedReferrals(referrerID string) (int, error)
+	GetUserReferrals(userID string) ([]*model.Referral, error)
+	ExtendUserPremium(id string, d time.Duration) (time.Time, error)
+	GetExpiredPremiumGrants(now time.Time) ([]*model.User, error)
+	ClearPremiumGrant(id string, now time.Time) error
+
+	GetRecipeStats() ([]*model.RecipeStats, error)
+	SaveRecipeStats(stats []*model.RecipeStats) error
+	DeleteRecipeStats(recipeID string) error
+
+	GetCuisines() ([]*model.Cuisine, error)
+	GetCuisineByID(id string) (*model.Cuisine, error)
+	GetCuisineBySlug(slug string) (*model.Cuisine, error)
+	CreateCuisine(cuisine *model.Cuisine) error
+	UpdateCuisine(id string, cuisine *model.Cuisine) error
+	DeleteCuisine(id string) error
+
+	GetMealTypes() ([]*model.MealType, error)
+	GetMealTypeByID(id string) (*model.MealType, error)
+	GetMealTypeByCode(code int) (*model.MealType, error)
+	ResolveMealType(value string) (*model.MealType, error)
+	CreateMealType(mealType *model.MealType) error
+	UpdateMealType(id string, mealType *model.MealType) error
+	DeleteMealType(id string) error
+
+	GetSubstitutions() ([]*model.Substitution, error)
+	GetSubstitutionByID(id string) (*model.Substitution, error)
+	GetSubstitutionsFor(ingredients []string) ([]*model.Substitution, error)
+	CreateSubstitution(substitution *model.Substitution) error
+	UpdateSubstitution(id string, alternatives []model.Alternative) (*model.Substitution, error)
+	DeleteSubstitution(id string) error
 }
 
 type MongoDB struct {
 	ingredientCollection *mongo.Collection
 	recipeCollection     *mongo.Collection
 	userCollection       *mongo.Collection
+	statsCollection      *mongo.Collection
+	cuisineCollection    *mongo.Collection
+	mealTypeCollection   *mongo.Collection
+	revisionCollection   *mongo.Collection
+	erasureCollection    *mongo.Collection
+	auditCollection      *mongo.Collection
+	likeCollection       *mongo.Collection
+
+	subscriptionCollection *mongo.Collection
+	billingEventCollection *mongo.Collection
+
+	couponCollection     *mongo.Collection
+	redemptionCollection *mongo.Collection
+	referralCollection   *mongo.Collection
+
+	substitutionCollection *mongo.Collection
 }
 
 func NewMongo(client *mongo.Client) DB {
 	ingredientCollection := client.Database("cucinia").Collection("ingredients")
 	recipeCollection := client.Database("cucinia").Collection("recipes")
 	userCollection := client.Database("cucinia").Collection("users")
+	statsCollection := client.Database("cucinia").Collection("recipe_stats")
+	cuisineCollection := client.Database("cucinia").Collection("cuisines")
+	mealTypeCollection := client.Database("cucinia").Collection("meal_types")
+	revisionCollection := revisionCollection(client.Database("cucinia"))
+	erasureCollection := client.Database("cucinia").Collection("erasures")
+	auditCollection := auditCollection(client.Database("cucinia"))
+	likeCollection := client.Database("cucinia").Collection("likes")
+	subscriptionCollection := client.Database("cucinia").Collection("subscriptions")
+	billingEventCollection := client.Database("cucinia").Collection("billing_events")
+	couponCollection := client.Database("cucinia").Collection("coupons")
+	redemptionCollection := client.Database("cucinia").Collection("coupon_redemptions")
+	referralCollection := client.Database("cucinia").Collection("referrals")
+	substitutionCollection := client.Database("cucinia").Collection("substitutions")
 
 	_, err := userCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
 		Keys:    bson.D{{Key: "email", Value: 1}},
@@ -65,10 +189,49 @@ func NewMongo(client *mongo.Client) DB {
 		log.Fatal(err)
 	}
 
+	setupTaxonomies(cuisineCollection, mealTypeCollection, recipeCollection)
+	setupModeration(recipeCollection)
+	setupIdentities(userCollection)
+	setupLikes(likeCollection)
+	err = runMigrations(client.Database("cucinia").Collection("migrations"), []migration{
+		{"user-ids", func(ctx context.Context) error {
+			return migrateUserIDs(ctx, userCollection, recipeCollection, revisionCollection)
+		}},
+		{"likes", func(ctx context.Context) error {
+			return migrateLikes(ctx, userCollection, likeCollection)
+		}},
+		{"email-case", func(ctx context.Context) error {
+			return migrateEmailCase(ctx, userCollection)
+		}},
+	})
+	if err != nil {
+		log.Println("erro migrando dados:", err)
+	}
+	setupErasures(erasureCollection)
+	setupSubscriptions(subscriptionCollection)
+	setupPromotions(couponCollection, redemptionCollection, referralCollection, userCollection)
+	setupSubstitutions(substitutionCollection)
+
 	return &MongoDB{
 		ingredientCollection: ingredientCollection,
 		recipeCollection:     recipeCollection,
 		userCollection:       userCollection,
+		statsCollection:      statsCollection,
+		cuisineCollection:    cuisineCollection,
+		mealTypeCollection:   mealTypeCollection,
+		revisionCollection:   revisionCollection,
+		erasureCollection:    erasureCollection,
+		auditCollection:      auditCollection,
+		likeCollection:       likeCollection,
+
+		subscriptionCollection: subscriptionCollection,
+		billingEventCollection: billingEventCollection,
+
+		couponCollection:     couponCollection,
+		redemptionCollection: redemptionCollection,
+		referralCollection:   referralCollection,
+
+		substitutionCollection: substitutionCollection,
 	}
 }
 
@@ -184,7 +347,7 @@ func (m MongoDB) DeleteIngredient(id string) error {
 }
 
 func (m MongoDB) GetRecipes() ([]*model.Recipe, error) {
-	cursor, err := m.recipeCollection.Find(context.TODO(), bson.M{})
+	cursor, err := m.recipeCollection.Find(context.TODO(), bson.M{"status": model.RecipePublished})
 	if err != nil {
 		log.Println("erro no fetch das receitas:", err.Error())
 		return nil, err
@@ -221,7 +384,7 @@ func (m MongoDB) GetRecipeByID(id string) (*model.Recipe, error) {
 }
 
 func (m MongoDB) GetRecipesByCuisine(cuisine string) ([]*model.Recipe, error) {
-	cursor, err := m.recipeCollection.Find(context.TODO(), bson.M{"cuisine": cuisine})
+	cursor, err := m.recipeCollection.Find(context.TODO(), bson.M{"cuisine": cuisine, "status": model.RecipePublished})
 	if err != nil {
 		return nil, err
 	}
@@ -240,7 +403,15 @@ func (m MongoDB) GetRecipesByCuisine(cuisine string) ([]*model.Recipe, error) {
 }
 
 func (m MongoDB) GetRecipesByTypeOf(typeOf string) ([]*model.Recipe, error) {
-	cursor, err := m.recipeCollection.Find(context.TODO(), bson.M{"type_of": typeOf})
+	mealType, err := m.ResolveMealType(typeOf)
+	if err != nil {
+		return nil, err
+	}
+	if mealType == nil {
+		return nil, &ValidationError{Field: "type_of", Message: "tipo de refeição '" + typeOf + "' não é válido"}
+	}
+
+	cursor, err := m.recipeCollection.Find(context.TODO(), bson.M{"type_of": mealType.Code, "status": model.RecipePublished})
 	if err != nil {
 		return nil, err
 	}
@@ -259,7 +430,7 @@ func (m MongoDB) GetRecipesByTypeOf(typeOf string) ([]*model.Recipe, error) {
 }
 
 func (m MongoDB) GetRecipesByIngredient(ingredient string) ([]*model.Recipe, error) {
-	cursor, err := m.recipeCollection.Find(context.TODO(), bson.M{"ingredients": bson.M{"$regex": ingredient}})
+	cursor, err := m.recipeCollection.Find(context.TODO(), bson.M{"ingredients": bson.M{"$regex": ingredient}, "status": model.RecipePublished})
 	if err != nil {
 		return nil, err
 	}
@@ -279,6 +450,8 @@ func (m MongoDB) GetRecipesByIngredient(ingredient string) ([]*model.Recipe, err
 
 func (m MongoDB) CreateRecipe(recipe *model.Recipe) error {
 	recipe.ID = primitive.NewObjectID()
+	recipe.Status = model.RecipePublished
+	recipe.Version = 1
 
 	existingRecipe := m.recipeCollection.FindOne(context.TODO(), bson.M{"name": recipe.Name})
 	if existingRecipe.Err() == nil {
@@ -287,6 +460,41 @@ func (m MongoDB) CreateRecipe(recipe *model.Recipe) error {
 		return existingRecipe.Err()
 	}
 
+	if err := m.validateRecipe(recipe); err != nil {
+		return err
+	}
+
+	_, err := m.recipeCollection.InsertOne(context.TODO(), recipe)
+	if err != nil {
+		return err
+	}
+
+	return m.insertRevision(recipe, recipe.AuthorID, nil, nil)
+}
+
+func (m MongoDB) UpdateRecipe(id string, recipe *model.Recipe, expectedVersion int, author string) error {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return errors.New("ID inválido")
+	}
+
+	if err := m.validateRecipe(recipe); err != nil {
+		return err
+	}
+
+	set := recipeContent(recipe)
+	delete(set, "images")
+
+	return m.changeRecipe(id, nil, set, expectedVersion, author, nil)
+}
+
+var validRestrictions = map[string]bool{
+	"vegano":      true,
+	"vegetariano": true,
+	"laticinio":   true,
+	"gluten":      true,
+}
+
+func (m MongoDB) validateRecipe(recipe *model.Recipe) error {
 	ingredientsString := strings.Join(recipe.Ingredients, ",")
 	ingredientsList := strings.Split(ingredientsString, ",")
 	for _, ingredientName := range ingredientsList {
@@ -296,82 +504,40 @@ func (m MongoDB) CreateRecipe(recipe *model.Recipe) error {
 			return err
 		}
 		if count == 0 {
-			return errors.New("Ingrediente '" + exactIngredient + "' não existe")
+			return &ValidationError{Field: "ingredients", Message: "Ingrediente '" + exactIngredient + "' não existe"}
 		}
 	}
 
-	validRestrictions := map[string]bool{
-		"vegano":      true,
-		"vegetariano": true,
-		"laticinio":   true,
-		"gluten":      true,
-	}
 	for _, restriction := range recipe.Restriction {
 		if !validRestrictions[restriction] {
-			return errors.New("restrição '" + restriction + "' inválida")
+			return &ValidationError{Field: "restriction", Message: "restrição '" + restriction + "' inválida"}
 		}
 	}
 
-	validCuisines := map[string]bool{"italiana": true, "francesa": true, "brasileira": true, "americana": true, "mexicana": true, "turca": true, "chinesa": true}
-	if !validCuisines[recipe.Cuisine] {
-		return errors.New("culinária '" + recipe.Cuisine + "' não é válida")
-	}
-
-	_, err := m.recipeCollection.InsertOne(context.TODO(), recipe)
+	cuisine, err := m.GetCuisineBySlug(recipe.Cuisine)
 	if err != nil {
 		return err
 	}
-
-	return nil
-}
-
-func (m MongoDB) UpdateRecipe(id string, recipe *model.Recipe) error {
-	objID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return errors.New("ID inválido")
+	if cuisine == nil {
+		return &ValidationError{Field: "cuisine", Message: "culinária '" + recipe.Cuisine + "' não é válida"}
 	}
 
-	var existingRecipe model.Recipe
-	err = m.recipeCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&existingRecipe)
+	mealType, err := m.GetMealTypeByCode(recipe.TypeOf)
 	if err != nil {
-		return errors.New("receita não encontrada")
-	}
-
-	validRestrictions := map[string]bool{
-		"vegano":      true,
-		"vegetariano": true,
-		"laticinio":   true,
-		"gluten":      true,
-	}
-	for _, restriction := range recipe.Restriction {
-		if !validRestrictions[restriction] {
-			return errors.New("restrição '" + restriction + "' inválida")
-		}
-	}
-
-	update := bson.M{
-		"$set": bson.M{
-			"name":        recipe.Name,
-			"description": recipe.Description,
-			"cuisine":     recipe.Cuisine,
-			"type_of":     recipe.TypeOf,
-			"image":       recipe.Image,
-			"ingredients": recipe.Ingredients,
-			"difficulty":  recipe.Difficulty,
-			"restriction": recipe.Restriction,
-			"premium":     recipe.Premium,
-			"percentage":  recipe.Percentage,
-		},
+		return err
 	}
-
-	updatedRecipe := m.recipeCollection.FindOneAndUpdate(context.TODO(), bson.M{"_id": objID}, update)
-	if updatedRecipe.Err() != nil {
-		return errors.New("receita não encontrada")
+	if mealType == nil {
+		return &ValidationError{Field: "type_of", Message: "tipo de refeição '" + strconv.Itoa(recipe.TypeOf) + "' não é válido"}
 	}
 
 	return nil
 }
 
+func (m MongoDB) SetRecipeImages(id string, image string, images map[string]string, author string) error {
+	set := bson.M{"image": image, "images": images}
+	return m.changeRecipe(id, nil, set, AnyVersion, author, nil)
+}
+
 func (m MongoDB) DeleteRecipe(id string) error {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -386,11 +552,18 @@ func (m MongoDB) DeleteRecipe(id string) error {
 	return nil
 }
 
-func (m MongoDB) GetRecipesByMultipleCriteria(excludedRestrictions []string, ingredient, typeOf, cuisine string, userPremium bool) ([]*model.Recipe, error) {
-	filter := bson.M{}
+func (m MongoDB) GetRecipesByMultipleCriteria(excludedRestrictions []string, ingredient, typeOf, cuisine string) ([]*model.Recipe, error) {
+	filter := bson.M{"status": model.RecipePublished}
 
 	if typeOf != "" {
-		filter["type_of"] = typeOf
+		mealType, err := m.ResolveMealType(typeOf)
+		if err != nil {
+			return nil, err
+		}
+		if mealType == nil {
+			return nil, &ValidationError{Field: "type_of", Message: "tipo de refeição '" + typeOf + "' não é válido"}
+		}
+		filter["type_of"] = mealType.Code
 	}
 
 	if cuisine != "" {
@@ -437,10 +610,6 @@ func (m MongoDB) GetRecipesByMultipleCriteria(excludedRestrictions []string, ing
 		return nil, nil
 	}
 
-	if !userPremium {
-		filter["premium"] = false
-	}
-
 	cursor, err := m.recipeCollection.Find(context.TODO(), filter)
 	if err != nil {
 		return nil, errors.New("error fetching recipes")
@@ -474,6 +643,16 @@ func (m MongoDB) GetRecipesByMultipleCriteria(excludedRestrictions []string, ing
 
 func (m MongoDB) CreateUser(user *model.User) error {
 	user.Premium = false
+	user.Role = model.RoleUser
+	user.EmailVerified = false
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.RecoveryCodes = nil
+	user.Identities = nil
+	user.ErasureScheduledFor = nil
+	user.PremiumUntil = nil
+	user.ReferralCode = ""
+	user.ID = primitive.NewObjectID()
 
 	_, err := m.userCollection.InsertOne(context.Background(), user)
 	if err != nil {
@@ -483,8 +662,16 @@ func (m MongoDB) CreateUser(user *model.User) error {
 	return nil
 }
 
-func (m MongoDB) DeleteUser(email string) error {
-	_, err := m.userCollection.DeleteOne(context.Background(), bson.M{"email": email})
+func (m MongoDB) DeleteUser(id string) error {
+	filter, err := userFilter(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := m.userCollection.DeleteOne(context.Background(), filter)
+	if err == nil && result.DeletedCount == 0 {
+		return ErrNotFound
+	}
 	if err != nil {
 		log.Println("Failed to delete user:", err)
 		return errors.New("Failed to delete user")
@@ -507,6 +694,23 @@ func (m MongoDB) GetAllUsers() ([]*model.User, error) {
 	return users, nil
 }
 
+func (m MongoDB) GetUserByID(id string) (*model.User, error) {
+	filter, err := userFilter(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var user model.User
+	err = m.userCollection.FindOne(context.Background(), filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (m MongoDB) GetUserByEmail(email string) (*model.User, error) {
 	var user model.User
 	err := m.userCollection.FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
@@ -516,8 +720,37 @@ func (m MongoDB) GetUserByEmail(email string) (*model.User, error) {
 	return &user, nil
 }
 
-func (m MongoDB) AddUserIngredient(email string, ingredient string) error {
-	user, err := m.GetUserByEmail(email)
+// migrateEmailCase lower-cases the e-mails stored before registration did,
+// since they are now looked up lower-cased. When another account already
+// holds the lower-cased address the e-mail is kept and logged, to be sorted
+// out by hand.
+func migrateEmailCase(ctx context.Context, userCollection *mongo.Collection) error {
+	opts := options.Find().SetProjection(bson.M{"email": 1})
+	cursor, err := userCollection.Find(ctx, bson.M{"email": bson.M{"$regex": "[A-Z]"}}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user model.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		_, err := userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"email": strings.ToLower(user.Email)}})
+		if mongo.IsDuplicateKeyError(err) {
+			log.Println("migração de e-mails: outra conta já usa o e-mail de", user.ID.Hex(), "em minúsculas; mantido")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (m MongoDB) AddUserIngredient(id string, ingredient string) error {
+	user, err := m.GetUserByID(id)
 	if err != nil {
 		return err
 	}
@@ -533,7 +766,7 @@ func (m MongoDB) AddUserIngredient(email string, ingredient string) error {
 
 	user.Ingredients = append(user.Ingredients, ingredient)
 
-	filter := bson.M{"email": email}
+	filter := bson.M{"_id": user.ID}
 	update := bson.M{"$set": bson.M{"ingredients": user.Ingredients}}
 	_, err = m.userCollection.UpdateOne(context.Background(), filter, update)
 	if err != nil {
@@ -543,8 +776,8 @@ func (m MongoDB) AddUserIngredient(email string, ingredient string) error {
 	return nil
 }
 
-func (m MongoDB) RemoveUserIngredient(email string, ingredient string) error {
-	user, err := m.GetUserByEmail(email)
+func (m MongoDB) RemoveUserIngredient(id string, ingredient string) error {
+	user, err := m.GetUserByID(id)
 	if err != nil {
 		return err
 	}
@@ -559,7 +792,7 @@ func (m MongoDB) RemoveUserIngredient(email string, ingredient string) error {
 		}
 	}
 
-	filter := bson.M{"email": email}
+	filter := bson.M{"_id": user.ID}
 	update := bson.M{"$set": bson.M{"ingredients": updatedIngredients}}
 	_, err = m.userCollection.UpdateOne(context.Background(), filter, update)
 	if err != nil {
@@ -569,8 +802,8 @@ func (m MongoDB) RemoveUserIngredient(email string, ingredient string) error {
 	return nil
 }
 
-func (m MongoDB) RemoveAllUserIngredients(email string) error {
-	user, err := m.GetUserByEmail(email)
+func (m MongoDB) RemoveAllUserIngredients(id string) error {
+	user, err := m.GetUserByID(id)
 	if err != nil {
 		return err
 	}
@@ -578,7 +811,7 @@ func (m MongoDB) RemoveAllUserIngredients(email string) error {
 		return errors.New("User not found")
 	}
 
-	filter := bson.M{"email": email}
+	filter := bson.M{"_id": user.ID}
 	update := bson.M{"$set": bson.M{"ingredients": []string{}}}
 
 	_, err = m.userCollection.UpdateOne(context.Background(), filter, update)
@@ -589,65 +822,38 @@ func (m MongoDB) RemoveAllUserIngredients(email string) error {
 	return nil
 }
 
-func (m MongoDB) LikeRecipe(email string, recipeID string) error {
-	user, err := m.GetUserByEmail(email)
-	if err != nil {
-		return err
-	}
-	if user == nil {
-		return errors.New("User not found")
-	}
+func (m MongoDB) SetUserPremium(id string, premium bool) error {
+	return m.updateUser(id, bson.M{"premium": premium})
+}
 
-	for _, likedRecipeID := range user.LikedRecipes {
-		if likedRecipeID == recipeID {
-			return errors.New("Recipe already liked by the user")
-		}
-	}
+func (m MongoDB) SetUserEmailVerified(id string) error {
+	return m.updateUser(id, bson.M{"email_verified": true})
+}
 
-	user.LikedRecipes = append(user.LikedRecipes, recipeID)
+func (m MongoDB) SetUserPassword(id string, hash string) error {
+	return m.updateUser(id, bson.M{"password": hash})
+}
 
-	filter := bson.M{"email": email}
-	update := bson.M{"$set": bson.M{"liked_recipes": user.LikedRecipes}}
-	_, err = m.userCollection.UpdateOne(context.Background(), filter, update)
+func userFilter(id string) (bson.M, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return err
+		return nil, errors.New("ID inválido")
 	}
-
-	return nil
+	return bson.M{"_id": objID}, nil
 }
 
-func (m MongoDB) UnlikeRecipe(email string, recipeID string) error {
-	user, err := m.GetUserByEmail(email)
+func (m MongoDB) updateUser(id string, set bson.M) error {
+	filter, err := userFilter(id)
 	if err != nil {
 		return err
 	}
-	if user == nil {
-		return errors.New("User not found")
-	}
-
-	updatedLikedRecipes := make([]string, 0)
-	for _, likedRecipeID := range user.LikedRecipes {
-		if likedRecipeID != recipeID {
-			updatedLikedRecipes = append(updatedLikedRecipes, likedRecipeID)
-		}
-	}
 
-	filter := bson.M{"email": email}
-	update := bson.M{"$set": bson.M{"liked_recipes": updatedLikedRecipes}}
-	_, err = m.userCollection.UpdateOne(context.Background(), filter, update)
+	result, err := m.userCollection.UpdateOne(context.Background(), filter, bson.M{"$set": set})
 	if err != nil {
 		return err
 	}
-
-	return nil
-}
-
-func (m MongoDB) SetUserPremium(email string, premium bool) error {
-	filter := bson.M{"email": email}
-	update := bson.M{"$set": bson.M{"premium": premium}}
-	_, err := m.userCollection.UpdateOne(context.Background(), filter, update)
-	if err != nil {
-		return err
+	if result.MatchedCount == 0 {
+		return ErrNotFound
 	}
 	return nil
 }
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"cucinia/model"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrErasureScheduled = errors.New("a exclusão da conta já foi solicitada")
+
+func setupErasures(erasureCollection *mongo.Collection) {
+	_, err := erasureCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "scheduled_for", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (m MongoDB) GetRecipesByReviewer(reviewerID string) ([]*model.Recipe, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "reviewed_at", Value: -1}})
+	return m.findRecipes(bson.M{"reviewed_by": reviewerID}, opts)
+}
+
+func (m MongoDB) GetRevisionsByAuthor(authorID string) ([]*model.RecipeRevision, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := m.revisionCollection.Find(context.TODO(), bson.M{"author": authorID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	revisions := []*model.RecipeRevision{}
+	if err := cursor.All(context.Background(), &revisions); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (m MongoDB) GetUserErasures(id string) ([]*model.Erasure, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "requested_at", Value: -1}})
+	return m.findErasures(bson.M{"user_id": id}, opts)
+}
+
+// ScheduleUserErasure marks the account for deletion at the given time and
+// records the request.
+func (m MongoDB) ScheduleUserErasure(id string, requestedBy string, at time.Time) (*model.Erasure, error) {
+	filter, err := userFilter(id)
+	if err != nil {
+		return nil, err
+	}
+	filter["erasure_scheduled_for"] = bson.M{"$exists": false}
+
+	result, err := m.userCollection.UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"erasure_scheduled_for": at}})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := m.GetUserByID(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrErasureScheduled
+	}
+
+	erasure := &model.Erasure{
+		ID:           primitive.NewObjectID(),
+		UserID:       id,
+		RequestedBy:  requestedBy,
+		Status:       model.ErasureScheduled,
+		RequestedAt:  time.Now().UTC(),
+		ScheduledFor: at,
+	}
+	if _, err := m.erasureCollection.InsertOne(context.Background(), erasure); err != nil {
+		return nil, err
+	}
+
+	return erasure, nil
+}
+
+func (m MongoDB) CancelUserErasure(id string) error {
+	filter, err := userFilter(id)
+	if err != nil {
+		return err
+	}
+	filter["erasure_scheduled_for"] = bson.M{"$exists": true}
+
+	result, err := m.userCollection.UpdateOne(context.Background(), filter, bson.M{"$unset": bson.M{"erasure_scheduled_for": ""}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	_, err = m.erasureCollection.UpdateMany(context.Background(),
+		bson.M{"user_id": id, "status": model.ErasureScheduled},
+		bson.M{"$set": bson.M{"status": model.ErasureCancelled, "cancelled_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+func (m MongoDB) GetDueErasures(now time.Time) ([]*model.Erasure, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "scheduled_for", Value: 1}})
+	return m.findErasures(bson.M{"status": model.ErasureScheduled, "scheduled_for": bson.M{"$lte": now}}, opts)
+}
+
+// EraseUser removes the account and everything that points to it.
+// Unpublished recipes are deleted along with their revisions; published
+// recipes, moderation decisions and revisions stay but lose every reference
+// to the user. The user document goes last, so a failure halfway can be
+// retried.
+func (m MongoDB) EraseUser(id string) (*model.Erasure, error) {
+	filter, err := userFilter(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	removed := map[string]int64{}
+
+	unpublished, err := m.recipeCollection.Distinct(ctx, "_id", bson.M{"author_id": id, "status": bson.M{"$ne": model.RecipePublished}})
+	if err != nil {
+		return nil, err
+	}
+	if len(unpublished) > 0 {
+		result, err := m.revisionCollection.DeleteMany(ctx, bson.M{"recipe_id": bson.M{"$in": unpublished}})
+		if err != nil {
+			return nil, err
+		}
+		removed["revisions"] = result.DeletedCount
+
+		result, err = m.recipeCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": unpublished}})
+		if err != nil {
+			return nil, err
+		}
+		removed["recipes"] = result.DeletedCount
+	}
+
+	anonymize := []struct {
+		name       string
+		collection *mongo.Collection
+		filter     bson.M
+		update     bson.M
+	}{
+		{"recipes_anonymized", m.recipeCollection, bson.M{"author_id": id}, bson.M{"$unset": bson.M{"author_id": "", "author_name": ""}}},
+		{"reviews_anonymized", m.recipeCollection, bson.M{"reviewed_by": id}, bson.M{"$unset": bson.M{"reviewed_by": ""}}},
+		{"revisions_anonymized", m.revisionCollection, bson.M{"author": id}, bson.M{"$set": bson.M{"author": ""}}},
+		{"snapshots_anonymized", m.revisionCollection, bson.M{"snapshot.author_id": id}, bson.M{"$unset": bson.M{"snapshot.author_id": "", "snapshot.author_name": ""}}},
+		{"snapshot_reviews_anonymized", m.revisionCollection, bson.M{"snapshot.reviewed_by": id}, bson.M{"$unset": bson.M{"snapshot.reviewed_by": ""}}},
+	}
+	for _, a := range anonymize {
+		result, err := a.collection.UpdateMany(ctx, a.filter, a.update)
+		if err != nil {
+			return nil, err
+		}
+		removed[a.name] = result.ModifiedCount
+	}
+
+	// The audit log keeps the erased user's ID, like the erasure record,
+	// but not the IPs they acted from nor their name in recipe snapshots
+	// recorded before names were left out.
+	pseudonymize := []struct {
+		filter bson.M
+		unset  string
+	}{
+		{bson.M{"actor_id": id, "ip": bson.M{"$exists": true}}, "ip"},
+		{bson.M{"before.author_id": id, "before.author_name": bson.M{"$exists": true}}, "before.author_name"},
+		{bson.M{"after.author_id": id, "after.author_name": bson.M{"$exists": true}}, "after.author_name"},
+	}
+	for _, p := range pseudonymize {
+		result, err := m.auditCollection.UpdateMany(ctx, p.filter, bson.M{"$unset": bson.M{p.unset: ""}})
+		if err != nil {
+			return nil, err
+		}
+		removed["audit_pseudonymized"] += result.ModifiedCount
+	}
+
+	result, err := m.likeCollection.DeleteMany(ctx, bson.M{"user_id": id})
+	if err != nil {
+		return nil, err
+	}
+	removed["likes"] = result.DeletedCount
+
+	result, err = m.referralCollection.DeleteMany(ctx, bson.M{"$or": []bson.M{{"referrer_id": id}, {"invitee_id": id}}})
+	if err != nil {
+		return nil, err
+	}
+	removed["referrals"] = result.DeletedCount
+
+	result, err = m.redemptionCollection.DeleteMany(ctx, bson.M{"user_id": id})
+	if err != nil {
+		return nil, err
+	}
+	removed["coupon_redemptions"] = result.DeletedCount
+
+	result, err = m.userCollection.DeleteOne(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	removed["users"] = result.DeletedCount
+
+	now := time.Now().UTC()
+	var erasure model.Erasure
+	err = m.erasureCollection.FindOneAndUpdate(ctx,
+		bson.M{"user_id": id, "status": model.ErasureScheduled},
+		bson.M{
+			"$set":         bson.M{"status": model.ErasureCompleted, "completed_at": now, "removed": removed},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "requested_by": id, "requested_at": now, "scheduled_for": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&erasure)
+	if err != nil {
+		return nil, err
+	}
+
+	return &erasure, nil
+}
+
+func (m MongoDB) findErasures(filter bson.M, opts ...*options.FindOptions) ([]*model.Erasure, error) {
+	cursor, err := m.erasureCollection.Find(context.TODO(), filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	erasures := []*model.Erasure{}
+	if err := cursor.All(context.Background(), &erasures); err != nil {
+		return nil, err
+	}
+
+	return erasures, nil
+}
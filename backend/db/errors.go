@@ -0,0 +1,18 @@
+package db
+
+import "errors"
+
+var ErrNotFound = errors.New("registro não encontrado")
+
+var ErrEmailInUse = errors.New("e-mail já cadastrado")
+
+// ValidationError reports invalid input so handlers can answer with 400
+// instead of treating it as a database failure.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"cucinia/model"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func setupIdentities(userCollection *mongo.Collection) {
+	_, err := userCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"identities.subject": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (m MongoDB) GetUserByIdentity(provider string, subject string) (*model.User, error) {
+	var user model.User
+	err := m.userCollection.FindOne(context.Background(), bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (m MongoDB) LinkUserIdentity(id string, identity model.Identity) error {
+	filter, err := userFilter(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := m.userCollection.UpdateOne(context.Background(),
+		filter,
+		bson.M{"$addToSet": bson.M{"identities": identity}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
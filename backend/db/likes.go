@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"cucinia/model"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func setupLikes(likeCollection *mongo.Collection) {
+	_, err := likeCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "recipe_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "recipe_id", Value: 1}}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// LikeRecipe records that the user likes the recipe and reports whether the
+// like is new. Liking a recipe twice changes nothing.
+func (m MongoDB) LikeRecipe(userID string, recipeID string) (bool, error) {
+	filter, err := userFilter(userID)
+	if err != nil {
+		return false, err
+	}
+
+	like := model.Like{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		RecipeID:  recipeID,
+		CreatedAt: time.Now().UTC(),
+	}
+	_, err = m.likeCollection.InsertOne(context.Background(), like)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = m.userCollection.UpdateOne(context.Background(), filter, bson.M{"$addToSet": bson.M{"liked_recipes": recipeID}})
+	return true, err
+}
+
+// UnlikeRecipe removes the user's like of the recipe and returns it, or nil
+// if there was none.
+func (m MongoDB) UnlikeRecipe(userID string, recipeID string) (*model.Like, error) {
+	filter, err := userFilter(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var like model.Like
+	err = m.likeCollection.FindOneAndDelete(context.Background(), bson.M{"user_id": userID, "recipe_id": recipeID}).Decode(&like)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = m.userCollection.UpdateOne(context.Background(), filter, bson.M{"$pull": bson.M{"liked_recipes": recipeID}})
+	return &like, err
+}
+
+// migrateLikes creates the like records for the likes kept only in the
+// users' liked_recipes. Their time is unknown, so they count from now.
+func migrateLikes(ctx context.Context, userCollection, likeCollection *mongo.Collection) error {
+	opts := options.Find().SetProjection(bson.M{"liked_recipes": 1})
+	cursor, err := userCollection.Find(ctx, bson.M{"liked_recipes.0": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	now := time.Now().UTC()
+	for cursor.Next(ctx) {
+		var user model.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		for _, recipeID := range user.LikedRecipes {
+			like := model.Like{ID: primitive.NewObjectID(), UserID: user.ID.Hex(), RecipeID: recipeID, CreatedAt: now}
+			if _, err := likeCollection.InsertOne(ctx, like); err != nil && !mongo.IsDuplicateKeyError(err) {
+				return err
+			}
+		}
+	}
+	return cursor.Err()
+}
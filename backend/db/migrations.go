@@ -0,0 +1,43 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migration is a one-off change to data already stored. Its id is recorded
+// in the migrations collection once it succeeds, so it never runs again.
+type migration struct {
+	id  string
+	run func(ctx context.Context) error
+}
+
+// runMigrations runs, in order, the migrations not yet recorded as done. It
+// stops at the first failure; that migration and the ones after it are
+// tried again on the next start. Migrations must be safe to run twice, as
+// two instances starting together may both run one.
+func runMigrations(collection *mongo.Collection, migrations []migration) error {
+	ctx := context.Background()
+	for _, m := range migrations {
+		err := collection.FindOne(ctx, bson.M{"_id": m.id}).Err()
+		if err == nil {
+			continue
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		if err := m.run(ctx); err != nil {
+			return fmt.Errorf("migração %s: %w", m.id, err)
+		}
+		_, err = collection.InsertOne(ctx, bson.M{"_id": m.id, "applied_at": time.Now().UTC()})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
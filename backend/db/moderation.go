@@ -0,0 +1,202 @@
+package db
+
+import (
+	"context"
+	"cucinia/model"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrInvalidTransition = errors.New("a receita não está em um estado que permita essa ação")
+
+// setupModeration marks recipes created before the moderation workflow as
+// published so they keep showing up in public listings, and gives them a
+// version to start revisions from.
+func setupModeration(recipeCollection *mongo.Collection) {
+	_, err := recipeCollection.UpdateMany(context.Background(),
+		bson.M{"status": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"status": model.RecipePublished}},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = recipeCollection.UpdateMany(context.Background(),
+		bson.M{"version": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"version": 0}},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = recipeCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "submitted_at", Value: 1}}},
+		{Keys: bson.D{{Key: "author_id", Value: 1}}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (m MongoDB) CreateSubmission(recipe *model.Recipe) error {
+	recipe.ID = primitive.NewObjectID()
+	recipe.Status = model.RecipeDraft
+	recipe.Premium = false
+	recipe.Image = ""
+	recipe.Images = nil
+	recipe.Percentage = 0
+	recipe.SubmittedAt = nil
+	recipe.ReviewedBy = ""
+	recipe.ReviewedAt = nil
+	recipe.ReviewComment = ""
+	recipe.Version = 1
+
+	_, err := m.recipeCollection.InsertOne(context.TODO(), recipe)
+	if err != nil {
+		return err
+	}
+
+	return m.insertRevision(recipe, recipe.AuthorID, nil, nil)
+}
+
+// UpdateSubmission edits a draft or rejected recipe of its author. Editing a
+// rejected recipe moves it back to draft so it can be submitted again.
+func (m MongoDB) UpdateSubmission(id string, recipe *model.Recipe, expectedVersion int) error {
+	filter := bson.M{
+		"author_id": recipe.AuthorID,
+		"status":    bson.M{"$in": bson.A{model.RecipeDraft, model.RecipeRejected}},
+	}
+
+	set := recipeContent(recipe)
+	delete(set, "image")
+	delete(set, "images")
+	delete(set, "premium")
+	delete(set, "percentage")
+
+	err := m.changeRecipe(id, filter, set, expectedVersion, recipe.AuthorID, nil)
+	if errors.Is(err, ErrNotFound) {
+		return ErrInvalidTransition
+	}
+	if err != nil {
+		return err
+	}
+
+	return m.transitionRecipe(mustObjectID(id), []string{model.RecipeDraft, model.RecipeRejected}, bson.M{"status": model.RecipeDraft})
+}
+
+func (m MongoDB) SubmitRecipe(id string) error {
+	recipe, err := m.GetRecipeByID(id)
+	if err != nil {
+		return err
+	}
+	if recipe == nil {
+		return ErrNotFound
+	}
+
+	if err := m.validateRecipe(recipe); err != nil {
+		return err
+	}
+	if err := m.checkRecipeNameAvailable(recipe); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	return m.transitionRecipe(recipe.ID, []string{model.RecipeDraft}, bson.M{
+		"status":       model.RecipePending,
+		"submitted_at": now,
+	})
+}
+
+func (m MongoDB) ReviewRecipe(id string, approve bool, reviewer, comment string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("ID inválido")
+	}
+
+	status := model.RecipeRejected
+	if approve {
+		recipe, err := m.GetRecipeByID(id)
+		if err != nil {
+			return err
+		}
+		if recipe == nil {
+			return ErrNotFound
+		}
+		if err := m.checkRecipeNameAvailable(recipe); err != nil {
+			return err
+		}
+		status = model.RecipePublished
+	}
+
+	return m.transitionRecipe(objID, []string{model.RecipePending}, bson.M{
+		"status":         status,
+		"reviewed_by":    reviewer,
+		"reviewed_at":    time.Now().UTC(),
+		"review_comment": comment,
+	})
+}
+
+func (m MongoDB) transitionRecipe(id primitive.ObjectID, from []string, set bson.M) error {
+	res, err := m.recipeCollection.UpdateOne(context.TODO(),
+		bson.M{"_id": id, "status": bson.M{"$in": from}},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrInvalidTransition
+	}
+	return nil
+}
+
+func (m MongoDB) checkRecipeNameAvailable(recipe *model.Recipe) error {
+	count, err := m.recipeCollection.CountDocuments(context.TODO(), bson.M{
+		"_id":    bson.M{"$ne": recipe.ID},
+		"name":   recipe.Name,
+		"status": model.RecipePublished,
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return &ValidationError{Field: "name", Message: "A receita '" + recipe.Name + "' já existe"}
+	}
+	return nil
+}
+
+func (m MongoDB) GetRecipesByAuthor(authorID string) ([]*model.Recipe, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	return m.findRecipes(bson.M{"author_id": authorID}, opts)
+}
+
+func (m MongoDB) GetRecipesByStatus(status string) ([]*model.Recipe, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "submitted_at", Value: 1}})
+	return m.findRecipes(bson.M{"status": status}, opts)
+}
+
+func (m MongoDB) findRecipes(filter bson.M, opts ...*options.FindOptions) ([]*model.Recipe, error) {
+	cursor, err := m.recipeCollection.Find(context.TODO(), filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	recipes := []*model.Recipe{}
+	if err := cursor.All(context.Background(), &recipes); err != nil {
+		return nil, err
+	}
+
+	return recipes, nil
+}
+
+func mustObjectID(id string) primitive.ObjectID {
+	objID, _ := primitive.ObjectIDFromHex(id)
+	return objID
+}
@@ -0,0 +1,424 @@
+package db
+
+import (
+	"context"
+	"cucinia/model"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrCodeInUse = errors.New("código já existe")
+
+var ErrCouponRedeemed = errors.New("cupom já utilizado")
+
+var ErrReferralExists = errors.New("usuário já foi indicado")
+
+// CouponUpdate holds the coupon fields admins may change after creation.
+// Nil fields are left alone.
+type CouponUpdate struct {
+	Description    *string
+	ExpiresAt      *time.Time
+	MaxRedemptions *int
+	Active         *bool
+}
+
+func setupPromotions(couponCollection, redemptionCollection, referralCollection, userCollection *mongo.Collection) {
+	ctx := context.Background()
+	indexes := []struct {
+		collection *mongo.Collection
+		models     []mongo.IndexModel
+	}{
+		{couponCollection, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		}},
+		{redemptionCollection, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "coupon_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		}},
+		{referralCollection, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "invitee_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "referrer_id", Value: 1}}},
+		}},
+		{userCollection, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "referral_code", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+			{Keys: bson.D{{Key: "premium_until", Value: 1}}, Options: options.Index().SetSparse(true)},
+		}},
+	}
+	for _, index := range indexes {
+		if _, err := index.collection.Indexes().CreateMany(ctx, index.models); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func (m MongoDB) CreateCoupon(coupon *model.Coupon) error {
+	coupon.ID = primitive.NewObjectID()
+	coupon.Redemptions = 0
+	coupon.Active = true
+	coupon.CreatedAt = time.Now().UTC()
+
+	_, err := m.couponCollection.InsertOne(context.Background(), coupon)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrCodeInUse
+	}
+	return err
+}
+
+func (m MongoDB) GetCoupons() ([]*model.Coupon, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := m.couponCollection.Find(context.TODO(), bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	coupons := []*model.Coupon{}
+	if err := cursor.All(context.Background(), &coupons); err != nil {
+		return nil, err
+	}
+	return coupons, nil
+}
+
+func (m MongoDB) GetCouponByID(id string) (*model.Coupon, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return m.findCoupon(bson.M{"_id": objID})
+}
+
+func (m MongoDB) GetCouponByCode(code string) (*model.Coupon, error) {
+	return m.findCoupon(bson.M{"code": code})
+}
+
+func (m MongoDB) UpdateCoupon(id string, update CouponUpdate) (*model.Coupon, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	set := bson.M{}
+	if update.Description != nil {
+		set["description"] = *update.Description
+	}
+	if update.ExpiresAt != nil {
+		set["expires_at"] = *update.ExpiresAt
+	}
+	if update.MaxRedemptions != nil {
+		set["max_redemptions"] = *update.MaxRedemptions
+	}
+	if update.Active != nil {
+		set["active"] = *update.Active
+	}
+	if len(set) > 0 {
+		result, err := m.couponCollection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{"$set": set})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrNotFound
+		}
+	}
+
+	return m.GetCouponByID(id)
+}
+
+// GetRedeemableCoupon returns the coupon with code if userID may still
+// redeem it on plan.
+func (m MongoDB) GetRedeemableCoupon(code string, userID string, plan string) (*model.Coupon, error) {
+	coupon, err := m.GetCouponByCode(code)
+	if errors.Is(err, ErrNotFound) {
+		return nil, &ValidationError{Field: "coupon", Message: "cupom inválido"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCoupon(coupon, plan, time.Now()); err != nil {
+		return nil, err
+	}
+
+	count, err := m.redemptionCollection.CountDocuments(context.Background(), bson.M{"coupon_id": coupon.ID, "user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrCouponRedeemed
+	}
+	return coupon, nil
+}
+
+func checkCoupon(coupon *model.Coupon, plan string, now time.Time) error {
+	if !coupon.Active || (coupon.ExpiresAt != nil && !coupon.ExpiresAt.After(now)) {
+		return &ValidationError{Field: "coupon", Message: "cupom expirado"}
+	}
+	if coupon.MaxRedemptions > 0 && coupon.Redemptions >= coupon.MaxRedemptions {
+		return &ValidationError{Field: "coupon", Message: "cupom esgotado"}
+	}
+	if plan != "" && len(coupon.Plans) > 0 {
+		for _, p := range coupon.Plans {
+			if p == plan {
+				return nil
+			}
+		}
+		return &ValidationError{Field: "coupon", Message: "cupom não vale para este plano"}
+	}
+	return nil
+}
+
+// RedeemCoupon uses up one redemption of the coupon for userID. Limits are
+// enforced atomically, so concurrent redemptions cannot go past
+// MaxRedemptions or redeem twice for the same user.
+func (m MongoDB) RedeemCoupon(code string, userID string, plan string) (*model.Coupon, *model.CouponRedemption, error) {
+	coupon, err := m.GetRedeemableCoupon(code, userID, plan)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx := context.Background()
+	redemption := &model.CouponRedemption{
+		ID:         primitive.NewObjectID(),
+		CouponID:   coupon.ID,
+		Code:       coupon.Code,
+		UserID:     userID,
+		RedeemedAt: time.Now().UTC(),
+	}
+	if _, err := m.redemptionCollection.InsertOne(ctx, redemption); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, nil, ErrCouponRedeemed
+		}
+		return nil, nil, err
+	}
+
+	result, err := m.couponCollection.UpdateOne(ctx, bson.M{
+		"_id":    coupon.ID,
+		"active": true,
+		"$or": []bson.M{
+			{"max_redemptions": 0},
+			{"$expr": bson.M{"$lt": bson.A{"$redemptions", "$max_redemptions"}}},
+		},
+	}, bson.M{"$inc": bson.M{"redemptions": 1}})
+	if err == nil && result.MatchedCount == 0 {
+		err = &ValidationError{Field: "coupon", Message: "cupom esgotado"}
+	}
+	if err != nil {
+		m.redemptionCollection.DeleteOne(ctx, bson.M{"_id": redemption.ID})
+		return nil, nil, err
+	}
+
+	coupon.Redemptions++
+	return coupon, redemption, nil
+}
+
+// SetRedemptionSubscription links a redemption to the subscription it was
+// used on.
+func (m MongoDB) SetRedemptionSubscription(redemptionID string, subscriptionID string) error {
+	objID, err := primitive.ObjectIDFromHex(redemptionID)
+	if err != nil {
+		return ErrNotFound
+	}
+	_, err = m.redemptionCollection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{"$set": bson.M{"subscription_id": subscriptionID}})
+	return err
+}
+
+// ReleaseCouponRedemption undoes RedeemCoupon when the subscription it was
+// meant for could not be started.
+func (m MongoDB) ReleaseCouponRedemption(redemptionID string) error {
+	objID, err := primitive.ObjectIDFromHex(redemptionID)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	var redemption model.CouponRedemption
+	err = m.redemptionCollection.FindOneAndDelete(context.Background(), bson.M{"_id": objID}).Decode(&redemption)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = m.couponCollection.UpdateOne(context.Background(),
+		bson.M{"_id": redemption.CouponID, "redemptions": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"redemptions": -1}},
+	)
+	return err
+}
+
+func (m MongoDB) GetCouponRedemptions(couponID string) ([]*model.CouponRedemption, error) {
+	objID, err := primitive.ObjectIDFromHex(couponID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return m.findRedemptions(bson.M{"coupon_id": objID})
+}
+
+func (m MongoDB) GetUserRedemptions(userID string) ([]*model.CouponRedemption, error) {
+	return m.findRedemptions(bson.M{"user_id": userID})
+}
+
+// SetUserReferralCode gives the user a referral code unless they already
+// have one. It returns ErrCodeInUse when another user has code.
+func (m MongoDB) SetUserReferralCode(id string, code string) error {
+	filter, err := userFilter(id)
+	if err != nil {
+		return err
+	}
+	filter["referral_code"] = bson.M{"$exists": false}
+
+	_, err = m.userCollection.UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"referral_code": code}})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrCodeInUse
+	}
+	return err
+}
+
+func (m MongoDB) GetUserByReferralCode(code string) (*model.User, error) {
+	var user model.User
+	err := m.userCollection.FindOne(context.Background(), bson.M{"referral_code": code}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (m MongoDB) CreateReferral(referral *model.Referral) error {
+	referral.ID = primitive.NewObjectID()
+	referral.CreatedAt = time.Now().UTC()
+
+	_, err := m.referralCollection.InsertOne(context.Background(), referral)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrReferralExists
+	}
+	return err
+}
+
+// CountRewardedReferrals counts the referrals that earned referrerID
+// premium days.
+func (m MongoDB) CountRewardedReferrals(referrerID string) (int, error) {
+	count, err := m.referralCollection.CountDocuments(context.Background(), bson.M{
+		"referrer_id":   referrerID,
+		"referrer_days": bson.M{"$gt": 0},
+	})
+	return int(count), err
+}
+
+// GetUserReferrals lists the referrals the user took part in, as referrer
+// or invitee.
+func (m MongoDB) GetUserReferrals(userID string) ([]*model.Referral, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := m.referralCollection.Find(context.TODO(), bson.M{"$or": []bson.M{
+		{"referrer_id": userID},
+		{"invitee_id": userID},
+	}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	referrals := []*model.Referral{}
+	if err := cursor.All(context.Background(), &referrals); err != nil {
+		return nil, err
+	}
+	return referrals, nil
+}
+
+// ExtendUserPremium grants premium for d more, counted from the end of the
+// current grant or from now, and returns when the grant ends.
+func (m MongoDB) ExtendUserPremium(id string, d time.Duration) (time.Time, error) {
+	filter, err := userFilter(id)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now().UTC()
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"premium": true,
+		"premium_until": bson.M{"$add": bson.A{
+			bson.M{"$max": bson.A{now, bson.M{"$ifNull": bson.A{"$premium_until", now}}}},
+			d.Milliseconds(),
+		}},
+	}}}}
+
+	var user model.User
+	err = m.userCollection.FindOneAndUpdate(context.Background(), filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return *user.PremiumUntil, nil
+}
+
+// GetExpiredPremiumGrants lists users whose granted premium ran out before
+// now.
+func (m MongoDB) GetExpiredPremiumGrants(now time.Time) ([]*model.User, error) {
+	cursor, err := m.userCollection.Find(context.TODO(), bson.M{"premium_until": bson.M{"$lte": now}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	users := []*model.User{}
+	if err := cursor.All(context.Background(), &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ClearPremiumGrant forgets a grant that ran out before now. It returns
+// ErrInvalidTransition when the grant was extended in the meantime.
+func (m MongoDB) ClearPremiumGrant(id string, now time.Time) error {
+	filter, err := userFilter(id)
+	if err != nil {
+		return err
+	}
+	filter["premium_until"] = bson.M{"$lte": now}
+
+	result, err := m.userCollection.UpdateOne(context.Background(), filter, bson.M{"$unset": bson.M{"premium_until": ""}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidTransition
+	}
+	return nil
+}
+
+func (m MongoDB) findCoupon(filter bson.M) (*model.Coupon, error) {
+	var coupon model.Coupon
+	err := m.couponCollection.FindOne(context.Background(), filter).Decode(&coupon)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+func (m MongoDB) findRedemptions(filter bson.M) ([]*model.CouponRedemption, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "redeemed_at", Value: -1}})
+	cursor, err := m.redemptionCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	redemptions := []*model.CouponRedemption{}
+	if err := cursor.All(context.Background(), &redemptions); err != nil {
+		return nil, err
+	}
+	return redemptions, nil
+}
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"context"
+	"cucinia/model"
+	"errors"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AnyVersion skips the optimistic concurrency check of a recipe change.
+const AnyVersion = -1
+
+var ErrVersionConflict = errors.New("a receita foi alterada por outra pessoa, recarregue e tente novamente")
+
+// mapDocuments makes a collection decode embedded documents held in
+// interface{} values as maps, which keeps them readable once encoded as
+// JSON.
+func mapDocuments() *options.CollectionOptions {
+	registry := bson.NewRegistryBuilder().
+		RegisterTypeMapEntry(bsontype.EmbeddedDocument, reflect.TypeOf(bson.M{})).
+		Build()
+	return options.Collection().SetRegistry(registry)
+}
+
+func revisionCollection(database *mongo.Database) *mongo.Collection {
+	// Field diffs hold arbitrary values.
+	collection := database.Collection("recipe_revisions", mapDocuments())
+
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "recipe_id", Value: 1}, {Key: "rev", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return collection
+}
+
+// recipeContent lists the fields of a recipe that are versioned.
+func recipeContent(recipe *model.Recipe) bson.M {
+	return bson.M{
+		"name":        recipe.Name,
+		"description": recipe.Description,
+		"cuisine":     recipe.Cuisine,
+		"type_of":     recipe.TypeOf,
+		"image":       recipe.Image,
+		"images":      recipe.Images,
+		"ingredients": recipe.Ingredients,
+		"difficulty":  recipe.Difficulty,
+		"restriction": recipe.Restriction,
+		"premium":     recipe.Premium,
+		"percentage":  recipe.Percentage,
+	}
+}
+
+func diffContent(before bson.M, set bson.M) []model.FieldChange {
+	fields := make([]string, 0, len(set))
+	for field := range set {
+		if _, versioned := before[field]; versioned {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var changes []model.FieldChange
+	for _, field := range fields {
+		if !sameValue(before[field], set[field]) {
+			changes = append(changes, model.FieldChange{Field: field, From: before[field], To: set[field]})
+		}
+	}
+	return changes
+}
+
+func sameValue(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if isEmptyCollection(va) && isEmptyCollection(vb) {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func isEmptyCollection(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return false
+}
+
+// changeRecipe applies set to a recipe, bumps its version and stores the
+// result as a new immutable revision. filter narrows which recipes may be
+// changed (e.g. only drafts of a given author).
+func (m MongoDB) changeRecipe(id string, filter bson.M, set bson.M, expectedVersion int, author string, restoredFrom *int) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("ID inválido")
+	}
+
+	query := bson.M{"_id": objID}
+	for k, v := range filter {
+		query[k] = v
+	}
+
+	var current model.Recipe
+	if err := m.recipeCollection.FindOne(context.TODO(), query).Decode(&current); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+	if expectedVersion != AnyVersion && current.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+
+	changes := diffContent(recipeContent(&current), set)
+	if len(changes) == 0 && restoredFrom == nil {
+		return nil
+	}
+
+	if err := m.ensureBaselineRevision(&current); err != nil {
+		return err
+	}
+
+	query["version"] = current.Version
+	var updated model.Recipe
+	err = m.recipeCollection.FindOneAndUpdate(context.TODO(), query,
+		bson.M{"$set": set, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	return m.insertRevision(&updated, author, changes, restoredFrom)
+}
+
+// ensureBaselineRevision records the state of recipes created before
+// revisions existed, so their first version can still be restored.
+func (m MongoDB) ensureBaselineRevision(recipe *model.Recipe) error {
+	count, err := m.revisionCollection.CountDocuments(context.TODO(), bson.M{"recipe_id": recipe.ID})
+	if err != nil || count > 0 {
+		return err
+	}
+	return m.insertRevision(recipe, recipe.AuthorID, nil, nil)
+}
+
+func (m MongoDB) insertRevision(recipe *model.Recipe, author string, changes []model.FieldChange, restoredFrom *int) error {
+	revision := model.RecipeRevision{
+		ID:           primitive.NewObjectID(),
+		RecipeID:     recipe.ID,
+		Rev:          recipe.Version,
+		Author:       author,
+		CreatedAt:    time.Now().UTC(),
+		Changes:      changes,
+		RestoredFrom: restoredFrom,
+		Snapshot:     *recipe,
+	}
+	if revision.Changes == nil {
+		revision.Changes = []model.FieldChange{}
+	}
+
+	_, err := m.revisionCollection.InsertOne(context.TODO(), revision)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrVersionConflict
+	}
+	return err
+}
+
+func (m MongoDB) GetRecipeRevisions(recipeID string) ([]*model.RecipeRevision, error) {
+	objID, err := primitive.ObjectIDFromHex(recipeID)
+	if err != nil {
+		return nil, errors.New("ID inválido")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "rev", Value: -1}})
+	cursor, err := m.revisionCollection.Find(context.TODO(), bson.M{"recipe_id": objID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	revisions := []*model.RecipeRevision{}
+	if err := cursor.All(context.Background(), &revisions); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (m MongoDB) RestoreRecipeRevision(recipeID string, rev int, expectedVersion int, author string) error {
+	objID, err := primitive.ObjectIDFromHex(recipeID)
+	if err != nil {
+		return errors.New("ID inválido")
+	}
+
+	var revision model.RecipeRevision
+	err = m.revisionCollection.FindOne(context.TODO(), bson.M{"recipe_id": objID, "rev": rev}).Decode(&revision)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := m.validateRecipe(&revision.Snapshot); err != nil {
+		return err
+	}
+
+	return m.changeRecipe(recipeID, nil, recipeContent(&revision.Snapshot), expectedVersion, author, &rev)
+}
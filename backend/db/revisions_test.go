@@ -0,0 +1,28 @@
+package db
+
+import (
+	"cucinia/model"
+	"testing"
+)
+
+func TestDiffContentPercentage(t *testing.T) {
+	before := &model.Recipe{Name: "Bolo", Cuisine: "brasileira", TypeOf: 1, Ingredients: []string{"ovo"}, Percentage: 10}
+	after := *before
+	after.Percentage = 50
+
+	// What UpdateRecipe sets for a PATCH.
+	set := recipeContent(&after)
+	delete(set, "images")
+
+	changes := diffContent(recipeContent(before), set)
+	if len(changes) != 1 {
+		t.Fatalf("changes = %+v, want one", changes)
+	}
+	if c := changes[0]; c.Field != "percentage" || c.From != 10.0 || c.To != 50.0 {
+		t.Errorf("change = %+v", c)
+	}
+
+	if changes := diffContent(recipeContent(before), recipeContent(before)); len(changes) != 0 {
+		t.Errorf("unchanged recipe: changes = %+v", changes)
+	}
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"cucinia/model"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (m MongoDB) GetRecipeStats() ([]*model.RecipeStats, error) {
+	cursor, err := m.statsCollection.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var stats []*model.RecipeStats
+	if err := cursor.All(context.Background(), &stats); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (m MongoDB) SaveRecipeStats(stats []*model.RecipeStats) error {
+	if len(stats) == 0 {
+		return nil
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(stats))
+	for _, s := range stats {
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": s.RecipeID}).
+			SetReplacement(s).
+			SetUpsert(true))
+	}
+
+	_, err := m.statsCollection.BulkWrite(context.TODO(), writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func (m MongoDB) DeleteRecipeStats(recipeID string) error {
+	objID, err := primitive.ObjectIDFromHex(recipeID)
+	if err != nil {
+		return errors.New("ID inválido")
+	}
+
+	_, err = m.statsCollection.DeleteOne(context.TODO(), bson.M{"_id": objID})
+	return err
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"cucinia/model"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrSubscriptionExists = errors.New("já existe uma assinatura em andamento")
+
+var ErrEventProcessed = errors.New("evento já processado")
+
+// liveSubscriptionStatuses are the statuses of a subscription that has not
+// ended. A user has at most one.
+var liveSubscriptionStatuses = []string{
+	model.SubscriptionIncomplete,
+	model.SubscriptionTrialing,
+	model.SubscriptionActive,
+	model.SubscriptionPastDue,
+}
+
+func setupSubscriptions(subscriptionCollection *mongo.Collection) {
+	_, err := subscriptionCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "current_period_end", Value: 1}}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (m MongoDB) CreateSubscription(subscription *model.Subscription) error {
+	count, err := m.subscriptionCollection.CountDocuments(context.Background(), bson.M{
+		"user_id": subscription.UserID,
+		"status":  bson.M{"$in": liveSubscriptionStatuses},
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrSubscriptionExists
+	}
+
+	subscription.ID = primitive.NewObjectID()
+	subscription.CreatedAt = time.Now().UTC()
+	_, err = m.subscriptionCollection.InsertOne(context.Background(), subscription)
+	return err
+}
+
+func (m MongoDB) GetSubscriptionByID(id string) (*model.Subscription, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return m.findSubscription(bson.M{"_id": objID})
+}
+
+// GetCurrentSubscription returns the subscription of the user that has not
+// ended yet.
+func (m MongoDB) GetCurrentSubscription(userID string) (*model.Subscription, error) {
+	return m.findSubscription(bson.M{"user_id": userID, "status": bson.M{"$in": liveSubscriptionStatuses}})
+}
+
+func (m MongoDB) GetUserSubscriptions(userID string) ([]*model.Subscription, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	return m.findSubscriptions(bson.M{"user_id": userID}, opts)
+}
+
+// HasUsedTrial tells whether the user ever had a trial, which is only
+// granted once.
+func (m MongoDB) HasUsedTrial(userID string) (bool, error) {
+	count, err := m.subscriptionCollection.CountDocuments(context.Background(), bson.M{
+		"user_id":       userID,
+		"trial_ends_at": bson.M{"$exists": true},
+	})
+	return count > 0, err
+}
+
+func (m MongoDB) SetSubscriptionExternalID(id string, externalID string) error {
+	return m.updateSubscription(id, nil, bson.M{"$set": bson.M{"external_id": externalID}})
+}
+
+// ActivateSubscription records a paid period. Late payments bring an ended
+// subscription back, since the user was charged.
+func (m MongoDB) ActivateSubscription(id string, externalID string, start, end time.Time) error {
+	set := bson.M{
+		"status":               model.SubscriptionActive,
+		"current_period_start": start,
+		"current_period_end":   end,
+	}
+	if externalID != "" {
+		set["external_id"] = externalID
+	}
+	return m.updateSubscription(id, nil, bson.M{"$set": set, "$unset": bson.M{"ended_at": ""}})
+}
+
+func (m MongoDB) SetSubscriptionPastDue(id string) error {
+	return m.updateSubscription(id,
+		[]string{model.SubscriptionActive, model.SubscriptionTrialing},
+		bson.M{"$set": bson.M{"status": model.SubscriptionPastDue}},
+	)
+}
+
+// CancelSubscription stops renewals. The user keeps access until the end
+// of the period already paid or of the trial; a subscription that was never
+// paid ends at once.
+func (m MongoDB) CancelSubscription(id string) (*model.Subscription, error) {
+	subscription, err := m.GetSubscriptionByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	switch subscription.Status {
+	case model.SubscriptionIncomplete:
+		err = m.updateSubscription(id, []string{model.SubscriptionIncomplete}, bson.M{"$set": bson.M{
+			"status":      model.SubscriptionCanceled,
+			"canceled_at": now,
+			"ended_at":    now,
+		}})
+	case model.SubscriptionTrialing, model.SubscriptionActive, model.SubscriptionPastDue:
+		err = m.updateSubscription(id, liveSubscriptionStatuses, bson.M{"$set": bson.M{
+			"cancel_at_period_end": true,
+			"canceled_at":          now,
+		}})
+	default:
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetSubscriptionByID(id)
+}
+
+// GetDueSubscriptions lists subscriptions whose period or trial is over, and
+// unpaid ones created before incompleteBefore.
+func (m MongoDB) GetDueSubscriptions(now time.Time, incompleteBefore time.Time) ([]*model.Subscription, error) {
+	return m.findSubscriptions(bson.M{"$or": []bson.M{
+		{
+			"status":             bson.M{"$in": []string{model.SubscriptionTrialing, model.SubscriptionActive, model.SubscriptionPastDue}},
+			"current_period_end": bson.M{"$lte": now},
+		},
+		{
+			"status":     model.SubscriptionIncomplete,
+			"created_at": bson.M{"$lte": incompleteBefore},
+		},
+	}})
+}
+
+// EndSubscription moves a subscription that has not ended to status. It
+// returns ErrInvalidTransition when it already ended, e.g. because another
+// instance of the job got there first.
+func (m MongoDB) EndSubscription(id string, status string) error {
+	return m.updateSubscription(id, liveSubscriptionStatuses, bson.M{"$set": bson.M{
+		"status":   status,
+		"ended_at": time.Now().UTC(),
+	}})
+}
+
+// RecordBillingEvent remembers a webhook event so redeliveries are ignored.
+func (m MongoDB) RecordBillingEvent(eventID string) error {
+	_, err := m.billingEventCollection.InsertOne(context.Background(), bson.M{"_id": eventID, "received_at": time.Now().UTC()})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEventProcessed
+	}
+	return err
+}
+
+// ForgetBillingEvent lets an event that failed to be processed be handled
+// again when the provider retries it.
+func (m MongoDB) ForgetBillingEvent(eventID string) error {
+	_, err := m.billingEventCollection.DeleteOne(context.Background(), bson.M{"_id": eventID})
+	return err
+}
+
+func (m MongoDB) updateSubscription(id string, from []string, update bson.M) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	filter := bson.M{"_id": objID}
+	if from != nil {
+		filter["status"] = bson.M{"$in": from}
+	}
+
+	result, err := m.subscriptionCollection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if from == nil {
+			return ErrNotFound
+		}
+		return ErrInvalidTransition
+	}
+	return nil
+}
+
+func (m MongoDB) findSubscription(filter bson.M) (*model.Subscription, error) {
+	var subscription model.Subscription
+	err := m.subscriptionCollection.FindOne(context.Background(), filter).Decode(&subscription)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func (m MongoDB) findSubscriptions(filter bson.M, opts ...*options.FindOptions) ([]*model.Subscription, error) {
+	cursor, err := m.subscriptionCollection.Find(context.TODO(), filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	subscriptions := []*model.Subscription{}
+	if err := cursor.All(context.Background(), &subscriptions); err != nil {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"context"
+	"cucinia/model"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultSubstitutions covers the ingredients that most often keep a recipe
+// from a restriction.
+var defaultSubstitutions = []*model.Substitution{
+	{Ingredient: "manteiga", Alternatives: []model.Alternative{
+		{Name: "Óleo", Ratio: 0.75, Notes: "Use óleo de sabor neutro; em massas de bolo a textura fica mais úmida.", Restriction: []string{}},
+		{Name: "Azeite", Ratio: 0.75, Notes: "Melhor em pratos salgados.", Restriction: []string{}},
+		{Name: "Margarina", Ratio: 1, Notes: "Confira no rótulo se não contém leite.", Restriction: []string{}},
+	}},
+	{Ingredient: "leite", Alternatives: []model.Alternative{
+		{Name: "Leite de coco", Ratio: 1, Notes: "Dá sabor de coco; dilua em água para suavizar.", Restriction: []string{}},
+		{Name: "Leite de aveia", Ratio: 1, Restriction: []string{"gluten"}},
+		{Name: "Leite de soja", Ratio: 1, Restriction: []string{}},
+		{Name: "Água", Ratio: 1, Notes: "Funciona em massas; o resultado fica menos macio.", Restriction: []string{}},
+	}},
+	{Ingredient: "ovo", Alternatives: []model.Alternative{
+		{Name: "Linhaça", Ratio: 1, Notes: "1 colher de sopa de linhaça moída hidratada em 3 de água por ovo.", Restriction: []string{}},
+		{Name: "Banana", Ratio: 0.5, Notes: "Meia banana amassada por ovo, em receitas doces.", Restriction: []string{}},
+	}},
+	{Ingredient: "farinha de trigo", Alternatives: []model.Alternative{
+		{Name: "Farinha de arroz", Ratio: 1, Notes: "Misture com um pouco de fécula para dar liga.", Restriction: []string{}},
+		{Name: "Farinha de aveia", Ratio: 1, Notes: "Só é sem glúten se a aveia for certificada.", Restriction: []string{"gluten"}},
+	}},
+	{Ingredient: "creme de leite", Alternatives: []model.Alternative{
+		{Name: "Leite de coco", Ratio: 1, Restriction: []string{}},
+	}},
+	{Ingredient: "queijo", Alternatives: []model.Alternative{
+		{Name: "Tofu", Ratio: 1, Notes: "Tempere com sal e levedura nutricional.", Restriction: []string{}},
+	}},
+	{Ingredient: "mel", Alternatives: []model.Alternative{
+		{Name: "Açúcar mascavo", Ratio: 1.25, Restriction: []string{}},
+	}},
+}
+
+func setupSubstitutions(substitutionCollection *mongo.Collection) {
+	_, err := substitutionCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "ingredient", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if count, err := substitutionCollection.CountDocuments(context.Background(), bson.M{}); err == nil && count == 0 {
+		for _, substitution := range defaultSubstitutions {
+			substitution.ID = primitive.NewObjectID()
+			substitution.UpdatedAt = time.Now()
+			for i := range substitution.Alternatives {
+				substitution.Alternatives[i].Source = model.SourceSeed
+			}
+			if _, err := substitutionCollection.InsertOne(context.Background(), substitution); err != nil {
+				log.Println("erro inserindo substituição padrão:", err)
+			}
+		}
+	}
+}
+
+func (m MongoDB) GetSubstitutions() ([]*model.Substitution, error) {
+	return m.findSubstitutions(bson.M{})
+}
+
+// GetSubstitutionsFor returns the substitutions of the given ingredients,
+// whatever their case.
+func (m MongoDB) GetSubstitutionsFor(ingredients []string) ([]*model.Substitution, error) {
+	names := make([]string, len(ingredients))
+	for i, ingredient := range ingredients {
+		names[i] = strings.ToLower(strings.TrimSpace(ingredient))
+	}
+	return m.findSubstitutions(bson.M{"ingredient": bson.M{"$in": names}})
+}
+
+func (m MongoDB) findSubstitutions(filter bson.M) ([]*model.Substitution, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "ingredient", Value: 1}})
+	cursor, err := m.substitutionCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	substitutions := []*model.Substitution{}
+	if err := cursor.All(context.Background(), &substitutions); err != nil {
+		return nil, err
+	}
+	return substitutions, nil
+}
+
+func (m MongoDB) GetSubstitutionByID(id string) (*model.Substitution, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	var substitution model.Substitution
+	err = m.substitutionCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&substitution)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &substitution, nil
+}
+
+func (m MongoDB) CreateSubstitution(substitution *model.Substitution) error {
+	substitution.ID = primitive.NewObjectID()
+	substitution.Ingredient = strings.TrimSpace(strings.ToLower(substitution.Ingredient))
+	if substitution.Ingredient == "" {
+		return &ValidationError{Field: "ingredient", Message: "o ingrediente é obrigatório"}
+	}
+	substitution.UpdatedAt = time.Now()
+
+	_, err := m.substitutionCollection.InsertOne(context.TODO(), substitution)
+	if mongo.IsDuplicateKeyError(err) {
+		return &ValidationError{Field: "ingredient", Message: "já existem substituições para '" + substitution.Ingredient + "'"}
+	}
+	return err
+}
+
+// UpdateSubstitution replaces the alternatives of a substitution.
+func (m MongoDB) UpdateSubstitution(id string, alternatives []model.Alternative) (*model.Substitution, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	update := bson.M{"$set": bson.M{
+		"alternatives": alternatives,
+		"updated_at":   time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var substitution model.Substitution
+	err = m.substitutionCollection.FindOneAndUpdate(context.TODO(), bson.M{"_id": objID}, update, opts).Decode(&substitution)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &substitution, nil
+}
+
+func (m MongoDB) DeleteSubstitution(id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	res, err := m.substitutionCollection.DeleteOne(context.TODO(), bson.M{"_id": objID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package db
+
+import (
+	"context"
+	"cucinia/model"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var defaultCuisines = []*model.Cuisine{
+	{Slug: "brasileira", Labels: map[string]string{"pt-BR": "Brasileira", "en": "Brazilian"}, Icon: "🇧🇷", Order: 1},
+	{Slug: "italiana", Labels: map[string]string{"pt-BR": "Italiana", "en": "Italian"}, Icon: "🇮🇹", Order: 2},
+	{Slug: "francesa", Labels: map[string]string{"pt-BR": "Francesa", "en": "French"}, Icon: "🇫🇷", Order: 3},
+	{Slug: "americana", Labels: map[string]string{"pt-BR": "Americana", "en": "American"}, Icon: "🇺🇸", Order: 4},
+	{Slug: "mexicana", Labels: map[string]string{"pt-BR": "Mexicana", "en": "Mexican"}, Icon: "🇲🇽", Order: 5},
+	{Slug: "turca", Labels: map[string]string{"pt-BR": "Turca", "en": "Turkish"}, Icon: "🇹🇷", Order: 6},
+	{Slug: "chinesa", Labels: map[string]string{"pt-BR": "Chinesa", "en": "Chinese"}, Icon: "🇨🇳", Order: 7},
+	{Slug: "inglesa", Labels: map[string]string{"pt-BR": "Inglesa", "en": "English"}, Icon: "🇬🇧", Order: 8},
+}
+
+var defaultMealTypes = []*model.MealType{
+	{Code: 1, Slug: "cafe-da-manha", Labels: map[string]string{"pt-BR": "Café da manhã", "en": "Breakfast"}, Icon: "☕", Order: 1},
+	{Code: 2, Slug: "almoco", Labels: map[string]string{"pt-BR": "Almoço", "en": "Lunch"}, Icon: "🍽️", Order: 2},
+	{Code: 3, Slug: "jantar", Labels: map[string]string{"pt-BR": "Jantar", "en": "Dinner"}, Icon: "🌙", Order: 3},
+	{Code: 4, Slug: "lanche-da-tarde", Labels: map[string]string{"pt-BR": "Lanche da tarde", "en": "Afternoon snack"}, Icon: "🥪", Order: 4},
+	{Code: 5, Slug: "sobremesa", Labels: map[string]string{"pt-BR": "Sobremesa", "en": "Dessert"}, Icon: "🍰", Order: 5},
+}
+
+func setupTaxonomies(cuisineCollection, mealTypeCollection, recipeCollection *mongo.Collection) {
+	_, err := cuisineCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "slug", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = mealTypeCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if count, err := cuisineCollection.CountDocuments(context.Background(), bson.M{}); err == nil && count == 0 {
+		for _, cuisine := range defaultCuisines {
+			cuisine.ID = primitive.NewObjectID()
+			if _, err := cuisineCollection.InsertOne(context.Background(), cuisine); err != nil {
+				log.Println("erro inserindo culinária padrão:", err)
+			}
+		}
+	}
+
+	adoptRecipeCuisines(cuisineCollection, recipeCollection)
+
+	if count, err := mealTypeCollection.CountDocuments(context.Background(), bson.M{}); err == nil && count == 0 {
+		for _, mealType := range defaultMealTypes {
+			mealType.ID = primitive.NewObjectID()
+			if _, err := mealTypeCollection.InsertOne(context.Background(), mealType); err != nil {
+				log.Println("erro inserindo tipo de refeição padrão:", err)
+			}
+		}
+	}
+}
+
+// adoptRecipeCuisines adds the cuisines stored recipes already use but the
+// taxonomy lacks, so older recipes keep passing validation.
+func adoptRecipeCuisines(cuisineCollection, recipeCollection *mongo.Collection) {
+	slugs, err := recipeCollection.Distinct(context.Background(), "cuisine", bson.M{})
+	if err != nil {
+		log.Println("erro listando culinárias das receitas:", err)
+		return
+	}
+
+	order := len(defaultCuisines)
+	for _, value := range slugs {
+		slug, _ := value.(string)
+		if slug == "" {
+			continue
+		}
+		order++
+		res, err := cuisineCollection.UpdateOne(context.Background(),
+			bson.M{"slug": slug},
+			bson.M{"$setOnInsert": bson.M{
+				"_id":    primitive.NewObjectID(),
+				"labels": map[string]string{"pt-BR": slug},
+				"icon":   "",
+				"order":  order,
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			log.Println("erro inserindo culinária de receita:", err)
+		} else if res.UpsertedCount > 0 {
+			log.Println("culinária", slug, "adicionada a partir das receitas")
+		}
+	}
+}
+
+func (m MongoDB) GetCuisines() ([]*model.Cuisine, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "order", Value: 1}, {Key: "slug", Value: 1}})
+	cursor, err := m.cuisineCollection.Find(context.TODO(), bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var cuisines []*model.Cuisine
+	if err := cursor.All(context.Background(), &cuisines); err != nil {
+		return nil, err
+	}
+
+	return cuisines, nil
+}
+
+func (m MongoDB) GetCuisineByID(id string) (*model.Cuisine, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	var cuisine model.Cuisine
+	if err := m.cuisineCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&cuisine); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &cuisine, nil
+}
+
+func (m MongoDB) GetCuisineBySlug(slug string) (*model.Cuisine, error) {
+	var cuisine model.Cuisine
+	err := m.cuisineCollection.FindOne(context.TODO(), bson.M{"slug": slug}).Decode(&cuisine)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &cuisine, nil
+}
+
+func (m MongoDB) CreateCuisine(cuisine *model.Cuisine) error {
+	cuisine.ID = primitive.NewObjectID()
+	cuisine.Slug = strings.TrimSpace(strings.ToLower(cuisine.Slug))
+	if cuisine.Slug == "" {
+		return &ValidationError{Field: "slug", Message: "o slug da culinária é obrigatório"}
+	}
+
+	_, err := m.cuisineCollection.InsertOne(context.TODO(), cuisine)
+	if mongo.IsDuplicateKeyError(err) {
+		return &ValidationError{Field: "slug", Message: "a culinária '" + cuisine.Slug + "' já existe"}
+	}
+	return err
+}
+
+func (m MongoDB) UpdateCuisine(id string, cuisine *model.Cuisine) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("ID inválido")
+	}
+
+	update := bson.M{"$set": bson.M{
+		"labels": cuisine.Labels,
+		"icon":   cuisine.Icon,
+		"order":  cuisine.Order,
+	}}
+
+	res, err := m.cuisineCollection.UpdateOne(context.TODO(), bson.M{"_id": objID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (m MongoDB) DeleteCuisine(id string) error {
+	cuisine, err := m.GetCuisineByID(id)
+	if err != nil {
+		return err
+	}
+
+	count, err := m.recipeCollection.CountDocuments(context.TODO(), bson.M{"cuisine": cuisine.Slug})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return &ValidationError{Field: "slug", Message: "a culinária '" + cuisine.Slug + "' ainda é usada por receitas"}
+	}
+
+	_, err = m.cuisineCollection.DeleteOne(context.TODO(), bson.M{"_id": cuisine.ID})
+	return err
+}
+
+func (m MongoDB) GetMealTypes() ([]*model.MealType, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "order", Value: 1}, {Key: "code", Value: 1}})
+	cursor, err := m.mealTypeCollection.Find(context.TODO(), bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var mealTypes []*model.MealType
+	if err := cursor.All(context.Background(), &mealTypes); err != nil {
+		return nil, err
+	}
+
+	return mealTypes, nil
+}
+
+func (m MongoDB) GetMealTypeByID(id string) (*model.MealType, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	mealType, err := m.findMealType(bson.M{"_id": objID})
+	if err == nil && mealType == nil {
+		return nil, ErrNotFound
+	}
+	return mealType, err
+}
+
+func (m MongoDB) GetMealTypeByCode(code int) (*model.MealType, error) {
+	return m.findMealType(bson.M{"code": code})
+}
+
+// ResolveMealType accepts either the numeric code stored in recipes or the
+// slug of a meal type, as both show up in URLs and query strings.
+func (m MongoDB) ResolveMealType(value string) (*model.MealType, error) {
+	if code, err := strconv.Atoi(value); err == nil {
+		return m.GetMealTypeByCode(code)
+	}
+	return m.findMealType(bson.M{"slug": strings.ToLower(value)})
+}
+
+func (m MongoDB) findMealType(filter bson.M) (*model.MealType, error) {
+	var mealType model.MealType
+	err := m.mealTypeCollection.FindOne(context.TODO(), filter).Decode(&mealType)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &mealType, nil
+}
+
+func (m MongoDB) CreateMealType(mealType *model.MealType) error {
+	mealType.ID = primitive.NewObjectID()
+	mealType.Slug = strings.TrimSpace(strings.ToLower(mealType.Slug))
+	if mealType.Slug == "" {
+		return &ValidationError{Field: "slug", Message: "o slug do tipo de refeição é obrigatório"}
+	}
+	if mealType.Code <= 0 {
+		return &ValidationError{Field: "code", Message: "o código do tipo de refeição deve ser positivo"}
+	}
+
+	_, err := m.mealTypeCollection.InsertOne(context.TODO(), mealType)
+	if mongo.IsDuplicateKeyError(err) {
+		return &ValidationError{Field: "code", Message: "o tipo de refeição '" + mealType.Slug + "' já existe"}
+	}
+	return err
+}
+
+func (m MongoDB) UpdateMealType(id string, mealType *model.MealType) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("ID inválido")
+	}
+
+	update := bson.M{"$set": bson.M{
+		"labels": mealType.Labels,
+		"icon":   mealType.Icon,
+		"order":  mealType.Order,
+	}}
+
+	res, err := m.mealTypeCollection.UpdateOne(context.TODO(), bson.M{"_id": objID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (m MongoDB) DeleteMealType(id string) error {
+	mealType, err := m.GetMealTypeByID(id)
+	if err != nil {
+		return err
+	}
+
+	count, err := m.recipeCollection.CountDocuments(context.TODO(), bson.M{"type_of": mealType.Code})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return &ValidationError{Field: "code", Message: "o tipo de refeição '" + mealType.Slug + "' ainda é usado por receitas"}
+	}
+
+	_, err = m.mealTypeCollection.DeleteOne(context.TODO(), bson.M{"_id": mealType.ID})
+	return err
+}
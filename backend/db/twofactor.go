@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (m MongoDB) EnableUserTOTP(id string, secret string, recoveryCodes []string) error {
+	return m.updateUser(id, bson.M{
+		"totp_enabled":   true,
+		"totp_secret":    secret,
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+func (m MongoDB) DisableUserTOTP(id string) error {
+	filter, err := userFilter(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := m.userCollection.UpdateOne(context.Background(),
+		filter,
+		bson.M{
+			"$set":   bson.M{"totp_enabled": false},
+			"$unset": bson.M{"totp_secret": "", "recovery_codes": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m MongoDB) SetUserRecoveryCodes(id string, recoveryCodes []string) error {
+	return m.updateUser(id, bson.M{"recovery_codes": recoveryCodes})
+}
+
+// UseUserRecoveryCode removes a hashed recovery code in a single update, so
+// the same code can never log in twice.
+func (m MongoDB) UseUserRecoveryCode(id string, recoveryCode string) error {
+	filter, err := userFilter(id)
+	if err != nil {
+		return err
+	}
+	filter["recovery_codes"] = recoveryCode
+
+	result, err := m.userCollection.UpdateOne(context.Background(),
+		filter,
+		bson.M{"$pull": bson.M{"recovery_codes": recoveryCode}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
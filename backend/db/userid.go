@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrateUserIDs rewrites references that used to hold a user's e-mail
+// (recipe authors and reviewers, revision authors) to the user's ID.
+// References to e-mails that no longer belong to any user are kept as they
+// are and counted in the log, so they can still be fixed by hand.
+func migrateUserIDs(ctx context.Context, userCollection, recipeCollection, revisionCollection *mongo.Collection) error {
+	ids := map[string]string{}
+	idFor := func(email string) (string, error) {
+		if id, ok := ids[email]; ok {
+			return id, nil
+		}
+		var user struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		id := ""
+		err := userCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+		if err == nil {
+			id = user.ID.Hex()
+		} else if err != mongo.ErrNoDocuments {
+			return "", err
+		}
+		ids[email] = id
+		return id, nil
+	}
+	unmatched := func(collection *mongo.Collection, field string, email string) error {
+		count, err := collection.CountDocuments(ctx, bson.M{field: email})
+		if err != nil {
+			return err
+		}
+		log.Printf("migração de IDs: %d referências em %s.%s apontam para um e-mail sem usuário e foram mantidas", count, collection.Name(), field)
+		return nil
+	}
+
+	renames := []struct {
+		collection *mongo.Collection
+		from, to   string
+	}{
+		{recipeCollection, "author_email", "author_id"},
+		{revisionCollection, "snapshot.author_email", "snapshot.author_id"},
+	}
+	for _, r := range renames {
+		emails, err := r.collection.Distinct(ctx, r.from, bson.M{r.from: bson.M{"$exists": true}})
+		if err != nil {
+			return err
+		}
+		for _, value := range emails {
+			email, _ := value.(string)
+			id, err := idFor(email)
+			if err != nil {
+				return err
+			}
+			if id == "" {
+				if err := unmatched(r.collection, r.from, email); err != nil {
+					return err
+				}
+				continue
+			}
+			update := bson.M{"$set": bson.M{r.to: id}, "$unset": bson.M{r.from: ""}}
+			if _, err := r.collection.UpdateMany(ctx, bson.M{r.from: email}, update); err != nil {
+				return err
+			}
+		}
+	}
+
+	// These fields keep their name, only e-mails (the values with an @) are
+	// replaced.
+	replacements := []struct {
+		collection *mongo.Collection
+		field      string
+	}{
+		{recipeCollection, "reviewed_by"},
+		{revisionCollection, "author"},
+		{revisionCollection, "snapshot.reviewed_by"},
+	}
+	for _, r := range replacements {
+		emails, err := r.collection.Distinct(ctx, r.field, bson.M{r.field: bson.M{"$regex": "@"}})
+		if err != nil {
+			return err
+		}
+		for _, value := range emails {
+			email, _ := value.(string)
+			id, err := idFor(email)
+			if err != nil {
+				return err
+			}
+			if id == "" {
+				if err := unmatched(r.collection, r.field, email); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := r.collection.UpdateMany(ctx, bson.M{r.field: email}, bson.M{"$set": bson.M{r.field: id}}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := recipeCollection.Indexes().DropOne(ctx, "author_email_1"); err != nil {
+		if cmdErr, ok := err.(mongo.CommandError); !ok || cmdErr.Name != "IndexNotFound" {
+			log.Println("erro removendo índice antigo de autores:", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDatabase connects to the MongoDB at MONGO_TEST_URI and returns a new
+// database dropped when the test ends. Tests needing it are skipped when the
+// variable is not set.
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI não definido")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	database := client.Database("cucinia_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return database
+}
+
+func TestMigrateUserIDsTwice(t *testing.T) {
+	database := testDatabase(t)
+	ctx := context.Background()
+	users, recipes, revisions := database.Collection("users"), database.Collection("recipes"), database.Collection("recipe_revisions")
+
+	ana, bia := primitive.NewObjectID(), primitive.NewObjectID()
+	migrated, kept, unchanged := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	insert := func(collection *mongo.Collection, documents ...interface{}) {
+		t.Helper()
+		if _, err := collection.InsertMany(ctx, documents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert(users,
+		bson.M{"_id": ana, "email": "ana@example.com"},
+		bson.M{"_id": bia, "email": "bia@example.com"},
+	)
+	insert(recipes,
+		bson.M{"_id": migrated, "name": "Bolo", "author_email": "ana@example.com", "reviewed_by": "bia@example.com"},
+		bson.M{"_id": kept, "name": "Pudim", "author_email": "sumiu@example.com", "reviewed_by": "sumiu@example.com"},
+		bson.M{"_id": unchanged, "name": "Torta", "author_id": ana.Hex(), "reviewed_by": bia.Hex()},
+	)
+	insert(revisions,
+		bson.M{"_id": primitive.NewObjectID(), "recipe_id": migrated, "rev": 1, "author": "ana@example.com",
+			"snapshot": bson.M{"name": "Bolo", "author_email": "ana@example.com", "reviewed_by": "bia@example.com"}},
+		bson.M{"_id": primitive.NewObjectID(), "recipe_id": kept, "rev": 1, "author": "sumiu@example.com",
+			"snapshot": bson.M{"name": "Pudim", "author_email": "sumiu@example.com"}},
+	)
+	if _, err := recipes.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "author_email", Value: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	find := func(collection *mongo.Collection, filter bson.M) bson.M {
+		t.Helper()
+		var document bson.M
+		if err := collection.FindOne(ctx, filter).Decode(&document); err != nil {
+			t.Fatal(err)
+		}
+		return document
+	}
+	for run := 1; run <= 2; run++ {
+		if err := migrateUserIDs(ctx, users, recipes, revisions); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+
+		recipe := find(recipes, bson.M{"_id": migrated})
+		if _, ok := recipe["author_email"]; ok || recipe["author_id"] != ana.Hex() || recipe["reviewed_by"] != bia.Hex() {
+			t.Errorf("run %d: migrated recipe = %v", run, recipe)
+		}
+		recipe = find(recipes, bson.M{"_id": kept})
+		if _, ok := recipe["author_id"]; ok || recipe["author_email"] != "sumiu@example.com" || recipe["reviewed_by"] != "sumiu@example.com" {
+			t.Errorf("run %d: recipe of an unknown e-mail = %v", run, recipe)
+		}
+		recipe = find(recipes, bson.M{"_id": unchanged})
+		if recipe["author_id"] != ana.Hex() || recipe["reviewed_by"] != bia.Hex() {
+			t.Errorf("run %d: recipe already holding IDs = %v", run, recipe)
+		}
+
+		var revision struct {
+			Author   string `bson:"author"`
+			Snapshot bson.M `bson:"snapshot"`
+		}
+		if err := revisions.FindOne(ctx, bson.M{"recipe_id": migrated}).Decode(&revision); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := revision.Snapshot["author_email"]; ok || revision.Author != ana.Hex() || revision.Snapshot["author_id"] != ana.Hex() || revision.Snapshot["reviewed_by"] != bia.Hex() {
+			t.Errorf("run %d: migrated revision = %+v", run, revision)
+		}
+		revision.Snapshot = nil
+		if err := revisions.FindOne(ctx, bson.M{"recipe_id": kept}).Decode(&revision); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := revision.Snapshot["author_id"]; ok || revision.Author != "sumiu@example.com" || revision.Snapshot["author_email"] != "sumiu@example.com" {
+			t.Errorf("run %d: revision of an unknown e-mail = %+v", run, revision)
+		}
+
+		specs, err := recipes.Indexes().ListSpecifications(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, spec := range specs {
+			if spec.Name == "author_email_1" {
+				t.Errorf("run %d: index author_email_1 kept", run)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+//go:build heic && cgo
+
+package imaging
+
+/*
+#cgo pkg-config: libheif
+#include <stdlib.h>
+#include <string.h>
+#include <libheif/heif.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"sync"
+	"unsafe"
+)
+
+// HEICSupported tells whether HEIC photos can be decoded. It is true when
+// built with the heic tag, which links libheif.
+const HEICSupported = true
+
+var heicInit sync.Once
+
+func heicError(err C.struct_heif_error) error {
+	if err.code == C.heif_error_Ok {
+		return nil
+	}
+	return errors.New("heic: " + C.GoString(err.message))
+}
+
+// decodeHEIC decodes the primary image of a HEIC file, with its rotation
+// and mirroring applied.
+func decodeHEIC(data []byte) (image.Image, error) {
+	heicInit.Do(func() { C.heif_init(nil) })
+
+	ctx := C.heif_context_alloc()
+	if ctx == nil {
+		return nil, errors.New("heic: sem memória")
+	}
+	defer C.heif_context_free(ctx)
+
+	// libheif reads from C memory, which the Go garbage collector leaves
+	// alone while it works.
+	mem := C.CBytes(data)
+	defer C.free(mem)
+	if err := heicError(C.heif_context_read_from_memory_without_copy(ctx, mem, C.size_t(len(data)), nil)); err != nil {
+		return nil, err
+	}
+
+	var handle *C.struct_heif_image_handle
+	if err := heicError(C.heif_context_get_primary_image_handle(ctx, &handle)); err != nil {
+		return nil, err
+	}
+	defer C.heif_image_handle_release(handle)
+
+	width := int(C.heif_image_handle_get_width(handle))
+	height := int(C.heif_image_handle_get_height(handle))
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("heic: dimensões inválidas")
+	}
+	if width*height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	var img *C.struct_heif_image
+	if err := heicError(C.heif_decode_image(handle, &img, C.heif_colorspace_RGB, C.heif_chroma_interleaved_RGBA, nil)); err != nil {
+		return nil, err
+	}
+	defer C.heif_image_release(img)
+
+	// Rotations may swap the sides, so the size comes from the decoded
+	// image.
+	width = int(C.heif_image_get_width(img, C.heif_channel_interleaved))
+	height = int(C.heif_image_get_height(img, C.heif_channel_interleaved))
+	var stride C.int
+	plane := C.heif_image_get_plane_readonly(img, C.heif_channel_interleaved, &stride)
+	if plane == nil || width <= 0 || height <= 0 || int(stride) < width*4 {
+		return nil, errors.New("heic: imagem decodificada inválida")
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	src := unsafe.Slice((*byte)(unsafe.Pointer(plane)), int(stride)*height)
+	for y := 0; y < height; y++ {
+		copy(dst.Pix[y*dst.Stride:y*dst.Stride+width*4], src[y*int(stride):])
+	}
+	return dst, nil
+}
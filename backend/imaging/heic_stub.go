@@ -0,0 +1,16 @@
+//go:build !heic || !cgo
+
+package imaging
+
+import (
+	"errors"
+	"image"
+)
+
+// HEICSupported tells whether HEIC photos can be decoded. Builds without
+// the heic tag do not link libheif and cannot.
+const HEICSupported = false
+
+func decodeHEIC(data []byte) (image.Image, error) {
+	return nil, errors.New("heic: suporte não incluído neste build")
+}
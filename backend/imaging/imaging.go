@@ -0,0 +1,139 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const (
+	MaxUploadSize = 10 << 20
+	maxPixels     = 40_000_000
+)
+
+var (
+	ErrUnsupportedType = errors.New("formato de imagem não suportado, use JPEG, PNG, GIF ou WEBP")
+	ErrTooLarge        = errors.New("imagem grande demais")
+)
+
+var extensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+type Variant struct {
+	Name        string
+	ContentType string
+	Ext         string
+	Data        []byte
+}
+
+type size struct {
+	name  string
+	width int
+}
+
+var sizes = []size{
+	{name: "thumb", width: 320},
+	{name: "medium", width: 960},
+}
+
+// Sniff detects the real content type from the file contents, ignoring
+// whatever the client claimed, and returns it with its file extension.
+func Sniff(data []byte) (string, string, error) {
+	contentType := http.DetectContentType(data)
+	ext, ok := extensions[contentType]
+	if !ok {
+		return "", "", ErrUnsupportedType
+	}
+	return contentType, ext, nil
+}
+
+func Decode(data []byte, contentType string) (image.Image, error) {
+	r := bytes.NewReader(data)
+
+	var cfg image.Config
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		cfg, err = jpeg.DecodeConfig(r)
+	case "image/png":
+		cfg, err = png.DecodeConfig(r)
+	case "image/gif":
+		cfg, err = gif.DecodeConfig(r)
+	case "image/webp":
+		cfg, err = webp.DecodeConfig(r)
+	default:
+		return nil, ErrUnsupportedType
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	r.Reset(data)
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.Decode(r)
+	case "image/png":
+		return png.Decode(r)
+	case "image/gif":
+		return gif.Decode(r)
+	default:
+		return webp.Decode(r)
+	}
+}
+
+// Resize scales img down so it is at most width pixels wide, keeping the
+// aspect ratio. Smaller images are returned untouched.
+func Resize(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// Variants builds the resized JPEG renditions served for recipe images,
+// and WEBP ones too when WebPSupported.
+func Variants(img image.Image) ([]Variant, error) {
+	var variants []Variant
+	for _, s := range sizes {
+		resized := Resize(img, s.width)
+
+		var jpg bytes.Buffer
+		if err := jpeg.Encode(&jpg, resized, &jpeg.Options{Quality: 82}); err != nil {
+			return nil, err
+		}
+		variants = append(variants, Variant{Name: s.name, ContentType: "image/jpeg", Ext: "jpg", Data: jpg.Bytes()})
+
+		if !WebPSupported {
+			continue
+		}
+		wp, err := encodeWebP(resized, 80)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, Variant{Name: s.name, ContentType: "image/webp", Ext: "webp", Data: wp})
+	}
+	return variants, nil
+}
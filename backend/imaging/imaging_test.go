@@ -0,0 +1,48 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestVariants(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1200, 800))
+	for y := 0; y < 800; y++ {
+		for x := 0; x < 1200; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	variants, err := Variants(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perSize := 1
+	if WebPSupported {
+		perSize = 2
+	}
+	if len(variants) != len(sizes)*perSize {
+		t.Fatalf("%d variants, want %d", len(variants), len(sizes)*perSize)
+	}
+
+	widths := map[string]int{}
+	for _, s := range sizes {
+		widths[s.name] = s.width
+	}
+	for _, v := range variants {
+		if sniffed, ext, err := Sniff(v.Data); err != nil || sniffed != v.ContentType || ext != v.Ext {
+			t.Errorf("%s.%s sniffed as %q %q (%v)", v.Name, v.Ext, sniffed, ext, err)
+			continue
+		}
+		decoded, err := Decode(v.Data, v.ContentType)
+		if err != nil {
+			t.Errorf("%s.%s: %v", v.Name, v.Ext, err)
+			continue
+		}
+		want := image.Pt(widths[v.Name], widths[v.Name]*800/1200)
+		if got := decoded.Bounds().Size(); got != want {
+			t.Errorf("%s.%s is %v, want %v", v.Name, v.Ext, got, want)
+		}
+	}
+}
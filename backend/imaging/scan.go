@@ -0,0 +1,418 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+)
+
+// ScanMaxSide is the longest side, in pixels, of photos sent to the AI.
+// Larger ones only cost more to send without improving what it finds.
+const ScanMaxSide = 1600
+
+var ErrUnsupportedScanType = errors.New("formato de imagem não suportado, use JPEG, PNG, WEBP ou HEIC")
+
+// Scan is a photo ready to be sent to the AI.
+type Scan struct {
+	ContentType string
+	Data        []byte
+	// Image is the decoded photo. It is nil for HEIC photos when HEIC
+	// support was not built in.
+	Image image.Image
+}
+
+// PrepareScan detects the real format of a photo and makes it fit to send:
+// it is turned upright, scaled down to ScanMaxSide and encoded again as
+// JPEG, which leaves its metadata behind. Builds without HEIC support keep
+// HEIC photos as they are, except for their EXIF data, which is blanked.
+func PrepareScan(data []byte) (*Scan, error) {
+	if isHEIC(data) {
+		if !HEICSupported {
+			out := append([]byte(nil), data...)
+			stripHEICExif(out)
+			return &Scan{ContentType: "image/heic", Data: out}, nil
+		}
+		// libheif applies the rotation and mirroring of the file.
+		img, err := decodeHEIC(data)
+		if err != nil {
+			return nil, err
+		}
+		return encodeScan(Fit(img, ScanMaxSide))
+	}
+
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/webp":
+	default:
+		return nil, ErrUnsupportedScanType
+	}
+
+	img, err := Decode(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	img = Fit(img, ScanMaxSide)
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+	return encodeScan(img)
+}
+
+func encodeScan(img image.Image) (*Scan, error) {
+	img = flatten(img)
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return &Scan{ContentType: "image/jpeg", Data: out.Bytes(), Image: img}, nil
+}
+
+// Fit scales img down so neither side is longer than side pixels, keeping
+// the aspect ratio.
+func Fit(img image.Image, side int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() >= bounds.Dy() {
+		return Resize(img, side)
+	}
+	if bounds.Dy() <= side {
+		return img
+	}
+	return Resize(img, max(bounds.Dx()*side/bounds.Dy(), 1))
+}
+
+// flatten paints transparent areas white, as JPEG has no transparency.
+func flatten(img image.Image) image.Image {
+	if _, ok := img.(*image.YCbCr); ok {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG: 1 when it is upright
+// or has none, up to 8 for the other rotations and mirrorings.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// Image data starts; metadata comes before it.
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return 1
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if value := int(order.Uint16(tiff[entry+8:])); value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient turns img upright according to its EXIF orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	// Orientations 5 to 8 swap width and height.
+	swap := orientation >= 5
+	dw, dh := w, h
+	if swap {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+var heicBrands = map[string]bool{
+	"heic": true, "heix": true, "hevc": true, "hevx": true,
+	"heim": true, "heis": true, "mif1": true, "msf1": true,
+}
+
+func isHEIC(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size < 16 || size > len(data) {
+		return false
+	}
+	if heicBrands[string(data[8:12])] {
+		return true
+	}
+	// Compatible brands follow the major brand and its version.
+	for i := 16; i+4 <= size; i += 4 {
+		if heicBrands[string(data[i:i+4])] {
+			return true
+		}
+	}
+	return false
+}
+
+// box is an ISO BMFF box: its type and the bytes inside it.
+type box struct {
+	kind    string
+	payload []byte
+}
+
+func readBoxes(data []byte) []box {
+	var boxes []box
+	for i := 0; i+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		kind := string(data[i+4 : i+8])
+		header := 8
+		switch size {
+		case 0:
+			size = len(data) - i
+		case 1:
+			if i+16 > len(data) {
+				return boxes
+			}
+			large := binary.BigEndian.Uint64(data[i+8:])
+			if large > uint64(len(data)-i) {
+				return boxes
+			}
+			size, header = int(large), 16
+		}
+		if size < header || i+size > len(data) {
+			return boxes
+		}
+		boxes = append(boxes, box{kind: kind, payload: data[i+header : i+size]})
+		i += size
+	}
+	return boxes
+}
+
+// stripHEICExif blanks the EXIF item of a HEIC file in place. The file keeps
+// its layout, so nothing else has to move.
+func stripHEICExif(data []byte) {
+	for _, top := range readBoxes(data) {
+		if top.kind != "meta" || len(top.payload) < 4 {
+			continue
+		}
+		// meta is a full box: version and flags come first.
+		children := readBoxes(top.payload[4:])
+
+		exif := map[uint32]bool{}
+		for _, child := range children {
+			if child.kind == "iinf" {
+				exif = exifItems(child.payload)
+			}
+		}
+		if len(exif) == 0 {
+			return
+		}
+		for _, child := range children {
+			if child.kind == "iloc" {
+				for _, extent := range itemExtents(child.payload, exif, len(data)) {
+					clear(data[extent[0]:extent[1]])
+				}
+			}
+		}
+		return
+	}
+}
+
+// exifItems returns the IDs of the items of type Exif in an iinf box.
+func exifItems(iinf []byte) map[uint32]bool {
+	items := map[uint32]bool{}
+	if len(iinf) < 6 {
+		return items
+	}
+	start := 6
+	if iinf[0] != 0 {
+		start = 8
+	}
+	if start > len(iinf) {
+		return items
+	}
+	for _, infe := range readBoxes(iinf[start:]) {
+		p := infe.payload
+		if infe.kind != "infe" || len(p) < 4 {
+			continue
+		}
+		switch version := p[0]; {
+		case version == 2 && len(p) >= 12:
+			if string(p[8:12]) == "Exif" {
+				items[uint32(binary.BigEndian.Uint16(p[4:]))] = true
+			}
+		case version == 3 && len(p) >= 14:
+			if string(p[10:14]) == "Exif" {
+				items[binary.BigEndian.Uint32(p[4:])] = true
+			}
+		}
+	}
+	return items
+}
+
+// itemExtents returns where the data of the given items starts and ends in
+// a file of size bytes, from an iloc box. Only data stored in the file
+// itself is listed, and extents that do not fit in it are left out.
+func itemExtents(iloc []byte, items map[uint32]bool, size int) [][2]int {
+	if len(iloc) < 8 {
+		return nil
+	}
+	version := iloc[0]
+	offsetSize := int(iloc[4] >> 4)
+	lengthSize := int(iloc[4] & 0x0F)
+	baseOffsetSize := int(iloc[5] >> 4)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(iloc[5] & 0x0F)
+	}
+
+	r := &fieldReader{data: iloc, pos: 6, ok: true}
+	var count uint64
+	if version < 2 {
+		count = r.read(2)
+	} else {
+		count = r.read(4)
+	}
+
+	var extents [][2]int
+	for n := uint64(0); n < count && r.ok; n++ {
+		var id uint64
+		if version < 2 {
+			id = r.read(2)
+		} else {
+			id = r.read(4)
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = r.read(2) & 0x0F
+		}
+		r.read(2) // data reference index
+		base := r.read(baseOffsetSize)
+		extentCount := r.read(2)
+		for e := uint64(0); e < extentCount && r.ok; e++ {
+			r.read(indexSize)
+			offset := r.read(offsetSize)
+			length := r.read(lengthSize)
+			if !items[uint32(id)] || method != 0 {
+				continue
+			}
+			// Offsets and lengths may take 8 bytes each: they are checked
+			// one at a time so their sum cannot overflow.
+			limit := uint64(size)
+			if base > limit || offset > limit-base {
+				continue
+			}
+			start := base + offset
+			if length == 0 || length > limit-start {
+				continue
+			}
+			extents = append(extents, [2]int{int(start), int(start + length)})
+		}
+	}
+	if !r.ok {
+		return nil
+	}
+	return extents
+}
+
+// fieldReader reads big-endian fields of 0, 2, 4 or 8 bytes, remembering
+// whether data ran out.
+type fieldReader struct {
+	data []byte
+	pos  int
+	ok   bool
+}
+
+func (r *fieldReader) read(size int) uint64 {
+	if !r.ok || r.pos+size > len(r.data) {
+		r.ok = false
+		return 0
+	}
+	var value uint64
+	switch size {
+	case 0:
+	case 2:
+		value = uint64(binary.BigEndian.Uint16(r.data[r.pos:]))
+	case 4:
+		value = uint64(binary.BigEndian.Uint32(r.data[r.pos:]))
+	case 8:
+		value = binary.BigEndian.Uint64(r.data[r.pos:])
+	default:
+		r.ok = false
+		return 0
+	}
+	r.pos += size
+	return value
+}
@@ -0,0 +1,154 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"testing"
+)
+
+func bmffBox(kind string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, kind...), body...)
+}
+
+// craftedHEIC is a HEIC file whose Exif item has the given 8-byte extent.
+func craftedHEIC(offset, length uint64) []byte {
+	infe := bmffBox("infe", []byte{2, 0, 0, 0, 0, 1, 0, 0}, []byte("Exif\x00"))
+	iinf := bmffBox("iinf", []byte{0, 0, 0, 0, 0, 1}, infe)
+
+	iloc := []byte{0, 0, 0, 0, 0x88, 0x00, 0, 1, 0, 1, 0, 0, 0, 1}
+	iloc = binary.BigEndian.AppendUint64(iloc, offset)
+	iloc = binary.BigEndian.AppendUint64(iloc, length)
+
+	meta := bmffBox("meta", []byte{0, 0, 0, 0}, iinf, bmffBox("iloc", iloc))
+	ftyp := bmffBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	return append(append(ftyp, meta...), bytes.Repeat([]byte("EXIF"), 8)...)
+}
+
+func TestStripHEICExifCraftedExtents(t *testing.T) {
+	file := craftedHEIC(0, 0)
+	exifAt := uint64(len(file) - 32)
+
+	tests := map[string]struct {
+		offset, length uint64
+		blanked        bool
+	}{
+		"valid":                  {offset: exifAt, length: 32, blanked: true},
+		"sum overflows int":      {offset: exifAt, length: math.MaxInt64 - 8},
+		"sum overflows uint64":   {offset: exifAt, length: math.MaxUint64 - 4},
+		"offset beyond the file": {offset: math.MaxUint64, length: 1},
+		"length beyond the file": {offset: exifAt, length: 33},
+		"empty":                  {offset: exifAt, length: 0},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := craftedHEIC(tt.offset, tt.length)
+			if !isHEIC(data) {
+				t.Fatal("crafted file not detected as HEIC")
+			}
+			stripHEICExif(data)
+			blanked := !bytes.Contains(data, []byte("EXIF"))
+			if blanked != tt.blanked {
+				t.Errorf("EXIF blanked = %v, want %v", blanked, tt.blanked)
+			}
+		})
+	}
+}
+
+func FuzzStripHEICExif(f *testing.F) {
+	f.Add(craftedHEIC(0, 0))
+	f.Add(craftedHEIC(16, math.MaxInt64-8))
+	if sample, err := os.ReadFile("testdata/exif.heic"); err == nil {
+		f.Add(sample)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		size := len(data)
+		stripHEICExif(data)
+		if len(data) != size {
+			t.Fatalf("length changed from %d to %d", size, len(data))
+		}
+	})
+}
+
+// testdata/exif.heic is a 96x64 photo, red on the left and blue on the
+// right, with an EXIF description of "SECRET-GPS".
+func TestPrepareScanHEIC(t *testing.T) {
+	data, err := os.ReadFile("testdata/exif.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan, err := PrepareScan(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(scan.Data, []byte("SECRET-GPS")) {
+		t.Error("EXIF data was sent along")
+	}
+
+	if !HEICSupported {
+		if scan.ContentType != "image/heic" || len(scan.Data) != len(data) {
+			t.Errorf("got %s of %d bytes, want the HEIC file kept", scan.ContentType, len(scan.Data))
+		}
+		return
+	}
+
+	if scan.ContentType != "image/jpeg" || scan.Image == nil {
+		t.Fatalf("got %s, want a decoded JPEG", scan.ContentType)
+	}
+	if bounds := scan.Image.Bounds(); bounds.Dx() != 96 || bounds.Dy() != 64 {
+		t.Errorf("size = %v, want 96x64", bounds)
+	}
+	left := color.RGBAModel.Convert(scan.Image.At(10, 32)).(color.RGBA)
+	right := color.RGBAModel.Convert(scan.Image.At(85, 32)).(color.RGBA)
+	if left.R < 150 || left.B > 100 || right.B < 150 || right.R > 100 {
+		t.Errorf("colors = %v / %v, want red / blue", left, right)
+	}
+}
+
+func TestPrepareScanResizes(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3000, 1000))
+	var data bytes.Buffer
+	if err := png.Encode(&data, img); err != nil {
+		t.Fatal(err)
+	}
+
+	scan, err := PrepareScan(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := scan.Image.Bounds(); bounds.Dx() != ScanMaxSide || bounds.Dy() != ScanMaxSide/3 {
+		t.Errorf("size = %v, want %dx%d", bounds, ScanMaxSide, ScanMaxSide/3)
+	}
+}
+
+// testdata/red-blue.webp is a lossless 96x64 WEBP, red on the left and
+// blue on the right.
+func TestPrepareScanWebP(t *testing.T) {
+	data, err := os.ReadFile("testdata/red-blue.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan, err := PrepareScan(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scan.ContentType != "image/jpeg" || scan.Image == nil {
+		t.Fatalf("got %s, want a decoded JPEG", scan.ContentType)
+	}
+	if bounds := scan.Image.Bounds(); bounds.Dx() != 96 || bounds.Dy() != 64 {
+		t.Errorf("size = %v, want 96x64", bounds)
+	}
+	left := color.RGBAModel.Convert(scan.Image.At(10, 32)).(color.RGBA)
+	right := color.RGBAModel.Convert(scan.Image.At(85, 32)).(color.RGBA)
+	if left.R < 150 || left.B > 100 || right.B < 150 || right.R > 100 {
+		t.Errorf("colors = %v / %v, want red / blue", left, right)
+	}
+}
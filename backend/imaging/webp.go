@@ -0,0 +1,42 @@
+//go:build webp && cgo
+
+package imaging
+
+/*
+#cgo pkg-config: libwebp
+#include <stdlib.h>
+#include <webp/encode.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"unsafe"
+)
+
+// WebPSupported tells whether WEBP renditions can be encoded. It is true
+// when built with the webp tag, which links libwebp.
+const WebPSupported = true
+
+// encodeWebP encodes img as a lossy WEBP of the given quality, from 0 to
+// 100.
+func encodeWebP(img image.Image, quality float32) ([]byte, error) {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, errors.New("webp: imagem vazia")
+	}
+
+	// libwebp takes non-premultiplied RGBA rows.
+	rgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	var out *C.uint8_t
+	size := C.WebPEncodeRGBA((*C.uint8_t)(unsafe.Pointer(&rgba.Pix[0])), C.int(bounds.Dx()), C.int(bounds.Dy()), C.int(rgba.Stride), C.float(quality), &out)
+	if size == 0 || out == nil {
+		return nil, errors.New("webp: falha ao codificar a imagem")
+	}
+	defer C.WebPFree(unsafe.Pointer(out))
+	return C.GoBytes(unsafe.Pointer(out), C.int(size)), nil
+}
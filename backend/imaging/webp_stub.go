@@ -0,0 +1,16 @@
+//go:build !webp || !cgo
+
+package imaging
+
+import (
+	"errors"
+	"image"
+)
+
+// WebPSupported tells whether WEBP renditions can be encoded. Builds
+// without the webp tag do not link libwebp and serve JPEG only.
+const WebPSupported = false
+
+func encodeWebP(img image.Image, quality float32) ([]byte, error) {
+	return nil, errors.New("webp: suporte não incluído neste build")
+}
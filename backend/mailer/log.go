@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Log does not deliver anything: it prints each message and, when dir is
+// set, saves it as an .eml file that can be opened in any mail client.
+type Log struct {
+	dir  string
+	from string
+}
+
+func NewLog(dir, from string) (*Log, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &Log{dir: dir, from: from}, nil
+}
+
+func (l *Log) Send(ctx context.Context, msg Message) error {
+	log.Printf("e-mail para %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	if l.dir == "" {
+		return nil
+	}
+
+	body, err := build(l.from, msg)
+	if err != nil {
+		return err
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), recipient)
+	return os.WriteFile(filepath.Join(l.dir, name), body, 0o644)
+}
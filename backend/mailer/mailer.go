@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewFromEnv picks the mailer from MAILER: "smtp" for real delivery or
+// "log" (default) to write messages to MAIL_LOG_DIR during development.
+func NewFromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Cucinia <nao-responda@cucinia.com.br>"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		return NewLog(os.Getenv("MAIL_LOG_DIR"), from)
+	case "smtp":
+		return NewSMTP(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		})
+	default:
+		return nil, errors.New("MAILER desconhecido: " + os.Getenv("MAILER"))
+	}
+}
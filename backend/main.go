@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"cucinia/ai"
+	"cucinia/billing"
 	"cucinia/db"
+	"cucinia/mailer"
+	"cucinia/storage"
 	"cucinia/web"
 	"log"
 	"os"
@@ -31,8 +35,28 @@ func main() {
 		panic(err)
 	}
 
+	store, err := storage.NewFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mail, err := mailer.NewFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pay, err := billing.NewFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	provider, err := ai.NewFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	cors := os.Getenv("profile") == "prod"
-	app := web.NewApp(mongoDB, redisClient, cors)
+	app := web.NewApp(mongoDB, redisClient, store, mail, pay, provider, cors)
 
 	err = app.Serve()
 	log.Println("Error", err)
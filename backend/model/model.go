@@ -1,6 +1,10 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type Ingredient struct {
 	ID   primitive.ObjectID `json:"id" bson:"_id"`
@@ -14,19 +18,286 @@ type Recipe struct {
 	Cuisine     string             `json:"cuisine" bson:"cuisine"`
 	TypeOf      int                `json:"type_of" bson:"type_of"`
 	Image       string             `json:"image" bson:"image"`
+	Images      map[string]string  `json:"images,omitempty" bson:"images,omitempty"`
 	Ingredients []string           `json:"ingredients" bson:"ingredients"`
 	Difficulty  string             `json:"difficulty" bson:"difficulty"`
 	Restriction []string           `json:"restriction" bson:"restriction"`
 	Premium     bool               `json:"premium" bson:"premium"`
 	Percentage  float64            `json:"percentage" bson:"percentage"`
+
+	Status        string     `json:"status" bson:"status"`
+	AuthorID      string     `json:"author_id,omitempty" bson:"author_id,omitempty"`
+	AuthorName    string     `json:"author_name,omitempty" bson:"author_name,omitempty"`
+	SubmittedAt   *time.Time `json:"submitted_at,omitempty" bson:"submitted_at,omitempty"`
+	ReviewedBy    string     `json:"-" bson:"reviewed_by,omitempty"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+	ReviewComment string     `json:"review_comment,omitempty" bson:"review_comment,omitempty"`
+	Version       int        `json:"version" bson:"version"`
+	// Generated marks recipes written by the AI for their author, and
+	// PromptVersion tells which prompt they were written with.
+	Generated     bool   `json:"generated,omitempty" bson:"generated,omitempty"`
+	PromptVersion string `json:"prompt_version,omitempty" bson:"prompt_version,omitempty"`
+
+	// Locked marks the teaser of a premium recipe sent to users whose plan
+	// does not include it.
+	Locked bool `json:"locked,omitempty" bson:"-"`
 }
 
+const (
+	RecipeDraft     = "draft"
+	RecipePending   = "pending"
+	RecipePublished = "published"
+	RecipeRejected  = "rejected"
+)
+
 type User struct {
-	Name         string   `json:"name" bson:"name"`
-	Email        string   `json:"email" bson:"email"`
-	Password     string   `json:"password" bson:"password"`
-	Ingredients  []string `json:"ingredients" bson:"ingredients"`
-	Restriction  []string `json:"restriction" bson:"restriction"`
-	LikedRecipes []string `json:"liked_recipes" bson:"liked_recipes"`
-	Premium      bool     `json:"premium" bson:"premium"`
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name          string             `json:"name" bson:"name"`
+	Email         string             `json:"email" bson:"email"`
+	Password      string             `json:"-" bson:"password"`
+	Ingredients   []string           `json:"ingredients" bson:"ingredients"`
+	Restriction   []string           `json:"restriction" bson:"restriction"`
+	LikedRecipes  []string           `json:"liked_recipes" bson:"liked_recipes"`
+	Premium       bool               `json:"premium" bson:"premium"`
+	Role          string             `json:"role" bson:"role"`
+	EmailVerified bool               `json:"email_verified" bson:"email_verified"`
+	TOTPEnabled   bool               `json:"totp_enabled" bson:"totp_enabled"`
+	TOTPSecret    string             `json:"-" bson:"totp_secret,omitempty"`
+	RecoveryCodes []string           `json:"-" bson:"recovery_codes,omitempty"`
+	Identities    []Identity         `json:"identities,omitempty" bson:"identities,omitempty"`
+
+	ErasureScheduledFor *time.Time `json:"erasure_scheduled_for,omitempty" bson:"erasure_scheduled_for,omitempty"`
+
+	// PremiumUntil is when premium granted by promotions, e.g. referrals,
+	// runs out. Premium paid by a subscription does not depend on it.
+	PremiumUntil *time.Time `json:"premium_until,omitempty" bson:"premium_until,omitempty"`
+	ReferralCode string     `json:"-" bson:"referral_code,omitempty"`
+	// ReferredBy is the user whose referral link this account registered
+	// through. Both are rewarded once the e-mail is confirmed.
+	ReferredBy string `json:"-" bson:"referred_by,omitempty"`
+}
+
+// Identity links a user to an account at an external OpenID Connect
+// provider.
+type Identity struct {
+	Provider string `json:"provider" bson:"provider"`
+	Subject  string `json:"subject" bson:"subject"`
+}
+
+// Erasure is the audit record of an account deletion. It keeps no
+// personal data besides the user ID, only what shows the request was
+// honoured and what was removed.
+type Erasure struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	UserID       string             `json:"user_id" bson:"user_id"`
+	RequestedBy  string             `json:"requested_by" bson:"requested_by"`
+	Status       string             `json:"status" bson:"status"`
+	RequestedAt  time.Time          `json:"requested_at" bson:"requested_at"`
+	ScheduledFor time.Time          `json:"scheduled_for" bson:"scheduled_for"`
+	CancelledAt  *time.Time         `json:"cancelled_at,omitempty" bson:"cancelled_at,omitempty"`
+	CompletedAt  *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	Removed      map[string]int64   `json:"removed,omitempty" bson:"removed,omitempty"`
+}
+
+const (
+	ErasureScheduled = "scheduled"
+	ErasureCancelled = "cancelled"
+	ErasureCompleted = "completed"
+)
+
+type Subscription struct {
+	ID                 primitive.ObjectID `json:"id" bson:"_id"`
+	UserID             string             `json:"user_id" bson:"user_id"`
+	PlanID             string             `json:"plan" bson:"plan"`
+	Status             string             `json:"status" bson:"status"`
+	ExternalID         string             `json:"-" bson:"external_id,omitempty"`
+	CreatedAt          time.Time          `json:"created_at" bson:"created_at"`
+	TrialEndsAt        *time.Time         `json:"trial_ends_at,omitempty" bson:"trial_ends_at,omitempty"`
+	CurrentPeriodStart *time.Time         `json:"current_period_start,omitempty" bson:"current_period_start,omitempty"`
+	CurrentPeriodEnd   *time.Time         `json:"current_period_end,omitempty" bson:"current_period_end,omitempty"`
+	CancelAtPeriodEnd  bool               `json:"cancel_at_period_end" bson:"cancel_at_period_end"`
+	CanceledAt         *time.Time         `json:"canceled_at,omitempty" bson:"canceled_at,omitempty"`
+	EndedAt            *time.Time         `json:"ended_at,omitempty" bson:"ended_at,omitempty"`
+	Coupon             string             `json:"coupon,omitempty" bson:"coupon,omitempty"`
+	PercentOff         int                `json:"percent_off,omitempty" bson:"percent_off,omitempty"`
+}
+
+// A subscription starts incomplete (waiting for the first payment) or
+// trialing, becomes active once paid and past_due when a renewal fails.
+// It ends as canceled, when the user cancelled, or expired.
+const (
+	SubscriptionIncomplete = "incomplete"
+	SubscriptionTrialing   = "trialing"
+	SubscriptionActive     = "active"
+	SubscriptionPastDue    = "past_due"
+	SubscriptionCanceled   = "canceled"
+	SubscriptionExpired    = "expired"
+)
+
+// Coupon is a promotion code created by admins. It discounts the first
+// paid period of a subscription, lengthens its trial, or both. Each user
+// redeems a coupon once.
+type Coupon struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Code        string             `json:"code" bson:"code"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	PercentOff  int                `json:"percent_off" bson:"percent_off"`
+	TrialDays   int                `json:"trial_days" bson:"trial_days"`
+	// Plans limits the coupon to some plans; empty means every plan.
+	Plans     []string   `json:"plans,omitempty" bson:"plans,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	// MaxRedemptions caps how many users may redeem it; 0 means no cap.
+	MaxRedemptions int       `json:"max_redemptions" bson:"max_redemptions"`
+	Redemptions    int       `json:"redemptions" bson:"redemptions"`
+	Active         bool      `json:"active" bson:"active"`
+	CreatedBy      string    `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
+}
+
+type CouponRedemption struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id"`
+	CouponID       primitive.ObjectID `json:"coupon_id" bson:"coupon_id"`
+	Code           string             `json:"code" bson:"code"`
+	UserID         string             `json:"user_id" bson:"user_id"`
+	SubscriptionID string             `json:"subscription_id,omitempty" bson:"subscription_id,omitempty"`
+	RedeemedAt     time.Time          `json:"redeemed_at" bson:"redeemed_at"`
+}
+
+// Like records that UserID liked RecipeID. There is at most one per user
+// and recipe.
+type Like struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	RecipeID  string             `json:"recipe_id" bson:"recipe_id"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Referral records that InviteeID registered through ReferrerID's link and
+// the premium days each of them got for it.
+type Referral struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	ReferrerID   string             `json:"referrer_id" bson:"referrer_id"`
+	InviteeID    string             `json:"invitee_id" bson:"invitee_id"`
+	ReferrerDays int                `json:"referrer_days" bson:"referrer_days"`
+	InviteeDays  int                `json:"invitee_days" bson:"invitee_days"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// AuditEntry records one change to the catalog or to an account: who made
+// it, from which request, and the entity before and after.
+type AuditEntry struct {
+	ID        primitive.ObjectID     `json:"id" bson:"_id"`
+	At        time.Time              `json:"at" bson:"at"`
+	ActorID   string                 `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	Action    string                 `json:"action" bson:"action"`
+	Entity    string                 `json:"entity" bson:"entity"`
+	EntityID  string                 `json:"entity_id" bson:"entity_id"`
+	Before    map[string]interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After     map[string]interface{} `json:"after,omitempty" bson:"after,omitempty"`
+	RequestID string                 `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	IP        string                 `json:"ip,omitempty" bson:"ip,omitempty"`
+}
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditSubmit  = "submit"
+	AuditApprove = "approve"
+	AuditReject  = "reject"
+	AuditRestore = "restore"
+	AuditPremium = "premium"
+)
+
+const (
+	EntityIngredient   = "ingredient"
+	EntityRecipe       = "recipe"
+	EntityUser         = "user"
+	EntityCoupon       = "coupon"
+	EntitySubstitution = "substitution"
+	EntityCuisine      = "cuisine"
+	EntityMealType     = "meal_type"
+)
+
+const (
+	RoleUser   = "user"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+type Cuisine struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id"`
+	Slug   string             `json:"slug" bson:"slug"`
+	Labels map[string]string  `json:"labels" bson:"labels"`
+	Icon   string             `json:"icon" bson:"icon"`
+	Order  int                `json:"order" bson:"order"`
+}
+
+type MealType struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id"`
+	Code   int                `json:"code" bson:"code"`
+	Slug   string             `json:"slug" bson:"slug"`
+	Labels map[string]string  `json:"labels" bson:"labels"`
+	Icon   string             `json:"icon" bson:"icon"`
+	Order  int                `json:"order" bson:"order"`
+}
+
+// Substitution lists what can replace an ingredient in a recipe.
+type Substitution struct {
+	ID primitive.ObjectID `json:"id" bson:"_id"`
+	// Ingredient is the lower case name of the ingredient replaced.
+	Ingredient   string        `json:"ingredient" bson:"ingredient"`
+	Alternatives []Alternative `json:"alternatives" bson:"alternatives"`
+	UpdatedAt    time.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+type Alternative struct {
+	Name string `json:"name" bson:"name"`
+	// Ratio is how much of the alternative replaces one measure of the
+	// ingredient, e.g. 0.75 for ¾ xícara of óleo per xícara of manteiga.
+	Ratio float64 `json:"ratio" bson:"ratio"`
+	Notes string  `json:"notes,omitempty" bson:"notes,omitempty"`
+	// Restriction lists the restrictions the alternative does not meet, as
+	// in recipes.
+	Restriction []string `json:"restriction" bson:"restriction"`
+	// Source is SourceSeed, SourceAdmin or SourceAI. Alternatives of the AI
+	// also keep the prompt version that suggested them.
+	Source        string `json:"source" bson:"source"`
+	PromptVersion string `json:"prompt_version,omitempty" bson:"prompt_version,omitempty"`
+}
+
+const (
+	SourceSeed  = "seed"
+	SourceAdmin = "admin"
+	SourceAI    = "ai"
+)
+
+type Taxonomies struct {
+	Cuisines  []*Cuisine  `json:"cuisines"`
+	MealTypes []*MealType `json:"meal_types"`
+}
+
+type RecipeStats struct {
+	RecipeID  primitive.ObjectID `json:"recipe_id" bson:"_id"`
+	Views     int64              `json:"views" bson:"views"`
+	Likes     int64              `json:"likes" bson:"likes"`
+	Cooks     int64              `json:"cooks" bson:"cooks"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type FieldChange struct {
+	Field string      `json:"field" bson:"field"`
+	From  interface{} `json:"from" bson:"from"`
+	To    interface{} `json:"to" bson:"to"`
+}
+
+type RecipeRevision struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	RecipeID     primitive.ObjectID `json:"recipe_id" bson:"recipe_id"`
+	Rev          int                `json:"rev" bson:"rev"`
+	Author       string             `json:"author" bson:"author"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	Changes      []FieldChange      `json:"changes" bson:"changes"`
+	RestoredFrom *int               `json:"restored_from,omitempty" bson:"restored_from,omitempty"`
+	Snapshot     Recipe             `json:"snapshot" bson:"snapshot"`
 }
@@ -0,0 +1,135 @@
+package oidc_test
+
+import (
+	"context"
+	"cucinia/oidc"
+	"cucinia/oidc/oidctest"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ana = oidctest.User{Subject: "sub-ana", Email: "ana@example.com", EmailVerified: true, Name: "Ana"}
+
+func newProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	server := oidctest.New("cucinia", "segredo", ana)
+	t.Cleanup(server.Close)
+	return server, oidc.New(oidc.Config{
+		Issuer:       server.Issuer(),
+		ClientID:     "cucinia",
+		ClientSecret: "segredo",
+		RedirectURL:  "http://app.test/callback",
+	})
+}
+
+// authorize signs in at the provider and returns the code it sends back.
+func authorize(t *testing.T, p *oidc.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize = %s to %q", res.Status, res.Header.Get("Location"))
+	}
+	if !strings.HasPrefix(callback.String(), "http://app.test/callback?") || callback.Query().Get("state") != state {
+		t.Fatalf("redirected to %s", callback)
+	}
+	return callback.Query().Get("code")
+}
+
+func TestLogin(t *testing.T) {
+	_, p := newProvider(t)
+	code := authorize(t, p, "state", "nonce", "verifier")
+
+	token, err := p.Exchange(context.Background(), code, "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.Verify(context.Background(), token.IDToken, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != ana.Subject || claims.Email != ana.Email || !claims.EmailVerified || claims.Name != ana.Name {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	if _, err := p.Exchange(context.Background(), code, "verifier"); err == nil {
+		t.Error("a code was accepted twice")
+	}
+}
+
+func TestDiscoveryChecksIssuer(t *testing.T) {
+	server, _ := newProvider(t)
+	p := oidc.New(oidc.Config{Issuer: server.Issuer() + "/", ClientID: "cucinia", RedirectURL: "http://app.test/callback"})
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Error("discovery accepted a document for another issuer")
+	}
+}
+
+func TestExchangeNeedsPKCEVerifier(t *testing.T) {
+	_, p := newProvider(t)
+	code := authorize(t, p, "state", "nonce", "verifier")
+	if _, err := p.Exchange(context.Background(), code, "another verifier"); err == nil {
+		t.Error("code exchanged without the PKCE verifier")
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	tests := map[string]struct {
+		override map[string]interface{}
+		nonce    string
+		tamper   func(string) string
+	}{
+		"wrong nonce":    {nonce: "another nonce"},
+		"expired":        {override: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}},
+		"future iat":     {override: map[string]interface{}{"iat": time.Now().Add(time.Hour).Unix()}},
+		"wrong audience": {override: map[string]interface{}{"aud": "someone-else"}},
+		"wrong issuer":   {override: map[string]interface{}{"iss": "https://evil.example.com"}},
+		"no subject":     {override: map[string]interface{}{"sub": ""}},
+		"forged subject": {tamper: func(token string) string {
+			parts := strings.Split(token, ".")
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			payload = []byte(strings.Replace(string(payload), ana.Subject, "sub-eve", 1))
+			parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+			return strings.Join(parts, ".")
+		}},
+		"unsigned": {tamper: func(token string) string {
+			parts := strings.Split(token, ".")
+			return "eyJhbGciOiJub25lIn0." + parts[1] + "."
+		}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, p := newProvider(t)
+			server.Override = tt.override
+			code := authorize(t, p, "state", "nonce", "verifier")
+			token, err := p.Exchange(context.Background(), code, "verifier")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			idToken, nonce := token.IDToken, "nonce"
+			if tt.tamper != nil {
+				idToken = tt.tamper(idToken)
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			if claims, err := p.Verify(context.Background(), idToken, nonce); err == nil {
+				t.Errorf("accepted %+v", claims)
+			}
+		})
+	}
+}
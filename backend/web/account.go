@@ -0,0 +1,332 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"cucinia/mailer"
+	"cucinia/model"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	tokenVerifyEmail   = "verify"
+	tokenResetPassword = "reset"
+	tokenChangeEmail   = "email"
+
+	verifyEmailDuration   = 48 * time.Hour
+	resetPasswordDuration = time.Hour
+
+	minPasswordLength = 8
+)
+
+// consumeTokenScript reads and deletes a token in one step, so a link can
+// never be used twice even when two requests race.
+var consumeTokenScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value then
+	redis.call("DEL", KEYS[1])
+end
+return value
+`)
+
+var errInvalidToken = errors.New("Link inválido ou expirado.")
+
+func validatePassword(password string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return errors.New("A senha deve ter pelo menos 8 caracteres.")
+	}
+	return nil
+}
+
+func appURL() string {
+	if u := os.Getenv("APP_URL"); u != "" {
+		return u
+	}
+	return "http://localhost:3000"
+}
+
+// Only a hash of each token is kept in Redis, so a leaked dump cannot be
+// used to take over accounts.
+func accountTokenKey(purpose, token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + purpose + ":" + hex.EncodeToString(sum[:])
+}
+
+func latestTokenKey(purpose, userID string) string {
+	return "token:" + purpose + ":latest:" + userID
+}
+
+// issueAccountToken creates a single-use token for a user. Issuing a new
+// token invalidates the previous one of the same purpose.
+func (a *App) issueAccountToken(purpose, userID string, ttl time.Duration) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	key := accountTokenKey(purpose, token)
+
+	previous, err := a.rdb.Get(latestTokenKey(purpose, userID)).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+
+	pipe := a.rdb.TxPipeline()
+	if previous != "" {
+		pipe.Del(previous)
+	}
+	pipe.Set(key, userID, ttl)
+	pipe.Set(latestTokenKey(purpose, userID), key, ttl)
+	if _, err := pipe.Exec(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// revokeAccountTokens drops the pending e-mail tokens of a user, the e-mail
+// change waiting for confirmation and any 2FA enrollment in progress.
+func (a *App) revokeAccountTokens(userID string) error {
+	keys := []string{emailChangeKey(userID), twoFactorEnrollKey(userID)}
+	for _, purpose := range []string{tokenVerifyEmail, tokenResetPassword, tokenChangeEmail} {
+		latest := latestTokenKey(purpose, userID)
+		if token, err := a.rdb.Get(latest).Result(); err == nil {
+			keys = append(keys, token)
+		}
+		keys = append(keys, latest)
+	}
+	return a.rdb.Del(keys...).Err()
+}
+
+func (a *App) consumeAccountToken(purpose, token string) (string, error) {
+	if token == "" {
+		return "", errInvalidToken
+	}
+
+	userID, err := consumeTokenScript.Run(a.rdb, []string{accountTokenKey(purpose, token)}).String()
+	if err == redis.Nil {
+		return "", errInvalidToken
+	}
+	if err != nil {
+		return "", err
+	}
+
+	a.rdb.Del(latestTokenKey(purpose, userID))
+	return userID, nil
+}
+
+// sendAccountEmail sends a link with a token tied to the user to the
+// address to.
+func (a *App) sendAccountEmail(ctx context.Context, user *model.User, to, template, purpose, path string, ttl time.Duration, validFor string) error {
+	token, err := a.issueAccountToken(purpose, user.ID.Hex(), ttl)
+	if err != nil {
+		return err
+	}
+
+	msg, err := mailer.Render(template, to, struct {
+		Name     string
+		Link     string
+		ValidFor string
+	}{
+		Name:     user.Name,
+		Link:     appURL() + path + "?token=" + url.QueryEscape(token),
+		ValidFor: validFor,
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.mail.Send(ctx, msg)
+}
+
+// normalizeEmail trims and lower-cases an e-mail so the same address always
+// maps to the same account. ok is false when it is not a plain address.
+func normalizeEmail(email string) (normalized string, ok bool) {
+	normalized = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(normalized)
+	return normalized, err == nil && addr.Address == normalized
+}
+
+func (a *App) sendVerificationEmail(ctx context.Context, user *model.User) error {
+	return a.sendAccountEmail(ctx, user, user.Email, "verify_email", tokenVerifyEmail, "/verificar-email", verifyEmailDuration, "48 horas")
+}
+
+func (a *App) sendPasswordResetEmail(ctx context.Context, user *model.User) error {
+	return a.sendAccountEmail(ctx, user, user.Email, "reset_password", tokenResetPassword, "/redefinir-senha", resetPasswordDuration, "1 hora")
+}
+
+func (a *App) sendEmailChangeEmail(ctx context.Context, user *model.User, newEmail string) error {
+	return a.sendAccountEmail(ctx, user, newEmail, "change_email", tokenChangeEmail, "/confirmar-novo-email", verifyEmailDuration, "48 horas")
+}
+
+// sendEmailChangedNotice tells the previous address of user that the
+// account moved to newEmail, so a change its owner did not make is noticed.
+func (a *App) sendEmailChangedNotice(ctx context.Context, user *model.User, newEmail string) error {
+	msg, err := mailer.Render("email_changed", user.Email, struct {
+		Name     string
+		NewEmail string
+	}{
+		Name:     user.Name,
+		NewEmail: newEmail,
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.mail.Send(ctx, msg)
+}
+
+// markEmailVerified confirms the e-mail of user, however they proved they
+// own it. The first confirmation pays out the referral they signed up with.
+func (a *App) markEmailVerified(c *gin.Context, user *model.User) error {
+	if err := a.d.SetUserEmailVerified(user.ID.Hex()); err != nil {
+		return err
+	}
+	if !user.EmailVerified && user.ReferredBy != "" {
+		a.applyReferral(c, user)
+	}
+	return nil
+}
+
+func (a *App) VerifyEmail(c *gin.Context) {
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	userID, err := a.consumeAccountToken(tokenVerifyEmail, payload.Token)
+	if err == errInvalidToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := a.d.GetUserByID(userID)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	if err := a.markEmailVerified(c, user); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	a.invalidateUserCache(userID)
+	a.invalidateUsersCache()
+
+	c.JSON(http.StatusOK, gin.H{"message": "E-mail confirmado."})
+}
+
+func (a *App) ResendVerificationEmail(c *gin.Context) {
+	user := currentUser(c)
+	if user.EmailVerified {
+		c.JSON(http.StatusConflict, gin.H{"error": "E-mail já confirmado."})
+		return
+	}
+
+	if err := a.sendVerificationEmail(c.Request.Context(), user); err != nil {
+		log.Println("erro enviando e-mail de verificação:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao enviar o e-mail."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "E-mail de verificação enviado."})
+}
+
+// ForgotPassword always answers the same way so it cannot be used to find
+// out which e-mails have an account.
+func (a *App) ForgotPassword(c *gin.Context) {
+	var payload struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	email, _ := normalizeEmail(payload.Email)
+	user, err := a.d.GetUserByEmail(email)
+	if err == nil && user != nil {
+		if err := a.sendPasswordResetEmail(c.Request.Context(), user); err != nil {
+			log.Println("erro enviando e-mail de redefinição de senha:", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Se o e-mail estiver cadastrado, você receberá um link para redefinir a senha."})
+}
+
+func (a *App) ResetPassword(c *gin.Context) {
+	var payload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	if err := validatePassword(payload.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := a.consumeAccountToken(tokenResetPassword, payload.Token)
+	if err == errInvalidToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao fazer o hashing."})
+		return
+	}
+
+	before, err := a.d.GetUserByID(userID)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.d.SetUserPassword(userID, string(hashedPassword)); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	// The link went to the account's e-mail, so using it confirms it.
+	if !before.EmailVerified {
+		if err := a.markEmailVerified(c, before); err != nil {
+			log.Println("erro confirmando e-mail:", err)
+		}
+	}
+	a.auditUserChange(c, model.AuditUpdate, before)
+	a.invalidateUserCache(userID)
+	a.invalidateUsersCache()
+	a.clearAllLoginFailures(before.Email)
+
+	// Anyone holding an old session loses access together with the old password.
+	if err := a.revokeSessions(userID, ""); err != nil {
+		log.Println("erro encerrando sessões:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Senha redefinida. Faça login novamente."})
+}
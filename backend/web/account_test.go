@@ -0,0 +1,91 @@
+package web
+
+import (
+	"cucinia/model"
+	"net/http"
+	"testing"
+)
+
+func TestRegisterValidatesAndNormalizesEmail(t *testing.T) {
+	ta := newTestApp(t)
+
+	for _, email := range []string{"", "ana", "Ana <ana@example.com>", "ana@example.com, bia@example.com"} {
+		w := ta.do(http.MethodPost, "/api/v1/register", map[string]string{"email": email, "password": "uma senha bem longa 42"}, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("register %q = %d, want 400", email, w.Code)
+		}
+	}
+
+	user := ta.register("  Ana@Example.com ", "")
+	if user.Email != "ana@example.com" {
+		t.Errorf("stored e-mail = %q, want it lower-cased", user.Email)
+	}
+	w := ta.do(http.MethodPost, "/api/v1/register", map[string]string{"email": "ana@example.COM", "password": "uma senha bem longa 42"}, "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("second register with another case = %d, want 400", w.Code)
+	}
+	w = ta.do(http.MethodPost, "/api/v1/login", map[string]string{"email": "ANA@example.com", "password": "uma senha bem longa 42"}, "")
+	if w.Code != http.StatusOK {
+		t.Errorf("login with another case = %d, want 200", w.Code)
+	}
+}
+
+func TestUnverifiedAccountsAreLimited(t *testing.T) {
+	ta := newTestApp(t)
+	user := ta.register("ana@example.com", "")
+	w := ta.do(http.MethodPost, "/api/v1/login", map[string]string{"email": "ana@example.com", "password": "uma senha bem longa 42"}, "")
+	token := decode[struct {
+		Token string `json:"token"`
+	}](t, w).Token
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/api/v1/submissions"},
+		{http.MethodGet, "/api/v1/users/me/referral"},
+		{http.MethodPost, "/api/v1/ai/recipes"},
+		{http.MethodPost, "/api/v1/gen"},
+		{http.MethodPost, "/api/v1/recipes/" + user.ID.Hex() + "/assistant"},
+	} {
+		w := ta.do(route.method, route.path, map[string]string{}, token)
+		if w.Code != http.StatusForbidden || decode[map[string]any](t, w)["email_verification_required"] != true {
+			t.Errorf("%s %s = %d, want 403 asking to verify", route.method, route.path, w.Code)
+		}
+	}
+
+	if code := ta.verifyEmail(user.ID.Hex()); code != http.StatusOK {
+		t.Fatalf("verify = %d", code)
+	}
+	if w := ta.do(http.MethodGet, "/api/v1/users/me/referral", nil, token); w.Code == http.StatusForbidden {
+		t.Error("referral still refused after verification")
+	}
+}
+
+func TestPasswordLoginWaitsForVerificationAfterIdentityLink(t *testing.T) {
+	ta := newTestApp(t)
+	user := ta.register("ana@example.com", "")
+	ta.db.updateUser(user.ID.Hex(), func(user *model.User) {
+		user.Identities = []model.Identity{{Provider: "mock", Subject: "sub-ana"}}
+	})
+
+	login := func() int {
+		return ta.do(http.MethodPost, "/api/v1/login", map[string]string{"email": "ana@example.com", "password": "uma senha bem longa 42"}, "").Code
+	}
+	if code := login(); code != http.StatusForbidden {
+		t.Errorf("login = %d, want 403 until the e-mail is confirmed", code)
+	}
+
+	// A reset link reaches the mailbox, so using it confirms the e-mail.
+	token, err := ta.issueAccountToken(tokenResetPassword, user.ID.Hex(), resetPasswordDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := ta.do(http.MethodPost, "/api/v1/password/reset", map[string]string{"token": token, "password": "uma senha bem longa 42"}, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset = %d: %s", w.Code, w.Body)
+	}
+	if !ta.db.user(user.ID.Hex()).EmailVerified {
+		t.Error("reset did not confirm the e-mail")
+	}
+	if code := login(); code != http.StatusOK {
+		t.Errorf("login after reset = %d, want 200", code)
+	}
+}
@@ -0,0 +1,471 @@
+package web
+
+import (
+	"context"
+	"cucinia/ai"
+	"cucinia/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+const (
+	// generatedRecipeTTL is how long a generated recipe can be saved.
+	generatedRecipeTTL = 24 * time.Hour
+	// recipeAttempts is how many answers the model may give before
+	// generation fails. Rejected answers are explained in the next prompt.
+	recipeAttempts   = 2
+	recipeGenTimeout = time.Minute
+)
+
+// Recipes list in "restriction" the restrictions they do not meet, e.g.
+// scrambled eggs have "vegano" and "laticinio".
+const (
+	restrictionVegan      = "vegano"
+	restrictionVegetarian = "vegetariano"
+	restrictionDairy      = "laticinio"
+	restrictionGluten     = "gluten"
+)
+
+var restrictions = []string{restrictionVegan, restrictionVegetarian, restrictionDairy, restrictionGluten}
+
+// restrictionKeywords maps words found in ingredient names to the
+// restrictions those ingredients break. It catches what the model forgets to
+// declare; it does not need to be complete.
+var restrictionKeywords = map[string][]string{
+	"leite":     {restrictionDairy, restrictionVegan},
+	"manteiga":  {restrictionDairy, restrictionVegan},
+	"queijo":    {restrictionDairy, restrictionVegan},
+	"iogurte":   {restrictionDairy, restrictionVegan},
+	"requeijão": {restrictionDairy, restrictionVegan},
+	"nata":      {restrictionDairy, restrictionVegan},
+	"ricota":    {restrictionDairy, restrictionVegan},
+	"muçarela":  {restrictionDairy, restrictionVegan},
+	"mussarela": {restrictionDairy, restrictionVegan},
+	"parmesão":  {restrictionDairy, restrictionVegan},
+	"chantilly": {restrictionDairy, restrictionVegan},
+	"carne":     {restrictionVegetarian, restrictionVegan},
+	"frango":    {restrictionVegetarian, restrictionVegan},
+	"peixe":     {restrictionVegetarian, restrictionVegan},
+	"bacon":     {restrictionVegetarian, restrictionVegan},
+	"presunto":  {restrictionVegetarian, restrictionVegan},
+	"linguiça":  {restrictionVegetarian, restrictionVegan},
+	"salsicha":  {restrictionVegetarian, restrictionVegan},
+	"calabresa": {restrictionVegetarian, restrictionVegan},
+	"salame":    {restrictionVegetarian, restrictionVegan},
+	"atum":      {restrictionVegetarian, restrictionVegan},
+	"sardinha":  {restrictionVegetarian, restrictionVegan},
+	"camarão":   {restrictionVegetarian, restrictionVegan},
+	"peru":      {restrictionVegetarian, restrictionVegan},
+	"ovo":       {restrictionVegan},
+	"mel":       {restrictionVegan},
+	"maionese":  {restrictionVegan},
+	"gelatina":  {restrictionVegetarian, restrictionVegan},
+	"farinha":   {restrictionGluten},
+	"trigo":     {restrictionGluten},
+	"pão":       {restrictionGluten},
+	"macarrão":  {restrictionGluten},
+	"biscoito":  {restrictionGluten},
+	"bolacha":   {restrictionGluten},
+	"cevada":    {restrictionGluten},
+	"centeio":   {restrictionGluten},
+	"torrada":   {restrictionGluten},
+	"cerveja":   {restrictionGluten},
+}
+
+// plantBased are names that contain a keyword above without breaking its
+// restrictions.
+var plantBased = []string{
+	"leite de coco", "leite de castanha", "leite de amêndoa", "leite de soja", "leite de aveia",
+	"farinha de mandioca", "farinha de milho", "farinha de arroz",
+	"manteiga de amendoim", "manteiga de cacau", "manteiga vegana", "queijo vegano", "queijo de castanha",
+}
+
+// impliedRestrictions returns the restrictions an ingredient breaks, as far
+// as its name tells.
+func impliedRestrictions(ingredient string) []string {
+	name := strings.ToLower(ingredient)
+	var implied []string
+	switch {
+	case name == "pão de queijo":
+		// Made of cassava starch, not wheat.
+		return []string{restrictionDairy, restrictionVegan}
+	case slices.ContainsFunc(plantBased, func(p string) bool { return strings.HasPrefix(name, p) }):
+		return nil
+	}
+	for _, word := range strings.Fields(name) {
+		implied = append(implied, restrictionKeywords[word]...)
+	}
+	return implied
+}
+
+// recipeRestrictions completes the restrictions a recipe declares with the
+// ones its ingredients imply. Anything that is not vegetarian, or has dairy,
+// is not vegan either.
+func recipeRestrictions(declared []string, ingredients []string) []string {
+	set := map[string]bool{}
+	for _, restriction := range declared {
+		set[restriction] = true
+	}
+	for _, ingredient := range ingredients {
+		for _, restriction := range impliedRestrictions(ingredient) {
+			set[restriction] = true
+		}
+	}
+	if set[restrictionVegetarian] || set[restrictionDairy] {
+		set[restrictionVegan] = true
+	}
+
+	result := []string{}
+	for _, restriction := range restrictions {
+		if set[restriction] {
+			result = append(result, restriction)
+		}
+	}
+	return result
+}
+
+// recipeContext is what generated recipes are checked against.
+type recipeContext struct {
+	// pantry maps the lower case names of the ingredients the recipe may
+	// use to their names in the catalog.
+	pantry       map[string]string
+	restrictions []string
+	cuisines     map[string]bool
+	mealTypes    map[int]string
+}
+
+// loadRecipeContext gathers the user's pantry, leaving out ingredients that
+// are not in the catalog or break one of their restrictions, and the
+// taxonomies.
+func (a *App) loadRecipeContext(user *model.User) (*recipeContext, error) {
+	catalog, err := a.d.GetIngredients()
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, ingredient := range catalog {
+		names[strings.ToLower(strings.TrimSpace(ingredient.Name))] = ingredient.Name
+	}
+
+	rc := &recipeContext{
+		pantry:       map[string]string{},
+		restrictions: user.Restriction,
+		cuisines:     map[string]bool{},
+		mealTypes:    map[int]string{},
+	}
+	for _, ingredient := range user.Ingredients {
+		key := strings.ToLower(strings.TrimSpace(ingredient))
+		name, ok := names[key]
+		if !ok || breaksAny(impliedRestrictions(name), user.Restriction) != "" {
+			continue
+		}
+		rc.pantry[key] = name
+	}
+
+	cuisines, err := a.d.GetCuisines()
+	if err != nil {
+		return nil, err
+	}
+	for _, cuisine := range cuisines {
+		rc.cuisines[cuisine.Slug] = true
+	}
+	mealTypes, err := a.d.GetMealTypes()
+	if err != nil {
+		return nil, err
+	}
+	for _, mealType := range mealTypes {
+		rc.mealTypes[mealType.Code] = mealType.Slug
+	}
+	return rc, nil
+}
+
+// breaksAny returns the first of the user's restrictions found in broken.
+func breaksAny(broken []string, userRestrictions []string) string {
+	for _, restriction := range userRestrictions {
+		if slices.Contains(broken, restriction) {
+			return restriction
+		}
+	}
+	return ""
+}
+
+func (rc *recipeContext) request(unit string) ai.RecipeRequest {
+	req := ai.RecipeRequest{
+		Restrictions: rc.restrictions,
+		MealTypes:    rc.mealTypes,
+		Unit:         unit,
+	}
+	for _, name := range rc.pantry {
+		req.Pantry = append(req.Pantry, name)
+	}
+	sort.Strings(req.Pantry)
+	for slug := range rc.cuisines {
+		req.Cuisines = append(req.Cuisines, slug)
+	}
+	sort.Strings(req.Cuisines)
+	return req
+}
+
+// check validates a generated recipe and normalizes it: ingredient names as
+// in the catalog, restrictions completed. Its errors are meant for the model
+// to correct its answer.
+func (rc *recipeContext) check(recipe *ai.GeneratedRecipe) error {
+	recipe.Name = strings.TrimSpace(recipe.Name)
+	recipe.Description = strings.TrimSpace(recipe.Description)
+	switch {
+	case recipe.Name == "" || len(recipe.Name) > 120:
+		return errors.New("\"name\" deve ter de 1 a 120 caracteres.")
+	case recipe.Description == "":
+		return errors.New("\"description\" está vazio.")
+	case len(recipe.Ingredients) == 0:
+		return errors.New("\"ingredients\" está vazio.")
+	case !rc.cuisines[recipe.Cuisine]:
+		return fmt.Errorf("a culinária '%s' não é permitida.", recipe.Cuisine)
+	case rc.mealTypes[recipe.TypeOf] == "":
+		return fmt.Errorf("o tipo de refeição %d não é permitido.", recipe.TypeOf)
+	case !slices.Contains(ai.Difficulties, recipe.Difficulty):
+		return fmt.Errorf("a dificuldade '%s' não é permitida.", recipe.Difficulty)
+	}
+
+	ingredients := []string{}
+	for _, ingredient := range recipe.Ingredients {
+		name, ok := rc.pantry[strings.ToLower(strings.TrimSpace(ingredient))]
+		if !ok {
+			return fmt.Errorf("o ingrediente '%s' não está na lista permitida.", ingredient)
+		}
+		if !slices.Contains(ingredients, name) {
+			ingredients = append(ingredients, name)
+		}
+	}
+	recipe.Ingredients = ingredients
+
+	for _, restriction := range recipe.Restriction {
+		if !slices.Contains(restrictions, restriction) {
+			return fmt.Errorf("a restrição '%s' não existe.", restriction)
+		}
+	}
+	recipe.Restriction = recipeRestrictions(recipe.Restriction, recipe.Ingredients)
+	if broken := breaksAny(recipe.Restriction, rc.restrictions); broken != "" {
+		return fmt.Errorf("a receita não é adequada para a restrição '%s'.", broken)
+	}
+	return nil
+}
+
+// generateRecipe asks the provider for a recipe for userID until it gives a
+// valid one or runs out of attempts. It returns the prompt version used.
+func (a *App) generateRecipe(ctx context.Context, rc *recipeContext, userID string) (*ai.GeneratedRecipe, string, error) {
+	req := rc.request(userID)
+	var lastErr error
+	var version string
+	for attempt := 0; attempt < recipeAttempts; attempt++ {
+		answer, err := a.ai.GenerateRecipe(ctx, req)
+		version = answer.Prompt
+		if err != nil {
+			if ctx.Err() == nil {
+				recordPromptOutcome(a.rdb, ai.TaskRecipe, version, outcomeError, 1)
+			}
+			return nil, version, err
+		}
+
+		recipe, err := ai.ParseRecipe(answer.Text)
+		if err == nil {
+			err = rc.check(recipe)
+		}
+		if err == nil {
+			outcome := outcomeValid
+			if attempt > 0 {
+				outcome = outcomeCorrected
+			}
+			recordPromptOutcome(a.rdb, ai.TaskRecipe, version, outcome, 1)
+			return recipe, version, nil
+		}
+		log.Println("receita gerada recusada:", err)
+		lastErr = err
+		req.Feedback = err.Error()
+	}
+	recordPromptOutcome(a.rdb, ai.TaskRecipe, version, outcomeInvalid, 1)
+	return nil, version, &invalidGenerationError{lastErr}
+}
+
+// invalidGenerationError is returned when no answer of the model was valid.
+type invalidGenerationError struct {
+	err error
+}
+
+func (e *invalidGenerationError) Error() string {
+	return "A IA não gerou uma receita válida: " + e.err.Error()
+}
+
+type generatedRecipeEntry struct {
+	UserID        string             `json:"user_id"`
+	Recipe        ai.GeneratedRecipe `json:"recipe"`
+	PromptVersion string             `json:"prompt_version,omitempty"`
+}
+
+func generatedRecipeKey(id string) string {
+	return "ai:recipe:" + id
+}
+
+// generatedRecipesKey indexes the generated recipes of a user, to erase
+// them with the account.
+func generatedRecipesKey(userID string) string {
+	return "ai:recipes:" + userID
+}
+
+type generatedRecipeExport struct {
+	ID string `json:"id"`
+	generatedRecipeEntry
+}
+
+// exportGeneratedRecipes lists the generated recipes of userID not saved
+// yet.
+func (a *App) exportGeneratedRecipes(userID string) ([]generatedRecipeExport, error) {
+	ids, err := a.rdb.SMembers(generatedRecipesKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	recipes := []generatedRecipeExport{}
+	for _, id := range ids {
+		val, err := a.rdb.Get(generatedRecipeKey(id)).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		recipe := generatedRecipeExport{ID: id}
+		if err := json.Unmarshal([]byte(val), &recipe.generatedRecipeEntry); err != nil || recipe.UserID != userID {
+			continue
+		}
+		recipes = append(recipes, recipe)
+	}
+	return recipes, nil
+}
+
+// forgetGeneratedRecipes deletes the unsaved generated recipes of userID.
+func (a *App) forgetGeneratedRecipes(userID string) error {
+	return deleteIndexed(a.rdb, generatedRecipesKey(userID), func(id string) []string {
+		return []string{generatedRecipeKey(id)}
+	})
+}
+
+// GenerateRecipe writes a recipe from the caller's pantry that respects
+// their restrictions. It is kept for a day for the caller to save it as a
+// draft.
+func (a *App) GenerateRecipe(c *gin.Context) {
+	user := currentUser(c)
+
+	rc, err := a.loadRecipeContext(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rc.pantry) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Adicione à sua despensa ingredientes do catálogo compatíveis com suas restrições."})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), recipeGenTimeout)
+	defer cancel()
+	recipe, version, err := a.generateRecipe(ctx, rc, user.ID.Hex())
+	var invalid *invalidGenerationError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ai.ErrNotConfigured):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Geração de receitas indisponível."})
+		return
+	case err != nil:
+		log.Println("erro gerando receita:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Falha ao gerar a receita."})
+		return
+	}
+
+	id, err := a.storeGeneratedRecipe(user.ID.Hex(), recipe, version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":             id,
+		"recipe":         recipe,
+		"prompt_version": version,
+		"expires_at":     time.Now().Add(generatedRecipeTTL).UTC(),
+	})
+}
+
+// storeGeneratedRecipe keeps a recipe generated for userID until it is
+// saved or expires, and returns its ID.
+func (a *App) storeGeneratedRecipe(userID string, recipe *ai.GeneratedRecipe, version string) (string, error) {
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+	entry, err := json.Marshal(generatedRecipeEntry{UserID: userID, Recipe: *recipe, PromptVersion: version})
+	if err != nil {
+		return "", err
+	}
+
+	pipe := a.rdb.TxPipeline()
+	pipe.Set(generatedRecipeKey(id), entry, generatedRecipeTTL)
+	pipe.SAdd(generatedRecipesKey(userID), id)
+	pipe.Expire(generatedRecipesKey(userID), generatedRecipeTTL)
+	if _, err := pipe.Exec(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// SaveGeneratedRecipe stores a generated recipe as a draft of the caller,
+// only visible to them until they submit it for review.
+func (a *App) SaveGeneratedRecipe(c *gin.Context) {
+	user := currentUser(c)
+	key := generatedRecipeKey(c.Param("id"))
+
+	val, err := a.rdb.Get(key).Result()
+	var entry generatedRecipeEntry
+	if err == nil {
+		err = json.Unmarshal([]byte(val), &entry)
+	}
+	if err != nil || entry.UserID != user.ID.Hex() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receita gerada não encontrada."})
+		return
+	}
+
+	generated := entry.Recipe
+	recipe := &model.Recipe{
+		Name:          generated.Name,
+		Description:   generated.Description,
+		Cuisine:       generated.Cuisine,
+		TypeOf:        generated.TypeOf,
+		Ingredients:   generated.Ingredients,
+		Difficulty:    generated.Difficulty,
+		Restriction:   generated.Restriction,
+		AuthorID:      user.ID.Hex(),
+		AuthorName:    user.Name,
+		Generated:     true,
+		PromptVersion: entry.PromptVersion,
+	}
+	if err := a.d.CreateSubmission(recipe); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	a.rdb.Del(key)
+	recordPromptOutcome(a.rdb, ai.TaskRecipe, entry.PromptVersion, outcomeSaved, 1)
+	a.audit(c, model.AuditCreate, model.EntityRecipe, recipe.ID.Hex(), nil, recipe)
+
+	c.JSON(http.StatusCreated, recipe)
+}
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"context"
+	"cucinia/ai"
+	"cucinia/billing"
+	"cucinia/model"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// scriptedRecipes gives the answers in order, then lets ai.Fake answer. It
+// keeps the feedback sent with each request.
+type scriptedRecipes struct {
+	ai.Fake
+	answers  []string
+	feedback []string
+}
+
+func (p *scriptedRecipes) GenerateRecipe(ctx context.Context, req ai.RecipeRequest) (ai.Answer, error) {
+	p.feedback = append(p.feedback, req.Feedback)
+	if len(p.answers) == 0 {
+		return p.Fake.GenerateRecipe(ctx, req)
+	}
+	answer := p.answers[0]
+	p.answers = p.answers[1:]
+	return ai.Answer{Text: answer, Prompt: "recipe@test"}, nil
+}
+
+type generatedRecipeResponse struct {
+	ID            string             `json:"id"`
+	Recipe        ai.GeneratedRecipe `json:"recipe"`
+	PromptVersion string             `json:"prompt_version"`
+}
+
+// newRecipeTestApp has a catalog and a vegetarian user whose pantry holds
+// milk and eggs, bacon, which they cannot eat, and something not in the
+// catalog.
+func newRecipeTestApp(t *testing.T, provider *scriptedRecipes) (*testApp, *model.User, string) {
+	provider.Prompts = mustPrompts(t)
+	ta := newTestAppWith(t, billing.None{}, provider)
+	for _, name := range []string{"Leite", "Ovo", "Bacon", "Farinha"} {
+		ta.db.CreateIngredient(&model.Ingredient{Name: name})
+	}
+	ta.db.cuisines = []*model.Cuisine{{ID: primitive.NewObjectID(), Slug: "brasileira"}}
+	ta.db.mealTypes = []*model.MealType{{ID: primitive.NewObjectID(), Code: 2, Slug: "jantar"}, {ID: primitive.NewObjectID(), Code: 1, Slug: "almoco"}}
+
+	user, token := ta.addUser("ana@example.com", model.RoleUser)
+	ta.db.updateUser(user.ID.Hex(), func(u *model.User) {
+		u.Ingredients = []string{"leite", "Ovo", "bacon", "unicórnio"}
+		u.Restriction = []string{restrictionVegetarian}
+	})
+	return ta, user, token
+}
+
+func TestGenerateRecipeFromPantry(t *testing.T) {
+	provider := &scriptedRecipes{}
+	ta, user, token := newRecipeTestApp(t, provider)
+
+	w := ta.do(http.MethodPost, "/api/v1/ai/recipes", nil, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("generate = %d %s", w.Code, w.Body)
+	}
+	generated := decode[generatedRecipeResponse](t, w)
+	recipe := generated.Recipe
+	if !slices.Equal(recipe.Ingredients, []string{"Leite", "Ovo"}) {
+		t.Errorf("ingredients = %v, want only the catalog's names of what the user may eat", recipe.Ingredients)
+	}
+	if !slices.Equal(recipe.Restriction, []string{restrictionVegan, restrictionDairy}) {
+		t.Errorf("restriction = %v, want the ones implied by the ingredients", recipe.Restriction)
+	}
+	if recipe.Cuisine != "brasileira" || recipe.TypeOf != 1 || generated.PromptVersion == "" {
+		t.Errorf("got %+v with prompt %q", recipe, generated.PromptVersion)
+	}
+	if len(provider.feedback) != 1 {
+		t.Errorf("%d requests to the model for a valid answer", len(provider.feedback))
+	}
+	if used := ta.quotaUsed(user, featureAIRecipes); used != 1 {
+		t.Errorf("%d uses counted, want 1", used)
+	}
+
+	w = ta.do(http.MethodPost, "/api/v1/ai/recipes/"+generated.ID+"/save", nil, token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("save = %d %s", w.Code, w.Body)
+	}
+	saved := decode[model.Recipe](t, w)
+	if saved.Status != model.RecipeDraft || saved.AuthorID != user.ID.Hex() || !saved.Generated || saved.PromptVersion != generated.PromptVersion {
+		t.Errorf("saved %+v", saved)
+	}
+	if w := ta.do(http.MethodPost, "/api/v1/ai/recipes/"+generated.ID+"/save", nil, token); w.Code != http.StatusNotFound {
+		t.Errorf("second save = %d, want 404", w.Code)
+	}
+}
+
+func TestGenerateRecipeRetriesInvalidAnswers(t *testing.T) {
+	tests := map[string]struct {
+		answers  []string
+		feedback string
+	}{
+		"bad JSON":        {answers: []string{`{"name": "Omelete",`}, feedback: "JSON inválido"},
+		"unknown field":   {answers: []string{`{"name": "Omelete", "calories": 300}`}, feedback: "JSON inválido"},
+		"outside schema":  {answers: []string{`{"name": "Omelete", "description": "Bata os ovos.", "cuisine": "brasileira", "type_of": 1, "ingredients": ["Ovo"], "difficulty": "impossível", "restriction": []}`}, feedback: "dificuldade"},
+		"not in pantry":   {answers: []string{`{"name": "Bolo", "description": "Asse.", "cuisine": "brasileira", "type_of": 1, "ingredients": ["Farinha"], "difficulty": "fácil", "restriction": []}`}, feedback: "'Farinha' não está na lista"},
+		"breaks the diet": {answers: []string{`{"name": "Ovos com bacon", "description": "Frite.", "cuisine": "brasileira", "type_of": 1, "ingredients": ["Ovo"], "difficulty": "fácil", "restriction": ["vegetariano"]}`}, feedback: "'vegetariano'"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			provider := &scriptedRecipes{answers: tt.answers}
+			ta, _, token := newRecipeTestApp(t, provider)
+
+			w := ta.do(http.MethodPost, "/api/v1/ai/recipes", nil, token)
+			if w.Code != http.StatusOK {
+				t.Fatalf("generate = %d %s, want the corrected answer", w.Code, w.Body)
+			}
+			if len(provider.feedback) != 2 || !strings.Contains(provider.feedback[1], tt.feedback) {
+				t.Errorf("feedback = %q, want the retry to explain %q", provider.feedback, tt.feedback)
+			}
+		})
+	}
+}
+
+func TestGenerateRecipeGivesUp(t *testing.T) {
+	provider := &scriptedRecipes{}
+	for i := 0; i < recipeAttempts; i++ {
+		provider.answers = append(provider.answers, "não é JSON")
+	}
+	ta, user, token := newRecipeTestApp(t, provider)
+
+	w := ta.do(http.MethodPost, "/api/v1/ai/recipes", nil, token)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("generate = %d %s, want 502", w.Code, w.Body)
+	}
+	if len(provider.feedback) != recipeAttempts {
+		t.Errorf("%d requests to the model, want %d", len(provider.feedback), recipeAttempts)
+	}
+	if used := ta.quotaUsed(user, featureAIRecipes); used != 0 {
+		t.Errorf("%d uses counted for a failed generation", used)
+	}
+	if keys := ta.redis.Keys(); slices.ContainsFunc(keys, func(key string) bool { return strings.HasPrefix(key, "ai:recipe:") }) {
+		t.Errorf("invalid recipe stored: %v", keys)
+	}
+}
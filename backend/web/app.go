@@ -1,15 +1,19 @@
 package web
 
 import (
-	ai "cucinia/ai"
+	"cucinia/ai"
+	"cucinia/billing"
 	"cucinia/db"
+	"cucinia/mailer"
 	"cucinia/model"
+	"cucinia/oidc"
+	"cucinia/storage"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
@@ -17,28 +21,79 @@ import (
 )
 
 type App struct {
-	d      db.DB
-	rdb    *redis.Client
-	router *gin.Engine
+	d         db.DB
+	rdb       *redis.Client
+	router    *gin.Engine
+	stats     *recipeStats
+	gen       *genQueue
+	scanCache *scanCache
+	store     storage.Storage
+	mail      mailer.Mailer
+	limiter   *rateLimiter
+	oidc      map[string]*oidc.Provider
+	billing   billing.Provider
+	ai        ai.Provider
+
+	erasureGrace time.Duration
 }
 
-func NewApp(d db.DB, rdb *redis.Client, cors bool) *App {
-	app := &App{
-		d:      d,
-		rdb:    rdb,
-		router: gin.Default(),
-	}
-
+func NewApp(d db.DB, rdb *redis.Client, store storage.Storage, mail mailer.Mailer, pay billing.Provider, provider ai.Provider, cors bool) *App {
+	app := newApp(d, rdb, store, mail, pay, provider)
+	app.stats.start()
+	app.gen.start()
+	go app.erasureLoop()
+	go app.subscriptionLoop()
 	app.setupRoutes(cors)
 	return app
 }
 
+// newApp wires the App without starting its background jobs.
+func newApp(d db.DB, rdb *redis.Client, store storage.Storage, mail mailer.Mailer, pay billing.Provider, provider ai.Provider) *App {
+	app := &App{
+		d:       d,
+		rdb:     rdb,
+		store:   store,
+		mail:    mail,
+		billing: pay,
+		ai:      provider,
+		limiter: newRateLimiter(rdb),
+		oidc:    newOIDCProviders(),
+		router:  newRouter(),
+		stats:   newRecipeStats(d, rdb),
+
+		erasureGrace: newErasureGracePeriod(),
+	}
+
+	app.scanCache = newScanCache(rdb)
+	app.gen = newGenQueue(rdb, app.scanCache, provider, app.releaseUsage)
+	return app
+}
+
+// newRouter creates the gin engine. The client IP that rate limits and the
+// audit log rely on is only taken from X-Forwarded-For or X-Real-IP when the
+// request comes from one of the proxies in TRUSTED_PROXIES (comma separated
+// IPs or CIDRs); otherwise it is the address of the connection.
+func newRouter() *gin.Engine {
+	router := gin.Default()
+	var proxies []string
+	for _, n := range parseIPNets("TRUSTED_PROXIES", os.Getenv("TRUSTED_PROXIES")) {
+		proxies = append(proxies, n.String())
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		log.Println("erro configurando os proxies confiáveis:", err)
+	}
+	return router
+}
+
 func (a *App) setupRoutes(cors bool) {
+	a.router.Use(requestID)
+
 	if cors {
 		a.router.Use(func(c *gin.Context) {
 			c.Writer.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-			c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+			c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-Match, X-Request-ID")
+			c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-ID, X-Quota-Limit, X-Quota-Remaining, X-Quota-Reset, Retry-After")
 			c.Writer.Header().Set("ngrok-skip-browser-warning", "true")
 			if c.Request.Method == "OPTIONS" {
 				c.AbortWithStatus(http.StatusOK)
@@ -48,42 +103,140 @@ func (a *App) setupRoutes(cors bool) {
 		})
 	}
 
-	api := a.router.Group("/api/v1")
+	api := a.router.Group("/api/v1", a.rateLimit("api"))
 	{
 		api.GET("/ingredients", a.GetIngredients)
 		api.GET("/ingredients/:id", a.GetIngredientByID)
-		api.POST("/ingredients", a.CreateIngredient)
-		api.PATCH("/ingredients/:id", a.UpdateIngredient)
-		api.DELETE("/ingredients/:id", a.DeleteIngredient)
+		api.POST("/ingredients", a.requireAuth, a.requireRole(model.RoleEditor, model.RoleAdmin), a.CreateIngredient)
+		api.PATCH("/ingredients/:id", a.requireAuth, a.requireRole(model.RoleEditor, model.RoleAdmin), a.UpdateIngredient)
+		api.DELETE("/ingredients/:id", a.requireAuth, a.requireRole(model.RoleEditor, model.RoleAdmin), a.DeleteIngredient)
+
+		api.GET("/recipes", a.optionalAuth, a.GetRecipes)
+		api.GET("/recipes/by-cuisine/:cuisine", a.optionalAuth, a.GetRecipesByCuisine)
+		api.GET("/recipes/by-id/:id", a.optionalAuth, a.GetRecipeByID)
+		api.GET("/recipes/by-type/:type", a.optionalAuth, a.GetRecipesByTypeOf)
+		api.GET("/recipes/by-ingredient/:ingredient", a.optionalAuth, a.GetRecipesByIngredient)
+		api.GET("/recipes/by-multiple-criteria", a.optionalAuth, a.GetRecipesByMultipleCriteria)
+		api.GET("/recipes/trending", a.optionalAuth, a.GetTrendingRecipes)
+		api.GET("/recipes/popular", a.optionalAuth, a.GetPopularRecipes)
+		api.POST("/recipes/:id/cook", a.requireAuth, a.CookRecipe)
+		api.POST("/recipes/:id/image", a.requireAuth, a.UploadRecipeImage)
+		api.GET("/recipes/:id/substitutions", a.optionalAuth, a.GetRecipeSubstitutions)
+		api.POST("/recipes/:id/assistant", a.requireAuth, a.requireVerifiedEmail, a.rateLimit("assistant"), a.AskAssistant)
+		api.GET("/recipes/:id/assistant/:session", a.requireAuth, a.GetAssistantSession)
+		api.DELETE("/recipes/:id/assistant/:session", a.requireAuth, a.DeleteAssistantSession)
+		api.GET("/media/*key", a.ServeMedia)
+		api.POST("/recipes", a.requireAuth, a.requireRole(model.RoleEditor, model.RoleAdmin), a.CreateRecipe)
+		api.PATCH("/recipes/:id", a.requireAuth, a.requireRole(model.RoleEditor, model.RoleAdmin), a.UpdateRecipe)
+		api.DELETE("/recipes/:id", a.requireAuth, a.requireRole(model.RoleEditor, model.RoleAdmin), a.DeleteRecipe)
+		api.GET("/recipes/:id/revisions", a.requireAuth, a.requireRole(model.RoleEditor, model.RoleAdmin), a.GetRecipeRevisions)
+		api.POST("/recipes/:id/revisions/:rev/restore", a.requireAuth, a.requireRole(model.RoleEditor, model.RoleAdmin), a.RestoreRecipeRevision)
+
+		api.POST("/register", a.rateLimit("auth"), a.RegisterUser)
+		api.POST("/login", a.rateLimit("auth"), a.LoginUser)
+		api.POST("/login/2fa", a.rateLimit("auth"), a.LoginTwoFactor)
+		api.GET("/auth/oidc", a.GetOIDCProviders)
+		api.GET("/auth/oidc/:provider/login", a.rateLimit("auth"), a.OIDCLogin)
+		api.GET("/auth/oidc/:provider/callback", a.rateLimit("auth"), a.OIDCCallback)
+		api.DELETE("/users/:id", a.requireAuth, a.requireRole(model.RoleAdmin), a.DeleteUser)
+
+		api.GET("/users", a.requireAuth, a.requireRole(model.RoleAdmin), a.GetUsers)
+		api.GET("/users/:id", a.optionalAuth, a.GetUser)
+		api.GET("/users/liked-recipes", a.requireAuth, a.GetUserLikedRecipes)
+
+		api.POST("/user-ingredients/add", a.requireAuth, a.AddUserIngredient)
+		api.POST("/user-ingredients/remove", a.requireAuth, a.RemoveUserIngredient)
+		api.DELETE("/user-ingredients/remove/all", a.requireAuth, a.RemoveAllUserIngredients)
+
+		api.POST("/like-recipe", a.requireAuth, a.LikeRecipe)
+		api.POST("/unlike-recipe", a.requireAuth, a.UnlikeRecipe)
+
+		api.GET("/plans", a.GetPlans)
+		api.GET("/coupons/:code", a.requireAuth, a.rateLimit("auth"), a.CheckCoupon)
+		api.POST("/billing/webhook", a.BillingWebhook)
+
+		api.POST("/gen", a.requireAuth, a.requireVerifiedEmail, a.rateLimit("gen"), a.checkScanCache, a.requireQuota(featureAIScans), a.Gemini)
+		api.GET("/gen/:job", a.requireAuth, a.GetGenJob)
+		api.DELETE("/gen/:job", a.requireAuth, a.CancelGenJob)
+
+		api.POST("/ai/recipes", a.requireAuth, a.requireVerifiedEmail, a.rateLimit("gen"), a.requireQuota(featureAIRecipes), a.GenerateRecipe)
+		api.POST("/ai/recipes/:id/save", a.requireAuth, a.SaveGeneratedRecipe)
+
+		api.GET("/taxonomies", a.GetTaxonomies)
+		api.POST("/logout", a.LogoutUser)
+
+		api.POST("/verify-email", a.rateLimit("auth"), a.VerifyEmail)
+		api.POST("/verify-email/resend", a.requireAuth, a.rateLimit("auth"), a.ResendVerificationEmail)
+		api.POST("/password/forgot", a.rateLimit("auth"), a.ForgotPassword)
+		api.POST("/password/reset", a.rateLimit("auth"), a.ResetPassword)
+	}
+
+	me := api.Group("/users/me", a.requireAuth)
+	{
+		me.GET("", a.GetMe)
+		me.PATCH("", a.UpdateMe)
+		me.POST("/password", a.rateLimit("auth"), a.ChangePassword)
+		me.POST("/email", a.rateLimit("auth"), a.RequestEmailChange)
+		me.GET("/export", a.ExportMe)
+		me.GET("/entitlements", a.GetEntitlements)
+		me.POST("/erasure", a.rateLimit("auth"), a.RequestErasure)
+		me.DELETE("/erasure", a.CancelErasure)
+		me.GET("/subscription", a.GetSubscription)
+		me.POST("/subscription", a.StartSubscription)
+		me.DELETE("/subscription", a.CancelSubscription)
+		me.GET("/referral", a.requireVerifiedEmail, a.GetReferral)
+	}
+	api.POST("/email-change/confirm", a.rateLimit("auth"), a.ConfirmEmailChange)
+
+	twoFactor := api.Group("/users/me/2fa", a.requireAuth, a.rateLimit("auth"))
+	{
+		twoFactor.POST("/enroll", a.EnrollTwoFactor)
+		twoFactor.POST("/confirm", a.ConfirmTwoFactor)
+		twoFactor.POST("/disable", a.DisableTwoFactor)
+		twoFactor.POST("/recovery-codes", a.RegenerateRecoveryCodes)
+	}
 
-		api.GET("/recipes", a.GetRecipes)
-		api.GET("/recipes/by-cuisine/:cuisine", a.GetRecipesByCuisine)
-		api.GET("/recipes/by-id/:id", a.GetRecipeByID)
-		api.GET("/recipes/by-type/:type", a.GetRecipesByTypeOf)
-		api.GET("/recipes/by-ingredient/:ingredient", a.GetRecipesByIngredient)
-		api.GET("/recipes/by-multiple-criteria", a.GetRecipesByMultipleCriteria)
-		api.POST("/recipes", a.CreateRecipe)
-		api.PATCH("/recipes/:id", a.UpdateRecipe)
-		api.DELETE("/recipes/:id", a.DeleteRecipe)
+	submissions := api.Group("/submissions", a.requireAuth, a.requireVerifiedEmail)
+	{
+		submissions.GET("", a.GetSubmissions)
+		submissions.POST("", a.CreateSubmission)
+		submissions.GET("/:id", a.GetSubmission)
+		submissions.PATCH("/:id", a.UpdateSubmission)
+		submissions.DELETE("/:id", a.DeleteSubmission)
+		submissions.POST("/:id/submit", a.SubmitRecipe)
+	}
 
-		api.POST("/register", a.RegisterUser)
-		api.POST("/login", a.LoginUser)
-		api.DELETE("/users/:email", a.DeleteUser)
+	review := api.Group("/review", a.requireAuth, a.requireRole(model.RoleEditor, model.RoleAdmin))
+	{
+		review.GET("/queue", a.GetReviewQueue)
+		review.POST("/:id/approve", a.ApproveRecipe)
+		review.POST("/:id/reject", a.RejectRecipe)
+	}
 
-		api.GET("/users", a.GetUsers)
-		api.GET("/users/:email", a.GetUserByEmail)
-		api.GET("/users/liked-recipes", a.GetUserLikedRecipes)
+	admin := api.Group("/admin", a.requireAuth, a.requireRole(model.RoleAdmin))
+	{
+		admin.POST("/cuisines", a.CreateCuisine)
+		admin.PATCH("/cuisines/:id", a.UpdateCuisine)
+		admin.DELETE("/cuisines/:id", a.DeleteCuisine)
 
-		api.POST("/user-ingredients/add", a.AddUserIngredient)
-		api.POST("/user-ingredients/remove", a.RemoveUserIngredient)
-		api.DELETE("/user-ingredients/remove/all", a.RemoveAllUserIngredients)
+		admin.POST("/meal-types", a.CreateMealType)
+		admin.PATCH("/meal-types/:id", a.UpdateMealType)
+		admin.DELETE("/meal-types/:id", a.DeleteMealType)
 
-		api.POST("/like-recipe", a.LikeRecipe)
-		api.POST("/unlike-recipe", a.UnlikeRecipe)
+		admin.GET("/substitutions", a.GetSubstitutions)
+		admin.POST("/substitutions", a.CreateSubstitution)
+		admin.PATCH("/substitutions/:id", a.UpdateSubstitution)
+		admin.DELETE("/substitutions/:id", a.DeleteSubstitution)
+		admin.POST("/substitutions/:id/enrich", a.rateLimit("gen"), a.EnrichSubstitution)
 
-		api.POST("/upgrade", a.UpgradeToPremium)
+		admin.GET("/audit", a.GetAuditLog)
+		admin.GET("/metrics/scan-cache", a.GetScanCacheMetrics)
+		admin.GET("/metrics/prompts", a.GetPromptMetrics)
 
-		api.POST("/gen", a.Gemini)
+		admin.GET("/coupons", a.GetCoupons)
+		admin.POST("/coupons", a.CreateCoupon)
+		admin.PATCH("/coupons/:id", a.UpdateCoupon)
+		admin.GET("/coupons/:id/redemptions", a.GetCouponRedemptions)
 	}
 }
 
@@ -157,6 +310,7 @@ func (a *App) CreateIngredient(c *gin.Context) {
 	}
 
 	a.rdb.Del("ingredients")
+	a.audit(c, model.AuditCreate, model.EntityIngredient, ingredient.ID.Hex(), nil, ingredient)
 
 	c.JSON(http.StatusCreated, ingredient)
 }
@@ -197,11 +351,14 @@ func (a *App) UpdateIngredient(c *gin.Context) {
 		a.rdb.Set("ingredient:"+id, ingredientJSON, 0)
 	}
 
+	a.audit(c, model.AuditUpdate, model.EntityIngredient, id, existingIngredient, updatedIngredient)
+
 	c.JSON(http.StatusOK, updatedIngredient)
 }
 
 func (a *App) DeleteIngredient(c *gin.Context) {
 	id := c.Param("id")
+	existingIngredient, _ := a.d.GetIngredientByID(id)
 	if err := a.d.DeleteIngredient(id); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -209,12 +366,16 @@ func (a *App) DeleteIngredient(c *gin.Context) {
 
 	a.rdb.Del("ingredient:" + id)
 	a.rdb.Del("ingredients")
+	a.audit(c, model.AuditDelete, model.EntityIngredient, id, existingIngredient, nil)
 
 	c.JSON(http.StatusNoContent, gin.H{})
 }
 
 func (a *App) GetRecipes(c *gin.Context) {
-	val, err := a.rdb.Get("recipes").Result()
+	tier := a.accessTier(c)
+	cacheKey := recipeListKey(tier)
+
+	val, err := a.rdb.Get(cacheKey).Result()
 	if err == nil {
 		var recipes []*model.Recipe
 		if err := json.Unmarshal([]byte(val), &recipes); err != nil {
@@ -230,10 +391,11 @@ func (a *App) GetRecipes(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recipes = recipesForTier(recipes, tier)
 
 	recipesJSON, err := json.Marshal(recipes)
 	if err == nil {
-		a.rdb.Set("recipes", recipesJSON, 0)
+		a.rdb.Set(cacheKey, recipesJSON, 0)
 	}
 
 	c.JSON(http.StatusOK, recipes)
@@ -242,7 +404,10 @@ func (a *App) GetRecipes(c *gin.Context) {
 func (a *App) GetRecipesByCuisine(c *gin.Context) {
 	cuisine := c.Param("cuisine")
 
-	val, err := a.rdb.Get("recipes:cuisine:" + cuisine).Result()
+	tier := a.accessTier(c)
+	cacheKey := recipeListKey(tier, "cuisine", cuisine)
+
+	val, err := a.rdb.Get(cacheKey).Result()
 	if err == nil {
 		var recipes []*model.Recipe
 		if err := json.Unmarshal([]byte(val), &recipes); err != nil {
@@ -258,10 +423,11 @@ func (a *App) GetRecipesByCuisine(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recipes = recipesForTier(recipes, tier)
 
 	recipesJSON, err := json.Marshal(recipes)
 	if err == nil {
-		a.rdb.Set("recipes:cuisine:"+cuisine, recipesJSON, 0)
+		a.rdb.Set(cacheKey, recipesJSON, 0)
 	}
 
 	c.JSON(http.StatusOK, recipes)
@@ -277,7 +443,12 @@ func (a *App) GetRecipeByID(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, recipe)
+		if !isPublished(recipe) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Receita não encontrada."})
+			return
+		}
+		a.trackOnce(c, id, eventView)
+		a.respondWithTier(c, recipe)
 		return
 	}
 
@@ -286,19 +457,39 @@ func (a *App) GetRecipeByID(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if !isPublished(recipe) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receita não encontrada."})
+		return
+	}
 
 	recipeJSON, err := json.Marshal(recipe)
 	if err == nil {
 		a.rdb.Set("recipe:"+id, recipeJSON, 0)
 	}
 
+	a.trackOnce(c, id, eventView)
+
+	a.respondWithTier(c, recipe)
+}
+
+// respondWithTier sends recipe, or its teaser when the caller's tier does
+// not include it. Only full recipes carry an ETag, since teasers cannot be
+// edited.
+func (a *App) respondWithTier(c *gin.Context, recipe *model.Recipe) {
+	recipe = recipeForTier(recipe, a.accessTier(c))
+	if !recipe.Locked {
+		c.Header("ETag", recipeETag(recipe.Version))
+	}
 	c.JSON(http.StatusOK, recipe)
 }
 
 func (a *App) GetRecipesByTypeOf(c *gin.Context) {
 	typeOf := c.Param("type")
 
-	val, err := a.rdb.Get("recipes:type:" + typeOf).Result()
+	tier := a.accessTier(c)
+	cacheKey := recipeListKey(tier, "type", typeOf)
+
+	val, err := a.rdb.Get(cacheKey).Result()
 	if err == nil {
 		var recipes []*model.Recipe
 		if err := json.Unmarshal([]byte(val), &recipes); err != nil {
@@ -311,13 +502,14 @@ func (a *App) GetRecipesByTypeOf(c *gin.Context) {
 
 	recipes, err := a.d.GetRecipesByTypeOf(typeOf)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
 		return
 	}
+	recipes = recipesForTier(recipes, tier)
 
 	recipesJSON, err := json.Marshal(recipes)
 	if err == nil {
-		a.rdb.Set("recipes:type:"+typeOf, recipesJSON, 0)
+		a.rdb.Set(cacheKey, recipesJSON, 0)
 	}
 
 	c.JSON(http.StatusOK, recipes)
@@ -326,7 +518,10 @@ func (a *App) GetRecipesByTypeOf(c *gin.Context) {
 func (a *App) GetRecipesByIngredient(c *gin.Context) {
 	ingredient := c.Param("ingredient")
 
-	val, err := a.rdb.Get("recipes:ingredient:" + ingredient).Result()
+	tier := a.accessTier(c)
+	cacheKey := recipeListKey(tier, "ingredient", ingredient)
+
+	val, err := a.rdb.Get(cacheKey).Result()
 	if err == nil {
 		var recipes []*model.Recipe
 		if err := json.Unmarshal([]byte(val), &recipes); err != nil {
@@ -342,10 +537,11 @@ func (a *App) GetRecipesByIngredient(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recipes = recipesForTier(recipes, tier)
 
 	recipesJSON, err := json.Marshal(recipes)
 	if err == nil {
-		a.rdb.Set("recipes:ingredient:"+ingredient, recipesJSON, 0)
+		a.rdb.Set(cacheKey, recipesJSON, 0)
 	}
 
 	c.JSON(http.StatusOK, recipes)
@@ -358,12 +554,17 @@ func (a *App) CreateRecipe(c *gin.Context) {
 		return
 	}
 
+	user := currentUser(c)
+	recipe.AuthorID = user.ID.Hex()
+	recipe.AuthorName = user.Name
+
 	if err := a.d.CreateRecipe(&recipe); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
 		return
 	}
 
-	a.rdb.Del("recipes")
+	a.invalidateRecipeListsCache()
+	a.audit(c, model.AuditCreate, model.EntityRecipe, recipe.ID.Hex(), nil, recipe)
 
 	c.JSON(http.StatusCreated, recipe)
 }
@@ -376,25 +577,39 @@ func (a *App) UpdateRecipe(c *gin.Context) {
 		return
 	}
 
-	if err := a.d.UpdateRecipe(id, &recipe); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	expectedVersion, ok := ifMatchVersion(c, true)
+	if !ok {
+		return
+	}
+
+	before, _ := a.d.GetRecipeByID(id)
+	if err := a.d.UpdateRecipe(id, &recipe, expectedVersion, currentUser(c).ID.Hex()); err != nil {
+		c.JSON(statusForError(err, http.StatusNotFound), gin.H{"error": err.Error()})
 		return
 	}
 
 	a.rdb.Del("recipe:" + id)
+	a.invalidateRecipeListsCache()
+	a.auditRecipe(c, model.AuditUpdate, id, before)
 
-	c.JSON(http.StatusOK, recipe)
+	a.respondWithRecipe(c, id)
 }
 
 func (a *App) DeleteRecipe(c *gin.Context) {
 	id := c.Param("id")
+	before, _ := a.d.GetRecipeByID(id)
 	if err := a.d.DeleteRecipe(id); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
 	a.rdb.Del("recipe:" + id)
-	a.rdb.Del("recipes")
+	a.invalidateRecipeListsCache()
+	a.stats.forget(id)
+	if before != nil {
+		a.deleteRecipeMedia(before)
+	}
+	a.audit(c, model.AuditDelete, model.EntityRecipe, id, before, nil)
 
 	c.JSON(http.StatusNoContent, gin.H{})
 }
@@ -404,9 +619,9 @@ func (a *App) GetRecipesByMultipleCriteria(c *gin.Context) {
 	ingredient := c.Query("ingredient")
 	typeOf := c.Query("type_of")
 	cuisine := c.Query("cuisine")
-	userPremium := c.Query("premium") == "true"
+	tier := a.accessTier(c)
 
-	cacheKey := buildRecipesCacheKey(excludedRestriction, ingredient, typeOf, cuisine, userPremium)
+	cacheKey := buildRecipesCacheKey(tier, excludedRestriction, ingredient, typeOf, cuisine)
 
 	val, err := a.rdb.Get(cacheKey).Result()
 	if err == nil {
@@ -424,46 +639,61 @@ func (a *App) GetRecipesByMultipleCriteria(c *gin.Context) {
 		excludedRestrictions = append(excludedRestrictions, excludedRestriction)
 	}
 
-	recipes, err := a.d.GetRecipesByMultipleCriteria(excludedRestrictions, ingredient, typeOf, cuisine, userPremium)
+	recipes, err := a.d.GetRecipesByMultipleCriteria(excludedRestrictions, ingredient, typeOf, cuisine)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
 		return
 	}
+	recipes = recipesForTier(recipes, tier)
 
-	filteredRecipes := filterRecipesByPremiumStatus(recipes, userPremium)
-
-	recipesJSON, err := json.Marshal(filteredRecipes)
+	recipesJSON, err := json.Marshal(recipes)
 	if err == nil {
 		a.rdb.Set(cacheKey, recipesJSON, 0)
 	}
 
-	c.JSON(http.StatusOK, filteredRecipes)
-}
-
-func filterRecipesByPremiumStatus(recipes []*model.Recipe, userPremium bool) []*model.Recipe {
-	filteredRecipes := make([]*model.Recipe, 0)
-
-	for _, recipe := range recipes {
-		if userPremium || !recipe.Premium {
-			filteredRecipes = append(filteredRecipes, recipe)
-		}
-	}
-
-	return filteredRecipes
+	c.JSON(http.StatusOK, recipes)
 }
 
-func buildRecipesCacheKey(excludedRestriction, ingredient, typeOf, cuisine string, userPremium bool) string {
-	return fmt.Sprintf("recipes:excluded:%s:ingredient:%s:type:%s:cuisine:%s:premium:%t",
-		excludedRestriction, ingredient, typeOf, cuisine, userPremium)
+func buildRecipesCacheKey(tier, excludedRestriction, ingredient, typeOf, cuisine string) string {
+	return recipeListKey(tier, fmt.Sprintf("excluded:%s:ingredient:%s:type:%s:cuisine:%s",
+		excludedRestriction, ingredient, typeOf, cuisine))
 }
 
 func (a *App) RegisterUser(c *gin.Context) {
-	var user model.User
+	var payload struct {
+		Name         string   `json:"name"`
+		Email        string   `json:"email"`
+		Password     string   `json:"password"`
+		Ingredients  []string `json:"ingredients"`
+		Restriction  []string `json:"restriction"`
+		ReferralCode string   `json:"referral_code"`
+	}
 
-	if err := c.BindJSON(&user); err != nil {
+	if err := c.BindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
 		return
 	}
+	email, ok := normalizeEmail(payload.Email)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "E-mail inválido."})
+		return
+	}
+	user := model.User{
+		Name:        payload.Name,
+		Email:       email,
+		Password:    payload.Password,
+		Ingredients: payload.Ingredients,
+		Restriction: payload.Restriction,
+	}
+
+	if code := normalizeCode(payload.ReferralCode); code != "" {
+		referrer, err := a.d.GetUserByReferralCode(code)
+		if err != nil {
+			c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": "Código de indicação inválido."})
+			return
+		}
+		user.ReferredBy = referrer.ID.Hex()
+	}
 
 	existingUser, err := a.d.GetUserByEmail(user.Email)
 	if err == nil && existingUser != nil {
@@ -471,6 +701,11 @@ func (a *App) RegisterUser(c *gin.Context) {
 		return
 	}
 
+	if err := validatePassword(user.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao fazer o hashing."})
@@ -483,15 +718,19 @@ func (a *App) RegisterUser(c *gin.Context) {
 		return
 	}
 
+	a.audit(c, model.AuditCreate, model.EntityUser, user.ID.Hex(), nil, newAuditUser(&user))
+
 	err = a.invalidateUsersCache()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao limpar cache."})
 		return
 	}
 
-	user.Password = ""
+	if err := a.sendVerificationEmail(c.Request.Context(), &user); err != nil {
+		log.Println("erro enviando e-mail de verificação:", err)
+	}
 
-	c.JSON(http.StatusCreated, user)
+	c.JSON(http.StatusCreated, newSelfUser(&user))
 }
 
 func (a *App) LoginUser(c *gin.Context) {
@@ -503,38 +742,68 @@ func (a *App) LoginUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	credentials.Email, _ = normalizeEmail(credentials.Email)
+
+	if wait := a.loginLockedFor(credentials.Email, c.ClientIP()); wait > 0 {
+		setRetryAfter(c, wait)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Muitas tentativas de login, tente novamente mais tarde."})
+		return
+	}
 
 	user, err := a.d.GetUserByEmail(credentials.Email)
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(credentials.Password))
+	}
 	if err != nil {
+		if wait := a.recordLoginFailure(credentials.Email, c.ClientIP()); wait > 0 {
+			setRetryAfter(c, wait)
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Credenciais inválidas"})
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(credentials.Password))
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Credenciais inválidas"})
+	// An identity provider signed in to this account, but nobody has
+	// confirmed owning the e-mail the password was set for.
+	if !user.EmailVerified && len(user.Identities) > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Confirme seu e-mail antes de entrar com senha.", "email_verification_required": true})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Login bem sucedido!", "user": user})
+	if user.TOTPEnabled {
+		challenge, err := a.createTwoFactorChallenge(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar a sessão."})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Informe o código de verificação.", "two_factor_required": true, "challenge": challenge})
+		return
+	}
+
+	a.completeLogin(c, user)
 }
 
-func (a *App) DeleteUser(c *gin.Context) {
-	email := c.Param("email")
+func (a *App) completeLogin(c *gin.Context, user *model.User) {
+	a.clearLoginFailures(user.Email, c.ClientIP())
+	a.rememberLoginIP(user.Email, c.ClientIP())
 
-	err := a.d.DeleteUser(email)
+	token, err := a.createSession(user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao apagar o usuário."})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar a sessão."})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Usuário apagado com sucesso."})
+	c.JSON(http.StatusOK, gin.H{
+		"message":                   "Login bem sucedido!",
+		"token":                     token,
+		"user":                      newSelfUser(user),
+		"two_factor_setup_required": isPrivileged(user) && !user.TOTPEnabled,
+	})
 }
 
 func (a *App) GetUsers(c *gin.Context) {
 	val, err := a.rdb.Get("users").Result()
 	if err == nil {
-		var users []*model.User
+		var users []adminUser
 		if err := json.Unmarshal([]byte(val), &users); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user data"})
 			return
@@ -548,33 +817,32 @@ func (a *App) GetUsers(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	views := newAdminUsers(users)
 
-	usersJSON, err := json.Marshal(users)
+	usersJSON, err := json.Marshal(views)
 	if err == nil {
 		a.rdb.Set("users", usersJSON, 0)
 	}
 
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, views)
 }
 
-func (a *App) GetUserByEmail(c *gin.Context) {
-	email := c.Param("email")
+func (a *App) GetUser(c *gin.Context) {
+	id := c.Param("id")
 
-	val, err := a.rdb.Get("user:" + email).Result()
+	val, err := a.rdb.Get("user:" + id).Result()
 	if err == nil {
-		var user *model.User
+		var user adminUser
 		if err := json.Unmarshal([]byte(val), &user); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user data"})
 			return
 		}
 
-		user.Password = ""
-
-		c.JSON(http.StatusOK, user)
+		c.JSON(http.StatusOK, viewFor(currentUser(c), user))
 		return
 	}
 
-	user, err := a.d.GetUserByEmail(email)
+	user, err := a.d.GetUserByID(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found."})
 		return
@@ -585,19 +853,13 @@ func (a *App) GetUserByEmail(c *gin.Context) {
 		return
 	}
 
-	userJSON, err := json.Marshal(user)
-	if err == nil {
-		a.rdb.Set("user:"+email, userJSON, 0)
-	}
+	view := a.cacheUser(user)
 
-	user.Password = ""
-
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, viewFor(currentUser(c), view))
 }
 
 func (a *App) AddUserIngredient(c *gin.Context) {
 	var userIngredient struct {
-		UserId     string `json:"email"`
 		Ingredient string `json:"ingredient"`
 	}
 
@@ -606,28 +868,18 @@ func (a *App) AddUserIngredient(c *gin.Context) {
 		return
 	}
 
-	err := a.d.AddUserIngredient(userIngredient.UserId, userIngredient.Ingredient)
+	userID := currentUser(c).ID.Hex()
+	err := a.d.AddUserIngredient(userID, userIngredient.Ingredient)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, err := a.d.GetUserByEmail(userIngredient.UserId)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated user"})
-		return
-	}
-	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Ingredient added successfully", "user": user})
+	a.respondWithUpdatedUser(c, userID, "Ingredient added successfully")
 }
 
 func (a *App) RemoveUserIngredient(c *gin.Context) {
 	var userIngredient struct {
-		UserId     string `json:"email"`
 		Ingredient string `json:"ingredient"`
 	}
 
@@ -636,52 +888,30 @@ func (a *App) RemoveUserIngredient(c *gin.Context) {
 		return
 	}
 
-	err := a.d.RemoveUserIngredient(userIngredient.UserId, userIngredient.Ingredient)
+	userID := currentUser(c).ID.Hex()
+	err := a.d.RemoveUserIngredient(userID, userIngredient.Ingredient)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, err := a.d.GetUserByEmail(userIngredient.UserId)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated user"})
-		return
-	}
-	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Ingredient removed successfully", "user": user})
+	a.respondWithUpdatedUser(c, userID, "Ingredient removed successfully")
 }
 
 func (a *App) RemoveAllUserIngredients(c *gin.Context) {
-	var request struct {
-		Email string `json:"email"`
-	}
-
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		return
-	}
-
-	if request.Email == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email field is required"})
-		return
-	}
-
-	err := a.d.RemoveAllUserIngredients(request.Email)
+	userID := currentUser(c).ID.Hex()
+	err := a.d.RemoveAllUserIngredients(userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear user ingredients"})
 		return
 	}
+	a.invalidateUserCache(userID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "All ingredients cleared successfully"})
 }
 
 func (a *App) LikeRecipe(c *gin.Context) {
 	var likeRequest struct {
-		UserId   string `json:"email"`
 		RecipeID string `json:"recipe_id"`
 	}
 
@@ -691,31 +921,33 @@ func (a *App) LikeRecipe(c *gin.Context) {
 		return
 	}
 
-	err := a.d.LikeRecipe(likeRequest.UserId, likeRequest.RecipeID)
+	recipe, err := a.d.GetRecipeByID(likeRequest.RecipeID)
 	if err != nil {
-		log.Println("Error liking recipe:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	if !isPublished(recipe) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receita não encontrada."})
 		return
 	}
 
-	user, err := a.d.GetUserByEmail(likeRequest.UserId)
+	userID := currentUser(c).ID.Hex()
+	liked, err := a.d.LikeRecipe(userID, likeRequest.RecipeID)
 	if err != nil {
-		log.Println("Error fetching updated user:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated user"})
+		log.Println("Error liking recipe:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if user == nil {
-		log.Println("User not found")
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
+
+	if liked {
+		a.stats.track(likeRequest.RecipeID, eventLike)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Recipe liked successfully", "user": user})
+	a.respondWithUpdatedUser(c, userID, "Recipe liked successfully")
 }
 
 func (a *App) UnlikeRecipe(c *gin.Context) {
 	var unlikeRequest struct {
-		UserId   string `json:"email"`
 		RecipeID string `json:"recipe_id"`
 	}
 
@@ -724,42 +956,38 @@ func (a *App) UnlikeRecipe(c *gin.Context) {
 		return
 	}
 
-	err := a.d.UnlikeRecipe(unlikeRequest.UserId, unlikeRequest.RecipeID)
+	userID := currentUser(c).ID.Hex()
+	like, err := a.d.UnlikeRecipe(userID, unlikeRequest.RecipeID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, err := a.d.GetUserByEmail(unlikeRequest.UserId)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated user"})
-		return
-	}
-	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
+	if like != nil {
+		a.stats.untrack(like.RecipeID, eventLike, like.CreatedAt)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Recipe unliked successfully", "user": user})
+	a.respondWithUpdatedUser(c, userID, "Recipe unliked successfully")
 }
 
-func (a *App) GetUserLikedRecipes(c *gin.Context) {
-	email := c.Query("email")
-	if email == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email parameter is required"})
-		return
-	}
+// respondWithUpdatedUser answers with the fresh self view of a user after
+// a change and drops the stale cached copy.
+func (a *App) respondWithUpdatedUser(c *gin.Context, userID string, message string) {
+	a.invalidateUserCache(userID)
 
-	user, err := a.d.GetUserByEmail(email)
+	user, err := a.d.GetUserByID(userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		log.Println("Error fetching updated user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated user"})
 		return
 	}
 
-	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
-	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "user": newSelfUser(user)})
+}
+
+func (a *App) GetUserLikedRecipes(c *gin.Context) {
+	user := currentUser(c)
+	tier := a.accessTier(c)
 
 	var detailedRecipes []model.Recipe
 
@@ -769,8 +997,8 @@ func (a *App) GetUserLikedRecipes(c *gin.Context) {
 			log.Println("Error fetching recipe by ID:", err)
 			continue
 		}
-		if recipe != nil {
-			detailedRecipes = append(detailedRecipes, *recipe)
+		if isPublished(recipe) {
+			detailedRecipes = append(detailedRecipes, *recipeForTier(recipe, tier))
 		}
 	}
 
@@ -791,6 +1019,9 @@ func (a *App) getRecipeByIDWithCache(id string) (*model.Recipe, error) {
 	if err != nil {
 		return nil, err
 	}
+	if recipe == nil {
+		return nil, nil
+	}
 
 	recipeJSON, err := json.Marshal(recipe)
 	if err == nil {
@@ -800,67 +1031,6 @@ func (a *App) getRecipeByIDWithCache(id string) (*model.Recipe, error) {
 	return recipe, nil
 }
 
-func (a *App) UpgradeToPremium(c *gin.Context) {
-	var upgradeRequest struct {
-		Email string `json:"email"`
-	}
-
-	if err := c.ShouldBindJSON(&upgradeRequest); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		return
-	}
-
-	err := a.d.SetUserPremium(upgradeRequest.Email, true)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	if err := a.invalidateUserCache(upgradeRequest.Email); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cache"})
-		return
-	}
-
-	user, err := a.d.GetUserByEmail(upgradeRequest.Email)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated user"})
-		return
-	}
-	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "User upgraded to premium", "user": user})
-}
-
-func (a *App) Gemini(c *gin.Context) {
-	file, _, err := c.Request.FormFile("image")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	defer file.Close()
-
-	out, err := os.CreateTemp("", "image-*.jpg")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer os.Remove(out.Name())
-	defer out.Close()
-
-	_, err = io.Copy(out, file)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	res := ai.SendImageFromFile(out.Name())
-	ai.PrintResponse(res)
-	c.JSON(http.StatusOK, res)
-}
-
 func (a *App) invalidateUsersCache() error {
 	err := a.rdb.Del("users").Err()
 	if err != nil {
@@ -869,8 +1039,18 @@ func (a *App) invalidateUsersCache() error {
 	return nil
 }
 
-func (a *App) invalidateUserCache(email string) error {
-	key := "user:" + email
+// cacheUser stores the admin view of user, the only form users are cached
+// in, and returns it.
+func (a *App) cacheUser(user *model.User) adminUser {
+	view := newAdminUser(user)
+	if userJSON, err := json.Marshal(view); err == nil {
+		a.rdb.Set("user:"+user.ID.Hex(), userJSON, 0)
+	}
+	return view
+}
+
+func (a *App) invalidateUserCache(id string) error {
+	key := "user:" + id
 	err := a.rdb.Del(key).Err()
 	if err != nil {
 		return err
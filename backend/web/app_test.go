@@ -0,0 +1,148 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"cucinia/ai"
+	"cucinia/billing"
+	"cucinia/mailer"
+	"cucinia/model"
+	"cucinia/storage"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testApp runs the API against an in-memory Redis and database, without
+// the background jobs of NewApp.
+type testApp struct {
+	*App
+	t     *testing.T
+	db    *fakeDB
+	redis *miniredis.Miniredis
+	mails *fakeMailer
+	// remoteAddr is where requests come from.
+	remoteAddr string
+}
+
+func newTestApp(t *testing.T) *testApp {
+	return newTestAppWith(t, billing.None{}, ai.Fake{Prompts: mustPrompts(t)})
+}
+
+func newTestAppWith(t *testing.T, pay billing.Provider, provider ai.Provider) *testApp {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newFakeDB()
+	mails := &fakeMailer{}
+	app := newApp(d, rdb, store, mails, pay, provider)
+	app.setupRoutes(false)
+	return &testApp{App: app, t: t, db: d, redis: mr, mails: mails, remoteAddr: "203.0.113.10:4000"}
+}
+
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// last returns the last message sent to to.
+func (m *fakeMailer) last(to string) (mailer.Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To == to {
+			return m.sent[i], true
+		}
+	}
+	return mailer.Message{}, false
+}
+
+func mustPrompts(t *testing.T) *ai.Prompts {
+	t.Helper()
+	prompts, err := ai.LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prompts
+}
+
+// addUser stores a user with a verified email and returns it with a
+// session token. Editors and admins get two-factor enabled, which the
+// routes for them require.
+func (ta *testApp) addUser(email string, role string) (*model.User, string) {
+	ta.t.Helper()
+	user := &model.User{ID: primitive.NewObjectID(), Name: email, Email: email, Role: role, EmailVerified: true, TOTPEnabled: role != model.RoleUser}
+	ta.db.putUser(user)
+	token, err := ta.createSession(user)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	return user, token
+}
+
+// do sends a request to the API. body, when not nil, is sent as JSON
+// unless it already is a []byte.
+func (ta *testApp) do(method, path string, body any, token string, headers ...string) *httptest.ResponseRecorder {
+	ta.t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			ta.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.RemoteAddr = ta.remoteAddr
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	ta.router.ServeHTTP(w, req)
+	return w
+}
+
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+	return v
+}
@@ -0,0 +1,321 @@
+package web
+
+import (
+	"cucinia/ai"
+	"cucinia/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+// Conversations with the cooking assistant are kept in Redis, one per
+// session:
+//
+//	assistant:<id>          JSON of the session
+//	assistant:<id>:lock     set while a question of the session is answered
+//	assistant:user:<user>   IDs of the user's sessions, to erase them with
+//	                        the account
+const (
+	assistantSessionTTL = 24 * time.Hour
+	// assistantLockTTL outlasts the longest answer, so a crashed server
+	// does not block a session for long.
+	assistantLockTTL = 2 * time.Minute
+	// assistantHistory is how many messages are kept and sent with each
+	// question, the oldest going first.
+	assistantHistory    = 20
+	assistantMaxMessage = 1000
+)
+
+type assistantSession struct {
+	UserID    string             `json:"user_id"`
+	RecipeID  string             `json:"recipe_id"`
+	Messages  []assistantMessage `json:"messages"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+type assistantMessage struct {
+	Role string `json:"role"`
+	Text string `json:"text"`
+	// PromptVersion is set on the answers of the assistant.
+	PromptVersion string    `json:"prompt_version,omitempty"`
+	At            time.Time `json:"at"`
+}
+
+func assistantKey(id string) string {
+	return "assistant:" + id
+}
+
+func assistantUserKey(userID string) string {
+	return "assistant:user:" + userID
+}
+
+// loadAssistantSession returns the session id of userID about recipeID, or
+// nil when there is none.
+func (a *App) loadAssistantSession(id, userID, recipeID string) *assistantSession {
+	val, err := a.rdb.Get(assistantKey(id)).Result()
+	if err != nil {
+		return nil
+	}
+	var session assistantSession
+	if err := json.Unmarshal([]byte(val), &session); err != nil || session.UserID != userID || session.RecipeID != recipeID {
+		return nil
+	}
+	return &session
+}
+
+func (a *App) saveAssistantSession(id string, session *assistantSession) error {
+	if len(session.Messages) > assistantHistory {
+		session.Messages = session.Messages[len(session.Messages)-assistantHistory:]
+	}
+	session.UpdatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	pipe := a.rdb.TxPipeline()
+	pipe.Set(assistantKey(id), data, assistantSessionTTL)
+	pipe.SAdd(assistantUserKey(session.UserID), id)
+	pipe.Expire(assistantUserKey(session.UserID), assistantSessionTTL)
+	_, err = pipe.Exec()
+	return err
+}
+
+type assistantExport struct {
+	ID string `json:"id"`
+	assistantSession
+}
+
+// exportAssistantSessions lists the conversations of userID still kept,
+// the latest first.
+func (a *App) exportAssistantSessions(userID string) ([]assistantExport, error) {
+	ids, err := a.rdb.SMembers(assistantUserKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := []assistantExport{}
+	for _, id := range ids {
+		val, err := a.rdb.Get(assistantKey(id)).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		session := assistantExport{ID: id}
+		if err := json.Unmarshal([]byte(val), &session.assistantSession); err != nil || session.UserID != userID {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt) })
+	return sessions, nil
+}
+
+// forgetAssistantSessions deletes the conversations of userID.
+func (a *App) forgetAssistantSessions(userID string) error {
+	return deleteIndexed(a.rdb, assistantUserKey(userID), func(id string) []string {
+		return []string{assistantKey(id), assistantKey(id) + ":lock"}
+	})
+}
+
+// assistantRecipe loads a recipe the caller may talk about, answering for
+// the handler when there is none.
+func (a *App) assistantRecipe(c *gin.Context) (*model.Recipe, bool) {
+	recipe, err := a.d.GetRecipeByID(c.Param("id"))
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !isPublished(recipe) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receita não encontrada."})
+		return nil, false
+	}
+	if recipeForTier(recipe, a.accessTier(c)).Locked {
+		abortUpgradeRequired(c, featurePremiumRecipes)
+		return nil, false
+	}
+	return recipe, true
+}
+
+// chatRequest gathers what the assistant knows when answering user about
+// recipe: the recipe, the user's pantry and restrictions, and the known
+// substitutions for its ingredients that suit those restrictions.
+func (a *App) chatRequest(user *model.User, recipe *model.Recipe, session *assistantSession, message string) (ai.ChatRequest, error) {
+	req := ai.ChatRequest{
+		Recipe: ai.ChatRecipe{
+			Name:        recipe.Name,
+			Description: recipe.Description,
+			Ingredients: recipe.Ingredients,
+			Restriction: recipe.Restriction,
+		},
+		Pantry:       user.Ingredients,
+		Restrictions: user.Restriction,
+		Message:      message,
+		Unit:         user.ID.Hex(),
+	}
+	for _, previous := range session.Messages {
+		req.History = append(req.History, ai.ChatMessage{Role: previous.Role, Text: previous.Text})
+	}
+
+	substitutions, err := a.d.GetSubstitutionsFor(recipe.Ingredients)
+	if err != nil {
+		return req, err
+	}
+	for _, substitution := range substitutions {
+		for _, alternative := range substitution.Alternatives {
+			if breaksAny(recipeRestrictions(alternative.Restriction, []string{alternative.Name}), user.Restriction) != "" {
+				continue
+			}
+			line := fmt.Sprintf("%s → %s (%g da quantidade)", substitution.Ingredient, alternative.Name, alternative.Ratio)
+			if alternative.Notes != "" {
+				line += ": " + alternative.Notes
+			}
+			req.Substitutions = append(req.Substitutions, line)
+		}
+	}
+	return req, nil
+}
+
+// AskAssistant answers a question about a recipe as Server-Sent Events: a
+// "session" event with the session to send follow-up questions to, "delta"
+// events with the answer as it is written, and "done" or "error" at the
+// end. Only answered questions are kept in the session.
+func (a *App) AskAssistant(c *gin.Context) {
+	user := currentUser(c)
+
+	var payload struct {
+		Message   string `json:"message"`
+		SessionID string `json:"session_id"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+	message := strings.TrimSpace(payload.Message)
+	if message == "" || utf8.RuneCountInString(message) > assistantMaxMessage {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A pergunta deve ter de 1 a %d caracteres.", assistantMaxMessage)})
+		return
+	}
+
+	recipe, ok := a.assistantRecipe(c)
+	if !ok {
+		return
+	}
+
+	id := payload.SessionID
+	if id == "" {
+		var err error
+		if id, err = newJobID(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// The session is read under the lock so answers given meanwhile are not
+	// lost when it is saved.
+	locked, err := a.rdb.SetNX(assistantKey(id)+":lock", 1, assistantLockTTL).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !locked {
+		c.JSON(http.StatusConflict, gin.H{"error": "Aguarde a resposta anterior terminar."})
+		return
+	}
+	defer a.rdb.Del(assistantKey(id) + ":lock")
+
+	session := &assistantSession{UserID: user.ID.Hex(), RecipeID: recipe.ID.Hex(), Messages: []assistantMessage{}}
+	if payload.SessionID != "" {
+		if session = a.loadAssistantSession(id, user.ID.Hex(), recipe.ID.Hex()); session == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversa não encontrada."})
+			return
+		}
+	}
+
+	req, err := a.chatRequest(user, recipe, session, message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// Keeps proxies such as nginx from holding the events back.
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.SSEvent("session", gin.H{"session_id": id})
+	c.Writer.Flush()
+
+	ctx := c.Request.Context()
+	answer, err := a.ai.Chat(ctx, req, func(chunk string) error {
+		c.SSEvent("delta", gin.H{"text": chunk})
+		c.Writer.Flush()
+		return ctx.Err()
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			// The client left; there is nobody to tell.
+			return
+		}
+		recordPromptOutcome(a.rdb, ai.TaskAssistant, answer.Prompt, outcomeError, 1)
+		text := "Falha ao consultar o assistente."
+		if errors.Is(err, ai.ErrNotConfigured) {
+			text = "Assistente indisponível."
+		} else {
+			log.Println("erro no assistente:", err)
+		}
+		c.SSEvent("error", gin.H{"error": text})
+		return
+	}
+
+	now := time.Now().UTC()
+	session.Messages = append(session.Messages,
+		assistantMessage{Role: ai.ChatUser, Text: message, At: now},
+		assistantMessage{Role: ai.ChatAssistant, Text: answer.Text, PromptVersion: answer.Prompt, At: now},
+	)
+	if err := a.saveAssistantSession(id, session); err != nil {
+		log.Println("erro salvando conversa", id+":", err)
+	}
+	recordPromptOutcome(a.rdb, ai.TaskAssistant, answer.Prompt, outcomeAnswered, 1)
+
+	c.SSEvent("done", gin.H{"session_id": id, "prompt_version": answer.Prompt})
+}
+
+// GetAssistantSession returns the messages of a conversation of the caller.
+func (a *App) GetAssistantSession(c *gin.Context) {
+	session := a.loadAssistantSession(c.Param("session"), currentUser(c).ID.Hex(), c.Param("id"))
+	if session == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversa não encontrada."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": c.Param("session"),
+		"recipe_id":  session.RecipeID,
+		"messages":   session.Messages,
+		"expires_at": session.UpdatedAt.Add(assistantSessionTTL),
+	})
+}
+
+func (a *App) DeleteAssistantSession(c *gin.Context) {
+	id := c.Param("session")
+	userID := currentUser(c).ID.Hex()
+	if a.loadAssistantSession(id, userID, c.Param("id")) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversa não encontrada."})
+		return
+	}
+	a.rdb.Del(assistantKey(id))
+	a.rdb.SRem(assistantUserKey(userID), id)
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
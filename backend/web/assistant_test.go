@@ -0,0 +1,169 @@
+package web
+
+import (
+	"cucinia/ai"
+	"cucinia/model"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type sseEvent struct {
+	name string
+	data map[string]string
+}
+
+// parseSSE splits a Server-Sent Events body into its events, failing on
+// anything that is not an event with a JSON data line.
+func parseSSE(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	if !strings.HasSuffix(body, "\n\n") {
+		t.Fatalf("body does not end with a complete event: %q", body)
+	}
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSuffix(body, "\n\n"), "\n\n") {
+		lines := strings.Split(block, "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "event:") || !strings.HasPrefix(lines[1], "data:") {
+			t.Fatalf("malformed event %q", block)
+		}
+		event := sseEvent{name: strings.TrimPrefix(lines[0], "event:")}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data:")), &event.data); err != nil {
+			t.Fatalf("event %q: %v", block, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func newAssistantTestApp(t *testing.T) (*testApp, *model.Recipe) {
+	ta := newTestApp(t)
+	recipe := &model.Recipe{ID: primitive.NewObjectID(), Name: "Bolo de cenoura", Ingredients: []string{"cenoura", "ovo"}, Status: model.RecipePublished}
+	ta.db.recipes[recipe.ID.Hex()] = recipe
+	return ta, recipe
+}
+
+// ask sends a question and returns its events.
+func (ta *testApp) ask(recipeID, sessionID, message, token string) []sseEvent {
+	ta.t.Helper()
+	w := ta.do(http.MethodPost, "/api/v1/recipes/"+recipeID+"/assistant", map[string]string{"session_id": sessionID, "message": message}, token)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		ta.t.Fatalf("ask = %d %s: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	return parseSSE(ta.t, w.Body.String())
+}
+
+func TestAssistantStreamsAnswer(t *testing.T) {
+	ta, recipe := newAssistantTestApp(t)
+	_, token := ta.addUser("ana@example.com", model.RoleUser)
+
+	events := ta.ask(recipe.ID.Hex(), "", "Posso trocar o ovo?", token)
+	if len(events) < 3 || events[0].name != "session" || events[len(events)-1].name != "done" {
+		t.Fatalf("events = %v, want session, deltas and done", events)
+	}
+	sessionID := events[0].data["session_id"]
+	var answer strings.Builder
+	for _, event := range events[1 : len(events)-1] {
+		if event.name != "delta" {
+			t.Fatalf("unexpected %q event in the answer", event.name)
+		}
+		answer.WriteString(event.data["text"])
+	}
+	want := `Você perguntou "Posso trocar o ovo?" sobre Bolo de cenoura, que leva cenoura, ovo. Mensagens anteriores: 0.`
+	if answer.String() != want {
+		t.Errorf("answer = %q, want %q", answer.String(), want)
+	}
+	done := events[len(events)-1].data
+	if done["session_id"] != sessionID || done["prompt_version"] == "" {
+		t.Errorf("done = %v", done)
+	}
+
+	// The follow-up question is sent with the conversation so far.
+	events = ta.ask(recipe.ID.Hex(), sessionID, "E o açúcar?", token)
+	if events[0].data["session_id"] != sessionID {
+		t.Errorf("follow-up went to session %s", events[0].data["session_id"])
+	}
+	if !strings.Contains(ta.answerOf(events), "Mensagens anteriores: 2.") {
+		t.Errorf("follow-up answer %q did not see the history", ta.answerOf(events))
+	}
+}
+
+func (ta *testApp) answerOf(events []sseEvent) string {
+	var answer strings.Builder
+	for _, event := range events {
+		if event.name == "delta" {
+			answer.WriteString(event.data["text"])
+		}
+	}
+	return answer.String()
+}
+
+func TestAssistantTrimsHistory(t *testing.T) {
+	ta, recipe := newAssistantTestApp(t)
+	_, token := ta.addUser("ana@example.com", model.RoleUser)
+
+	sessionID := ""
+	questions := assistantHistory/2 + 3
+	for i := 0; i < questions; i++ {
+		events := ta.ask(recipe.ID.Hex(), sessionID, fmt.Sprintf("Pergunta %d", i), token)
+		sessionID = events[0].data["session_id"]
+		if i == questions-1 && !strings.HasSuffix(ta.answerOf(events), fmt.Sprintf("Mensagens anteriores: %d.", assistantHistory)) {
+			t.Errorf("last answer %q, want the model sent only the last %d messages", ta.answerOf(events), assistantHistory)
+		}
+	}
+
+	w := ta.do(http.MethodGet, "/api/v1/recipes/"+recipe.ID.Hex()+"/assistant/"+sessionID, nil, token)
+	session := decode[struct {
+		Messages []assistantMessage `json:"messages"`
+	}](t, w)
+	if len(session.Messages) != assistantHistory {
+		t.Fatalf("%d messages kept, want %d", len(session.Messages), assistantHistory)
+	}
+	first := session.Messages[0]
+	if first.Role != ai.ChatUser || first.Text != fmt.Sprintf("Pergunta %d", questions-assistantHistory/2) {
+		t.Errorf("oldest message kept = %+v, want the oldest question of the last %d messages", first, assistantHistory)
+	}
+}
+
+func TestAssistantSessionsAreIsolated(t *testing.T) {
+	ta, recipe := newAssistantTestApp(t)
+	other := &model.Recipe{ID: primitive.NewObjectID(), Name: "Pão", Ingredients: []string{"farinha"}, Status: model.RecipePublished}
+	ta.db.recipes[other.ID.Hex()] = other
+	_, anaToken := ta.addUser("ana@example.com", model.RoleUser)
+	_, biaToken := ta.addUser("bia@example.com", model.RoleUser)
+
+	sessionID := ta.ask(recipe.ID.Hex(), "", "Posso congelar?", anaToken)[0].data["session_id"]
+	sessionPath := "/api/v1/recipes/" + recipe.ID.Hex() + "/assistant/" + sessionID
+
+	for name, request := range map[string]struct {
+		method, path, token string
+		body                any
+	}{
+		"another user reading":            {http.MethodGet, sessionPath, biaToken, nil},
+		"another user deleting":           {http.MethodDelete, sessionPath, biaToken, nil},
+		"another user asking":             {http.MethodPost, "/api/v1/recipes/" + recipe.ID.Hex() + "/assistant", biaToken, map[string]string{"session_id": sessionID, "message": "Qual é a senha?"}},
+		"the same user on another recipe": {http.MethodPost, "/api/v1/recipes/" + other.ID.Hex() + "/assistant", anaToken, map[string]string{"session_id": sessionID, "message": "E este?"}},
+	} {
+		if w := ta.do(request.method, request.path, request.body, request.token); w.Code != http.StatusNotFound {
+			t.Errorf("%s = %d, want 404", name, w.Code)
+		}
+	}
+
+	w := ta.do(http.MethodGet, sessionPath, nil, anaToken)
+	messages := decode[struct {
+		Messages []assistantMessage `json:"messages"`
+	}](t, w).Messages
+	if len(messages) != 2 {
+		t.Errorf("owner's session has %d messages, want 2", len(messages))
+	}
+
+	if w := ta.do(http.MethodDelete, sessionPath, nil, anaToken); w.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d", w.Code)
+	}
+	if w := ta.do(http.MethodGet, sessionPath, nil, anaToken); w.Code != http.StatusNotFound {
+		t.Errorf("deleted session = %d, want 404", w.Code)
+	}
+}
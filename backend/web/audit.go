@@ -0,0 +1,201 @@
+package web
+
+import (
+	"crypto/rand"
+	"cucinia/db"
+	"cucinia/model"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDHeader     = "X-Request-ID"
+	requestIDContextKey = "request_id"
+
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID tags every request with an ID, taken from the X-Request-ID
+// header when a proxy already set a sane one, and echoes it back.
+func requestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+
+	c.Set(requestIDContextKey, id)
+	c.Writer.Header().Set(requestIDHeader, id)
+	c.Next()
+}
+
+// auditUser is how users appear in audit snapshots. The log is append-only
+// and outlives erased accounts, so it holds no name or e-mail.
+type auditUser struct {
+	ID            string `json:"id"`
+	Role          string `json:"role"`
+	Premium       bool   `json:"premium"`
+	EmailVerified bool   `json:"email_verified"`
+	TOTPEnabled   bool   `json:"totp_enabled"`
+	HasPassword   bool   `json:"has_password"`
+
+	PremiumUntil *time.Time `json:"premium_until,omitempty"`
+}
+
+func newAuditUser(user *model.User) *auditUser {
+	if user == nil {
+		return nil
+	}
+	return &auditUser{
+		ID:            user.ID.Hex(),
+		Role:          user.Role,
+		Premium:       user.Premium,
+		EmailVerified: user.EmailVerified,
+		TOTPEnabled:   user.TOTPEnabled,
+		HasPassword:   user.Password != "",
+
+		PremiumUntil: user.PremiumUntil,
+	}
+}
+
+// snapshot turns an entity into the map stored in the audit log, with the
+// same field names the API uses. Nil entities give a nil map.
+func snapshot(v interface{}) map[string]interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return m
+}
+
+// newAuditEntry describes a change made while handling c. c is nil for
+// changes made by background jobs.
+func newAuditEntry(c *gin.Context, action, entity, entityID string, before, after interface{}) *model.AuditEntry {
+	entry := &model.AuditEntry{
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Before:   snapshot(before),
+		After:    snapshot(after),
+	}
+	if entity == model.EntityRecipe {
+		// Like auditUser, the log keeps no names that would outlive an
+		// erased author.
+		delete(entry.Before, "author_name")
+		delete(entry.After, "author_name")
+	}
+	if c != nil {
+		entry.RequestID = c.GetString(requestIDContextKey)
+		entry.IP = c.ClientIP()
+		if user := currentUser(c); user != nil {
+			entry.ActorID = user.ID.Hex()
+		}
+	}
+	return entry
+}
+
+// audit records a change. A failure to write the log is reported but does
+// not undo the change, which has already happened.
+func (a *App) audit(c *gin.Context, action, entity, entityID string, before, after interface{}) {
+	a.recordAudit(newAuditEntry(c, action, entity, entityID, before, after))
+}
+
+func (a *App) recordAudit(entry *model.AuditEntry) {
+	if err := a.d.RecordAudit(entry); err != nil {
+		log.Println("erro gravando auditoria:", entry.Action, entry.Entity, entry.EntityID, err)
+	}
+}
+
+// auditRecipe records a recipe change, reading the state after it from the
+// database.
+func (a *App) auditRecipe(c *gin.Context, action, id string, before *model.Recipe) {
+	after, err := a.d.GetRecipeByID(id)
+	if err != nil {
+		after = nil
+	}
+	a.audit(c, action, model.EntityRecipe, id, before, after)
+}
+
+// auditUserChange records a change to a user, reading the state after it
+// from the database.
+func (a *App) auditUserChange(c *gin.Context, action string, before *model.User) {
+	id := before.ID.Hex()
+	after, err := a.d.GetUserByID(id)
+	if err != nil {
+		after = nil
+	}
+	a.audit(c, action, model.EntityUser, id, newAuditUser(before), newAuditUser(after))
+}
+
+// parseAuditTime accepts RFC 3339 timestamps or plain dates. A plain date
+// used as the end of a range covers the whole day.
+func parseAuditTime(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func (a *App) GetAuditLog(c *gin.Context) {
+	filter := db.AuditFilter{
+		ActorID:  c.Query("actor"),
+		Entity:   c.Query("entity"),
+		EntityID: c.Query("entity_id"),
+		Limit:    defaultAuditLimit,
+	}
+
+	if value := c.Query("from"); value != "" {
+		from, err := parseAuditTime(value, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Data inicial inválida."})
+			return
+		}
+		filter.From = from
+	}
+	if value := c.Query("to"); value != "" {
+		to, err := parseAuditTime(value, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Data final inválida."})
+			return
+		}
+		filter.To = to
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Limite inválido."})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	entries, err := a.d.GetAuditEntries(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
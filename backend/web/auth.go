@@ -0,0 +1,208 @@
+package web
+
+import (
+	"crypto/rand"
+	"cucinia/model"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+const (
+	sessionDuration = 7 * 24 * time.Hour
+	userContextKey  = "user"
+)
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func sessionKey(token string) string {
+	return "session:" + token
+}
+
+func userSessionsKey(userID string) string {
+	return "sessions:" + userID
+}
+
+// createSession stores an opaque bearer token for the user. Every token is
+// also indexed per user so sessions can be revoked or migrated together.
+func (a *App) createSession(user *model.User) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	userID := user.ID.Hex()
+	pipe := a.rdb.TxPipeline()
+	pipe.Set(sessionKey(token), userID, sessionDuration)
+	pipe.SAdd(userSessionsKey(userID), token)
+	pipe.Expire(userSessionsKey(userID), sessionDuration)
+	if _, err := pipe.Exec(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (a *App) deleteSession(token string) error {
+	userID, err := a.rdb.Get(sessionKey(token)).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	pipe := a.rdb.TxPipeline()
+	pipe.Del(sessionKey(token))
+	pipe.SRem(userSessionsKey(userID), token)
+	_, err = pipe.Exec()
+	return err
+}
+
+// revokeSessions ends every session of a user except keep, e.g. after a
+// password change.
+func (a *App) revokeSessions(userID string, keep string) error {
+	tokens, err := a.rdb.SMembers(userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := a.rdb.TxPipeline()
+	for _, token := range tokens {
+		if token == keep {
+			continue
+		}
+		pipe.Del(sessionKey(token))
+		pipe.SRem(userSessionsKey(userID), token)
+	}
+	_, err = pipe.Exec()
+	return err
+}
+
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+func (a *App) requireAuth(c *gin.Context) {
+	token := bearerToken(c)
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Autenticação necessária."})
+		return
+	}
+
+	user := a.sessionUser(token)
+	if user == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Sessão inválida ou expirada."})
+		return
+	}
+
+	c.Set(userContextKey, user)
+	c.Next()
+}
+
+// optionalAuth loads the user when a valid session token is sent, but lets
+// anonymous requests through.
+func (a *App) optionalAuth(c *gin.Context) {
+	if token := bearerToken(c); token != "" {
+		if user := a.sessionUser(token); user != nil {
+			c.Set(userContextKey, user)
+		}
+	}
+	c.Next()
+}
+
+func (a *App) sessionUser(token string) *model.User {
+	userID, err := a.rdb.Get(sessionKey(token)).Result()
+	if err != nil {
+		return nil
+	}
+
+	user, err := a.d.GetUserByID(userID)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// requireVerifiedEmail keeps accounts that have not confirmed their e-mail
+// away from what costs money to run or is shown to others, so throwaway
+// addresses cannot be used for it.
+func (a *App) requireVerifiedEmail(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Autenticação necessária."})
+		return
+	}
+	if !user.EmailVerified {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":                       "Confirme seu e-mail para usar esta função.",
+			"email_verification_required": true,
+		})
+		return
+	}
+	c.Next()
+}
+
+func (a *App) requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Autenticação necessária."})
+			return
+		}
+
+		for _, role := range roles {
+			if user.Role == role {
+				if isPrivileged(user) && !user.TOTPEnabled {
+					c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+						"error":                     "Ative a verificação em duas etapas para usar esta função.",
+						"two_factor_setup_required": true,
+					})
+					return
+				}
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permissão insuficiente."})
+	}
+}
+
+// isPrivileged reports whether the user's role requires two-factor
+// authentication.
+func isPrivileged(user *model.User) bool {
+	return user != nil && (user.Role == model.RoleEditor || user.Role == model.RoleAdmin)
+}
+
+func currentUser(c *gin.Context) *model.User {
+	value, ok := c.Get(userContextKey)
+	if !ok {
+		return nil
+	}
+	user, _ := value.(*model.User)
+	return user
+}
+
+func (a *App) LogoutUser(c *gin.Context) {
+	if err := a.deleteSession(bearerToken(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao encerrar a sessão."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sessão encerrada."})
+}
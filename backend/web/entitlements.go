@@ -0,0 +1,339 @@
+package web
+
+import (
+	"cucinia/model"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+const (
+	featurePremiumRecipes = "premium_recipes"
+	featureAIScans        = "ai_scans"
+	featureAIRecipes      = "ai_recipes"
+	featureMealPlanner    = "meal_planner"
+	featureCollections    = "collections"
+)
+
+const freePlan = "free"
+
+const quotaContextKey = "quota:"
+
+// unlimited is the limit of a feature that has no cap on a plan. A limit of
+// 0 means the plan does not include the feature.
+const unlimited = -1
+
+type feature struct {
+	// metered features are counted: the limit caps how many times they are
+	// used, or how many of the things they create a user may have.
+	metered bool
+	// monthly counters start again every calendar month (UTC).
+	monthly bool
+}
+
+var features = map[string]feature{
+	featurePremiumRecipes: {},
+	featureAIScans:        {metered: true, monthly: true},
+	featureAIRecipes:      {metered: true, monthly: true},
+	featureMealPlanner:    {},
+	featureCollections:    {metered: true},
+}
+
+// planLimits holds the limit of every feature per plan. Features that are
+// only switched on or off use unlimited or 0.
+var planLimits = map[string]map[string]int{
+	freePlan: {
+		featurePremiumRecipes: 0,
+		featureAIScans:        5,
+		featureAIRecipes:      3,
+		featureMealPlanner:    0,
+		featureCollections:    3,
+	},
+	"monthly": {
+		featurePremiumRecipes: unlimited,
+		featureAIScans:        100,
+		featureAIRecipes:      60,
+		featureMealPlanner:    unlimited,
+		featureCollections:    unlimited,
+	},
+	"yearly": {
+		featurePremiumRecipes: unlimited,
+		featureAIScans:        150,
+		featureAIRecipes:      100,
+		featureMealPlanner:    unlimited,
+		featureCollections:    unlimited,
+	},
+}
+
+// consumeQuotaScript counts one use unless the limit was reached. It
+// answers {allowed, uses counted}.
+var consumeQuotaScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local used = tonumber(redis.call("GET", KEYS[1]) or "0")
+if limit >= 0 and used >= limit then
+	return {0, used}
+end
+
+used = redis.call("INCR", KEYS[1])
+if tonumber(ARGV[2]) > 0 then
+	redis.call("EXPIREAT", KEYS[1], ARGV[2])
+end
+return {1, used}
+`)
+
+var releaseQuotaScript = redis.NewScript(`
+if tonumber(redis.call("GET", KEYS[1]) or "0") > 0 then
+	return redis.call("DECR", KEYS[1])
+end
+return 0
+`)
+
+// userPlan is the plan whose limits apply to user. Premium granted without
+// a subscription, e.g. before subscriptions existed, gets the monthly plan.
+func (a *App) userPlan(user *model.User) string {
+	if user == nil || !user.Premium {
+		return freePlan
+	}
+	subscription, err := a.d.GetCurrentSubscription(user.ID.Hex())
+	if err == nil {
+		if _, ok := planLimits[subscription.PlanID]; ok {
+			return subscription.PlanID
+		}
+	}
+	return "monthly"
+}
+
+func featureLimit(plan, name string) int {
+	limits, ok := planLimits[plan]
+	if !ok {
+		limits = planLimits[freePlan]
+	}
+	return limits[name]
+}
+
+// hasFeature tells whether the plan of user includes the feature at all.
+// Metered features can still run out; see consumeQuota.
+func (a *App) hasFeature(user *model.User, name string) bool {
+	return featureLimit(a.userPlan(user), name) != 0
+}
+
+// quotaPeriod returns the suffix of the counter key for the period that
+// contains now and when that period ends.
+func quotaPeriod(name string, now time.Time) (string, time.Time) {
+	if !features[name].monthly {
+		return "", time.Time{}
+	}
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return ":" + start.Format("2006-01"), start.AddDate(0, 1, 0)
+}
+
+func usageKey(name, userID string, now time.Time) string {
+	suffix, _ := quotaPeriod(name, now)
+	return "usage:" + name + ":" + userID + suffix
+}
+
+type quotaUsage struct {
+	allowed bool
+	// key is the counter the use was counted on.
+	key string
+	// limit is unlimited when the plan has no cap.
+	limit    int
+	used     int
+	resetsAt time.Time
+}
+
+func (q quotaUsage) remaining() int {
+	if q.limit == unlimited {
+		return unlimited
+	}
+	return max(q.limit-q.used, 0)
+}
+
+// consumeQuota counts one use of a metered feature by user, unless their
+// plan's limit was reached. Redis failures let the use through, as with
+// rate limits.
+func (a *App) consumeQuota(user *model.User, name string) quotaUsage {
+	now := time.Now()
+	_, resetsAt := quotaPeriod(name, now)
+	usage := quotaUsage{limit: featureLimit(a.userPlan(user), name), resetsAt: resetsAt}
+	if usage.limit == 0 {
+		return usage
+	}
+
+	var expireAt int64
+	if !resetsAt.IsZero() {
+		// Kept a day past the reset so a late release still finds it.
+		expireAt = resetsAt.Add(24 * time.Hour).Unix()
+	}
+
+	usage.key = usageKey(name, user.ID.Hex(), now)
+	result, err := consumeQuotaScript.Run(a.rdb, []string{usage.key}, usage.limit, expireAt).Result()
+	if err != nil {
+		log.Println("erro contando uso de", name+":", err)
+		usage.allowed = true
+		return usage
+	}
+
+	values, _ := result.([]interface{})
+	if len(values) != 2 {
+		usage.allowed = true
+		return usage
+	}
+	allowed, _ := values[0].(int64)
+	used, _ := values[1].(int64)
+	usage.allowed = allowed == 1
+	usage.used = int(used)
+	return usage
+}
+
+// releaseQuota gives back a use counted by consumeQuota, e.g. because the
+// thing it created was deleted.
+func (a *App) releaseQuota(user *model.User, name string) {
+	a.releaseUsage(usageKey(name, user.ID.Hex(), time.Now()))
+}
+
+// releaseUsage gives back a use counted on key. Work that finishes after
+// the request, such as queued scans, keeps the key so the use is returned
+// to the period it was counted in.
+func (a *App) releaseUsage(key string) {
+	if err := releaseQuotaScript.Run(a.rdb, []string{key}).Err(); err != nil {
+		log.Println("erro devolvendo uso em", key+":", err)
+	}
+}
+
+// countedUsage returns the counter key requireQuota used for the request.
+func countedUsage(c *gin.Context, name string) string {
+	return c.GetString(quotaContextKey + name)
+}
+
+func (a *App) quotaUsed(user *model.User, name string) int {
+	used, err := a.rdb.Get(usageKey(name, user.ID.Hex(), time.Now())).Int()
+	if err != nil {
+		return 0
+	}
+	return used
+}
+
+func abortUpgradeRequired(c *gin.Context, name string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error":            "Recurso disponível apenas para assinantes premium.",
+		"feature":          name,
+		"upgrade_required": true,
+	})
+}
+
+// requireFeature lets the request through only when the caller's plan
+// includes the feature. It runs after requireAuth.
+func (a *App) requireFeature(name string) gin.HandlerFunc {
+	if _, ok := features[name]; !ok {
+		panic("recurso desconhecido: " + name)
+	}
+
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Autenticação necessária."})
+			return
+		}
+		if !a.hasFeature(user, name) {
+			abortUpgradeRequired(c, name)
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireQuota counts one use of a metered feature per request. Requests
+// that fail are not counted. It runs after requireAuth.
+func (a *App) requireQuota(name string) gin.HandlerFunc {
+	if !features[name].metered {
+		panic("recurso sem cota: " + name)
+	}
+
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Autenticação necessária."})
+			return
+		}
+
+		usage := a.consumeQuota(user, name)
+		if usage.limit == 0 {
+			abortUpgradeRequired(c, name)
+			return
+		}
+
+		header := c.Writer.Header()
+		if usage.limit != unlimited {
+			header.Set("X-Quota-Limit", strconv.Itoa(usage.limit))
+			header.Set("X-Quota-Remaining", strconv.Itoa(usage.remaining()))
+		}
+		if !usage.resetsAt.IsZero() {
+			header.Set("X-Quota-Reset", strconv.FormatInt(usage.resetsAt.Unix(), 10))
+		}
+
+		if !usage.allowed {
+			if !usage.resetsAt.IsZero() {
+				setRetryAfter(c, time.Until(usage.resetsAt))
+			}
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":          "Limite do seu plano atingido.",
+				"feature":        name,
+				"quota_exceeded": true,
+			})
+			return
+		}
+
+		c.Set(quotaContextKey+name, usage.key)
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusBadRequest && usage.key != "" {
+			a.releaseUsage(usage.key)
+		}
+	}
+}
+
+type entitlementView struct {
+	Enabled bool `json:"enabled"`
+	// Limit, Used and Remaining are only set on metered features; Limit and
+	// Remaining are left out when there is no cap.
+	Limit     *int       `json:"limit,omitempty"`
+	Used      *int       `json:"used,omitempty"`
+	Remaining *int       `json:"remaining,omitempty"`
+	Period    string     `json:"period,omitempty"`
+	ResetsAt  *time.Time `json:"resets_at,omitempty"`
+}
+
+func (a *App) GetEntitlements(c *gin.Context) {
+	user := currentUser(c)
+	plan := a.userPlan(user)
+
+	views := map[string]entitlementView{}
+	for name, f := range features {
+		limit := featureLimit(plan, name)
+		view := entitlementView{Enabled: limit != 0}
+
+		if f.metered && view.Enabled {
+			usage := quotaUsage{limit: limit, used: a.quotaUsed(user, name)}
+			view.Used = &usage.used
+			if limit != unlimited {
+				remaining := usage.remaining()
+				view.Limit = &limit
+				view.Remaining = &remaining
+			}
+			if f.monthly {
+				_, resetsAt := quotaPeriod(name, time.Now())
+				view.Period = "month"
+				view.ResetsAt = &resetsAt
+			}
+		}
+		views[name] = view
+	}
+
+	c.JSON(http.StatusOK, gin.H{"plan": plan, "features": views})
+}
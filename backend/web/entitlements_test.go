@@ -0,0 +1,134 @@
+package web
+
+import (
+	"cucinia/model"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type entitlementsResponse struct {
+	Plan     string                     `json:"plan"`
+	Features map[string]entitlementView `json:"features"`
+}
+
+func TestEntitlementsFollowPlan(t *testing.T) {
+	ta := newTestApp(t)
+	_, freeToken := ta.addUser("ana@example.com", model.RoleUser)
+	premium, premiumToken := ta.addUser("bia@example.com", model.RoleUser)
+	ta.db.SetUserPremium(premium.ID.Hex(), true)
+	ta.db.subscriptions["bia"] = &model.Subscription{ID: primitive.NewObjectID(), UserID: premium.ID.Hex(), PlanID: "yearly", Status: model.SubscriptionActive}
+
+	free := decode[entitlementsResponse](t, ta.do(http.MethodGet, "/api/v1/users/me/entitlements", nil, freeToken))
+	if free.Plan != freePlan || len(free.Features) != len(features) {
+		t.Fatalf("free user: %+v", free)
+	}
+	if free.Features[featurePremiumRecipes].Enabled || *free.Features[featureAIScans].Limit != 5 {
+		t.Errorf("free user: %+v", free.Features)
+	}
+
+	paid := decode[entitlementsResponse](t, ta.do(http.MethodGet, "/api/v1/users/me/entitlements", nil, premiumToken))
+	if paid.Plan != "yearly" || !paid.Features[featurePremiumRecipes].Enabled || *paid.Features[featureAIScans].Limit != 150 {
+		t.Errorf("yearly subscriber: %+v", paid)
+	}
+}
+
+func TestQuotaCountsOnlySuccessfulRequests(t *testing.T) {
+	ta := newTestApp(t)
+	user, token := ta.addUser("ana@example.com", model.RoleUser)
+	ta.router.POST("/test/quota", ta.requireAuth, ta.requireQuota(featureAIRecipes), func(c *gin.Context) {
+		status, _ := strconv.Atoi(c.Query("status"))
+		c.Status(status)
+	})
+	limit := featureLimit(freePlan, featureAIRecipes)
+
+	for i := 0; i < limit+2; i++ {
+		if w := ta.do(http.MethodPost, "/test/quota?status=502", nil, token); w.Code != http.StatusBadGateway {
+			t.Fatalf("failing request %d = %d, want it to reach the handler", i+1, w.Code)
+		}
+	}
+	if used := ta.quotaUsed(user, featureAIRecipes); used != 0 {
+		t.Fatalf("%d uses counted for failed requests", used)
+	}
+
+	for i := 0; i < limit; i++ {
+		w := ta.do(http.MethodPost, "/test/quota?status=200", nil, token)
+		if w.Code != http.StatusOK || w.Header().Get("X-Quota-Remaining") != strconv.Itoa(limit-i-1) {
+			t.Fatalf("request %d = %d, %s remaining", i+1, w.Code, w.Header().Get("X-Quota-Remaining"))
+		}
+	}
+	w := ta.do(http.MethodPost, "/test/quota?status=200", nil, token)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("request over the quota = %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestPremiumRecipesFollowEntitlement(t *testing.T) {
+	ta := newTestApp(t)
+	recipe := &model.Recipe{ID: primitive.NewObjectID(), Name: "Risoto", Description: "Segredo da casa", Ingredients: []string{"arroz"}, Premium: true, Status: model.RecipePublished}
+	ta.db.recipes[recipe.ID.Hex()] = recipe
+	_, freeToken := ta.addUser("ana@example.com", model.RoleUser)
+	premium, premiumToken := ta.addUser("bia@example.com", model.RoleUser)
+	ta.db.SetUserPremium(premium.ID.Hex(), true)
+
+	for _, tt := range []struct {
+		name, query, token string
+		locked             bool
+	}{
+		{"anonymous", "", "", true},
+		{"free plan", "", freeToken, true},
+		{"premium plan", "", premiumToken, false},
+		// The query parameter of old clients grants nothing.
+		{"free plan asking for premium", "?premium=true", freeToken, true},
+	} {
+		w := ta.do(http.MethodGet, "/api/v1/recipes/by-id/"+recipe.ID.Hex()+tt.query, nil, tt.token)
+		got := decode[model.Recipe](t, w)
+		if got.Locked != tt.locked || (len(got.Ingredients) == 0) != tt.locked {
+			t.Errorf("%s: locked = %v with %v, want locked = %v", tt.name, got.Locked, got.Ingredients, tt.locked)
+		}
+	}
+}
+
+func TestEntitlementsListPlannedFeatures(t *testing.T) {
+	ta := newTestApp(t)
+	_, freeToken := ta.addUser("ana@example.com", model.RoleUser)
+	premium, premiumToken := ta.addUser("bia@example.com", model.RoleUser)
+	ta.db.SetUserPremium(premium.ID.Hex(), true)
+
+	free := decode[entitlementsResponse](t, ta.do(http.MethodGet, "/api/v1/users/me/entitlements", nil, freeToken))
+	if f := free.Features[featureMealPlanner]; f.Enabled {
+		t.Errorf("free user: meal planner %+v", f)
+	}
+	if f := free.Features[featureCollections]; !f.Enabled || f.Limit == nil || *f.Limit != 3 || f.Period != "" {
+		t.Errorf("free user: collections %+v", f)
+	}
+
+	paid := decode[entitlementsResponse](t, ta.do(http.MethodGet, "/api/v1/users/me/entitlements", nil, premiumToken))
+	if f := paid.Features[featureMealPlanner]; !f.Enabled {
+		t.Errorf("premium user: meal planner %+v", f)
+	}
+	if f := paid.Features[featureCollections]; !f.Enabled || f.Limit != nil {
+		t.Errorf("premium user: collections %+v, want no cap", f)
+	}
+}
+
+func TestRequireFeature(t *testing.T) {
+	ta := newTestApp(t)
+	_, freeToken := ta.addUser("ana@example.com", model.RoleUser)
+	premium, premiumToken := ta.addUser("bia@example.com", model.RoleUser)
+	ta.db.SetUserPremium(premium.ID.Hex(), true)
+	ta.router.GET("/test/planner", ta.requireAuth, ta.requireFeature(featureMealPlanner), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := ta.do(http.MethodGet, "/test/planner", nil, freeToken)
+	if w.Code != http.StatusForbidden || decode[map[string]any](t, w)["upgrade_required"] != true {
+		t.Errorf("free user = %d %s, want 403 with upgrade_required", w.Code, w.Body)
+	}
+	if w := ta.do(http.MethodGet, "/test/planner", nil, premiumToken); w.Code != http.StatusOK {
+		t.Errorf("premium user = %d, want 200", w.Code)
+	}
+}
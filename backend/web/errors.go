@@ -0,0 +1,24 @@
+package web
+
+import (
+	"cucinia/db"
+	"errors"
+	"net/http"
+)
+
+func statusForError(err error, fallback int) int {
+	var validationErr *db.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrInvalidTransition), errors.Is(err, db.ErrEmailInUse), errors.Is(err, db.ErrErasureScheduled),
+		errors.Is(err, db.ErrSubscriptionExists), errors.Is(err, db.ErrCodeInUse), errors.Is(err, db.ErrCouponRedeemed):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrVersionConflict):
+		return http.StatusPreconditionFailed
+	default:
+		return fallback
+	}
+}
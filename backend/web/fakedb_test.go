@@ -0,0 +1,863 @@
+package web
+
+import (
+	"cucinia/db"
+	"cucinia/model"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeDB keeps in memory what the handlers under test need. Methods it does
+// not implement panic through the nil embedded interface, which points
+// tests at what is missing.
+type fakeDB struct {
+	db.DB
+
+	mu            sync.Mutex
+	users         map[string]*model.User
+	recipes       map[string]*model.Recipe
+	revisions     []*model.RecipeRevision
+	ingredients   map[string]*model.Ingredient
+	cuisines      []*model.Cuisine
+	mealTypes     []*model.MealType
+	substitutions []*model.Substitution
+	subscriptions map[string]*model.Subscription
+	billingEvents map[string]bool
+	referrals     []*model.Referral
+	likes         []*model.Like
+	audit         []*model.AuditEntry
+	stats         map[string]*model.RecipeStats
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		users:         map[string]*model.User{},
+		recipes:       map[string]*model.Recipe{},
+		ingredients:   map[string]*model.Ingredient{},
+		subscriptions: map[string]*model.Subscription{},
+		billingEvents: map[string]bool{},
+		stats:         map[string]*model.RecipeStats{},
+	}
+}
+
+func clone[T any](v *T) *T {
+	c := *v
+	return &c
+}
+
+func (f *fakeDB) putUser(user *model.User) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[user.ID.Hex()] = clone(user)
+}
+
+func (f *fakeDB) user(id string) *model.User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, ok := f.users[id]; ok {
+		return clone(user)
+	}
+	return nil
+}
+
+func (f *fakeDB) updateUser(id string, update func(*model.User)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[id]
+	if !ok {
+		return db.ErrNotFound
+	}
+	update(user)
+	return nil
+}
+
+func (f *fakeDB) CreateUser(user *model.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, other := range f.users {
+		if strings.EqualFold(other.Email, user.Email) {
+			return db.ErrEmailInUse
+		}
+	}
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	f.users[user.ID.Hex()] = clone(user)
+	return nil
+}
+
+func (f *fakeDB) GetUserByID(id string) (*model.User, error) {
+	if user := f.user(id); user != nil {
+		return user, nil
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) GetUserByEmail(email string) (*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if strings.EqualFold(user.Email, email) {
+			return clone(user), nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) GetAllUsers() ([]*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := []*model.User{}
+	for _, user := range f.users {
+		users = append(users, clone(user))
+	}
+	return users, nil
+}
+
+func (f *fakeDB) SetUserPremium(id string, premium bool) error {
+	return f.updateUser(id, func(user *model.User) { user.Premium = premium })
+}
+
+func (f *fakeDB) SetUserEmailVerified(id string) error {
+	return f.updateUser(id, func(user *model.User) { user.EmailVerified = true })
+}
+
+func (f *fakeDB) SetUserPassword(id string, hash string) error {
+	return f.updateUser(id, func(user *model.User) { user.Password = hash })
+}
+
+func (f *fakeDB) LikeRecipe(userID string, recipeID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[userID]
+	if !ok {
+		return false, db.ErrNotFound
+	}
+	for _, like := range f.likes {
+		if like.UserID == userID && like.RecipeID == recipeID {
+			return false, nil
+		}
+	}
+	f.likes = append(f.likes, &model.Like{ID: primitive.NewObjectID(), UserID: userID, RecipeID: recipeID, CreatedAt: time.Now().UTC()})
+	user.LikedRecipes = append(user.LikedRecipes, recipeID)
+	return true, nil
+}
+
+func (f *fakeDB) UnlikeRecipe(userID string, recipeID string) (*model.Like, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[userID]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	for i, like := range f.likes {
+		if like.UserID == userID && like.RecipeID == recipeID {
+			f.likes = slices.Delete(f.likes, i, i+1)
+			user.LikedRecipes = slices.DeleteFunc(user.LikedRecipes, func(id string) bool { return id == recipeID })
+			return like, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeDB) DisableUserTOTP(id string) error {
+	return f.updateUser(id, func(user *model.User) {
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.RecoveryCodes = nil
+	})
+}
+
+func (f *fakeDB) GetUserByIdentity(provider string, subject string) (*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if slices.Contains(user.Identities, model.Identity{Provider: provider, Subject: subject}) {
+			return clone(user), nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) LinkUserIdentity(id string, identity model.Identity) error {
+	return f.updateUser(id, func(user *model.User) {
+		if !slices.Contains(user.Identities, identity) {
+			user.Identities = append(user.Identities, identity)
+		}
+	})
+}
+
+func (f *fakeDB) ScheduleUserErasure(id string, requestedBy string, at time.Time) (*model.Erasure, error) {
+	return &model.Erasure{UserID: id, RequestedBy: requestedBy, Status: model.ErasureScheduled, ScheduledFor: at}, nil
+}
+
+func (f *fakeDB) EraseUser(id string) (*model.Erasure, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.users, id)
+	now := time.Now().UTC()
+	return &model.Erasure{UserID: id, Status: model.ErasureCompleted, CompletedAt: &now}, nil
+}
+
+func (f *fakeDB) RecordAudit(entry *model.AuditEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.audit = append(f.audit, entry)
+	return nil
+}
+
+func (f *fakeDB) GetIngredients() ([]*model.Ingredient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ingredients := []*model.Ingredient{}
+	for _, ingredient := range f.ingredients {
+		ingredients = append(ingredients, clone(ingredient))
+	}
+	return ingredients, nil
+}
+
+func (f *fakeDB) GetIngredientByID(id string) (*model.Ingredient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ingredient, ok := f.ingredients[id]; ok {
+		return clone(ingredient), nil
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) CreateIngredient(ingredient *model.Ingredient) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ingredient.ID = primitive.NewObjectID()
+	f.ingredients[ingredient.ID.Hex()] = clone(ingredient)
+	return nil
+}
+
+func (f *fakeDB) UpdateIngredient(id string, ingredient *model.Ingredient) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing, ok := f.ingredients[id]
+	if !ok {
+		return db.ErrNotFound
+	}
+	existing.Name = ingredient.Name
+	return nil
+}
+
+func (f *fakeDB) DeleteIngredient(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.ingredients, id)
+	return nil
+}
+
+func (f *fakeDB) GetRecipeByID(id string) (*model.Recipe, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if recipe, ok := f.recipes[id]; ok {
+		return clone(recipe), nil
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) CreateRecipe(recipe *model.Recipe) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.validateTaxonomy(recipe); err != nil {
+		return err
+	}
+	recipe.ID = primitive.NewObjectID()
+	recipe.Status = model.RecipePublished
+	recipe.Version = 1
+	f.recipes[recipe.ID.Hex()] = clone(recipe)
+	f.addRevision(recipe, recipe.AuthorID, nil)
+	return nil
+}
+
+func (f *fakeDB) UpdateRecipe(id string, recipe *model.Recipe, expectedVersion int, author string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.validateTaxonomy(recipe); err != nil {
+		return err
+	}
+	return f.changeRecipe(id, recipe, false, expectedVersion, author, nil)
+}
+
+func (f *fakeDB) GetRecipeRevisions(recipeID string) ([]*model.RecipeRevision, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	revisions := []*model.RecipeRevision{}
+	for _, revision := range f.revisions {
+		if revision.RecipeID.Hex() == recipeID {
+			revisions = append([]*model.RecipeRevision{clone(revision)}, revisions...)
+		}
+	}
+	return revisions, nil
+}
+
+func (f *fakeDB) RestoreRecipeRevision(recipeID string, rev int, expectedVersion int, author string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, revision := range f.revisions {
+		if revision.RecipeID.Hex() != recipeID || revision.Rev != rev {
+			continue
+		}
+		if err := f.validateTaxonomy(&revision.Snapshot); err != nil {
+			return err
+		}
+		return f.changeRecipe(recipeID, &revision.Snapshot, true, expectedVersion, author, &rev)
+	}
+	return db.ErrNotFound
+}
+
+// changeRecipe copies the versioned fields of content into the stored
+// recipe and records the revision, as the Mongo changeRecipe does. Updates
+// leave the image renditions alone; restores bring them back. The caller
+// holds f.mu.
+func (f *fakeDB) changeRecipe(id string, content *model.Recipe, images bool, expectedVersion int, author string, restoredFrom *int) error {
+	stored, ok := f.recipes[id]
+	if !ok {
+		return db.ErrNotFound
+	}
+	if expectedVersion != db.AnyVersion && stored.Version != expectedVersion {
+		return db.ErrVersionConflict
+	}
+	stored.Name, stored.Description, stored.Cuisine, stored.TypeOf = content.Name, content.Description, content.Cuisine, content.TypeOf
+	stored.Ingredients, stored.Difficulty, stored.Restriction = content.Ingredients, content.Difficulty, content.Restriction
+	stored.Image, stored.Premium, stored.Percentage = content.Image, content.Premium, content.Percentage
+	if images {
+		stored.Images = content.Images
+	}
+	stored.Version++
+	f.addRevision(stored, author, restoredFrom)
+	return nil
+}
+
+// addRevision records the current state of recipe. The caller holds f.mu.
+func (f *fakeDB) addRevision(recipe *model.Recipe, author string, restoredFrom *int) {
+	f.revisions = append(f.revisions, &model.RecipeRevision{
+		ID:           primitive.NewObjectID(),
+		RecipeID:     recipe.ID,
+		Rev:          recipe.Version,
+		Author:       author,
+		CreatedAt:    time.Now().UTC(),
+		Changes:      []model.FieldChange{},
+		RestoredFrom: restoredFrom,
+		Snapshot:     *clone(recipe),
+	})
+}
+
+// validateTaxonomy mirrors the cuisine and meal type checks of the Mongo
+// validateRecipe. The caller holds f.mu.
+func (f *fakeDB) validateTaxonomy(recipe *model.Recipe) error {
+	if !slices.ContainsFunc(f.cuisines, func(cuisine *model.Cuisine) bool { return cuisine.Slug == recipe.Cuisine }) {
+		return &db.ValidationError{Field: "cuisine", Message: "culinária '" + recipe.Cuisine + "' não é válida"}
+	}
+	if !slices.ContainsFunc(f.mealTypes, func(mealType *model.MealType) bool { return mealType.Code == recipe.TypeOf }) {
+		return &db.ValidationError{Field: "type_of", Message: "tipo de refeição '" + strconv.Itoa(recipe.TypeOf) + "' não é válido"}
+	}
+	return nil
+}
+
+func (f *fakeDB) CreateSubmission(recipe *model.Recipe) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	recipe.ID = primitive.NewObjectID()
+	recipe.Status = model.RecipeDraft
+	recipe.Premium = false
+	recipe.Image = ""
+	recipe.Images = nil
+	recipe.Percentage = 0
+	recipe.Version = 1
+	f.recipes[recipe.ID.Hex()] = clone(recipe)
+	return nil
+}
+
+func (f *fakeDB) SetRecipeImages(id string, image string, images map[string]string, author string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	recipe, ok := f.recipes[id]
+	if !ok {
+		return db.ErrNotFound
+	}
+	recipe.Image = image
+	recipe.Images = images
+	recipe.Version++
+	return nil
+}
+
+func (f *fakeDB) DeleteRecipe(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.recipes, id)
+	return nil
+}
+
+func (f *fakeDB) GetCuisines() ([]*model.Cuisine, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.cuisines), nil
+}
+
+func (f *fakeDB) GetCuisineByID(id string) (*model.Cuisine, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, cuisine := range f.cuisines {
+		if cuisine.ID.Hex() == id {
+			return clone(cuisine), nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) CreateCuisine(cuisine *model.Cuisine) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cuisine.ID = primitive.NewObjectID()
+	cuisine.Slug = strings.TrimSpace(strings.ToLower(cuisine.Slug))
+	if cuisine.Slug == "" {
+		return &db.ValidationError{Field: "slug", Message: "o slug da culinária é obrigatório"}
+	}
+	for _, other := range f.cuisines {
+		if other.Slug == cuisine.Slug {
+			return &db.ValidationError{Field: "slug", Message: "a culinária '" + cuisine.Slug + "' já existe"}
+		}
+	}
+	f.cuisines = append(f.cuisines, clone(cuisine))
+	return nil
+}
+
+func (f *fakeDB) UpdateCuisine(id string, cuisine *model.Cuisine) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, stored := range f.cuisines {
+		if stored.ID.Hex() == id {
+			updated := clone(stored)
+			updated.Labels, updated.Icon, updated.Order = cuisine.Labels, cuisine.Icon, cuisine.Order
+			f.cuisines[i] = updated
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (f *fakeDB) DeleteCuisine(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, cuisine := range f.cuisines {
+		if cuisine.ID.Hex() != id {
+			continue
+		}
+		for _, recipe := range f.recipes {
+			if recipe.Cuisine == cuisine.Slug {
+				return &db.ValidationError{Field: "slug", Message: "a culinária '" + cuisine.Slug + "' ainda é usada por receitas"}
+			}
+		}
+		f.cuisines = slices.Delete(f.cuisines, i, i+1)
+		return nil
+	}
+	return db.ErrNotFound
+}
+
+func (f *fakeDB) GetMealTypes() ([]*model.MealType, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.mealTypes), nil
+}
+
+func (f *fakeDB) GetMealTypeByID(id string) (*model.MealType, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, mealType := range f.mealTypes {
+		if mealType.ID.Hex() == id {
+			return clone(mealType), nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) CreateMealType(mealType *model.MealType) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	mealType.ID = primitive.NewObjectID()
+	mealType.Slug = strings.TrimSpace(strings.ToLower(mealType.Slug))
+	if mealType.Slug == "" {
+		return &db.ValidationError{Field: "slug", Message: "o slug do tipo de refeição é obrigatório"}
+	}
+	if mealType.Code <= 0 {
+		return &db.ValidationError{Field: "code", Message: "o código do tipo de refeição deve ser positivo"}
+	}
+	for _, other := range f.mealTypes {
+		if other.Slug == mealType.Slug || other.Code == mealType.Code {
+			return &db.ValidationError{Field: "code", Message: "o tipo de refeição '" + mealType.Slug + "' já existe"}
+		}
+	}
+	f.mealTypes = append(f.mealTypes, clone(mealType))
+	return nil
+}
+
+func (f *fakeDB) UpdateMealType(id string, mealType *model.MealType) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, stored := range f.mealTypes {
+		if stored.ID.Hex() == id {
+			updated := clone(stored)
+			updated.Labels, updated.Icon, updated.Order = mealType.Labels, mealType.Icon, mealType.Order
+			f.mealTypes[i] = updated
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (f *fakeDB) DeleteMealType(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, mealType := range f.mealTypes {
+		if mealType.ID.Hex() != id {
+			continue
+		}
+		for _, recipe := range f.recipes {
+			if recipe.TypeOf == mealType.Code {
+				return &db.ValidationError{Field: "code", Message: "o tipo de refeição '" + mealType.Slug + "' ainda é usado por receitas"}
+			}
+		}
+		f.mealTypes = slices.Delete(f.mealTypes, i, i+1)
+		return nil
+	}
+	return db.ErrNotFound
+}
+
+func (f *fakeDB) GetSubstitutions() ([]*model.Substitution, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.substitutions), nil
+}
+
+func (f *fakeDB) GetSubstitutionByID(id string) (*model.Substitution, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, substitution := range f.substitutions {
+		if substitution.ID.Hex() == id {
+			return clone(substitution), nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) GetSubstitutionsFor(ingredients []string) ([]*model.Substitution, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	substitutions := []*model.Substitution{}
+	for _, substitution := range f.substitutions {
+		if slices.ContainsFunc(ingredients, func(name string) bool { return strings.EqualFold(name, substitution.Ingredient) }) {
+			substitutions = append(substitutions, substitution)
+		}
+	}
+	return substitutions, nil
+}
+
+func (f *fakeDB) CreateSubstitution(substitution *model.Substitution) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	substitution.ID = primitive.NewObjectID()
+	substitution.Ingredient = strings.TrimSpace(strings.ToLower(substitution.Ingredient))
+	if substitution.Ingredient == "" {
+		return &db.ValidationError{Field: "ingredient", Message: "o ingrediente é obrigatório"}
+	}
+	for _, other := range f.substitutions {
+		if other.Ingredient == substitution.Ingredient {
+			return &db.ValidationError{Field: "ingredient", Message: "já existem substituições para '" + substitution.Ingredient + "'"}
+		}
+	}
+	substitution.UpdatedAt = time.Now()
+	f.substitutions = append(f.substitutions, clone(substitution))
+	return nil
+}
+
+func (f *fakeDB) UpdateSubstitution(id string, alternatives []model.Alternative) (*model.Substitution, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, substitution := range f.substitutions {
+		if substitution.ID.Hex() == id {
+			updated := clone(substitution)
+			updated.Alternatives = alternatives
+			updated.UpdatedAt = time.Now()
+			f.substitutions[i] = updated
+			return clone(updated), nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) DeleteSubstitution(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, substitution := range f.substitutions {
+		if substitution.ID.Hex() == id {
+			f.substitutions = slices.Delete(f.substitutions, i, i+1)
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (f *fakeDB) recipesWhere(match func(*model.Recipe) bool) []*model.Recipe {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	recipes := []*model.Recipe{}
+	for _, recipe := range f.recipes {
+		if match(recipe) {
+			recipes = append(recipes, clone(recipe))
+		}
+	}
+	return recipes
+}
+
+func (f *fakeDB) GetRecipesByAuthor(authorID string) ([]*model.Recipe, error) {
+	return f.recipesWhere(func(r *model.Recipe) bool { return r.AuthorID == authorID }), nil
+}
+
+func (f *fakeDB) GetRecipesByReviewer(reviewerID string) ([]*model.Recipe, error) {
+	return f.recipesWhere(func(r *model.Recipe) bool { return r.ReviewedBy == reviewerID }), nil
+}
+
+func (f *fakeDB) GetRevisionsByAuthor(authorID string) ([]*model.RecipeRevision, error) {
+	return []*model.RecipeRevision{}, nil
+}
+
+func (f *fakeDB) GetUserErasures(id string) ([]*model.Erasure, error) {
+	return []*model.Erasure{}, nil
+}
+
+func (f *fakeDB) GetUserRedemptions(userID string) ([]*model.CouponRedemption, error) {
+	return []*model.CouponRedemption{}, nil
+}
+
+func (f *fakeDB) GetUserReferrals(userID string) ([]*model.Referral, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	referrals := []*model.Referral{}
+	for _, referral := range f.referrals {
+		if referral.ReferrerID == userID || referral.InviteeID == userID {
+			referrals = append(referrals, clone(referral))
+		}
+	}
+	return referrals, nil
+}
+
+func (f *fakeDB) SetUserReferralCode(id string, code string) error {
+	return f.updateUser(id, func(user *model.User) {
+		if user.ReferralCode == "" {
+			user.ReferralCode = code
+		}
+	})
+}
+
+func (f *fakeDB) GetUserByReferralCode(code string) (*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if user.ReferralCode == code {
+			return clone(user), nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) CreateReferral(referral *model.Referral) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, other := range f.referrals {
+		if other.InviteeID == referral.InviteeID {
+			return db.ErrReferralExists
+		}
+	}
+	referral.ID = primitive.NewObjectID()
+	referral.CreatedAt = time.Now().UTC()
+	f.referrals = append(f.referrals, clone(referral))
+	return nil
+}
+
+func (f *fakeDB) CountRewardedReferrals(referrerID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, referral := range f.referrals {
+		if referral.ReferrerID == referrerID && referral.ReferrerDays > 0 {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeDB) ExtendUserPremium(id string, d time.Duration) (time.Time, error) {
+	var until time.Time
+	err := f.updateUser(id, func(user *model.User) {
+		until = time.Now().UTC()
+		if user.PremiumUntil != nil && user.PremiumUntil.After(until) {
+			until = *user.PremiumUntil
+		}
+		until = until.Add(d)
+		user.Premium = true
+		user.PremiumUntil = &until
+	})
+	return until, err
+}
+
+func (f *fakeDB) GetUserSubscriptions(userID string) ([]*model.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subscriptions := []*model.Subscription{}
+	for _, subscription := range f.subscriptions {
+		if subscription.UserID == userID {
+			subscriptions = append(subscriptions, clone(subscription))
+		}
+	}
+	return subscriptions, nil
+}
+
+func (f *fakeDB) CreateSubscription(subscription *model.Subscription) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, other := range f.subscriptions {
+		if other.UserID == subscription.UserID && other.EndedAt == nil && other.Status != model.SubscriptionExpired && other.Status != model.SubscriptionCanceled {
+			return db.ErrSubscriptionExists
+		}
+	}
+	subscription.ID = primitive.NewObjectID()
+	subscription.CreatedAt = time.Now().UTC()
+	f.subscriptions[subscription.ID.Hex()] = clone(subscription)
+	return nil
+}
+
+func (f *fakeDB) GetSubscriptionByID(id string) (*model.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if subscription, ok := f.subscriptions[id]; ok {
+		return clone(subscription), nil
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) GetCurrentSubscription(userID string) (*model.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	live := []string{model.SubscriptionIncomplete, model.SubscriptionTrialing, model.SubscriptionActive, model.SubscriptionPastDue}
+	for _, subscription := range f.subscriptions {
+		if subscription.UserID == userID && slices.Contains(live, subscription.Status) {
+			return clone(subscription), nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f *fakeDB) HasUsedTrial(userID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, subscription := range f.subscriptions {
+		if subscription.UserID == userID && subscription.TrialEndsAt != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeDB) updateSubscription(id string, update func(*model.Subscription)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subscription, ok := f.subscriptions[id]
+	if !ok {
+		return db.ErrNotFound
+	}
+	update(subscription)
+	return nil
+}
+
+func (f *fakeDB) SetSubscriptionExternalID(id string, externalID string) error {
+	return f.updateSubscription(id, func(s *model.Subscription) { s.ExternalID = externalID })
+}
+
+func (f *fakeDB) ActivateSubscription(id string, externalID string, start, end time.Time) error {
+	return f.updateSubscription(id, func(s *model.Subscription) {
+		s.Status = model.SubscriptionActive
+		s.CurrentPeriodStart, s.CurrentPeriodEnd = &start, &end
+		s.EndedAt = nil
+		if externalID != "" {
+			s.ExternalID = externalID
+		}
+	})
+}
+
+func (f *fakeDB) EndSubscription(id string, status string) error {
+	now := time.Now().UTC()
+	return f.updateSubscription(id, func(s *model.Subscription) { s.Status, s.EndedAt = status, &now })
+}
+
+func (f *fakeDB) RecordBillingEvent(eventID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.billingEvents[eventID] {
+		return db.ErrEventProcessed
+	}
+	f.billingEvents[eventID] = true
+	return nil
+}
+
+func (f *fakeDB) ForgetBillingEvent(eventID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.billingEvents, eventID)
+	return nil
+}
+
+func (f *fakeDB) SetUserRecoveryCodes(id string, hashes []string) error {
+	return f.updateUser(id, func(user *model.User) { user.RecoveryCodes = hashes })
+}
+
+func (f *fakeDB) GetRecipeStats() ([]*model.RecipeStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := []*model.RecipeStats{}
+	for _, s := range f.stats {
+		stats = append(stats, clone(s))
+	}
+	return stats, nil
+}
+
+func (f *fakeDB) SaveRecipeStats(stats []*model.RecipeStats) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range stats {
+		f.stats[s.RecipeID.Hex()] = clone(s)
+	}
+	return nil
+}
+
+func (f *fakeDB) DeleteRecipeStats(recipeID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.stats, recipeID)
+	return nil
+}
+
+func (f *fakeDB) ChangeUserEmail(id string, newEmail string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for otherID, other := range f.users {
+		if otherID != id && strings.EqualFold(other.Email, newEmail) {
+			return db.ErrEmailInUse
+		}
+	}
+	user, ok := f.users[id]
+	if !ok {
+		return db.ErrNotFound
+	}
+	user.Email, user.EmailVerified = newEmail, true
+	return nil
+}
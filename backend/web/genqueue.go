@@ -0,0 +1,754 @@
+package web
+
+import (
+	"context"
+	crand "crypto/rand"
+	"cucinia/ai"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+// Fridge scans run in the background: POST /gen stores the photo and queues
+// a job, workers send it to the AI and GET /gen/:job reports the outcome.
+//
+// Keys:
+//
+//	gen:job:<id>         hash with the job state
+//	gen:job:<id>:images  the uploaded photos, deleted once the job ends
+//	gen:queue            list of job IDs waiting for a worker
+//	gen:processing       list of job IDs a worker took
+//	gen:delayed          sorted set of job IDs waiting to be retried, by time
+//	gen:dead             list of job IDs that failed every attempt
+//	gen:user:<user>      set of the user's job IDs, to erase them with the
+//	                     account
+const (
+	genQueueKey      = "gen:queue"
+	genProcessingKey = "gen:processing"
+	genDelayedKey    = "gen:delayed"
+	genDeadKey       = "gen:dead"
+)
+
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobRetrying = "retrying"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+const (
+	// genJobTTL is how long finished jobs can still be read.
+	genJobTTL = 24 * time.Hour
+	// genHeartbeat is how often a running job shows it is alive and checks
+	// whether it should stop.
+	genHeartbeat = time.Second
+	// genStaleAfter is when a running job without heartbeats is considered
+	// lost with its worker and queued again.
+	genStaleAfter      = 30 * time.Second
+	genBackoffBase     = 2 * time.Second
+	genBackoffMax      = time.Minute
+	genDeadLetterLimit = 1000
+	genMaxWait         = 30 * time.Second
+)
+
+// transitionJobScript moves a job to another status only when it is in one
+// of the allowed ones, so a worker and a cancellation never both win.
+// ARGV: number of allowed statuses, the statuses, then field/value pairs.
+var transitionJobScript = redis.NewScript(`
+local current = redis.call("HGET", KEYS[1], "status")
+local allowed = tonumber(ARGV[1])
+for i = 2, allowed + 1 do
+	if current == ARGV[i] then
+		for j = allowed + 2, #ARGV, 2 do
+			redis.call("HSET", KEYS[1], ARGV[j], ARGV[j + 1])
+		end
+		return 1
+	end
+end
+return 0
+`)
+
+// heartbeatJobScript marks a job alive only while it runs, so a job deleted
+// under a worker, e.g. with its user's account, is not written back.
+// ARGV: the running status and the heartbeat.
+var heartbeatJobScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "status") ~= ARGV[1] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "heartbeat", ARGV[2])
+return 1
+`)
+
+// seenJobScript records that the client asked about a job, unless the job
+// was deleted or expired meanwhile, so it is not recreated without a TTL.
+// ARGV: the time of the read.
+var seenJobScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "seen_at", ARGV[1])
+return 1
+`)
+
+// scanFunc sends the photos of userID to the AI and returns what it found.
+type scanFunc func(ctx context.Context, userID string, images []ai.Image) (*scanResult, error)
+
+type genQueue struct {
+	rdb   *redis.Client
+	cache *scanCache
+	// release gives back the quota a job was counted against when it does
+	// not produce a result.
+	release func(key string)
+	scan    scanFunc
+
+	workers     int
+	maxAttempts int
+	timeout     time.Duration
+	// abandonAfter cancels jobs whose client stopped asking for them.
+	abandonAfter time.Duration
+}
+
+// newGenQueue reads GEN_WORKERS, GEN_MAX_ATTEMPTS, GEN_JOB_TIMEOUT and
+// GEN_ABANDON_AFTER.
+func newGenQueue(rdb *redis.Client, cache *scanCache, provider ai.Provider, release func(key string)) *genQueue {
+	return &genQueue{
+		rdb:     rdb,
+		cache:   cache,
+		release: release,
+		scan: func(ctx context.Context, userID string, images []ai.Image) (*scanResult, error) {
+			answer, err := provider.ScanImages(ctx, ai.ScanRequest{Images: images, Unit: userID})
+			if err != nil {
+				if ctx.Err() == nil {
+					recordPromptOutcome(rdb, ai.TaskScan, answer.Prompt, outcomeError, 1)
+				}
+				return nil, err
+			}
+
+			result := &scanResult{Ingredients: ai.ParseIngredients(answer.Text), PromptVersion: answer.Prompt}
+			if len(result.Ingredients) == 0 {
+				recordPromptOutcome(rdb, ai.TaskScan, answer.Prompt, outcomeEmpty, 1)
+			} else {
+				recordPromptOutcome(rdb, ai.TaskScan, answer.Prompt, outcomeFound, 1)
+				recordPromptOutcome(rdb, ai.TaskScan, answer.Prompt, outcomeIngredients, int64(len(result.Ingredients)))
+			}
+			return result, nil
+		},
+		workers:      envInt("GEN_WORKERS", 2),
+		maxAttempts:  envInt("GEN_MAX_ATTEMPTS", 3),
+		timeout:      envDuration("GEN_JOB_TIMEOUT", time.Minute),
+		abandonAfter: envDuration("GEN_ABANDON_AFTER", 2*time.Minute),
+	}
+}
+
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Println(name+": valor inválido, usando", fallback)
+		return fallback
+	}
+	return n
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Println(name+": valor inválido, usando", fallback)
+		return fallback
+	}
+	return d
+}
+
+func (q *genQueue) start() {
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+	go q.scheduleLoop()
+}
+
+func jobKey(id string) string {
+	return "gen:job:" + id
+}
+
+func jobImagesKey(id string) string {
+	return jobKey(id) + ":images"
+}
+
+func userJobsKey(userID string) string {
+	return "gen:user:" + userID
+}
+
+func nowMillis() string {
+	return strconv.FormatInt(time.Now().UnixMilli(), 10)
+}
+
+func (q *genQueue) transition(id string, from []string, fields ...string) bool {
+	args := []interface{}{len(from)}
+	for _, status := range from {
+		args = append(args, status)
+	}
+	fields = append(fields, "updated_at", nowMillis())
+	for _, field := range fields {
+		args = append(args, field)
+	}
+
+	moved, err := transitionJobScript.Run(q.rdb, []string{jobKey(id)}, args...).Int()
+	if err != nil {
+		log.Println("erro atualizando tarefa", id+":", err)
+		return false
+	}
+	q.rdb.Expire(jobKey(id), genJobTTL)
+	return moved == 1
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// enqueue stores a scan for userID and queues it.
+func (q *genQueue) enqueue(userID string, upload *scanUpload, quotaKey string) (string, error) {
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+	now := nowMillis()
+
+	formats := make([]string, len(upload.images))
+	for i, scan := range upload.images {
+		formats[i] = strings.TrimPrefix(scan.ContentType, "image/")
+	}
+	job := map[string]interface{}{
+		"user_id":    userID,
+		"status":     jobQueued,
+		"attempts":   0,
+		"formats":    strings.Join(formats, ","),
+		"quota_key":  quotaKey,
+		"sha":        upload.fingerprint.sha,
+		"created_at": now,
+		"updated_at": now,
+		"seen_at":    now,
+	}
+	if upload.fingerprint.hasPHash {
+		job["phash"] = strconv.FormatUint(upload.fingerprint.phash, 16)
+	}
+
+	pipe := q.rdb.TxPipeline()
+	pipe.HMSet(jobKey(id), job)
+	pipe.Expire(jobKey(id), genJobTTL)
+	for _, scan := range upload.images {
+		pipe.RPush(jobImagesKey(id), scan.Data)
+	}
+	pipe.Expire(jobImagesKey(id), genJobTTL)
+	pipe.LPush(genQueueKey, id)
+	pipe.SAdd(userJobsKey(userID), id)
+	pipe.Expire(userJobsKey(userID), genJobTTL)
+	if _, err := pipe.Exec(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// complete stores a scan answered from the cache as a finished job.
+func (q *genQueue) complete(userID string, result string, cached string) (genJobView, error) {
+	id, err := newJobID()
+	if err != nil {
+		return genJobView{}, err
+	}
+	now := nowMillis()
+
+	job := map[string]string{
+		"user_id":    userID,
+		"status":     jobDone,
+		"attempts":   "0",
+		"result":     result,
+		"cached":     cached,
+		"created_at": now,
+		"updated_at": now,
+		"seen_at":    now,
+	}
+	fields := make(map[string]interface{}, len(job))
+	for field, value := range job {
+		fields[field] = value
+	}
+
+	pipe := q.rdb.TxPipeline()
+	pipe.HMSet(jobKey(id), fields)
+	pipe.Expire(jobKey(id), genJobTTL)
+	pipe.SAdd(userJobsKey(userID), id)
+	pipe.Expire(userJobsKey(userID), genJobTTL)
+	if _, err := pipe.Exec(); err != nil {
+		return genJobView{}, err
+	}
+	return newGenJobView(id, job), nil
+}
+
+func (q *genQueue) work() {
+	for {
+		id, err := q.rdb.BRPopLPush(genQueueKey, genProcessingKey, 5*time.Second).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			log.Println("erro lendo a fila de análises:", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		q.process(id)
+		q.rdb.LRem(genProcessingKey, 1, id)
+	}
+}
+
+func (q *genQueue) process(id string) {
+	job, err := q.rdb.HGetAll(jobKey(id)).Result()
+	if err != nil || len(job) == 0 {
+		// Expired while it waited.
+		q.rdb.Del(jobImagesKey(id))
+		return
+	}
+
+	if q.abandoned(job) {
+		q.cancel(id, job, "Análise cancelada: o cliente deixou de acompanhar a tarefa.")
+		return
+	}
+
+	attempts, _ := strconv.Atoi(job["attempts"])
+	attempts++
+	if !q.transition(id, []string{jobQueued, jobRetrying}, "status", jobRunning, "attempts", strconv.Itoa(attempts), "heartbeat", nowMillis()) {
+		return
+	}
+
+	data, err := q.rdb.LRange(jobImagesKey(id), 0, -1).Result()
+	formats := strings.Split(job["formats"], ",")
+	if err != nil || len(data) == 0 || len(data) != len(formats) {
+		q.fail(id, job, "As imagens da análise expiraram.")
+		return
+	}
+	images := make([]ai.Image, len(data))
+	for i := range data {
+		images[i] = ai.Image{Format: formats[i], Data: []byte(data[i])}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+	defer cancel()
+	stopped := make(chan string, 1)
+	go q.watch(ctx, id, cancel, stopped)
+
+	result, err := q.scan(ctx, job["user_id"], images)
+	cancel()
+
+	select {
+	case reason := <-stopped:
+		q.cancel(id, job, reason)
+		return
+	default:
+	}
+
+	if err == nil {
+		data, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			q.fail(id, job, "Resposta inválida da IA.")
+			return
+		}
+		if q.transition(id, []string{jobRunning}, "status", jobDone, "result", string(data)) {
+			q.rdb.Del(jobImagesKey(id))
+			q.cache.store(jobFingerprint(job), string(data))
+		}
+		return
+	}
+
+	log.Println("erro na análise", id, "tentativa", strconv.Itoa(attempts)+":", err)
+	if attempts >= q.maxAttempts || ai.IsPermanent(err) {
+		q.fail(id, job, "Não foi possível analisar a imagem.")
+		return
+	}
+
+	retryAt := time.Now().Add(backoff(attempts))
+	if q.transition(id, []string{jobRunning}, "status", jobRetrying, "retry_at", strconv.FormatInt(retryAt.UnixMilli(), 10)) {
+		q.rdb.ZAdd(genDelayedKey, redis.Z{Score: float64(retryAt.UnixMilli()), Member: id})
+	}
+}
+
+// watch keeps the heartbeat of a running job and stops it when it is
+// cancelled or its client went away.
+func (q *genQueue) watch(ctx context.Context, id string, cancel context.CancelFunc, stopped chan<- string) {
+	ticker := time.NewTicker(genHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		beating, err := heartbeatJobScript.Run(q.rdb, []string{jobKey(id)}, jobRunning, nowMillis()).Int()
+		if err != nil {
+			continue
+		}
+		job, err := q.rdb.HGetAll(jobKey(id)).Result()
+		if err != nil {
+			continue
+		}
+		reason := ""
+		switch {
+		case beating == 0 || job["cancel"] == "1":
+			reason = "Análise cancelada."
+		case q.abandoned(job):
+			reason = "Análise cancelada: o cliente deixou de acompanhar a tarefa."
+		}
+		if reason != "" {
+			stopped <- reason
+			cancel()
+			return
+		}
+	}
+}
+
+// backoff doubles the wait after every failed attempt, with some jitter so
+// retries of jobs that failed together spread out.
+func backoff(attempt int) time.Duration {
+	wait := genBackoffMax
+	if attempt < 10 {
+		wait = min(genBackoffBase<<(attempt-1), genBackoffMax)
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait/5)+1))
+}
+
+func jobFingerprint(job map[string]string) scanFingerprint {
+	fp := scanFingerprint{sha: job["sha"]}
+	if phash, err := strconv.ParseUint(job["phash"], 16, 64); err == nil {
+		fp.phash, fp.hasPHash = phash, true
+	}
+	return fp
+}
+
+// seen marks that the client of a job still asks about it.
+func (q *genQueue) seen(id string) {
+	if err := seenJobScript.Run(q.rdb, []string{jobKey(id)}, nowMillis()).Err(); err != nil {
+		log.Println("erro atualizando tarefa", id+":", err)
+	}
+}
+
+func (q *genQueue) abandoned(job map[string]string) bool {
+	seen, err := strconv.ParseInt(job["seen_at"], 10, 64)
+	return err == nil && time.Since(time.UnixMilli(seen)) > q.abandonAfter
+}
+
+func (q *genQueue) fail(id string, job map[string]string, message string) {
+	if !q.transition(id, []string{jobQueued, jobRunning, jobRetrying}, "status", jobFailed, "error", message) {
+		return
+	}
+	q.finish(id, job)
+
+	pipe := q.rdb.TxPipeline()
+	pipe.LPush(genDeadKey, id)
+	pipe.LTrim(genDeadKey, 0, genDeadLetterLimit-1)
+	pipe.Exec()
+}
+
+func (q *genQueue) cancel(id string, job map[string]string, message string) bool {
+	if !q.transition(id, []string{jobQueued, jobRunning, jobRetrying}, "status", jobCanceled, "error", message) {
+		return false
+	}
+	q.finish(id, job)
+	return true
+}
+
+// finish cleans up after a job that ended without a result.
+func (q *genQueue) finish(id string, job map[string]string) {
+	q.rdb.Del(jobImagesKey(id))
+	q.rdb.ZRem(genDelayedKey, id)
+	if key := job["quota_key"]; key != "" {
+		q.release(key)
+	}
+}
+
+// scanExport is a scan as handed to its owner in a data export.
+type scanExport struct {
+	genJobView
+	// Photos are those still waiting to be analysed.
+	Photos []scanPhotoExport `json:"photos,omitempty"`
+}
+
+// scanPhotoExport is one photo of a scan, in the format it was sent in.
+type scanPhotoExport struct {
+	Format string `json:"format"`
+	Data   []byte `json:"data"`
+}
+
+// export lists the scans of userID still kept, the latest first.
+func (q *genQueue) export(userID string) ([]scanExport, error) {
+	ids, err := q.rdb.SMembers(userJobsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	scans := []scanExport{}
+	for _, id := range ids {
+		job, err := q.rdb.HGetAll(jobKey(id)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(job) == 0 || job["user_id"] != userID {
+			continue
+		}
+		photos, err := q.rdb.LRange(jobImagesKey(id), 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		scan := scanExport{genJobView: newGenJobView(id, job)}
+		formats := strings.Split(job["formats"], ",")
+		for i, photo := range photos {
+			format := ""
+			if i < len(formats) {
+				format = formats[i]
+			}
+			scan.Photos = append(scan.Photos, scanPhotoExport{Format: format, Data: []byte(photo)})
+		}
+		scans = append(scans, scan)
+	}
+	sort.Slice(scans, func(i, j int) bool { return scans[i].CreatedAt.After(scans[j].CreatedAt) })
+	return scans, nil
+}
+
+// forget deletes the jobs of userID with their photos and results. Workers
+// running one of them stop at the next heartbeat.
+func (q *genQueue) forget(userID string) error {
+	return deleteIndexed(q.rdb, userJobsKey(userID), func(id string) []string {
+		pipe := q.rdb.TxPipeline()
+		pipe.LRem(genQueueKey, 0, id)
+		pipe.ZRem(genDelayedKey, id)
+		pipe.LRem(genDeadKey, 0, id)
+		pipe.Exec()
+		return []string{jobKey(id), jobImagesKey(id)}
+	})
+}
+
+func (q *genQueue) scheduleLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		q.requeueDue()
+		if i%int(genStaleAfter/time.Second) == 0 {
+			q.requeueStale()
+		}
+		<-ticker.C
+	}
+}
+
+// requeueDue moves retries whose backoff is over back to the queue. ZRem
+// decides which instance moves each job.
+func (q *genQueue) requeueDue() {
+	ids, err := q.rdb.ZRangeByScore(genDelayedKey, redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		log.Println("erro lendo análises agendadas:", err)
+		return
+	}
+	for _, id := range ids {
+		if removed, err := q.rdb.ZRem(genDelayedKey, id).Result(); err == nil && removed == 1 {
+			q.rdb.LPush(genQueueKey, id)
+		}
+	}
+}
+
+// requeueStale gives the jobs a worker took but stopped working on, e.g.
+// because the server was restarted, to another worker. A job is stale
+// when neither its heartbeat nor its status changed for genStaleAfter,
+// whatever its status: a worker may have died before starting it, or
+// after it failed but before scheduling the retry. Jobs that already
+// ended only leave the list.
+func (q *genQueue) requeueStale() {
+	ids, err := q.rdb.LRange(genProcessingKey, 0, -1).Result()
+	if err != nil {
+		log.Println("erro lendo análises em andamento:", err)
+		return
+	}
+	for _, id := range ids {
+		job, err := q.rdb.HGetAll(jobKey(id)).Result()
+		if err != nil {
+			continue
+		}
+		if len(job) == 0 {
+			q.rdb.LRem(genProcessingKey, 1, id)
+			continue
+		}
+		if !jobStale(job) {
+			continue
+		}
+		if removed, err := q.rdb.LRem(genProcessingKey, 1, id).Result(); err != nil || removed == 0 {
+			continue
+		}
+		switch job["status"] {
+		case jobRunning:
+			if q.transition(id, []string{jobRunning}, "status", jobQueued) {
+				q.rdb.LPush(genQueueKey, id)
+			}
+		case jobQueued, jobRetrying:
+			q.rdb.ZRem(genDelayedKey, id)
+			q.rdb.LPush(genQueueKey, id)
+		}
+	}
+}
+
+// jobStale tells whether a job saw no heartbeat nor change for
+// genStaleAfter.
+func jobStale(job map[string]string) bool {
+	var last int64
+	for _, field := range []string{"heartbeat", "updated_at"} {
+		if at, err := strconv.ParseInt(job[field], 10, 64); err == nil {
+			last = max(last, at)
+		}
+	}
+	return time.Since(time.UnixMilli(last)) >= genStaleAfter
+}
+
+type genJobView struct {
+	ID       string          `json:"id"`
+	Status   string          `json:"status"`
+	Attempts int             `json:"attempts"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	// Cached tells how a scan answered from the cache was found: "exact"
+	// or "similar".
+	Cached    string     `json:"cached,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+func newGenJobView(id string, job map[string]string) genJobView {
+	view := genJobView{
+		ID:        id,
+		Status:    job["status"],
+		Error:     job["error"],
+		Cached:    job["cached"],
+		CreatedAt: millisTime(job["created_at"]),
+		UpdatedAt: millisTime(job["updated_at"]),
+	}
+	view.Attempts, _ = strconv.Atoi(job["attempts"])
+	if job["result"] != "" {
+		view.Result = json.RawMessage(job["result"])
+	}
+	if view.Status == jobRetrying && job["retry_at"] != "" {
+		retryAt := millisTime(job["retry_at"])
+		view.RetryAt = &retryAt
+	}
+	return view
+}
+
+func millisTime(value string) time.Time {
+	ms, _ := strconv.ParseInt(value, 10, 64)
+	return time.UnixMilli(ms).UTC()
+}
+
+func jobFinished(status string) bool {
+	return status == jobDone || status == jobFailed || status == jobCanceled
+}
+
+// Gemini queues a fridge scan and answers 202 with the job to follow. The
+// photos were read by checkScanCache.
+func (a *App) Gemini(c *gin.Context) {
+	upload := c.MustGet(scanUploadContextKey).(*scanUpload)
+
+	id, err := a.gen.enqueue(currentUser(c).ID.Hex(), upload, countedUsage(c, featureAIScans))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao enfileirar a análise."})
+		return
+	}
+
+	c.Header("Location", "/api/v1/gen/"+id)
+	c.JSON(http.StatusAccepted, gin.H{"id": id, "status": jobQueued})
+}
+
+// GetGenJob reports a scan. With ?wait=<seconds> it holds the request until
+// the job finishes or the wait is over. Every read tells the queue the
+// client is still there; jobs nobody asks about are cancelled.
+func (a *App) GetGenJob(c *gin.Context) {
+	id := c.Param("job")
+	userID := currentUser(c).ID.Hex()
+
+	wait := time.Duration(0)
+	if seconds, err := strconv.Atoi(c.Query("wait")); err == nil && seconds > 0 {
+		wait = min(time.Duration(seconds)*time.Second, genMaxWait)
+	}
+	deadline := time.Now().Add(wait)
+
+	for {
+		job, err := a.rdb.HGetAll(jobKey(id)).Result()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(job) == 0 || job["user_id"] != userID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Análise não encontrada."})
+			return
+		}
+		a.gen.seen(id)
+
+		if jobFinished(job["status"]) || !time.Now().Before(deadline) {
+			c.JSON(http.StatusOK, newGenJobView(id, job))
+			return
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// CancelGenJob stops a scan that has not finished. Its use is given back
+// to the quota.
+func (a *App) CancelGenJob(c *gin.Context) {
+	id := c.Param("job")
+
+	job, err := a.rdb.HGetAll(jobKey(id)).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(job) == 0 || job["user_id"] != currentUser(c).ID.Hex() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Análise não encontrada."})
+		return
+	}
+	if jobFinished(job["status"]) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A análise já terminou."})
+		return
+	}
+
+	// The flag makes the worker of a running job stop at its next
+	// heartbeat; the result it may still produce is thrown away.
+	a.rdb.HSet(jobKey(id), "cancel", "1")
+	if !a.gen.cancel(id, job, "Análise cancelada.") {
+		c.JSON(http.StatusConflict, gin.H{"error": "A análise já terminou."})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"id": id, "status": jobCanceled})
+}
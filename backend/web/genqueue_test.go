@@ -0,0 +1,148 @@
+package web
+
+import (
+	"context"
+	"cucinia/ai"
+	"cucinia/imaging"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// queueScan queues a scan of one photo for userID.
+func (ta *testApp) queueScan(userID string) string {
+	ta.t.Helper()
+	upload := &scanUpload{images: []*imaging.Scan{{ContentType: "image/jpeg", Data: []byte("foto")}}}
+	id, err := ta.gen.enqueue(userID, upload, "")
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	return id
+}
+
+// waitFor polls cond until it holds or a few seconds passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestForgottenJobStopsItsWorker(t *testing.T) {
+	ta := newTestApp(t)
+	ta.gen.scan = func(ctx context.Context, userID string, images []ai.Image) (*scanResult, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	id := ta.queueScan("u1")
+	ta.redis.Lpop(genQueueKey)
+
+	done := make(chan struct{})
+	go func() {
+		ta.gen.process(id)
+		close(done)
+	}()
+	waitFor(t, "the job to run", func() bool { return ta.redis.HGet(jobKey(id), "status") == jobRunning })
+
+	if err := ta.gen.forget("u1"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(3 * genHeartbeat):
+		t.Fatal("the worker kept running a deleted job")
+	}
+	if ta.redis.Exists(jobKey(id)) || ta.redis.Exists(jobImagesKey(id)) {
+		t.Error("the worker wrote the deleted job back")
+	}
+}
+
+func TestRequeueStaleRecoversCrashedWorkers(t *testing.T) {
+	ta := newTestApp(t)
+	old := strconv.FormatInt(time.Now().Add(-2*genStaleAfter).UnixMilli(), 10)
+	recent := nowMillis()
+
+	// Each job was taken by a worker that died at a different point.
+	jobs := map[string]struct {
+		status, updatedAt, heartbeat string
+		requeued, kept               bool
+	}{
+		"before starting":  {status: jobQueued, updatedAt: old, requeued: true},
+		"while running":    {status: jobRunning, updatedAt: old, heartbeat: old, requeued: true},
+		"before the retry": {status: jobRetrying, updatedAt: old, requeued: true},
+		"after finishing":  {status: jobDone, updatedAt: old},
+		"still running":    {status: jobRunning, updatedAt: old, heartbeat: recent, kept: true},
+		"just taken":       {status: jobQueued, updatedAt: recent, kept: true},
+	}
+	ids := map[string]string{}
+	for name, job := range jobs {
+		id := ta.queueScan("u1")
+		ids[name] = id
+		ta.redis.Lpop(genQueueKey)
+		ta.redis.Lpush(genProcessingKey, id)
+		ta.redis.HSet(jobKey(id), "status", job.status, "updated_at", job.updatedAt, "heartbeat", job.heartbeat)
+	}
+	ta.redis.ZAdd(genDelayedKey, 0, ids["before the retry"])
+
+	ta.gen.requeueStale()
+
+	queued, _ := ta.redis.List(genQueueKey)
+	processing, _ := ta.redis.List(genProcessingKey)
+	for name, job := range jobs {
+		id := ids[name]
+		if slices.Contains(queued, id) != job.requeued {
+			t.Errorf("%s: queued = %v, want %v", name, !job.requeued, job.requeued)
+		}
+		if slices.Contains(processing, id) != job.kept {
+			t.Errorf("%s: still processing = %v, want %v", name, !job.kept, job.kept)
+		}
+		if job.requeued && ta.redis.HGet(jobKey(id), "status") == jobRunning {
+			t.Errorf("%s: requeued as running", name)
+		}
+	}
+	if members, _ := ta.redis.ZMembers(genDelayedKey); len(members) != 0 {
+		t.Errorf("requeued retry still scheduled: %v", members)
+	}
+
+	// A worker takes the recovered jobs again.
+	done := map[string]bool{}
+	ta.gen.scan = func(ctx context.Context, userID string, images []ai.Image) (*scanResult, error) {
+		return &scanResult{Ingredients: []string{"leite"}}, nil
+	}
+	for len(queued) > 0 {
+		id, _ := ta.redis.Lpop(genQueueKey)
+		ta.gen.process(id)
+		done[id] = ta.redis.HGet(jobKey(id), "status") == jobDone
+		queued, _ = ta.redis.List(genQueueKey)
+	}
+	for name, job := range jobs {
+		if job.requeued && !done[ids[name]] {
+			t.Errorf("%s: not finished after the requeue", name)
+		}
+	}
+}
+
+func TestSeenKeepsDeletedJobsDeleted(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.queueScan("u1")
+	ta.redis.HSet(jobKey(id), "seen_at", "1")
+
+	ta.gen.seen(id)
+	if seen := ta.redis.HGet(jobKey(id), "seen_at"); seen == "1" {
+		t.Error("seen_at not updated")
+	}
+	if ttl := ta.redis.TTL(jobKey(id)); ttl <= 0 {
+		t.Errorf("job TTL = %v after a read, want it kept", ttl)
+	}
+
+	ta.redis.Del(jobKey(id))
+	ta.gen.seen(id)
+	if ta.redis.Exists(jobKey(id)) {
+		t.Error("a read recreated the deleted job")
+	}
+}
@@ -0,0 +1,50 @@
+package web
+
+import (
+	"cucinia/model"
+	"net/http"
+	"testing"
+)
+
+func TestIngredientChangesNeedEditor(t *testing.T) {
+	ta := newTestApp(t)
+	_, userToken := ta.addUser("ana@example.com", model.RoleUser)
+	editor, editorToken := ta.addUser("edu@example.com", model.RoleEditor)
+
+	for name, token := range map[string]string{"anonymous": "", "user": userToken} {
+		for _, request := range []struct{ method, path string }{
+			{http.MethodPost, "/api/v1/ingredients"},
+			{http.MethodPatch, "/api/v1/ingredients/000000000000000000000001"},
+			{http.MethodDelete, "/api/v1/ingredients/000000000000000000000001"},
+		} {
+			w := ta.do(request.method, request.path, map[string]string{"name": "sal"}, token)
+			if w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+				t.Errorf("%s %s as %s = %d, want 401 or 403", request.method, request.path, name, w.Code)
+			}
+		}
+	}
+	if len(ta.db.ingredients) != 0 || len(ta.db.audit) != 0 {
+		t.Fatal("a rejected request changed ingredients")
+	}
+
+	w := ta.do(http.MethodPost, "/api/v1/ingredients", map[string]string{"name": "sal"}, editorToken, "X-Forwarded-For", "192.0.2.1")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create as editor = %d %s", w.Code, w.Body)
+	}
+	id := decode[model.Ingredient](t, w).ID.Hex()
+	if w := ta.do(http.MethodPatch, "/api/v1/ingredients/"+id, map[string]string{"name": "sal grosso"}, editorToken); w.Code != http.StatusOK {
+		t.Fatalf("update as editor = %d %s", w.Code, w.Body)
+	}
+	if w := ta.do(http.MethodDelete, "/api/v1/ingredients/"+id, nil, editorToken); w.Code != http.StatusNoContent {
+		t.Fatalf("delete as editor = %d %s", w.Code, w.Body)
+	}
+
+	if len(ta.db.audit) != 3 {
+		t.Fatalf("%d audit entries, want 3", len(ta.db.audit))
+	}
+	for _, entry := range ta.db.audit {
+		if entry.ActorID != editor.ID.Hex() || entry.IP != "203.0.113.10" {
+			t.Errorf("%s audited as %q from %q, want the editor from the connection's IP", entry.Action, entry.ActorID, entry.IP)
+		}
+	}
+}
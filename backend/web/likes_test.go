@@ -0,0 +1,221 @@
+package web
+
+import (
+	"cucinia/model"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordEvents writes the pending recipe events to Redis, as the stats
+// consumer would.
+func (ta *testApp) recordEvents() {
+	var batch []recipeEvent
+	for {
+		select {
+		case event := <-ta.stats.events:
+			batch = append(batch, event)
+		default:
+			if len(batch) > 0 {
+				ta.stats.record(batch)
+			}
+			return
+		}
+	}
+}
+
+func (ta *testApp) eventScore(key, recipeID string) float64 {
+	ta.t.Helper()
+	ta.recordEvents()
+	if !ta.redis.Exists(key) {
+		return 0
+	}
+	score, err := ta.redis.ZScore(key, recipeID)
+	if err != nil {
+		return 0
+	}
+	return score
+}
+
+func TestLikesCountOncePerUser(t *testing.T) {
+	ta := newTestApp(t)
+	recipe := &model.Recipe{ID: primitive.NewObjectID(), Name: "Bolo de cenoura", Status: model.RecipePublished}
+	ta.db.recipes[recipe.ID.Hex()] = recipe
+	recipeID := recipe.ID.Hex()
+	user, token := ta.addUser("ana@example.com", model.RoleUser)
+	_, otherToken := ta.addUser("bia@example.com", model.RoleUser)
+	bucket := bucketKey(eventLike, trendingWindows["day"], time.Now().UTC())
+
+	like := func(path, token string) []string {
+		t.Helper()
+		w := ta.do(http.MethodPost, "/api/v1/"+path, map[string]string{"recipe_id": recipeID}, token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s = %d: %s", path, w.Code, w.Body)
+		}
+		return decode[struct {
+			User selfUser `json:"user"`
+		}](t, w).User.LikedRecipes
+	}
+
+	like("like-recipe", token)
+	if liked := like("like-recipe", token); len(liked) != 1 || liked[0] != recipeID {
+		t.Errorf("liked recipes after a double like = %v", liked)
+	}
+	if score := ta.eventScore(totalKey(eventLike), recipeID); score != 1 {
+		t.Errorf("likes after a double like = %v, want 1", score)
+	}
+
+	like("like-recipe", otherToken)
+	if score := ta.eventScore(totalKey(eventLike), recipeID); score != 2 {
+		t.Errorf("likes after another user liked = %v, want 2", score)
+	}
+
+	if liked := like("unlike-recipe", token); len(liked) != 0 {
+		t.Errorf("liked recipes after unliking = %v", liked)
+	}
+	like("unlike-recipe", token)
+	if score := ta.eventScore(totalKey(eventLike), recipeID); score != 1 {
+		t.Errorf("likes after unliking twice = %v, want 1", score)
+	}
+	if score := ta.eventScore(bucket, recipeID); score != 1 {
+		t.Errorf("trending likes after unliking = %v, want 1", score)
+	}
+	if len(ta.db.likes) != 1 || ta.db.likes[0].UserID == user.ID.Hex() {
+		t.Errorf("likes stored = %d, want only the other user's", len(ta.db.likes))
+	}
+}
+
+func TestLikeUnknownRecipe(t *testing.T) {
+	ta := newTestApp(t)
+	draft := &model.Recipe{ID: primitive.NewObjectID(), Name: "Rascunho", Status: model.RecipeDraft}
+	ta.db.recipes[draft.ID.Hex()] = draft
+	_, token := ta.addUser("ana@example.com", model.RoleUser)
+
+	for _, id := range []string{draft.ID.Hex(), primitive.NewObjectID().Hex()} {
+		if w := ta.do(http.MethodPost, "/api/v1/like-recipe", map[string]string{"recipe_id": id}, token); w.Code != http.StatusNotFound {
+			t.Errorf("like %s = %d, want 404", id, w.Code)
+		}
+	}
+	if len(ta.db.likes) != 0 || ta.eventScore(totalKey(eventLike), draft.ID.Hex()) != 0 {
+		t.Error("like of a recipe that is not published was counted")
+	}
+}
+
+func TestViewsAndCooksCountOncePerCaller(t *testing.T) {
+	ta := newTestApp(t)
+	recipe := &model.Recipe{ID: primitive.NewObjectID(), Name: "Bolo de cenoura", Status: model.RecipePublished}
+	ta.db.recipes[recipe.ID.Hex()] = recipe
+	recipeID := recipe.ID.Hex()
+	_, anaToken := ta.addUser("ana@example.com", model.RoleUser)
+	_, biaToken := ta.addUser("bia@example.com", model.RoleUser)
+
+	view := func(token string) {
+		t.Helper()
+		if w := ta.do(http.MethodGet, "/api/v1/recipes/by-id/"+recipeID, nil, token); w.Code != http.StatusOK {
+			t.Fatalf("view = %d: %s", w.Code, w.Body)
+		}
+	}
+	// The second view of each caller is served from the cache.
+	for _, token := range []string{anaToken, anaToken, biaToken, "", ""} {
+		view(token)
+	}
+	ta.remoteAddr = "198.51.100.7:4000"
+	view("")
+	if keys, _ := ta.rdb.Keys("stats:*").Result(); len(keys) != 0 {
+		t.Errorf("requests wrote %v to Redis, want tracking left to the consumer", keys)
+	}
+	if score := ta.eventScore(totalKey(eventView), recipeID); score != 4 {
+		t.Errorf("views = %v, want one for each user and IP", score)
+	}
+
+	if w := ta.do(http.MethodPost, "/api/v1/recipes/"+recipeID+"/cook", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous cook = %d, want 401", w.Code)
+	}
+	for _, token := range []string{anaToken, anaToken, anaToken, biaToken} {
+		if w := ta.do(http.MethodPost, "/api/v1/recipes/"+recipeID+"/cook", nil, token); w.Code != http.StatusOK {
+			t.Fatalf("cook = %d: %s", w.Code, w.Body)
+		}
+	}
+	if score := ta.eventScore(totalKey(eventCook), recipeID); score != 2 {
+		t.Errorf("cooks = %v, want one for each user", score)
+	}
+}
+
+func TestForgetDropsStoredStats(t *testing.T) {
+	ta := newTestApp(t)
+	recipeID := primitive.NewObjectID().Hex()
+	ta.stats.track(recipeID, eventCook)
+	ta.recordEvents()
+	if err := ta.stats.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ta.db.stats) != 1 {
+		t.Fatalf("stored stats = %d, want 1", len(ta.db.stats))
+	}
+
+	ta.stats.forget(recipeID)
+	ta.redis.FlushAll()
+	ta.stats.restore()
+	if score := ta.eventScore(totalKey(eventCook), recipeID); score != 0 {
+		t.Errorf("cooks restored after the recipe was deleted = %v, want 0", score)
+	}
+}
+
+func TestForgetLeavesRankings(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("editor@example.com", model.RoleEditor)
+	var ids []string
+	for _, name := range []string{"Bolo", "Pudim", "Torta"} {
+		recipe := &model.Recipe{ID: primitive.NewObjectID(), Name: name, Status: model.RecipePublished}
+		ta.db.recipes[recipe.ID.Hex()] = recipe
+		ids = append(ids, recipe.ID.Hex())
+	}
+	deleted := ids[0]
+
+	// The deleted recipe leads, with events in older buckets too.
+	now := time.Now().UTC()
+	for i, id := range ids {
+		for _, ago := range []time.Duration{0, 5 * time.Hour, 3 * 24 * time.Hour} {
+			for n := 0; n < len(ids)-i; n++ {
+				ta.stats.push(recipeEvent{recipeID: id, kind: eventCook, at: now.Add(-ago), delta: 1})
+			}
+		}
+	}
+	ta.recordEvents()
+
+	rankings := []string{"/api/v1/recipes/trending?limit=2", "/api/v1/recipes/trending?window=week&limit=2", "/api/v1/recipes/popular?limit=2"}
+	ranked := func(path string) []string {
+		t.Helper()
+		w := ta.do(http.MethodGet, path, nil, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s = %d %s", path, w.Code, w.Body)
+		}
+		var names []string
+		for _, recipe := range decode[[]rankedRecipe](t, w) {
+			names = append(names, recipe.Name)
+		}
+		return names
+	}
+	for _, path := range rankings {
+		if names := ranked(path); len(names) != 2 || names[0] != "Bolo" {
+			t.Fatalf("%s before the delete = %v", path, names)
+		}
+	}
+
+	if w := ta.do(http.MethodDelete, "/api/v1/recipes/"+deleted, nil, token); w.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d %s", w.Code, w.Body)
+	}
+	for _, path := range rankings {
+		if names := ranked(path); len(names) != 2 || names[0] != "Pudim" || names[1] != "Torta" {
+			t.Errorf("%s after the delete = %v, want Pudim and Torta", path, names)
+		}
+	}
+	keys, _ := ta.rdb.Keys("stats:*").Result()
+	for _, key := range keys {
+		if score := ta.eventScore(key, deleted); score != 0 {
+			t.Errorf("%s still scores the deleted recipe %v", key, score)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"cucinia/imaging"
+	"cucinia/model"
+	"cucinia/storage"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const mediaPrefix = "/api/v1/media/"
+
+// mediaKeyPattern matches the keys UploadRecipeImage stores objects under. Only
+// those are served, so the media route cannot be used to read anything
+// else from the storage with the service's credentials.
+var mediaKeyPattern = regexp.MustCompile(`^recipes/[0-9a-f]{24}/[0-9a-f]{16}/[a-z]+\.[a-z]+$`)
+
+func mediaURL(key string) string {
+	return mediaPrefix + key
+}
+
+// ownMediaKey returns the storage key behind url when it is an image
+// uploaded for the recipe, so a recipe can only lead to its own objects
+// being deleted.
+func ownMediaKey(recipeID, url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, mediaPrefix)
+	if !ok || !mediaKeyPattern.MatchString(key) || !strings.HasPrefix(key, "recipes/"+recipeID+"/") {
+		return "", false
+	}
+	return key, true
+}
+
+// deleteReplacedMedia deletes the images of recipe that images no longer
+// refers to.
+func (a *App) deleteReplacedMedia(recipe *model.Recipe, images map[string]string) {
+	kept := make(map[string]bool, len(images))
+	for _, url := range images {
+		kept[url] = true
+	}
+	for _, url := range recipe.Images {
+		key, ok := ownMediaKey(recipe.ID.Hex(), url)
+		if !ok || kept[url] {
+			continue
+		}
+		if err := a.store.Delete(context.Background(), key); err != nil {
+			log.Println("erro removendo imagem:", err)
+		}
+	}
+}
+
+func (a *App) UploadRecipeImage(c *gin.Context) {
+	id := c.Param("id")
+
+	recipe, err := a.d.GetRecipeByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if recipe == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receita não encontrada."})
+		return
+	}
+	if !canEditRecipe(currentUser(c), recipe) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permissão insuficiente."})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, imaging.MaxUploadSize+(1<<20))
+	file, _, err := c.Request.FormFile("image")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": imaging.ErrTooLarge.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, imaging.MaxUploadSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(data) > imaging.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": imaging.ErrTooLarge.Error()})
+		return
+	}
+
+	contentType, ext, err := imaging.Sniff(data)
+	if err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	}
+
+	img, err := imaging.Decode(data, contentType)
+	if errors.Is(err, imaging.ErrTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Imagem inválida."})
+		return
+	}
+
+	variants, err := imaging.Variants(img)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	prefix := "recipes/" + id + "/" + hex.EncodeToString(sum[:8]) + "/"
+	ctx := c.Request.Context()
+
+	originalKey := prefix + "original." + ext
+	if err := a.store.Put(ctx, originalKey, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao salvar a imagem."})
+		return
+	}
+
+	images := map[string]string{"original": mediaURL(originalKey)}
+	for _, v := range variants {
+		key := prefix + v.Name + "." + v.Ext
+		if err := a.store.Put(ctx, key, bytes.NewReader(v.Data), int64(len(v.Data)), v.ContentType); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao salvar a imagem."})
+			return
+		}
+		name := v.Name
+		if v.Ext == "webp" {
+			name += "_webp"
+		}
+		images[name] = mediaURL(key)
+	}
+
+	if err := a.d.SetRecipeImages(id, images["medium"], images, currentUser(c).ID.Hex()); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	a.deleteReplacedMedia(recipe, images)
+
+	a.rdb.Del("recipe:" + id)
+	if isPublished(recipe) {
+		a.invalidateRecipeListsCache()
+	}
+	a.auditRecipe(c, model.AuditUpdate, id, recipe)
+
+	a.respondWithRecipe(c, id)
+}
+
+func (a *App) ServeMedia(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !mediaKeyPattern.MatchString(key) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Arquivo não encontrado."})
+		return
+	}
+
+	body, obj, err := a.store.Get(c.Request.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Arquivo não encontrado."})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer body.Close()
+
+	header := c.Writer.Header()
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	header.Set("X-Content-Type-Options", "nosniff")
+	if obj.ETag != "" {
+		header.Set("ETag", obj.ETag)
+		if c.GetHeader("If-None-Match") == obj.ETag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+	if !obj.ModTime.IsZero() {
+		header.Set("Last-Modified", obj.ModTime.UTC().Format(http.TimeFormat))
+	}
+	size := obj.Size
+	if size <= 0 {
+		size = -1
+	}
+
+	contentType := obj.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	c.DataFromReader(http.StatusOK, size, contentType, body, nil)
+}
@@ -0,0 +1,110 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"cucinia/model"
+	"cucinia/storage"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestServeMediaOnlyServesRecipeImages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	image := "recipes/65f1c2a9b3e4d5f6a7b8c9d0/0123456789abcdef/thumb.webp"
+	for _, key := range []string{image, "exports/65f1c2a9b3e4d5f6a7b8c9d0.json"} {
+		if err := store.Put(ctx, key, strings.NewReader("data"), 4, "image/webp"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &App{store: store}
+	router := gin.New()
+	router.GET("/api/v1/media/*key", a.ServeMedia)
+
+	for path, want := range map[string]int{
+		mediaURL(image): http.StatusOK,
+		mediaURL("exports/65f1c2a9b3e4d5f6a7b8c9d0.json"):                         http.StatusNotFound,
+		mediaURL("recipes/65f1c2a9b3e4d5f6a7b8c9d0/0123456789abcdef/../../x.jpg"): http.StatusNotFound,
+		mediaURL("recipes/65f1c2a9b3e4d5f6a7b8c9d0/0123456789abcdef/%2e%2e.jpg"):  http.StatusNotFound,
+		mediaURL("recipes/65f1c2a9b3e4d5f6a7b8c9d0/0123456789abcdef/nope.jpg"):    http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+// uploadImage sends data as the image of recipe id.
+func (ta *testApp) uploadImage(id, token string, data []byte) *model.Recipe {
+	ta.t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("image", "bolo.png")
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+	w := ta.do(http.MethodPost, "/api/v1/recipes/"+id+"/image", body.Bytes(), token, "Content-Type", form.FormDataContentType())
+	if w.Code != http.StatusOK {
+		ta.t.Fatalf("upload = %d %s", w.Code, w.Body)
+	}
+	return ta.db.recipes[id]
+}
+
+func TestReuploadDeletesReplacedImages(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("editor@example.com", model.RoleEditor)
+	recipe := &model.Recipe{ID: primitive.NewObjectID(), Name: "Bolo", Status: model.RecipePublished}
+	ta.db.recipes[recipe.ID.Hex()] = recipe
+	id := recipe.ID.Hex()
+
+	keys := func(recipe *model.Recipe) []string {
+		var keys []string
+		for _, url := range recipe.Images {
+			key, ok := ownMediaKey(id, url)
+			if !ok {
+				t.Fatalf("image %s is not under the recipe", url)
+			}
+			keys = append(keys, key)
+		}
+		return keys
+	}
+
+	first := keys(ta.uploadImage(id, token, encodePNG(t, fridgePhoto(400, 300, 0))))
+	// Uploading the same file again keeps its images.
+	if again := keys(ta.uploadImage(id, token, encodePNG(t, fridgePhoto(400, 300, 0)))); len(again) != len(first) {
+		t.Fatalf("images after the same upload = %v, want %v", again, first)
+	}
+	for _, key := range first {
+		if !ta.stored(key) {
+			t.Errorf("%s deleted by uploading the same file", key)
+		}
+	}
+
+	second := keys(ta.uploadImage(id, token, encodePNG(t, fridgePhoto(400, 300, 1))))
+	for _, key := range first {
+		if ta.stored(key) {
+			t.Errorf("replaced image %s was kept", key)
+		}
+	}
+	for _, key := range second {
+		if !ta.stored(key) {
+			t.Errorf("new image %s is missing", key)
+		}
+	}
+}
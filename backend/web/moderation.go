@@ -0,0 +1,253 @@
+package web
+
+import (
+	"cucinia/model"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func isPublished(recipe *model.Recipe) bool {
+	return recipe != nil && recipe.Status == model.RecipePublished
+}
+
+// isEditor only grants editor privileges once two-factor authentication is
+// on, as requireRole does.
+func isEditor(user *model.User) bool {
+	return isPrivileged(user) && user.TOTPEnabled
+}
+
+// canEditRecipe allows editors to change any recipe and authors to change
+// their own recipes while they are not published.
+func canEditRecipe(user *model.User, recipe *model.Recipe) bool {
+	if isEditor(user) {
+		return true
+	}
+	return user != nil && recipe.AuthorID == user.ID.Hex() && !isPublished(recipe)
+}
+
+func (a *App) invalidateRecipeListsCache() {
+	a.rdb.Del("recipes")
+
+	if err := a.deleteKeysMatching("recipes:*"); err != nil {
+		log.Println("erro limpando cache de receitas:", err)
+	}
+}
+
+// deleteKeysMatching removes every Redis key matching pattern. It scans
+// instead of using KEYS so large databases are not blocked.
+func (a *App) deleteKeysMatching(pattern string) error {
+	var cursor uint64
+	for {
+		keys, next, err := a.rdb.Scan(cursor, pattern, 100).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			a.rdb.Del(keys...)
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func (a *App) ownSubmission(c *gin.Context) (*model.Recipe, bool) {
+	recipe, err := a.d.GetRecipeByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if recipe == nil || recipe.AuthorID != currentUser(c).ID.Hex() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receita não encontrada."})
+		return nil, false
+	}
+	return recipe, true
+}
+
+func (a *App) CreateSubmission(c *gin.Context) {
+	var recipe model.Recipe
+	if err := c.ShouldBindJSON(&recipe); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := currentUser(c)
+	recipe.AuthorID = user.ID.Hex()
+	recipe.AuthorName = user.Name
+	// Images are only set through UploadRecipeImage, and only the AI
+	// generation marks its recipes as generated.
+	recipe.Image = ""
+	recipe.Images = nil
+	recipe.Percentage = 0
+	recipe.Generated = false
+	recipe.PromptVersion = ""
+
+	if err := a.d.CreateSubmission(&recipe); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	a.audit(c, model.AuditCreate, model.EntityRecipe, recipe.ID.Hex(), nil, recipe)
+
+	c.JSON(http.StatusCreated, recipe)
+}
+
+func (a *App) GetSubmissions(c *gin.Context) {
+	recipes, err := a.d.GetRecipesByAuthor(currentUser(c).ID.Hex())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, recipes)
+}
+
+func (a *App) GetSubmission(c *gin.Context) {
+	recipe, ok := a.ownSubmission(c)
+	if !ok {
+		return
+	}
+
+	c.Header("ETag", recipeETag(recipe.Version))
+	c.JSON(http.StatusOK, recipe)
+}
+
+func (a *App) UpdateSubmission(c *gin.Context) {
+	before, ok := a.ownSubmission(c)
+	if !ok {
+		return
+	}
+
+	var recipe model.Recipe
+	if err := c.ShouldBindJSON(&recipe); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recipe.AuthorID = currentUser(c).ID.Hex()
+
+	expectedVersion, ok := ifMatchVersion(c, false)
+	if !ok {
+		return
+	}
+
+	if err := a.d.UpdateSubmission(c.Param("id"), &recipe, expectedVersion); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.rdb.Del("recipe:" + c.Param("id"))
+	a.auditRecipe(c, model.AuditUpdate, c.Param("id"), before)
+
+	a.respondWithRecipe(c, c.Param("id"))
+}
+
+func (a *App) SubmitRecipe(c *gin.Context) {
+	before, ok := a.ownSubmission(c)
+	if !ok {
+		return
+	}
+
+	if err := a.d.SubmitRecipe(c.Param("id")); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.rdb.Del("recipe:" + c.Param("id"))
+	a.auditRecipe(c, model.AuditSubmit, c.Param("id"), before)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Receita enviada para revisão."})
+}
+
+func (a *App) DeleteSubmission(c *gin.Context) {
+	recipe, ok := a.ownSubmission(c)
+	if !ok {
+		return
+	}
+	if isPublished(recipe) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Receitas publicadas só podem ser removidas por editores."})
+		return
+	}
+
+	if err := a.d.DeleteRecipe(c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	a.rdb.Del("recipe:" + c.Param("id"))
+	a.deleteRecipeMedia(recipe)
+	a.audit(c, model.AuditDelete, model.EntityRecipe, c.Param("id"), recipe, nil)
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+func (a *App) GetReviewQueue(c *gin.Context) {
+	recipes, err := a.d.GetRecipesByStatus(model.RecipePending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, recipes)
+}
+
+func (a *App) ApproveRecipe(c *gin.Context) {
+	var review struct {
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&review); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	a.review(c, true, strings.TrimSpace(review.Comment))
+}
+
+func (a *App) RejectRecipe(c *gin.Context) {
+	var review struct {
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&review); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	comment := strings.TrimSpace(review.Comment)
+	if comment == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Informe o motivo da rejeição."})
+		return
+	}
+
+	a.review(c, false, comment)
+}
+
+func (a *App) review(c *gin.Context, approve bool, comment string) {
+	id := c.Param("id")
+
+	before, _ := a.d.GetRecipeByID(id)
+	if err := a.d.ReviewRecipe(id, approve, currentUser(c).ID.Hex(), comment); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.rdb.Del("recipe:" + id)
+	if approve {
+		a.invalidateRecipeListsCache()
+	}
+
+	recipe, err := a.d.GetRecipeByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	action := model.AuditReject
+	if approve {
+		action = model.AuditApprove
+	}
+	a.audit(c, action, model.EntityRecipe, id, before, recipe)
+
+	c.JSON(http.StatusOK, recipe)
+}
@@ -0,0 +1,104 @@
+package web
+
+import (
+	"context"
+	"cucinia/model"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCreateSubmissionIgnoresImagesAndCounters(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("ana@example.com", model.RoleUser)
+	other := primitive.NewObjectID().Hex()
+	stolen := mediaURL("recipes/" + other + "/0123456789abcdef/full.webp")
+
+	w := ta.do(http.MethodPost, "/api/v1/submissions", map[string]any{
+		"name":           "Bolo",
+		"image":          stolen,
+		"images":         map[string]string{"full": stolen},
+		"percentage":     99,
+		"premium":        true,
+		"generated":      true,
+		"prompt_version": "v9",
+	}, token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d: %s", w.Code, w.Body)
+	}
+	created := decode[model.Recipe](t, w)
+	stored := ta.db.recipes[created.ID.Hex()]
+	for _, recipe := range []*model.Recipe{&created, stored} {
+		if recipe.Image != "" || recipe.Images != nil || recipe.Percentage != 0 || recipe.Premium || recipe.Generated || recipe.PromptVersion != "" {
+			t.Errorf("submission kept client-set fields: %+v", recipe)
+		}
+	}
+}
+
+func TestRecipeAuditLeavesOutAuthorName(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("ana@example.com", model.RoleUser)
+
+	if w := ta.do(http.MethodPost, "/api/v1/submissions", map[string]any{"name": "Bolo"}, token); w.Code != http.StatusCreated {
+		t.Fatalf("create = %d: %s", w.Code, w.Body)
+	}
+	if len(ta.db.audit) != 1 {
+		t.Fatalf("%d audit entries, want 1", len(ta.db.audit))
+	}
+	after := ta.db.audit[0].After
+	if _, ok := after["author_name"]; ok || after["author_id"] == nil || after["name"] != "Bolo" {
+		t.Errorf("audit snapshot = %v, want the recipe without its author's name", after)
+	}
+}
+
+func TestDeleteSubmissionDeletesItsImages(t *testing.T) {
+	ta := newTestApp(t)
+	ana, token := ta.addUser("ana@example.com", model.RoleUser)
+	draft := &model.Recipe{ID: primitive.NewObjectID(), Name: "Rascunho", Status: model.RecipeDraft, AuthorID: ana.ID.Hex()}
+	own := "recipes/" + draft.ID.Hex() + "/0123456789abcdef/medium.jpg"
+	others := "recipes/" + primitive.NewObjectID().Hex() + "/0123456789abcdef/medium.jpg"
+	for _, key := range []string{own, others} {
+		if err := ta.store.Put(context.Background(), key, strings.NewReader("data"), 4, "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	draft.Images = map[string]string{"medium": mediaURL(own), "thumb": mediaURL(others)}
+	ta.db.recipes[draft.ID.Hex()] = draft
+
+	if w := ta.do(http.MethodDelete, "/api/v1/submissions/"+draft.ID.Hex(), nil, token); w.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d %s", w.Code, w.Body)
+	}
+	if ta.stored(own) {
+		t.Error("the submission's image was kept")
+	}
+	if !ta.stored(others) {
+		t.Error("another recipe's image was deleted")
+	}
+}
+
+func TestDeleteRecipeDeletesItsImages(t *testing.T) {
+	ta := newTestApp(t)
+	_, editorToken := ta.addUser("editor@example.com", model.RoleEditor)
+	recipe := &model.Recipe{ID: primitive.NewObjectID(), Name: "Bolo", Status: model.RecipePublished}
+	own := "recipes/" + recipe.ID.Hex() + "/0123456789abcdef/medium.jpg"
+	others := "recipes/" + primitive.NewObjectID().Hex() + "/0123456789abcdef/medium.jpg"
+	for _, key := range []string{own, others} {
+		if err := ta.store.Put(context.Background(), key, strings.NewReader("data"), 4, "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recipe.Images = map[string]string{"medium": mediaURL(own), "thumb": mediaURL(others)}
+	ta.db.recipes[recipe.ID.Hex()] = recipe
+
+	if w := ta.do(http.MethodDelete, "/api/v1/recipes/"+recipe.ID.Hex(), nil, editorToken); w.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d %s", w.Code, w.Body)
+	}
+	if ta.stored(own) {
+		t.Error("the recipe's image was kept")
+	}
+	if !ta.stored(others) {
+		t.Error("another recipe's image was deleted")
+	}
+}
@@ -0,0 +1,264 @@
+package web
+
+import (
+	"cucinia/db"
+	"cucinia/model"
+	"cucinia/oidc"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+const oidcStateDuration = 10 * time.Minute
+
+type oidcState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+func apiURL() string {
+	if u := os.Getenv("API_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return "http://localhost:8080"
+}
+
+// newOIDCProviders reads OIDC_PROVIDERS, a comma separated list of names,
+// and for each name the OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and
+// optional _SCOPES variables.
+func newOIDCProviders() map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		cfg := oidc.Config{
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  apiURL() + "/api/v1/auth/oidc/" + name + "/callback",
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" {
+			log.Println("provedor OIDC ignorado, faltam ISSUER ou CLIENT_ID:", name)
+			continue
+		}
+		providers[name] = oidc.New(cfg)
+	}
+	return providers
+}
+
+func oidcStateKey(state string) string {
+	return "oidc:state:" + state
+}
+
+func (a *App) GetOIDCProviders(c *gin.Context) {
+	names := make([]string, 0, len(a.oidc))
+	for name := range a.oidc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.JSON(http.StatusOK, names)
+}
+
+func (a *App) OIDCLogin(c *gin.Context) {
+	name := c.Param("provider")
+	provider, ok := a.oidc[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provedor de login não encontrado."})
+		return
+	}
+
+	state, err1 := oidc.NewState()
+	nonce, err2 := oidc.NewState()
+	verifier, err3 := oidc.NewState()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Println("erro na descoberta OIDC:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Provedor de login indisponível."})
+		return
+	}
+
+	data, _ := json.Marshal(oidcState{Provider: name, Nonce: nonce, Verifier: verifier})
+	if err := a.rdb.Set(oidcStateKey(state), data, oidcStateDuration).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback finishes the login and sends the browser back to the front
+// end with the session token (or a 2FA challenge) in the URL fragment,
+// which never reaches server logs.
+func (a *App) OIDCCallback(c *gin.Context) {
+	name := c.Param("provider")
+	provider, ok := a.oidc[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provedor de login não encontrado."})
+		return
+	}
+
+	fail := func(message string) {
+		c.Redirect(http.StatusFound, appURL()+"/login/oidc#"+url.Values{"error": {message}}.Encode())
+	}
+
+	if c.Query("error") != "" {
+		fail("Login cancelado.")
+		return
+	}
+
+	raw, err := consumeTokenScript.Run(a.rdb, []string{oidcStateKey(c.Query("state"))}).String()
+	var state oidcState
+	if err != nil || json.Unmarshal([]byte(raw), &state) != nil || state.Provider != name {
+		if err != nil && err != redis.Nil {
+			log.Println("erro lendo estado OIDC:", err)
+		}
+		fail("Login expirado, tente novamente.")
+		return
+	}
+
+	ctx := c.Request.Context()
+	token, err := provider.Exchange(ctx, c.Query("code"), state.Verifier)
+	if err != nil {
+		log.Println("erro trocando código OIDC:", err)
+		fail("Não foi possível concluir o login.")
+		return
+	}
+
+	claims, err := provider.Verify(ctx, token.IDToken, state.Nonce)
+	if err != nil {
+		log.Println("erro validando id_token:", err)
+		fail("Não foi possível concluir o login.")
+		return
+	}
+
+	user, err := a.oidcUser(c, name, claims)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+
+	fragment := url.Values{}
+	if user.TOTPEnabled {
+		challenge, err := a.createTwoFactorChallenge(user)
+		if err != nil {
+			fail("Falha ao criar a sessão.")
+			return
+		}
+		fragment.Set("challenge", challenge)
+	} else {
+		session, err := a.createSession(user)
+		if err != nil {
+			fail("Falha ao criar a sessão.")
+			return
+		}
+		fragment.Set("token", session)
+	}
+
+	c.Redirect(http.StatusFound, appURL()+"/login/oidc#"+fragment.Encode())
+}
+
+// claimUnverifiedAccount hands an account whose e-mail was never confirmed
+// over to the owner of the address, vouched for by the provider. Whoever
+// registered it may have been someone else, so the password, 2FA, sessions
+// and pending e-mail tokens they set up stop working.
+func (a *App) claimUnverifiedAccount(c *gin.Context, user *model.User) error {
+	userID := user.ID.Hex()
+	if err := a.d.SetUserPassword(userID, ""); err != nil {
+		return err
+	}
+	if err := a.d.DisableUserTOTP(userID); err != nil {
+		return err
+	}
+	if err := a.revokeSessions(userID, ""); err != nil {
+		return err
+	}
+	if err := a.revokeAccountTokens(userID); err != nil {
+		return err
+	}
+	return a.markEmailVerified(c, user)
+}
+
+// oidcUser finds the user linked to the external identity. An existing
+// account is only linked by e-mail when the provider vouches for it,
+// otherwise anyone could claim someone else's address.
+func (a *App) oidcUser(c *gin.Context, provider string, claims *oidc.Claims) (*model.User, error) {
+	identity := model.Identity{Provider: provider, Subject: claims.Subject}
+
+	user, err := a.d.GetUserByIdentity(provider, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return nil, errors.New("Não foi possível concluir o login.")
+	}
+
+	email, ok := normalizeEmail(claims.Email)
+	if !ok {
+		return nil, errors.New("O provedor não compartilhou seu e-mail.")
+	}
+	claims.Email = email
+	existing, err := a.d.GetUserByEmail(claims.Email)
+	if err == nil && existing != nil {
+		if !claims.EmailVerified {
+			return nil, errors.New("Já existe uma conta com este e-mail. Entre com sua senha.")
+		}
+		if !existing.EmailVerified {
+			if err := a.claimUnverifiedAccount(c, existing); err != nil {
+				log.Println("erro assumindo conta não verificada:", err)
+				return nil, errors.New("Não foi possível concluir o login.")
+			}
+		}
+		if err := a.d.LinkUserIdentity(existing.ID.Hex(), identity); err != nil {
+			return nil, errors.New("Não foi possível concluir o login.")
+		}
+		a.auditUserChange(c, model.AuditUpdate, existing)
+		a.invalidateUserCache(existing.ID.Hex())
+		a.invalidateUsersCache()
+		return a.d.GetUserByID(existing.ID.Hex())
+	}
+
+	// Accounts created here have no password; one can be set later through
+	// the password reset flow.
+	user = &model.User{Name: claims.Name, Email: claims.Email}
+	if err := a.d.CreateUser(user); err != nil {
+		return nil, errors.New("Não foi possível criar a conta.")
+	}
+	if err := a.d.LinkUserIdentity(user.ID.Hex(), identity); err != nil {
+		return nil, errors.New("Não foi possível concluir o login.")
+	}
+	if claims.EmailVerified {
+		if err := a.markEmailVerified(c, user); err != nil {
+			log.Println("erro confirmando e-mail:", err)
+		}
+	} else if err := a.sendVerificationEmail(c.Request.Context(), user); err != nil {
+		log.Println("erro enviando e-mail de verificação:", err)
+	}
+	a.invalidateUsersCache()
+
+	created, err := a.d.GetUserByID(user.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	a.audit(c, model.AuditCreate, model.EntityUser, user.ID.Hex(), nil, newAuditUser(created))
+	return created, nil
+}
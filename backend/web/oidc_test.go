@@ -0,0 +1,223 @@
+package web
+
+import (
+	"cucinia/model"
+	"cucinia/oidc/oidctest"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newOIDCTestApp(t *testing.T, user oidctest.User) (*testApp, *oidctest.Server) {
+	server := oidctest.New("cucinia", "segredo", user)
+	t.Cleanup(server.Close)
+	t.Setenv("OIDC_PROVIDERS", "mock")
+	t.Setenv("OIDC_MOCK_ISSUER", server.Issuer())
+	t.Setenv("OIDC_MOCK_CLIENT_ID", "cucinia")
+	t.Setenv("OIDC_MOCK_CLIENT_SECRET", "segredo")
+	return newTestApp(t), server
+}
+
+// oidcLogin signs in through the mock provider and returns the callback
+// URL and the fragment the front end receives.
+func (ta *testApp) oidcLogin() (string, url.Values) {
+	ta.t.Helper()
+	w := ta.do(http.MethodGet, "/api/v1/auth/oidc/mock/login", nil, "")
+	if w.Code != http.StatusFound {
+		ta.t.Fatalf("login = %d %s", w.Code, w.Body)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	res.Body.Close()
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || callback.Path != "/api/v1/auth/oidc/mock/callback" {
+		ta.t.Fatalf("provider redirected to %q", res.Header.Get("Location"))
+	}
+
+	return callback.RequestURI(), ta.oidcCallback(callback.RequestURI())
+}
+
+func (ta *testApp) oidcCallback(path string) url.Values {
+	ta.t.Helper()
+	w := ta.do(http.MethodGet, path, nil, "")
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusFound || !strings.HasPrefix(location, appURL()+"/login/oidc#") {
+		ta.t.Fatalf("callback = %d to %q", w.Code, location)
+	}
+	fragment, err := url.ParseQuery(strings.SplitN(location, "#", 2)[1])
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	return fragment
+}
+
+// sessionUser is the user a session token belongs to.
+func (ta *testApp) sessionUser(token string) *model.User {
+	ta.t.Helper()
+	w := ta.do(http.MethodGet, "/api/v1/users/me", nil, token)
+	if w.Code != http.StatusOK {
+		ta.t.Fatalf("session %q: %d %s", token, w.Code, w.Body)
+	}
+	user, err := ta.db.GetUserByEmail(decode[selfUser](ta.t, w).Email)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	return user
+}
+
+func TestOIDCCreatesAccount(t *testing.T) {
+	ta, _ := newOIDCTestApp(t, oidctest.User{Subject: "sub-ana", Email: "ana@example.com", EmailVerified: true, Name: "Ana"})
+
+	callback, fragment := ta.oidcLogin()
+	if fragment.Get("token") == "" {
+		t.Fatalf("fragment = %v, want a session", fragment)
+	}
+	user := ta.sessionUser(fragment.Get("token"))
+	if user.Email != "ana@example.com" || user.Name != "Ana" || !user.EmailVerified || user.Password != "" {
+		t.Errorf("created %+v", user)
+	}
+	if len(user.Identities) != 1 || user.Identities[0] != (model.Identity{Provider: "mock", Subject: "sub-ana"}) {
+		t.Errorf("identities = %v", user.Identities)
+	}
+
+	// The state is single use.
+	if fragment := ta.oidcCallback(callback); fragment.Get("error") == "" {
+		t.Errorf("replayed callback = %v, want an error", fragment)
+	}
+
+	// The next login finds the account by its identity.
+	_, fragment = ta.oidcLogin()
+	if again := ta.sessionUser(fragment.Get("token")); again.ID != user.ID {
+		t.Errorf("second login signed in %s, want %s", again.ID.Hex(), user.ID.Hex())
+	}
+	if users, _ := ta.db.GetAllUsers(); len(users) != 1 {
+		t.Errorf("%d users, want 1", len(users))
+	}
+}
+
+func TestOIDCLinksVerifiedEmail(t *testing.T) {
+	ta, server := newOIDCTestApp(t, oidctest.User{Subject: "sub-ana", Email: "ANA@example.com", EmailVerified: false})
+	existing, _ := ta.addUser("ana@example.com", model.RoleUser)
+
+	_, fragment := ta.oidcLogin()
+	if fragment.Get("error") == "" {
+		t.Fatalf("fragment = %v: an unverified e-mail took over an account", fragment)
+	}
+	if len(ta.db.user(existing.ID.Hex()).Identities) != 0 {
+		t.Fatal("identity linked from an unverified e-mail")
+	}
+
+	server.User.EmailVerified = true
+	_, fragment = ta.oidcLogin()
+	if user := ta.sessionUser(fragment.Get("token")); user.ID != existing.ID || len(user.Identities) != 1 {
+		t.Errorf("signed in %+v, want the existing account linked", user)
+	}
+}
+
+func TestOIDCRejectsBadTokens(t *testing.T) {
+	for name, override := range map[string]map[string]interface{}{
+		"wrong audience": {"aud": "someone-else"},
+		"expired":        {"exp": time.Now().Add(-time.Hour).Unix()},
+		"wrong nonce":    {"nonce": "not-ours"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ta, server := newOIDCTestApp(t, oidctest.User{Subject: "sub-ana", Email: "ana@example.com", EmailVerified: true})
+			server.Override = override
+
+			_, fragment := ta.oidcLogin()
+			if fragment.Get("error") == "" || fragment.Get("token") != "" {
+				t.Errorf("fragment = %v, want an error", fragment)
+			}
+			if users, _ := ta.db.GetAllUsers(); len(users) != 0 {
+				t.Errorf("account created from a rejected token")
+			}
+		})
+	}
+}
+
+func TestOIDCAsksForSecondFactor(t *testing.T) {
+	ta, _ := newOIDCTestApp(t, oidctest.User{Subject: "sub-edu", Email: "edu@example.com", EmailVerified: true})
+	ta.addUser("edu@example.com", model.RoleEditor)
+
+	_, fragment := ta.oidcLogin()
+	if fragment.Get("challenge") == "" || fragment.Get("token") != "" {
+		t.Errorf("fragment = %v, want a two-factor challenge", fragment)
+	}
+}
+
+func TestOIDCClaimsUnverifiedAccount(t *testing.T) {
+	ta, _ := newOIDCTestApp(t, oidctest.User{Subject: "sub-ana", Email: "ana@example.com", EmailVerified: true})
+
+	// Someone else registered the address first and set everything up.
+	squatter := ta.register("ana@example.com", "")
+	squatterID := squatter.ID.Hex()
+	w := ta.do(http.MethodPost, "/api/v1/login", map[string]string{"email": "ana@example.com", "password": "uma senha bem longa 42"}, "")
+	token := decode[struct {
+		Token string `json:"token"`
+	}](t, w).Token
+	if token == "" {
+		t.Fatalf("login = %d %s", w.Code, w.Body)
+	}
+	resetToken, err := ta.issueAccountToken(tokenResetPassword, squatterID, resetPasswordDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta.db.updateUser(squatterID, func(user *model.User) {
+		user.TOTPEnabled = true
+		user.TOTPSecret = "JBSWY3DPEHPK3PXP"
+	})
+
+	_, fragment := ta.oidcLogin()
+	owner := ta.sessionUser(fragment.Get("token"))
+	if owner.ID != squatter.ID || !owner.EmailVerified || owner.TOTPEnabled || owner.Password != "" {
+		t.Errorf("claimed account = %+v, want verified without password or 2FA", owner)
+	}
+
+	if w := ta.do(http.MethodGet, "/api/v1/users/me", nil, token); w.Code != http.StatusUnauthorized {
+		t.Errorf("old session = %d, want 401", w.Code)
+	}
+	w = ta.do(http.MethodPost, "/api/v1/login", map[string]string{"email": "ana@example.com", "password": "uma senha bem longa 42"}, "")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("old password login = %d, want 401", w.Code)
+	}
+	w = ta.do(http.MethodPost, "/api/v1/password/reset", map[string]string{"token": resetToken, "password": "outra senha bem longa"}, "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("old reset link = %d, want 400", w.Code)
+	}
+}
+
+func TestOIDCClaimPaysReferral(t *testing.T) {
+	ta, _ := newOIDCTestApp(t, oidctest.User{Subject: "sub-bia", Email: "bia@example.com", EmailVerified: true})
+	referrer, _ := ta.addUser("ana@example.com", model.RoleUser)
+	ta.db.updateUser(referrer.ID.Hex(), func(user *model.User) { user.ReferralCode = "ANA2024" })
+	invitee := ta.register("bia@example.com", "ANA2024")
+
+	ta.oidcLogin()
+	if !ta.db.user(invitee.ID.Hex()).EmailVerified {
+		t.Fatal("e-mail not confirmed by the provider")
+	}
+	if len(ta.db.referrals) != 1 || !ta.db.user(referrer.ID.Hex()).Premium {
+		t.Errorf("referral not rewarded (%d referrals)", len(ta.db.referrals))
+	}
+}
+
+func TestOIDCRejectsMalformedEmail(t *testing.T) {
+	for _, email := range []string{"", "Ana <ana@example.com>", "ana@example.com, bia@example.com", "não é e-mail"} {
+		ta, _ := newOIDCTestApp(t, oidctest.User{Subject: "sub-ana", Email: email, EmailVerified: true})
+		existing, _ := ta.addUser("ana@example.com", model.RoleUser)
+
+		_, fragment := ta.oidcLogin()
+		if fragment.Get("error") == "" || fragment.Get("token") != "" {
+			t.Errorf("%q: fragment = %v, want an error", email, fragment)
+		}
+		if users, _ := ta.db.GetAllUsers(); len(users) != 1 || len(ta.db.user(existing.ID.Hex()).Identities) != 0 {
+			t.Errorf("%q: account created or linked", email)
+		}
+	}
+}
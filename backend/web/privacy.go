@@ -0,0 +1,348 @@
+package web
+
+import (
+	"context"
+	"cucinia/db"
+	"cucinia/model"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultErasureGracePeriod = 30 * 24 * time.Hour
+	erasureCheckInterval      = time.Hour
+)
+
+// newErasureGracePeriod reads how long a deletion request waits before the
+// account is erased from ERASURE_GRACE_PERIOD (a Go duration, e.g. "720h").
+func newErasureGracePeriod() time.Duration {
+	value := os.Getenv("ERASURE_GRACE_PERIOD")
+	if value == "" {
+		return defaultErasureGracePeriod
+	}
+
+	grace, err := time.ParseDuration(value)
+	if err != nil || grace < 0 {
+		log.Println("ERASURE_GRACE_PERIOD inválido, usando o padrão:", value)
+		return defaultErasureGracePeriod
+	}
+	return grace
+}
+
+type likeExport struct {
+	RecipeID string `json:"recipe_id"`
+	Name     string `json:"name,omitempty"`
+}
+
+type moderationExport struct {
+	RecipeID   string     `json:"recipe_id"`
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+}
+
+// userExport is the archive a user downloads to exercise the LGPD right of
+// access: everything the API keeps about them.
+type userExport struct {
+	GeneratedAt   time.Time                 `json:"generated_at"`
+	Profile       selfUser                  `json:"profile"`
+	Pantry        []string                  `json:"pantry"`
+	Likes         []likeExport              `json:"likes"`
+	Recipes       []*model.Recipe           `json:"recipes"`
+	Revisions     []*model.RecipeRevision   `json:"revisions"`
+	Moderation    []moderationExport        `json:"moderation"`
+	Subscriptions []*model.Subscription     `json:"subscriptions"`
+	Coupons       []*model.CouponRedemption `json:"coupons"`
+	Referrals     []*model.Referral         `json:"referrals"`
+	Erasures      []*model.Erasure          `json:"erasures"`
+
+	// Kept in Redis for a while: conversations with the assistant, fridge
+	// scans and generated recipes not saved yet.
+	Assistant        []assistantExport       `json:"assistant_conversations"`
+	Scans            []scanExport            `json:"fridge_scans"`
+	GeneratedRecipes []generatedRecipeExport `json:"generated_recipes"`
+}
+
+func (a *App) ExportMe(c *gin.Context) {
+	user := currentUser(c)
+	userID := user.ID.Hex()
+	profile := newSelfUser(user)
+
+	export := userExport{
+		GeneratedAt: time.Now().UTC(),
+		Profile:     profile,
+		Pantry:      profile.Ingredients,
+		Likes:       []likeExport{},
+		Moderation:  []moderationExport{},
+	}
+
+	for _, recipeID := range user.LikedRecipes {
+		like := likeExport{RecipeID: recipeID}
+		if recipe, err := a.d.GetRecipeByID(recipeID); err == nil && recipe != nil {
+			like.Name = recipe.Name
+		}
+		export.Likes = append(export.Likes, like)
+	}
+
+	var err error
+	if export.Recipes, err = a.d.GetRecipesByAuthor(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if export.Revisions, err = a.d.GetRevisionsByAuthor(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if export.Subscriptions, err = a.d.GetUserSubscriptions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if export.Coupons, err = a.d.GetUserRedemptions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if export.Referrals, err = a.d.GetUserReferrals(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if export.Erasures, err = a.d.GetUserErasures(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if export.Assistant, err = a.exportAssistantSessions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if export.Scans, err = a.gen.export(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if export.GeneratedRecipes, err = a.exportGeneratedRecipes(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	reviewed, err := a.d.GetRecipesByReviewer(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, recipe := range reviewed {
+		export.Moderation = append(export.Moderation, moderationExport{
+			RecipeID:   recipe.ID.Hex(),
+			Name:       recipe.Name,
+			Status:     recipe.Status,
+			ReviewedAt: recipe.ReviewedAt,
+			Comment:    recipe.ReviewComment,
+		})
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="cucinia-dados-`+userID+`.json"`)
+	c.Header("Cache-Control", "no-store")
+	c.IndentedJSON(http.StatusOK, export)
+}
+
+// RequestErasure schedules the deletion of the caller's account after the
+// grace period. Other sessions are ended; the current one stays so the
+// request can still be cancelled.
+func (a *App) RequestErasure(c *gin.Context) {
+	user := currentUser(c)
+
+	var payload struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+	if user.Password != "" && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Senha incorreta."})
+		return
+	}
+
+	userID := user.ID.Hex()
+	erasure, err := a.d.ScheduleUserErasure(userID, userID, time.Now().UTC().Add(a.erasureGrace))
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	a.invalidateUserCache(userID)
+	a.invalidateUsersCache()
+
+	if err := a.revokeSessions(userID, bearerToken(c)); err != nil {
+		log.Println("erro encerrando sessões:", err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "A exclusão da conta foi agendada.", "erasure": erasure})
+}
+
+func (a *App) CancelErasure(c *gin.Context) {
+	userID := currentUser(c).ID.Hex()
+
+	if err := a.d.CancelUserErasure(userID); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	a.invalidateUserCache(userID)
+	a.invalidateUsersCache()
+
+	c.JSON(http.StatusOK, gin.H{"message": "A exclusão da conta foi cancelada."})
+}
+
+// DeleteUser erases an account right away, skipping the grace period. Only
+// admins reach it; users go through RequestErasure.
+func (a *App) DeleteUser(c *gin.Context) {
+	id := c.Param("id")
+
+	_, err := a.d.ScheduleUserErasure(id, currentUser(c).ID.Hex(), time.Now().UTC())
+	if err != nil && !errors.Is(err, db.ErrErasureScheduled) {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	erasure, err := a.eraseUser(c, id)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": "Falha ao apagar o usuário."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Usuário apagado com sucesso.", "erasure": erasure})
+}
+
+func (a *App) erasureLoop() {
+	ticker := time.NewTicker(erasureCheckInterval)
+	defer ticker.Stop()
+	for {
+		a.runDueErasures()
+		<-ticker.C
+	}
+}
+
+func (a *App) runDueErasures() {
+	erasures, err := a.d.GetDueErasures(time.Now().UTC())
+	if err != nil {
+		log.Println("erro buscando exclusões agendadas:", err)
+		return
+	}
+
+	for _, erasure := range erasures {
+		if _, err := a.eraseUser(nil, erasure.UserID); err != nil {
+			log.Println("erro excluindo conta", erasure.UserID+":", err)
+		}
+	}
+}
+
+// eraseUser cascades the deletion of an account through Mongo, Redis and
+// the media storage. c is nil when the erasure job runs it.
+func (a *App) eraseUser(c *gin.Context, userID string) (*model.Erasure, error) {
+	// The user is gone already when a previous attempt failed after deleting
+	// it; the cleanup below still runs.
+	user, err := a.d.GetUserByID(userID)
+	if err != nil {
+		user = nil
+	}
+
+	recipes, err := a.d.GetRecipesByAuthor(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nobody is charged for an account that no longer exists. Past
+	// subscriptions are kept as billing records.
+	if subscription, err := a.d.GetCurrentSubscription(userID); err == nil {
+		if subscription.ExternalID != "" {
+			if err := a.billing.Cancel(context.Background(), subscription.ExternalID); err != nil {
+				return nil, err
+			}
+		}
+		a.d.EndSubscription(subscription.ID.Hex(), model.SubscriptionCanceled)
+	}
+
+	erasure, err := a.d.EraseUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := newAuditEntry(c, model.AuditDelete, model.EntityUser, userID, newAuditUser(user), nil)
+	if c == nil {
+		entry.ActorID = erasure.RequestedBy
+	}
+	a.recordAudit(entry)
+
+	email := ""
+	if user != nil {
+		email = user.Email
+	}
+	a.forgetUser(userID, email)
+
+	for _, recipe := range recipes {
+		recipeID := recipe.ID.Hex()
+		a.rdb.Del("recipe:" + recipeID)
+		if !isPublished(recipe) {
+			a.stats.forget(recipeID)
+			a.deleteRecipeMedia(recipe)
+		}
+	}
+	a.invalidateRecipeListsCache()
+
+	log.Println("conta excluída:", userID, erasure.Removed)
+	return erasure, nil
+}
+
+// forgetUser drops every Redis key that refers to the user: sessions,
+// caches, pending tokens, 2FA state, login lockouts, rate limit windows,
+// assistant conversations and AI generations.
+func (a *App) forgetUser(userID, email string) {
+	if err := a.revokeSessions(userID, ""); err != nil {
+		log.Println("erro encerrando sessões:", err)
+	}
+
+	if err := a.revokeAccountTokens(userID); err != nil {
+		log.Println("erro removendo tokens da conta:", err)
+	}
+	a.rdb.Del(userSessionsKey(userID))
+	if email != "" {
+		a.clearAllLoginFailures(email)
+		a.rdb.Del(loginKnownIPsKey(email))
+	}
+
+	for _, pattern := range []string{"2fa:used:" + userID + ":*", "ratelimit:*:user:" + userID, "usage:*:" + userID + "*", "stats:seen:*:user:" + userID + ":*"} {
+		if err := a.deleteKeysMatching(pattern); err != nil {
+			log.Println("erro limpando chaves do usuário:", err)
+		}
+	}
+	for _, forget := range []func(string) error{a.forgetAssistantSessions, a.gen.forget, a.forgetGeneratedRecipes} {
+		if err := forget(userID); err != nil {
+			log.Println("erro limpando dados de IA do usuário:", err)
+		}
+	}
+
+	a.invalidateUserCache(userID)
+	a.invalidateUsersCache()
+}
+
+// deleteIndexed deletes the keys of every ID in the set index, and the
+// index itself.
+func deleteIndexed(rdb *redis.Client, index string, keys func(id string) []string) error {
+	ids, err := rdb.SMembers(index).Result()
+	if err != nil {
+		return err
+	}
+	toDelete := []string{index}
+	for _, id := range ids {
+		toDelete = append(toDelete, keys(id)...)
+	}
+	return rdb.Del(toDelete...).Err()
+}
+
+func (a *App) deleteRecipeMedia(recipe *model.Recipe) {
+	a.deleteReplacedMedia(recipe, nil)
+}
@@ -0,0 +1,169 @@
+package web
+
+import (
+	"context"
+	"cucinia/ai"
+	"cucinia/imaging"
+	"cucinia/model"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// addAIData gives user a conversation with the assistant, a queued and a
+// finished fridge scan and an unsaved generated recipe.
+func (ta *testApp) addAIData(user *model.User) {
+	ta.t.Helper()
+	userID := user.ID.Hex()
+	session := &assistantSession{UserID: userID, RecipeID: "r1", Messages: []assistantMessage{{Role: ai.ChatUser, Text: "Posso usar margarina?"}}}
+	err := ta.saveAssistantSession("session-"+userID, session)
+	if err == nil {
+		upload := &scanUpload{images: []*imaging.Scan{{ContentType: "image/jpeg", Data: []byte("foto da geladeira")}}}
+		_, err = ta.gen.enqueue(userID, upload, "")
+	}
+	if err == nil {
+		_, err = ta.gen.complete(userID, `{"ingredients":["leite"]}`, "exact")
+	}
+	if err == nil {
+		_, err = ta.storeGeneratedRecipe(userID, &ai.GeneratedRecipe{Name: "Bolo"}, "v1")
+	}
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+}
+
+func TestErasureForgetsAIData(t *testing.T) {
+	ta := newTestApp(t)
+	_, adminToken := ta.addUser("admin@example.com", model.RoleAdmin)
+	ana, _ := ta.addUser("ana@example.com", model.RoleUser)
+	bia, _ := ta.addUser("bia@example.com", model.RoleUser)
+	ta.addAIData(ana)
+	ta.addAIData(bia)
+
+	// Every key that holds or indexes data of a user: named after them,
+	// holding their ID, or a job of theirs.
+	keysOf := func(user *model.User) []string {
+		userID := user.ID.Hex()
+		var keys []string
+		for _, key := range ta.redis.Keys() {
+			value := ""
+			switch ta.redis.Type(key) {
+			case "string":
+				value, _ = ta.redis.Get(key)
+			case "hash":
+				value = ta.redis.HGet(key, "user_id")
+			case "list":
+				value = ta.redis.HGet(strings.TrimSuffix(key, ":images"), "user_id")
+			}
+			if strings.Contains(key, userID) || strings.Contains(value, userID) {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+	anaKeys := keysOf(ana)
+	if len(anaKeys) < 8 {
+		t.Fatalf("only %v stored for the user", anaKeys)
+	}
+	biaKeys := keysOf(bia)
+
+	if w := ta.do(http.MethodDelete, "/api/v1/users/"+ana.ID.Hex(), nil, adminToken); w.Code != http.StatusOK {
+		t.Fatalf("erase = %d %s", w.Code, w.Body)
+	}
+	for _, key := range anaKeys {
+		if ta.redis.Exists(key) {
+			t.Errorf("%s left behind", key)
+		}
+	}
+	for _, key := range biaKeys {
+		if !ta.redis.Exists(key) {
+			t.Errorf("%s of another user was erased", key)
+		}
+	}
+}
+
+// stored tells whether the storage still holds key.
+func (ta *testApp) stored(key string) bool {
+	body, _, err := ta.store.Get(context.Background(), key)
+	if err == nil {
+		body.Close()
+	}
+	return err == nil
+}
+
+func TestErasureOnlyDeletesTheRecipesOwnImages(t *testing.T) {
+	ta := newTestApp(t)
+	_, adminToken := ta.addUser("admin@example.com", model.RoleAdmin)
+	ana, _ := ta.addUser("ana@example.com", model.RoleUser)
+
+	draft := &model.Recipe{ID: primitive.NewObjectID(), Name: "Rascunho", Status: model.RecipeDraft, AuthorID: ana.ID.Hex()}
+	published := primitive.NewObjectID().Hex()
+	own := "recipes/" + draft.ID.Hex() + "/0123456789abcdef/full.webp"
+	others := "recipes/" + published + "/0123456789abcdef/full.webp"
+	outside := "exports/" + published + ".json"
+	for _, key := range []string{own, others, outside} {
+		if err := ta.store.Put(context.Background(), key, strings.NewReader("data"), 4, "image/webp"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	draft.Images = map[string]string{"full": mediaURL(own), "medium": mediaURL(others), "original": mediaURL(outside)}
+	ta.db.recipes[draft.ID.Hex()] = draft
+
+	if w := ta.do(http.MethodDelete, "/api/v1/users/"+ana.ID.Hex(), nil, adminToken); w.Code != http.StatusOK {
+		t.Fatalf("erase = %d %s", w.Code, w.Body)
+	}
+	if ta.stored(own) {
+		t.Error("the draft's own image was kept")
+	}
+	for _, key := range []string{others, outside} {
+		if !ta.stored(key) {
+			t.Errorf("%s, not the draft's, was deleted", key)
+		}
+	}
+}
+
+func TestExportIncludesAIData(t *testing.T) {
+	ta := newTestApp(t)
+	ana, token := ta.addUser("ana@example.com", model.RoleUser)
+	bia, _ := ta.addUser("bia@example.com", model.RoleUser)
+	ta.addAIData(ana)
+	ta.addAIData(bia)
+
+	w := ta.do(http.MethodGet, "/api/v1/users/me/export", nil, token)
+	export := decode[struct {
+		Assistant []struct {
+			ID       string             `json:"id"`
+			UserID   string             `json:"user_id"`
+			Messages []assistantMessage `json:"messages"`
+		} `json:"assistant_conversations"`
+		Scans []struct {
+			Status string            `json:"status"`
+			Photos []scanPhotoExport `json:"photos"`
+		} `json:"fridge_scans"`
+		GeneratedRecipes []struct {
+			UserID string             `json:"user_id"`
+			Recipe ai.GeneratedRecipe `json:"recipe"`
+		} `json:"generated_recipes"`
+	}](t, w)
+
+	if len(export.Assistant) != 1 || export.Assistant[0].UserID != ana.ID.Hex() || export.Assistant[0].Messages[0].Text != "Posso usar margarina?" {
+		t.Errorf("assistant_conversations = %+v", export.Assistant)
+	}
+	photos := 0
+	for _, scan := range export.Scans {
+		for _, photo := range scan.Photos {
+			if string(photo.Data) != "foto da geladeira" {
+				t.Errorf("photo = %q", photo.Data)
+			}
+			photos++
+		}
+	}
+	if len(export.Scans) != 2 || photos != 1 {
+		t.Errorf("fridge_scans = %+v, want the queued and the finished scan", export.Scans)
+	}
+	if len(export.GeneratedRecipes) != 1 || export.GeneratedRecipes[0].UserID != ana.ID.Hex() || export.GeneratedRecipes[0].Recipe.Name != "Bolo" {
+		t.Errorf("generated_recipes = %+v", export.GeneratedRecipes)
+	}
+}
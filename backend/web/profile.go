@@ -0,0 +1,205 @@
+package web
+
+import (
+	"cucinia/db"
+	"cucinia/model"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func emailChangeKey(userID string) string {
+	return "email-change:" + userID
+}
+
+func (a *App) GetMe(c *gin.Context) {
+	c.JSON(http.StatusOK, newSelfUser(currentUser(c)))
+}
+
+func (a *App) UpdateMe(c *gin.Context) {
+	user := currentUser(c)
+
+	var payload struct {
+		Name        *string   `json:"name"`
+		Restriction *[]string `json:"restriction"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	userID := user.ID.Hex()
+	err := a.d.UpdateUserProfile(userID, db.UserProfile{Name: payload.Name, Restriction: payload.Restriction})
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	a.auditUserChange(c, model.AuditUpdate, user)
+	a.invalidateUserCache(userID)
+	a.invalidateUsersCache()
+
+	updated, err := a.d.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, newSelfUser(updated))
+}
+
+func (a *App) ChangePassword(c *gin.Context) {
+	user := currentUser(c)
+
+	var payload struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.CurrentPassword)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Senha atual incorreta."})
+		return
+	}
+	if err := validatePassword(payload.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(payload.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao fazer o hashing."})
+		return
+	}
+
+	if err := a.d.SetUserPassword(user.ID.Hex(), string(hashedPassword)); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	a.auditUserChange(c, model.AuditUpdate, user)
+	a.invalidateUserCache(user.ID.Hex())
+	a.invalidateUsersCache()
+
+	// Other devices are signed out; the one that changed the password stays.
+	if err := a.revokeSessions(user.ID.Hex(), bearerToken(c)); err != nil {
+		log.Println("erro encerrando sessões:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Senha alterada."})
+}
+
+// RequestEmailChange sends a confirmation link to the new address. The
+// account keeps the old e-mail until the link is used.
+func (a *App) RequestEmailChange(c *gin.Context) {
+	user := currentUser(c)
+
+	var payload struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	newEmail, ok := normalizeEmail(payload.Email)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "E-mail inválido."})
+		return
+	}
+	if newEmail == user.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Este já é o seu e-mail."})
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Senha incorreta."})
+		return
+	}
+
+	if existing, err := a.d.GetUserByEmail(newEmail); err == nil && existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": db.ErrEmailInUse.Error()})
+		return
+	}
+
+	if err := a.rdb.Set(emailChangeKey(user.ID.Hex()), newEmail, verifyEmailDuration).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.sendEmailChangeEmail(c.Request.Context(), user, newEmail); err != nil {
+		log.Println("erro enviando e-mail de troca de endereço:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao enviar o e-mail."})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Enviamos um link de confirmação para o novo e-mail."})
+}
+
+func (a *App) ConfirmEmailChange(c *gin.Context) {
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	userID, err := a.consumeAccountToken(tokenChangeEmail, payload.Token)
+	if err == errInvalidToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	newEmail, err := a.rdb.Get(emailChangeKey(userID)).Result()
+	if err == redis.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidToken.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := a.d.GetUserByID(userID)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.d.ChangeUserEmail(userID, newEmail); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	// The link went to the new address, which confirms it.
+	if !before.EmailVerified {
+		if err := a.markEmailVerified(c, before); err != nil {
+			log.Println("erro confirmando e-mail:", err)
+		}
+	}
+	a.auditUserChange(c, model.AuditUpdate, before)
+	a.invalidateUserCache(userID)
+	a.invalidateUsersCache()
+
+	// Links sent to the old address and its login lockouts no longer apply;
+	// the IPs the account logged in from still do.
+	if err := a.revokeAccountTokens(userID); err != nil {
+		log.Println("erro revogando links da conta:", err)
+	}
+	a.clearAllLoginFailures(before.Email)
+	a.rdb.Rename(loginKnownIPsKey(before.Email), loginKnownIPsKey(newEmail))
+
+	if err := a.sendEmailChangedNotice(c.Request.Context(), before, newEmail); err != nil {
+		log.Println("erro avisando o e-mail anterior:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "E-mail alterado.", "email": newEmail})
+}
@@ -0,0 +1,131 @@
+package web
+
+import (
+	"cucinia/db"
+	"cucinia/model"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// withPassword sets the password of user.
+func (ta *testApp) withPassword(user *model.User, password string) {
+	ta.t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	ta.db.SetUserPassword(user.ID.Hex(), string(hash))
+}
+
+// mailedToken returns the token of the link last mailed to to.
+func (ta *testApp) mailedToken(to string) string {
+	ta.t.Helper()
+	msg, ok := ta.mails.last(to)
+	if !ok {
+		ta.t.Fatalf("no e-mail sent to %s", to)
+	}
+	for _, field := range strings.Fields(msg.Text) {
+		if u, err := url.Parse(field); err == nil && u.Query().Get("token") != "" {
+			return u.Query().Get("token")
+		}
+	}
+	ta.t.Fatalf("no link in the e-mail to %s: %s", to, msg.Text)
+	return ""
+}
+
+func TestChangePassword(t *testing.T) {
+	ta := newTestApp(t)
+	user, token := ta.addUser("ana@example.com", model.RoleUser)
+	ta.withPassword(user, "senha-antiga-123")
+	otherToken, err := ta.createSession(ta.db.user(user.ID.Hex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := ta.do(http.MethodPost, "/api/v1/users/me/password", map[string]string{"current_password": "errada", "new_password": "Nova-senha-123"}, token)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong current password = %d, want 401", w.Code)
+	}
+
+	w = ta.do(http.MethodPost, "/api/v1/users/me/password", map[string]string{"current_password": "senha-antiga-123", "new_password": "Nova-senha-123"}, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("change password = %d %s", w.Code, w.Body)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(ta.db.user(user.ID.Hex()).Password), []byte("Nova-senha-123")) != nil {
+		t.Error("new password was not stored")
+	}
+	if w := ta.do(http.MethodGet, "/api/v1/users/me", nil, token); w.Code != http.StatusOK {
+		t.Errorf("session that changed the password = %d, want 200", w.Code)
+	}
+	if w := ta.do(http.MethodGet, "/api/v1/users/me", nil, otherToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("other session = %d, want 401", w.Code)
+	}
+}
+
+func TestEmailChangeWaitsForConfirmation(t *testing.T) {
+	ta := newTestApp(t)
+	user, token := ta.addUser("ana@example.com", model.RoleUser)
+	ta.withPassword(user, "senha-123-abc")
+
+	w := ta.do(http.MethodPost, "/api/v1/users/me/email", map[string]string{"email": "Ana.Nova@example.com", "password": "senha-123-abc"}, token)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("request change = %d %s", w.Code, w.Body)
+	}
+	if email := ta.db.user(user.ID.Hex()).Email; email != "ana@example.com" {
+		t.Fatalf("e-mail before confirmation = %q, want the old one", email)
+	}
+	if _, err := ta.db.GetUserByEmail("ana@example.com"); err != nil {
+		t.Errorf("old e-mail lookup before confirmation: %v", err)
+	}
+
+	// A reset link mailed to the old address stops working with the change.
+	resetToken, err := ta.issueAccountToken(tokenResetPassword, user.ID.Hex(), resetPasswordDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	confirmToken := ta.mailedToken("ana.nova@example.com")
+	if w := ta.do(http.MethodPost, "/api/v1/email-change/confirm", map[string]string{"token": confirmToken}, ""); w.Code != http.StatusOK {
+		t.Fatalf("confirm = %d %s", w.Code, w.Body)
+	}
+	if msg, ok := ta.mails.last("ana@example.com"); !ok || !strings.Contains(msg.Text, "ana.nova@example.com") {
+		t.Errorf("old address was not told about the change: %+v", msg)
+	}
+	if w := ta.do(http.MethodPost, "/api/v1/password/reset", map[string]string{"token": resetToken, "password": "Nova-senha-123"}, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("reset link of the old address = %d, want 400", w.Code)
+	}
+	if got := ta.db.user(user.ID.Hex()); got.Email != "ana.nova@example.com" || !got.EmailVerified {
+		t.Errorf("after confirmation: e-mail %q, verified %v", got.Email, got.EmailVerified)
+	}
+	if w := ta.do(http.MethodPost, "/api/v1/email-change/confirm", map[string]string{"token": confirmToken}, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("second use of the link = %d, want 400", w.Code)
+	}
+}
+
+func TestEmailChangeToTakenAddressOnConfirm(t *testing.T) {
+	ta := newTestApp(t)
+	user, token := ta.addUser("ana@example.com", model.RoleUser)
+	ta.withPassword(user, "senha-123-abc")
+
+	w := ta.do(http.MethodPost, "/api/v1/users/me/email", map[string]string{"email": "bia@example.com", "password": "senha-123-abc"}, token)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("request change = %d %s", w.Code, w.Body)
+	}
+	// Someone else signs up with the address before the link is used.
+	ta.addUser("bia@example.com", model.RoleUser)
+
+	w = ta.do(http.MethodPost, "/api/v1/email-change/confirm", map[string]string{"token": ta.mailedToken("bia@example.com")}, "")
+	if w.Code != http.StatusConflict || decode[map[string]string](t, w)["error"] != db.ErrEmailInUse.Error() {
+		t.Fatalf("confirm to a taken address = %d %s, want 409", w.Code, w.Body)
+	}
+	if email := ta.db.user(user.ID.Hex()).Email; email != "ana@example.com" {
+		t.Errorf("e-mail after the conflict = %q, want the old one", email)
+	}
+	if other, _ := ta.db.GetUserByEmail("bia@example.com"); other == nil || other.ID == user.ID {
+		t.Error("the other account lost its e-mail")
+	}
+}
@@ -0,0 +1,347 @@
+package web
+
+import (
+	"crypto/rand"
+	"cucinia/billing"
+	"cucinia/db"
+	"cucinia/model"
+	"encoding/base32"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// referralPremiumDays is what both the invitee and the referrer get
+	// when someone who registered through a referral link confirms their
+	// e-mail.
+	referralPremiumDays = 14
+	// maxRewardedReferrals caps the premium days one user can collect from
+	// referrals; invitees past the cap still get theirs.
+	maxRewardedReferrals = 12
+)
+
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+type couponPayload struct {
+	Code           string     `json:"code"`
+	Description    string     `json:"description"`
+	PercentOff     int        `json:"percent_off"`
+	TrialDays      int        `json:"trial_days"`
+	Plans          []string   `json:"plans"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	MaxRedemptions int        `json:"max_redemptions"`
+}
+
+func (p couponPayload) validate() error {
+	switch {
+	case !couponCodePattern.MatchString(p.Code):
+		return errors.New("Código inválido: use de 3 a 32 letras, números, - ou _.")
+	case p.PercentOff < 0 || p.PercentOff > 100:
+		return errors.New("O desconto deve estar entre 0 e 100%.")
+	case p.TrialDays < 0 || p.TrialDays > 365:
+		return errors.New("Os dias de teste devem estar entre 0 e 365.")
+	case p.PercentOff == 0 && p.TrialDays == 0:
+		return errors.New("O cupom precisa dar desconto ou dias de teste.")
+	case p.MaxRedemptions < 0:
+		return errors.New("Limite de usos inválido.")
+	case p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now()):
+		return errors.New("A validade deve estar no futuro.")
+	}
+	for _, plan := range p.Plans {
+		if _, ok := billing.PlanByID(plan); !ok {
+			return errors.New("Plano inválido: " + plan)
+		}
+	}
+	return nil
+}
+
+func (a *App) GetCoupons(c *gin.Context) {
+	coupons, err := a.d.GetCoupons()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupons)
+}
+
+func (a *App) CreateCoupon(c *gin.Context) {
+	var payload couponPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+	payload.Code = normalizeCode(payload.Code)
+	if err := payload.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	coupon := &model.Coupon{
+		Code:           payload.Code,
+		Description:    strings.TrimSpace(payload.Description),
+		PercentOff:     payload.PercentOff,
+		TrialDays:      payload.TrialDays,
+		Plans:          payload.Plans,
+		ExpiresAt:      payload.ExpiresAt,
+		MaxRedemptions: payload.MaxRedemptions,
+		CreatedBy:      currentUser(c).ID.Hex(),
+	}
+	if err := a.d.CreateCoupon(coupon); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.audit(c, model.AuditCreate, model.EntityCoupon, coupon.ID.Hex(), nil, coupon)
+
+	c.JSON(http.StatusCreated, coupon)
+}
+
+// UpdateCoupon changes what can change once a coupon is out: its
+// description, validity, usage limit and whether it is active. Discounts
+// stay as they were announced.
+func (a *App) UpdateCoupon(c *gin.Context) {
+	id := c.Param("id")
+
+	var payload struct {
+		Description    *string    `json:"description"`
+		ExpiresAt      *time.Time `json:"expires_at"`
+		MaxRedemptions *int       `json:"max_redemptions"`
+		Active         *bool      `json:"active"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+	if payload.MaxRedemptions != nil && *payload.MaxRedemptions < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Limite de usos inválido."})
+		return
+	}
+
+	before, err := a.d.GetCouponByID(id)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	coupon, err := a.d.UpdateCoupon(id, db.CouponUpdate{
+		Description:    payload.Description,
+		ExpiresAt:      payload.ExpiresAt,
+		MaxRedemptions: payload.MaxRedemptions,
+		Active:         payload.Active,
+	})
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.audit(c, model.AuditUpdate, model.EntityCoupon, id, before, coupon)
+
+	c.JSON(http.StatusOK, coupon)
+}
+
+func (a *App) GetCouponRedemptions(c *gin.Context) {
+	redemptions, err := a.d.GetCouponRedemptions(c.Param("id"))
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, redemptions)
+}
+
+// CheckCoupon tells the caller whether they can redeem a code and, given a
+// plan, what they would pay.
+func (a *App) CheckCoupon(c *gin.Context) {
+	planID := c.Query("plan")
+	var plan billing.Plan
+	if planID != "" {
+		var ok bool
+		if plan, ok = billing.PlanByID(planID); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Plano inválido."})
+			return
+		}
+	}
+
+	coupon, err := a.d.GetRedeemableCoupon(normalizeCode(c.Param("code")), currentUser(c).ID.Hex(), planID)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"code":        coupon.Code,
+		"description": coupon.Description,
+		"percent_off": coupon.PercentOff,
+		"trial_days":  coupon.TrialDays,
+		"plans":       coupon.Plans,
+		"expires_at":  coupon.ExpiresAt,
+	}
+	if planID != "" {
+		response["plan"] = plan.ID
+		response["price_cents"] = plan.DiscountedCents(coupon.PercentOff)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetReferral returns the caller's referral link, creating their code the
+// first time.
+func (a *App) GetReferral(c *gin.Context) {
+	user := currentUser(c)
+	userID := user.ID.Hex()
+
+	code := user.ReferralCode
+	for attempt := 0; code == "" && attempt < 5; attempt++ {
+		err := a.d.SetUserReferralCode(userID, newReferralCode())
+		if errors.Is(err, db.ErrCodeInUse) {
+			continue
+		}
+		if err != nil {
+			c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+		updated, err := a.d.GetUserByID(userID)
+		if err != nil {
+			c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+		code = updated.ReferralCode
+	}
+	if code == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao gerar o código de indicação."})
+		return
+	}
+
+	referrals, err := a.d.GetUserReferrals(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	invited, rewarded := 0, 0
+	for _, referral := range referrals {
+		if referral.ReferrerID != userID {
+			continue
+		}
+		invited++
+		if referral.ReferrerDays > 0 {
+			rewarded++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":              code,
+		"link":              appURL() + "/register?ref=" + code,
+		"days_per_referral": referralPremiumDays,
+		"invited":           invited,
+		"rewarded":          rewarded,
+		"rewards_remaining": max(maxRewardedReferrals-rewarded, 0),
+	})
+}
+
+func newReferralCode() string {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base32.StdEncoding.EncodeToString(b)
+}
+
+// applyReferral rewards the invitee and whoever referred them, once the
+// invitee has confirmed their e-mail so throwaway accounts earn nothing.
+// Failures are only logged: the e-mail was already confirmed. The referral
+// record is unique per invitee, so the reward is given only once.
+func (a *App) applyReferral(c *gin.Context, invitee *model.User) {
+	referrerID := invitee.ReferredBy
+	inviteeID := invitee.ID.Hex()
+	if _, err := a.d.GetUserByID(referrerID); err != nil {
+		log.Println("erro buscando quem indicou:", err)
+		return
+	}
+
+	referral := &model.Referral{
+		ReferrerID:  referrerID,
+		InviteeID:   inviteeID,
+		InviteeDays: referralPremiumDays,
+	}
+	rewarded, err := a.d.CountRewardedReferrals(referrerID)
+	if err != nil {
+		log.Println("erro contando indicações:", err)
+	} else if rewarded < maxRewardedReferrals {
+		referral.ReferrerDays = referralPremiumDays
+	}
+
+	if err := a.d.CreateReferral(referral); err != nil {
+		if !errors.Is(err, db.ErrReferralExists) {
+			log.Println("erro registrando indicação:", err)
+		}
+		return
+	}
+
+	if _, err := a.grantPremiumDays(c, inviteeID, referral.InviteeDays); err != nil {
+		log.Println("erro concedendo premium ao indicado:", err)
+	}
+	if referral.ReferrerDays > 0 {
+		if _, err := a.grantPremiumDays(c, referrerID, referral.ReferrerDays); err != nil {
+			log.Println("erro concedendo premium a quem indicou:", err)
+		}
+	}
+}
+
+// grantPremiumDays gives the user premium for days more, on top of any
+// grant still running. Paid subscriptions are not touched.
+func (a *App) grantPremiumDays(c *gin.Context, userID string, days int) (time.Time, error) {
+	before, err := a.d.GetUserByID(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	until, err := a.d.ExtendUserPremium(userID, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	a.auditUserChange(c, model.AuditPremium, before)
+	a.invalidateUserCache(userID)
+	a.invalidateUsersCache()
+	return until, nil
+}
+
+// expirePremiumGrants takes premium away from users whose granted days ran
+// out, unless a subscription still pays for it.
+func (a *App) expirePremiumGrants() {
+	now := time.Now().UTC()
+	users, err := a.d.GetExpiredPremiumGrants(now)
+	if err != nil {
+		log.Println("erro buscando premium concedido vencido:", err)
+		return
+	}
+
+	for _, user := range users {
+		userID := user.ID.Hex()
+		if err := a.d.ClearPremiumGrant(userID, now); err != nil {
+			if !errors.Is(err, db.ErrInvalidTransition) {
+				log.Println("erro encerrando premium concedido:", err)
+			}
+			continue
+		}
+		a.invalidateUserCache(userID)
+
+		if subscription, err := a.d.GetCurrentSubscription(userID); err == nil && subscription.Status != model.SubscriptionIncomplete {
+			continue
+		}
+		err := a.setPremium(nil, userID, false)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			log.Println("erro removendo premium:", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package web
+
+import (
+	"cucinia/ai"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+// Outcomes of AI answers are counted by prompt version, so versions can be
+// compared while an experiment runs (AI_<TASK>_EXPERIMENT).
+//
+// Keys:
+//
+//	metrics:prompts:<task>   hash of <version>:<outcome> counters
+const promptMetricsPrefix = "metrics:prompts:"
+
+const (
+	// Scans are found or empty; "ingredients" adds up what they found.
+	outcomeFound       = "found"
+	outcomeEmpty       = "empty"
+	outcomeIngredients = "ingredients"
+	// Recipes are valid at once, corrected after feedback or invalid, and
+	// later maybe saved.
+	outcomeValid     = "valid"
+	outcomeCorrected = "corrected"
+	outcomeInvalid   = "invalid"
+	outcomeSaved     = "saved"
+	// Substitution suggestions are valid or invalid; "added" adds up the
+	// alternatives accepted.
+	outcomeAdded = "added"
+	// Questions to the assistant are answered.
+	outcomeAnswered = "answered"
+	// outcomeError counts calls that failed, for every task.
+	outcomeError = "error"
+)
+
+// recordPromptOutcome adds n to an outcome of a prompt version. Answers
+// without a version, e.g. of a provider that sent no prompt, are not counted.
+func recordPromptOutcome(rdb *redis.Client, task, version, outcome string, n int64) {
+	if version == "" {
+		return
+	}
+	if err := rdb.HIncrBy(promptMetricsPrefix+task, version+":"+outcome, n).Err(); err != nil {
+		log.Println("erro registrando resultado do prompt", task+"/"+version+":", err)
+	}
+}
+
+// GetPromptMetrics reports the outcomes of every task by prompt version.
+func (a *App) GetPromptMetrics(c *gin.Context) {
+	metrics := map[string]map[string]map[string]int64{}
+	for _, task := range ai.Tasks {
+		counters, err := a.rdb.HGetAll(promptMetricsPrefix + task).Result()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		versions := map[string]map[string]int64{}
+		for field, value := range counters {
+			version, outcome, ok := strings.Cut(field, ":")
+			if !ok {
+				continue
+			}
+			if versions[version] == nil {
+				versions[version] = map[string]int64{}
+			}
+			versions[version][outcome], _ = strconv.ParseInt(value, 10, 64)
+		}
+		metrics[task] = versions
+	}
+
+	c.JSON(http.StatusOK, metrics)
+}
@@ -0,0 +1,353 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+const (
+	loginFailuresBeforeLockout = 5
+	loginLockoutBase           = time.Minute
+	loginLockoutMax            = time.Hour
+	loginFailuresMemory        = 24 * time.Hour
+
+	accountFailuresBeforeLockout = 20
+	accountFailuresWindow        = time.Hour
+	accountLockout               = 15 * time.Minute
+	loginKnownIPsMemory          = 90 * 24 * time.Hour
+)
+
+type rateLimit struct {
+	limit  int
+	window time.Duration
+}
+
+// defaultRateLimits can be overridden with RATE_LIMITS, e.g.
+// "api=300/1m,auth=10/1m,gen=5/1m,assistant=20/1m".
+var defaultRateLimits = map[string]rateLimit{
+	"api":       {limit: 300, window: time.Minute},
+	"auth":      {limit: 10, window: time.Minute},
+	"gen":       {limit: 5, window: time.Minute},
+	"assistant": {limit: 20, window: time.Minute},
+}
+
+// slidingWindowScript keeps one sorted set entry per request inside the
+// window. It answers {allowed, requests in window, ms until a slot frees}.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[4])
+	redis.call("PEXPIRE", KEYS[1], window)
+	return {1, count + 1, 0}
+end
+
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+return {0, count, tonumber(oldest[2]) + window - now}
+`)
+
+type rateLimiter struct {
+	rdb       *redis.Client
+	limits    map[string]rateLimit
+	allowlist []*net.IPNet
+}
+
+func newRateLimiter(rdb *redis.Client) *rateLimiter {
+	limits := map[string]rateLimit{}
+	for name, l := range defaultRateLimits {
+		limits[name] = l
+	}
+	for name, l := range parseRateLimits(os.Getenv("RATE_LIMITS")) {
+		limits[name] = l
+	}
+
+	return &rateLimiter{
+		rdb:       rdb,
+		limits:    limits,
+		allowlist: parseIPNets("RATE_LIMIT_ALLOWLIST", os.Getenv("RATE_LIMIT_ALLOWLIST")),
+	}
+}
+
+func parseRateLimits(value string) map[string]rateLimit {
+	limits := map[string]rateLimit{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		count, window, ok2 := strings.Cut(spec, "/")
+		limit, err := strconv.Atoi(count)
+		duration, err2 := time.ParseDuration(window)
+		if !ok || !ok2 || err != nil || err2 != nil || limit <= 0 || duration <= 0 {
+			log.Println("RATE_LIMITS: entrada inválida ignorada:", entry)
+			continue
+		}
+		limits[strings.TrimSpace(name)] = rateLimit{limit: limit, window: duration}
+	}
+	return limits
+}
+
+// parseIPNets reads comma separated IPs or CIDRs from the setting name.
+func parseIPNets(name, value string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Println(name+": entrada inválida ignorada:", entry)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func (r *rateLimiter) allowlisted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range r.allowlist {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// allow records a request of subject against the named limit. Redis
+// failures let the request through: an outage must not lock everyone out.
+func (r *rateLimiter) allow(name, subject string) (bool, rateLimit, int, time.Duration) {
+	l := r.limits[name]
+
+	nonce := make([]byte, 6)
+	rand.Read(nonce)
+	now := time.Now().UnixMilli()
+
+	result, err := slidingWindowScript.Run(r.rdb,
+		[]string{"ratelimit:" + name + ":" + subject},
+		now, l.window.Milliseconds(), l.limit, fmt.Sprintf("%d-%s", now, hex.EncodeToString(nonce)),
+	).Result()
+	if err != nil {
+		log.Println("erro no limitador de requisições:", err)
+		return true, l, 0, 0
+	}
+
+	values, _ := result.([]interface{})
+	if len(values) != 3 {
+		return true, l, 0, 0
+	}
+	allowed, _ := values[0].(int64)
+	count, _ := values[1].(int64)
+	retry, _ := values[2].(int64)
+
+	return allowed == 1, l, int(count), time.Duration(retry) * time.Millisecond
+}
+
+// rateLimit limits requests per authenticated user, or per IP for
+// anonymous callers, using the limit configured for name.
+func (a *App) rateLimit(name string) gin.HandlerFunc {
+	if _, ok := a.limiter.limits[name]; !ok {
+		panic("limite de requisições não configurado: " + name)
+	}
+
+	return func(c *gin.Context) {
+		if a.limiter.allowlisted(c.ClientIP()) {
+			c.Next()
+			return
+		}
+
+		allowed, l, count, retry := a.limiter.allow(name, a.rateLimitSubject(c))
+
+		header := c.Writer.Header()
+		header.Set("X-RateLimit-Limit", strconv.Itoa(l.limit))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(max(l.limit-count, 0)))
+
+		if !allowed {
+			setRetryAfter(c, retry)
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Muitas requisições, tente novamente mais tarde."})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (a *App) rateLimitSubject(c *gin.Context) string {
+	if user := currentUser(c); user != nil {
+		return "user:" + user.ID.Hex()
+	}
+	if token := bearerToken(c); token != "" {
+		if userID, err := a.rdb.Get(sessionKey(token)).Result(); err == nil {
+			return "user:" + userID
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func setRetryAfter(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+}
+
+// Failed logins are counted per email and IP, so that guessing from one
+// place locks only that place out. Guessing spread across many IPs is
+// caught by a higher, account-wide threshold, which locks out every IP
+// except those that logged in to the account before: failing on purpose
+// can keep strangers out of an account, but not its owner's usual
+// devices. The IPs that failed for an email are kept so a password reset
+// can lift all of its lockouts.
+func loginFailuresKey(email, ip string) string {
+	return "login:failures:" + strings.ToLower(email) + ":" + ip
+}
+
+func loginLockKey(email, ip string) string {
+	return "login:lock:" + strings.ToLower(email) + ":" + ip
+}
+
+func loginFailedIPsKey(email string) string {
+	return "login:ips:" + strings.ToLower(email)
+}
+
+func loginKnownIPsKey(email string) string {
+	return "login:known:" + strings.ToLower(email)
+}
+
+func accountFailuresKey(email string) string {
+	return "login:account-failures:" + strings.ToLower(email)
+}
+
+func accountLockKey(email string) string {
+	return "login:account-lock:" + strings.ToLower(email)
+}
+
+// countFailureScript counts one failure in KEYS[1], starting a window of
+// ARGV[1] ms with the first one.
+var countFailureScript = redis.NewScript(`
+local failures = redis.call("INCR", KEYS[1])
+if failures == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return failures
+`)
+
+// knownLoginIP tells whether ip logged in to email before. When Redis
+// cannot tell, the IP is treated as a stranger.
+func (a *App) knownLoginIP(email, ip string) bool {
+	known, err := a.rdb.SIsMember(loginKnownIPsKey(email), ip).Result()
+	return err == nil && known
+}
+
+// loginLockedFor tells how long logins to email from ip stay blocked.
+func (a *App) loginLockedFor(email, ip string) time.Duration {
+	keys := []string{loginLockKey(email, ip)}
+	if !a.knownLoginIP(email, ip) {
+		keys = append(keys, accountLockKey(email))
+	}
+	var wait time.Duration
+	for _, key := range keys {
+		if ttl, err := a.rdb.PTTL(key).Result(); err == nil && ttl > wait {
+			wait = ttl
+		}
+	}
+	return wait
+}
+
+// recordLoginFailure locks email out for ip after
+// loginFailuresBeforeLockout failures, doubling the lockout on every
+// further failure. After accountFailuresBeforeLockout failures from
+// anywhere within accountFailuresWindow, email is locked out for every IP
+// it was never logged in from.
+func (a *App) recordLoginFailure(email, ip string) time.Duration {
+	pipe := a.rdb.TxPipeline()
+	incr := pipe.Incr(loginFailuresKey(email, ip))
+	pipe.Expire(loginFailuresKey(email, ip), loginFailuresMemory)
+	pipe.SAdd(loginFailedIPsKey(email), ip)
+	pipe.Expire(loginFailedIPsKey(email), loginFailuresMemory)
+	account := countFailureScript.Eval(pipe, []string{accountFailuresKey(email)}, accountFailuresWindow.Milliseconds())
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("erro registrando falha de login:", err)
+		return 0
+	}
+
+	var wait time.Duration
+	if failures, _ := account.Int64(); failures >= accountFailuresBeforeLockout {
+		a.rdb.Set(accountLockKey(email), failures, accountLockout)
+		if !a.knownLoginIP(email, ip) {
+			wait = accountLockout
+		}
+	}
+
+	failures := incr.Val()
+	if failures < loginFailuresBeforeLockout {
+		return wait
+	}
+
+	lockout := loginLockoutMax
+	if exp := failures - loginFailuresBeforeLockout; exp < 6 {
+		lockout = min(loginLockoutBase<<exp, loginLockoutMax)
+	}
+	a.rdb.Set(loginLockKey(email, ip), failures, lockout)
+	return max(lockout, wait)
+}
+
+// rememberLoginIP keeps ip as one email logged in from, so the
+// account-wide lockout does not apply to it.
+func (a *App) rememberLoginIP(email, ip string) {
+	pipe := a.rdb.TxPipeline()
+	pipe.SAdd(loginKnownIPsKey(email), ip)
+	pipe.Expire(loginKnownIPsKey(email), loginKnownIPsMemory)
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("erro registrando IP de login:", err)
+	}
+}
+
+func (a *App) clearLoginFailures(email, ip string) {
+	a.rdb.Del(loginFailuresKey(email, ip), loginLockKey(email, ip))
+	a.rdb.SRem(loginFailedIPsKey(email), ip)
+}
+
+// clearAllLoginFailures lifts the lockouts of email everywhere, once its
+// owner proved who they are, e.g. with a password reset link.
+func (a *App) clearAllLoginFailures(email string) {
+	ips, err := a.rdb.SMembers(loginFailedIPsKey(email)).Result()
+	if err != nil {
+		log.Println("erro liberando tentativas de login:", err)
+		return
+	}
+	keys := []string{loginFailedIPsKey(email), accountFailuresKey(email), accountLockKey(email)}
+	for _, ip := range ips {
+		keys = append(keys, loginFailuresKey(email, ip), loginLockKey(email, ip))
+	}
+	a.rdb.Del(keys...)
+}
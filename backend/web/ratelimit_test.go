@@ -0,0 +1,145 @@
+package web
+
+import (
+	"cucinia/model"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "auth=3/1m")
+	t.Setenv("RATE_LIMIT_ALLOWLIST", "10.0.0.0/8")
+	ta := newTestApp(t)
+
+	for i := 0; i < 6; i++ {
+		w := ta.do(http.MethodPost, "/api/v1/login", map[string]string{"email": "x@example.com", "password": "x"}, "",
+			"X-Forwarded-For", fmt.Sprintf("10.0.0.%d", i), "X-Real-IP", fmt.Sprintf("198.51.100.%d", i))
+		if i >= 3 && w.Code != http.StatusTooManyRequests {
+			t.Fatalf("request %d = %d, want 429: forwarded headers were trusted", i+1, w.Code)
+		}
+	}
+}
+
+func TestRateLimitTrustsConfiguredProxies(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "auth=3/1m")
+	t.Setenv("TRUSTED_PROXIES", "203.0.113.0/24")
+	ta := newTestApp(t)
+
+	for i := 0; i < 6; i++ {
+		w := ta.do(http.MethodPost, "/api/v1/login", map[string]string{"email": "x@example.com", "password": "x"}, "",
+			"X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		if w.Code == http.StatusTooManyRequests {
+			t.Fatalf("request %d from a different client behind the proxy was limited", i+1)
+		}
+	}
+}
+
+func TestLoginLockoutIsPerIP(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "auth=100/1m")
+	ta := newTestApp(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("senha-correta"), bcrypt.MinCost)
+	user, _ := ta.addUser("vitima@example.com", model.RoleUser)
+	ta.db.SetUserPassword(user.ID.Hex(), string(hash))
+
+	login := func(password string) int {
+		return ta.do(http.MethodPost, "/api/v1/login", map[string]string{"email": "vitima@example.com", "password": password}, "").Code
+	}
+
+	ta.remoteAddr = "198.51.100.66:1234"
+	for i := 0; i < loginFailuresBeforeLockout; i++ {
+		login("chute")
+	}
+	if code := login("senha-correta"); code != http.StatusTooManyRequests {
+		t.Fatalf("attacker's IP after %d failures = %d, want 429", loginFailuresBeforeLockout, code)
+	}
+
+	ta.remoteAddr = "203.0.113.10:4000"
+	if code := login("senha-correta"); code != http.StatusOK {
+		t.Fatalf("owner's login from another IP = %d, want 200", code)
+	}
+
+	// A password reset link lifts the lockout everywhere.
+	token, err := ta.issueAccountToken(tokenResetPassword, user.ID.Hex(), resetPasswordDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := ta.do(http.MethodPost, "/api/v1/password/reset", map[string]string{"token": token, "password": "Nova-senha-123"}, ""); w.Code != http.StatusOK {
+		t.Fatalf("password reset = %d %s", w.Code, w.Body)
+	}
+	ta.remoteAddr = "198.51.100.66:1234"
+	if code := login("Nova-senha-123"); code != http.StatusOK {
+		t.Errorf("login after the reset = %d, want 200", code)
+	}
+}
+
+func TestLoginLockoutAcrossIPs(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "auth=100/1m")
+	ta := newTestApp(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("senha-correta"), bcrypt.MinCost)
+	user, _ := ta.addUser("vitima@example.com", model.RoleUser)
+	ta.db.SetUserPassword(user.ID.Hex(), string(hash))
+
+	login := func(ip, password string) int {
+		ta.remoteAddr = ip + ":1234"
+		return ta.do(http.MethodPost, "/api/v1/login", map[string]string{"email": "vitima@example.com", "password": password}, "").Code
+	}
+	guess := func(prefix string, n int) {
+		for i := 0; i < n; i++ {
+			login(fmt.Sprintf("%s.%d", prefix, i+1), "chute")
+		}
+	}
+
+	// The owner's usual IP.
+	if code := login("203.0.113.10", "senha-correta"); code != http.StatusOK {
+		t.Fatalf("owner's login = %d, want 200", code)
+	}
+
+	// One guess from each IP never reaches the per-IP threshold.
+	guess("198.51.100", accountFailuresBeforeLockout-1)
+	if code := login("203.0.113.11", "senha-correta"); code != http.StatusOK {
+		t.Fatalf("login below the account threshold = %d, want 200", code)
+	}
+	login("198.51.100.200", "chute")
+	if code := login("203.0.113.12", "senha-correta"); code != http.StatusTooManyRequests {
+		t.Fatalf("new IP after %d failures from different IPs = %d, want 429", accountFailuresBeforeLockout, code)
+	}
+	for _, ip := range []string{"203.0.113.10", "203.0.113.11"} {
+		if code := login(ip, "senha-correta"); code != http.StatusOK {
+			t.Errorf("owner's login from %s during the account lockout = %d, want 200", ip, code)
+		}
+	}
+
+	ta.redis.FastForward(accountLockout)
+	if code := login("203.0.113.12", "senha-correta"); code != http.StatusOK {
+		t.Errorf("login after the account lockout = %d, want 200", code)
+	}
+
+	// Failures are only counted within the window.
+	if ttl := ta.redis.TTL(accountFailuresKey("vitima@example.com")); ttl <= 0 || ttl > accountFailuresWindow {
+		t.Errorf("account failures expire in %v, want within %v", ttl, accountFailuresWindow)
+	}
+	ta.redis.FastForward(accountFailuresWindow)
+	guess("192.0.2", accountFailuresBeforeLockout-1)
+	if code := login("203.0.113.13", "senha-correta"); code != http.StatusOK {
+		t.Errorf("login with failures from an expired window = %d, want 200", code)
+	}
+
+	// A password reset link lifts the account lockout too.
+	guess("192.0.2", 1)
+	if code := login("203.0.113.14", "senha-correta"); code != http.StatusTooManyRequests {
+		t.Fatalf("new IP after the second lockout = %d, want 429", code)
+	}
+	token, err := ta.issueAccountToken(tokenResetPassword, user.ID.Hex(), resetPasswordDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := ta.do(http.MethodPost, "/api/v1/password/reset", map[string]string{"token": token, "password": "Nova-senha-123"}, ""); w.Code != http.StatusOK {
+		t.Fatalf("password reset = %d %s", w.Code, w.Body)
+	}
+	if code := login("203.0.113.14", "Nova-senha-123"); code != http.StatusOK {
+		t.Errorf("login after the reset = %d, want 200", code)
+	}
+}
@@ -0,0 +1,134 @@
+package web
+
+import (
+	"cucinia/model"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// register creates an account through the API, as a new visitor would.
+func (ta *testApp) register(email, referralCode string) *model.User {
+	ta.t.Helper()
+	w := ta.do(http.MethodPost, "/api/v1/register", map[string]any{
+		"name":          "Convidada",
+		"email":         email,
+		"password":      "uma senha bem longa 42",
+		"referral_code": referralCode,
+	}, "")
+	if w.Code != http.StatusCreated {
+		ta.t.Fatalf("register %s = %d: %s", email, w.Code, w.Body)
+	}
+	normalized, _ := normalizeEmail(email)
+	user, err := ta.db.GetUserByEmail(normalized)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	return user
+}
+
+func (ta *testApp) verifyEmail(userID string) int {
+	ta.t.Helper()
+	token, err := ta.issueAccountToken(tokenVerifyEmail, userID, verifyEmailDuration)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	return ta.do(http.MethodPost, "/api/v1/verify-email", map[string]string{"token": token}, "").Code
+}
+
+func TestReferralRewardWaitsForVerifiedEmail(t *testing.T) {
+	ta := newTestApp(t)
+	referrer, _ := ta.addUser("ana@example.com", model.RoleUser)
+	ta.db.updateUser(referrer.ID.Hex(), func(user *model.User) { user.ReferralCode = "ANA2024" })
+
+	invitee := ta.register("bia@example.com", "ana2024")
+	if invitee.Premium || invitee.ReferredBy != referrer.ID.Hex() {
+		t.Fatalf("registered invitee = premium %v, referred by %q", invitee.Premium, invitee.ReferredBy)
+	}
+	if ta.db.user(referrer.ID.Hex()).Premium || len(ta.db.referrals) != 0 {
+		t.Fatal("referral rewarded before the invitee confirmed their e-mail")
+	}
+
+	if code := ta.verifyEmail(invitee.ID.Hex()); code != http.StatusOK {
+		t.Fatalf("verify = %d", code)
+	}
+	for _, id := range []string{referrer.ID.Hex(), invitee.ID.Hex()} {
+		if user := ta.db.user(id); !user.Premium || user.PremiumUntil == nil {
+			t.Errorf("%s not rewarded after verification", user.Email)
+		}
+	}
+	until := *ta.db.user(invitee.ID.Hex()).PremiumUntil
+
+	// Another verification link for the same account gives nothing more.
+	if code := ta.verifyEmail(invitee.ID.Hex()); code != http.StatusOK {
+		t.Fatalf("second verify = %d", code)
+	}
+	if len(ta.db.referrals) != 1 || !ta.db.user(invitee.ID.Hex()).PremiumUntil.Equal(until) {
+		t.Errorf("second verification rewarded again: %d referrals", len(ta.db.referrals))
+	}
+}
+
+func TestUnverifiedInviteesAreNotCounted(t *testing.T) {
+	ta := newTestApp(t)
+	referrer, token := ta.addUser("ana@example.com", model.RoleUser)
+	ta.db.updateUser(referrer.ID.Hex(), func(user *model.User) { user.ReferralCode = "ANA2024" })
+
+	for i := 0; i < 3; i++ {
+		ta.register(fmt.Sprintf("convidada%d@example.com", i), "ANA2024")
+	}
+	w := ta.do(http.MethodGet, "/api/v1/users/me/referral", nil, token)
+	referral := decode[struct {
+		Invited          int `json:"invited"`
+		Rewarded         int `json:"rewarded"`
+		RewardsRemaining int `json:"rewards_remaining"`
+	}](t, w)
+	if referral.Invited != 0 || referral.Rewarded != 0 || referral.RewardsRemaining != maxRewardedReferrals {
+		t.Errorf("referral = %+v, want nothing counted for unverified accounts", referral)
+	}
+	if ta.db.user(referrer.ID.Hex()).Premium {
+		t.Error("referrer got premium from unverified accounts")
+	}
+}
+
+func TestReferralPaidOnEveryVerificationPath(t *testing.T) {
+	verifications := map[string]func(ta *testApp, invitee *model.User){
+		"password reset": func(ta *testApp, invitee *model.User) {
+			token, err := ta.issueAccountToken(tokenResetPassword, invitee.ID.Hex(), resetPasswordDuration)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w := ta.do(http.MethodPost, "/api/v1/password/reset", map[string]string{"token": token, "password": "Nova-senha-123"}, ""); w.Code != http.StatusOK {
+				t.Fatalf("reset = %d %s", w.Code, w.Body)
+			}
+		},
+		"e-mail change": func(ta *testApp, invitee *model.User) {
+			token, err := ta.createSession(invitee)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := ta.do(http.MethodPost, "/api/v1/users/me/email", map[string]string{"email": "bia.nova@example.com", "password": "uma senha bem longa 42"}, token)
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("request change = %d %s", w.Code, w.Body)
+			}
+			w = ta.do(http.MethodPost, "/api/v1/email-change/confirm", map[string]string{"token": ta.mailedToken("bia.nova@example.com")}, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("confirm = %d %s", w.Code, w.Body)
+			}
+		},
+	}
+
+	for name, verify := range verifications {
+		ta := newTestApp(t)
+		referrer, _ := ta.addUser("ana@example.com", model.RoleUser)
+		ta.db.updateUser(referrer.ID.Hex(), func(user *model.User) { user.ReferralCode = "ANA2024" })
+		invitee := ta.register("bia@example.com", "ANA2024")
+
+		verify(ta, invitee)
+		if !ta.db.user(invitee.ID.Hex()).EmailVerified {
+			t.Errorf("%s: e-mail not confirmed", name)
+		}
+		if len(ta.db.referrals) != 1 || !ta.db.user(referrer.ID.Hex()).Premium || !ta.db.user(invitee.ID.Hex()).Premium {
+			t.Errorf("%s: referral not rewarded (%d referrals)", name, len(ta.db.referrals))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package web
+
+import (
+	"cucinia/db"
+	"cucinia/model"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func recipeETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatchVersion reads the recipe version the client based its change on.
+// Editors must send it so concurrent edits are detected instead of lost.
+func ifMatchVersion(c *gin.Context, required bool) (int, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		if !required {
+			return db.AnyVersion, true
+		}
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "Envie o cabeçalho If-Match com o ETag da receita."})
+		return 0, false
+	}
+
+	header = strings.TrimPrefix(header, "W/")
+	version, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "If-Match inválido."})
+		return 0, false
+	}
+
+	return version, true
+}
+
+// respondWithRecipe answers with the current state of a recipe and its ETag.
+func (a *App) respondWithRecipe(c *gin.Context, id string) {
+	recipe, err := a.d.GetRecipeByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if recipe == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receita não encontrada."})
+		return
+	}
+
+	c.Header("ETag", recipeETag(recipe.Version))
+	c.JSON(http.StatusOK, recipe)
+}
+
+func (a *App) GetRecipeRevisions(c *gin.Context) {
+	revisions, err := a.d.GetRecipeRevisions(c.Param("id"))
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, revisions)
+}
+
+func (a *App) RestoreRecipeRevision(c *gin.Context) {
+	id := c.Param("id")
+
+	rev, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Revisão inválida."})
+		return
+	}
+
+	expectedVersion, ok := ifMatchVersion(c, true)
+	if !ok {
+		return
+	}
+
+	before, _ := a.d.GetRecipeByID(id)
+	if err := a.d.RestoreRecipeRevision(id, rev, expectedVersion, currentUser(c).ID.Hex()); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.rdb.Del("recipe:" + id)
+	a.invalidateRecipeListsCache()
+	a.auditRecipe(c, model.AuditRestore, id, before)
+
+	a.respondWithRecipe(c, id)
+}
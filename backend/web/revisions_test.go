@@ -0,0 +1,118 @@
+package web
+
+import (
+	"cucinia/model"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRecipeUpdatesNeedCurrentVersion(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("editor@example.com", model.RoleEditor)
+	id := ta.addRevisedRecipe(token)
+	path := "/api/v1/recipes/" + id
+	update := func(name string) map[string]any {
+		return map[string]any{"name": name, "cuisine": "italiana", "type_of": 2}
+	}
+
+	if w := ta.do(http.MethodPatch, path, update("Sem cabeçalho"), token); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("update without If-Match = %d, want 428", w.Code)
+	}
+	if w := ta.do(http.MethodPatch, path, update("Cabeçalho torto"), token, "If-Match", "um"); w.Code != http.StatusBadRequest {
+		t.Errorf("update with If-Match um = %d, want 400", w.Code)
+	}
+
+	w := ta.do(http.MethodPatch, path, update("Lasanha à bolonhesa"), token, "If-Match", `"1"`)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("update = %d %s, ETag %s", w.Code, w.Body, w.Header().Get("ETag"))
+	}
+
+	if w := ta.do(http.MethodPatch, path, update("Edição antiga"), token, "If-Match", `"1"`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("update based on version 1 = %d, want 412", w.Code)
+	}
+	if w := ta.do(http.MethodPatch, path, update("Lasanha verde"), token, "If-Match", `W/"2"`); w.Code != http.StatusOK || w.Header().Get("ETag") != `"3"` {
+		t.Errorf("update with a weak ETag = %d, ETag %s", w.Code, w.Header().Get("ETag"))
+	}
+
+	stored, _ := ta.db.GetRecipeByID(id)
+	if stored.Name != "Lasanha verde" || stored.Version != 3 {
+		t.Errorf("stored %q at version %d, want the last accepted update at 3", stored.Name, stored.Version)
+	}
+}
+
+func TestRestoreRevisionAddsRevision(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("editor@example.com", model.RoleEditor)
+	id := ta.addRevisedRecipe(token)
+	path := "/api/v1/recipes/" + id
+	for version, name := range []string{"Lasanha à bolonhesa", "Lasanha verde"} {
+		if w := ta.do(http.MethodPatch, path, map[string]any{"name": name, "cuisine": "italiana", "type_of": 2}, token, "If-Match", recipeETag(version+1)); w.Code != http.StatusOK {
+			t.Fatalf("update to %s = %d %s", name, w.Code, w.Body)
+		}
+	}
+
+	restore := path + "/revisions/1/restore"
+	if w := ta.do(http.MethodPost, restore, nil, token); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("restore without If-Match = %d, want 428", w.Code)
+	}
+	if w := ta.do(http.MethodPost, restore, nil, token, "If-Match", `"2"`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("restore based on version 2 = %d, want 412", w.Code)
+	}
+	if w := ta.do(http.MethodPost, path+"/revisions/9/restore", nil, token, "If-Match", `"3"`); w.Code != http.StatusNotFound {
+		t.Errorf("restore of a missing revision = %d, want 404", w.Code)
+	}
+	if w := ta.do(http.MethodPost, path+"/revisions/primeira/restore", nil, token, "If-Match", `"3"`); w.Code != http.StatusBadRequest {
+		t.Errorf("restore of revision primeira = %d, want 400", w.Code)
+	}
+
+	ta.db.audit = nil
+	w := ta.do(http.MethodPost, restore, nil, token, "If-Match", `"3"`)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"4"` {
+		t.Fatalf("restore = %d %s, ETag %s", w.Code, w.Body, w.Header().Get("ETag"))
+	}
+	if restored := decode[model.Recipe](t, w); restored.Name != "Lasanha" || restored.Version != 4 {
+		t.Errorf("restored %q at version %d, want Lasanha at 4", restored.Name, restored.Version)
+	}
+	if len(ta.db.audit) != 1 || ta.db.audit[0].Action != model.AuditRestore {
+		t.Errorf("audit = %+v, want one restore", ta.db.audit)
+	}
+
+	w = ta.do(http.MethodGet, path+"/revisions", nil, token)
+	revisions := decode[[]model.RecipeRevision](t, w)
+	if w.Code != http.StatusOK || len(revisions) != 4 {
+		t.Fatalf("revisions = %d, %d of them, want 4", w.Code, len(revisions))
+	}
+	for i, want := range []struct {
+		name         string
+		restoredFrom int
+	}{
+		{"Lasanha", 1},
+		{"Lasanha verde", 0},
+		{"Lasanha à bolonhesa", 0},
+		{"Lasanha", 0},
+	} {
+		revision := revisions[i]
+		restoredFrom := 0
+		if revision.RestoredFrom != nil {
+			restoredFrom = *revision.RestoredFrom
+		}
+		if revision.Rev != 4-i || revision.Snapshot.Name != want.name || restoredFrom != want.restoredFrom {
+			t.Errorf("revision %d = %q restored from %d, want %q restored from %d", revision.Rev, revision.Snapshot.Name, restoredFrom, want.name, want.restoredFrom)
+		}
+	}
+}
+
+// addRevisedRecipe creates, through the API, an Italian lunch recipe named
+// Lasanha at version 1.
+func (ta *testApp) addRevisedRecipe(token string) string {
+	ta.t.Helper()
+	ta.db.cuisines = []*model.Cuisine{{ID: primitive.NewObjectID(), Slug: "italiana"}}
+	ta.db.mealTypes = []*model.MealType{{ID: primitive.NewObjectID(), Code: 2, Slug: "almoco"}}
+	w := ta.do(http.MethodPost, "/api/v1/recipes", map[string]any{"name": "Lasanha", "cuisine": "italiana", "type_of": 2}, token)
+	if w.Code != http.StatusCreated {
+		ta.t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+	return decode[model.Recipe](ta.t, w).ID.Hex()
+}
@@ -0,0 +1,319 @@
+package web
+
+import (
+	"crypto/sha256"
+	"cucinia/imaging"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+// Scans of the same photo, or of one that only differs by compression or
+// size, are answered from a cache instead of asking the AI again. Photos are
+// found by their SHA-256 and by a difference hash (dHash) of their content.
+// Near-identical photos have dHashes a few bits apart; splitting the hash in
+// bands finds them without comparing against every cached one, as two hashes
+// at most scanSimilarBits apart always share a band.
+//
+// Keys:
+//
+//	scan:sha:<sha256>         result of a photo
+//	scan:phash:<dhash>        result of photos with this dHash
+//	scan:band:<n>:<bits>      dHashes whose band n holds these bits
+//	metrics:scan_cache        hit and miss counters
+const (
+	scanMetricsKey  = "metrics:scan_cache"
+	scanBands       = 4
+	scanSimilarBits = scanBands - 1
+
+	scanExactHit   = "exact"
+	scanSimilarHit = "similar"
+
+	scanUploadContextKey = "scan_upload"
+
+	// maxScanImages is how many photos one scan takes, e.g. of the fridge
+	// and of the pantry shelf.
+	maxScanImages = 3
+)
+
+// scanResult is what a finished scan holds. Results answered from the cache
+// keep the prompt version of the scan that produced them.
+type scanResult struct {
+	Ingredients   []string `json:"ingredients"`
+	PromptVersion string   `json:"prompt_version,omitempty"`
+}
+
+type scanFingerprint struct {
+	sha   string
+	phash uint64
+	// hasPHash is false for scans of several photos and for photos that
+	// cannot be decoded (HEIC); only their exact copies are found.
+	hasPHash bool
+}
+
+// scanUpload is the photos read by checkScanCache, for the handlers after
+// it.
+type scanUpload struct {
+	images      []*imaging.Scan
+	fingerprint scanFingerprint
+}
+
+// newScanFingerprint identifies a scan by the files sent, in order, and by
+// the content of its photo when there is only one.
+func newScanFingerprint(files [][]byte, scans []*imaging.Scan) scanFingerprint {
+	sum := sha256.Sum256(files[0])
+	if len(files) > 1 {
+		hash := sha256.New()
+		for _, file := range files {
+			fileSum := sha256.Sum256(file)
+			hash.Write(fileSum[:])
+		}
+		hash.Sum(sum[:0])
+	}
+	fp := scanFingerprint{sha: hex.EncodeToString(sum[:])}
+
+	if len(scans) == 1 && scans[0].Image != nil {
+		fp.phash, fp.hasPHash = dHash(scans[0].Image)
+	}
+	return fp
+}
+
+// dHash shrinks img to 9x8 gray cells and sets a bit for every cell brighter
+// than its right neighbour.
+func dHash(img image.Image) (uint64, bool) {
+	bounds := img.Bounds()
+	if bounds.Dx() < 9 || bounds.Dy() < 8 {
+		return 0, false
+	}
+
+	var cells [8][9]float64
+	var counts [8][9]int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := (y - bounds.Min.Y) * 8 / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			col := (x - bounds.Min.X) * 9 / bounds.Dx()
+			r, g, b, _ := img.At(x, y).RGBA()
+			cells[row][col] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[row][col]++
+		}
+	}
+
+	var hash uint64
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			hash <<= 1
+			if cells[row][col]/float64(counts[row][col]) > cells[row][col+1]/float64(counts[row][col+1]) {
+				hash |= 1
+			}
+		}
+	}
+	return hash, true
+}
+
+func phashKey(phash uint64) string {
+	return fmt.Sprintf("scan:phash:%016x", phash)
+}
+
+func bandKey(band int, phash uint64) string {
+	value := phash >> (band * 64 / scanBands) & (1<<(64/scanBands) - 1)
+	return fmt.Sprintf("scan:band:%d:%x", band, value)
+}
+
+type scanCache struct {
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+// newScanCache reads SCAN_CACHE_TTL.
+func newScanCache(rdb *redis.Client) *scanCache {
+	return &scanCache{rdb: rdb, ttl: envDuration("SCAN_CACHE_TTL", 7*24*time.Hour)}
+}
+
+// lookup returns the cached result of a photo and whether it was found by
+// an exact copy or a similar one. kind is empty on a miss.
+func (s *scanCache) lookup(fp scanFingerprint) (result string, kind string) {
+	if result, err := s.rdb.Get("scan:sha:" + fp.sha).Result(); err == nil {
+		return result, scanExactHit
+	}
+	if !fp.hasPHash {
+		return "", ""
+	}
+	if result, err := s.rdb.Get(phashKey(fp.phash)).Result(); err == nil {
+		return result, scanSimilarHit
+	}
+
+	checked := map[string]bool{}
+	for band := 0; band < scanBands; band++ {
+		members, err := s.rdb.SMembers(bandKey(band, fp.phash)).Result()
+		if err != nil {
+			continue
+		}
+		for _, member := range members {
+			if checked[member] {
+				continue
+			}
+			checked[member] = true
+			other, err := strconv.ParseUint(member, 16, 64)
+			if err != nil || bits.OnesCount64(other^fp.phash) > scanSimilarBits {
+				continue
+			}
+			if result, err := s.rdb.Get(phashKey(other)).Result(); err == nil {
+				return result, scanSimilarHit
+			}
+		}
+	}
+	return "", ""
+}
+
+func (s *scanCache) store(fp scanFingerprint, result string) {
+	pipe := s.rdb.Pipeline()
+	pipe.Set("scan:sha:"+fp.sha, result, s.ttl)
+	if fp.hasPHash {
+		pipe.Set(phashKey(fp.phash), result, s.ttl)
+		member := fmt.Sprintf("%016x", fp.phash)
+		for band := 0; band < scanBands; band++ {
+			pipe.SAdd(bandKey(band, fp.phash), member)
+			pipe.Expire(bandKey(band, fp.phash), s.ttl)
+		}
+	}
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("erro guardando análise no cache:", err)
+	}
+}
+
+func (s *scanCache) count(kind string) {
+	field := "misses"
+	switch kind {
+	case scanExactHit:
+		field = "exact_hits"
+	case scanSimilarHit:
+		field = "similar_hits"
+	}
+	s.rdb.HIncrBy(scanMetricsKey, field, 1)
+}
+
+// readScanImages reads and prepares the photos of a scan request, sent as
+// one or more "image" fields, answering the request when it cannot.
+func readScanImages(c *gin.Context) ([][]byte, []*imaging.Scan, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxScanImages*imaging.MaxUploadSize+(1<<20))
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": imaging.ErrTooLarge.Error()})
+			return nil, nil, false
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	headers := c.Request.MultipartForm.File["image"]
+	switch {
+	case len(headers) == 0:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Envie ao menos uma imagem."})
+		return nil, nil, false
+	case len(headers) > maxScanImages:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Envie no máximo %d imagens.", maxScanImages)})
+		return nil, nil, false
+	}
+
+	files := make([][]byte, 0, len(headers))
+	scans := make([]*imaging.Scan, 0, len(headers))
+	for _, header := range headers {
+		if header.Size > imaging.MaxUploadSize {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": imaging.ErrTooLarge.Error()})
+			return nil, nil, false
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, nil, false
+		}
+		data, err := io.ReadAll(io.LimitReader(file, imaging.MaxUploadSize+1))
+		file.Close()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, nil, false
+		}
+		if len(data) > imaging.MaxUploadSize {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": imaging.ErrTooLarge.Error()})
+			return nil, nil, false
+		}
+
+		scan, err := imaging.PrepareScan(data)
+		switch {
+		case errors.Is(err, imaging.ErrUnsupportedScanType):
+			c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return nil, nil, false
+		case errors.Is(err, imaging.ErrTooLarge):
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return nil, nil, false
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Imagem inválida."})
+			return nil, nil, false
+		}
+		files = append(files, data)
+		scans = append(scans, scan)
+	}
+	return files, scans, true
+}
+
+// checkScanCache answers scans of photos already seen with a finished job.
+// It runs before requireQuota, so these scans are free.
+func (a *App) checkScanCache(c *gin.Context) {
+	files, scans, ok := readScanImages(c)
+	if !ok {
+		return
+	}
+	fp := newScanFingerprint(files, scans)
+
+	result, kind := a.scanCache.lookup(fp)
+	a.scanCache.count(kind)
+	if kind == "" {
+		c.Set(scanUploadContextKey, &scanUpload{images: scans, fingerprint: fp})
+		c.Next()
+		return
+	}
+
+	job, err := a.gen.complete(currentUser(c).ID.Hex(), result, kind)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar a análise."})
+		return
+	}
+	c.Header("Location", "/api/v1/gen/"+job.ID)
+	c.AbortWithStatusJSON(http.StatusOK, job)
+}
+
+// GetScanCacheMetrics reports how often scans were answered from the cache.
+func (a *App) GetScanCacheMetrics(c *gin.Context) {
+	counters, err := a.rdb.HGetAll(scanMetricsKey).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	exact, _ := strconv.ParseInt(counters["exact_hits"], 10, 64)
+	similar, _ := strconv.ParseInt(counters["similar_hits"], 10, 64)
+	misses, _ := strconv.ParseInt(counters["misses"], 10, 64)
+	hitRate := 0.0
+	if total := exact + similar + misses; total > 0 {
+		hitRate = float64(exact+similar) / float64(total)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"exact_hits":   exact,
+		"similar_hits": similar,
+		"misses":       misses,
+		"hit_rate":     hitRate,
+		"ttl_seconds":  int64(a.scanCache.ttl.Seconds()),
+	})
+}
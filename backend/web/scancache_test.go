@@ -0,0 +1,239 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"cucinia/ai"
+	"cucinia/imaging"
+	"cucinia/model"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/bits"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fridgePhoto draws a photo made of 9x8 blocks whose brightness jumps
+// between neighbours, so its dHash survives recompression and resizing.
+// seed changes the pattern.
+func fridgePhoto(width, height, seed int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			col, row := x*9/width, y*8/height
+			v := uint8((col*37 + row*91 + seed*53) % 256)
+			img.Set(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image, quality int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func fingerprintOf(t *testing.T, data []byte) scanFingerprint {
+	t.Helper()
+	scan, err := imaging.PrepareScan(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newScanFingerprint([][]byte{data}, []*imaging.Scan{scan})
+}
+
+func TestDHashSurvivesRecompressionAndResizing(t *testing.T) {
+	original := fridgePhoto(360, 320, 0)
+	hash, ok := dHash(original)
+	if !ok {
+		t.Fatal("no dHash for the original")
+	}
+
+	copies := map[string]image.Image{
+		"half size": imaging.Fit(original, 180),
+		"odd size":  imaging.Fit(original, 251),
+	}
+	for name, data := range map[string][]byte{"jpeg q90": encodeJPEG(t, original, 90), "jpeg q40": encodeJPEG(t, original, 40)} {
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		copies[name] = img
+	}
+	for name, img := range copies {
+		other, _ := dHash(img)
+		if d := bits.OnesCount64(hash ^ other); d > scanSimilarBits {
+			t.Errorf("%s: %d bits from the original, want at most %d", name, d, scanSimilarBits)
+		}
+	}
+
+	other, _ := dHash(fridgePhoto(360, 320, 1))
+	if d := bits.OnesCount64(hash ^ other); d <= scanSimilarBits {
+		t.Errorf("another photo is only %d bits away", d)
+	}
+	if _, ok := dHash(fridgePhoto(8, 8, 0)); ok {
+		t.Error("dHash of a photo smaller than 9x8")
+	}
+}
+
+func TestScanCacheLookup(t *testing.T) {
+	ta := newTestApp(t)
+	original := fridgePhoto(360, 320, 0)
+	data := encodeJPEG(t, original, 90)
+	ta.scanCache.store(fingerprintOf(t, data), `{"ingredients":["leite"]}`)
+
+	tests := map[string]struct {
+		data []byte
+		want string
+	}{
+		"same file":    {data, scanExactHit},
+		"recompressed": {encodeJPEG(t, original, 50), scanSimilarHit},
+		"resized png":  {encodePNG(t, imaging.Fit(original, 200)), scanSimilarHit},
+		"other photo":  {encodeJPEG(t, fridgePhoto(360, 320, 1), 90), ""},
+	}
+	for name, tt := range tests {
+		result, kind := ta.scanCache.lookup(fingerprintOf(t, tt.data))
+		if kind != tt.want {
+			t.Errorf("%s: found as %q, want %q", name, kind, tt.want)
+		}
+		if kind != "" && result != `{"ingredients":["leite"]}` {
+			t.Errorf("%s: result %q", name, result)
+		}
+	}
+
+	// Scans of several photos are only found by the exact same files.
+	files := [][]byte{data, encodeJPEG(t, fridgePhoto(360, 320, 2), 90)}
+	var scans []*imaging.Scan
+	for _, file := range files {
+		scan, err := imaging.PrepareScan(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scans = append(scans, scan)
+	}
+	fp := newScanFingerprint(files, scans)
+	if fp.hasPHash {
+		t.Error("a scan of several photos has a dHash")
+	}
+	if _, kind := ta.scanCache.lookup(fp); kind != "" {
+		t.Errorf("several photos found as %q before being stored", kind)
+	}
+}
+
+// postScan sends photos to POST /api/v1/gen.
+func (ta *testApp) postScan(token string, photos ...[]byte) *httptest.ResponseRecorder {
+	ta.t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, photo := range photos {
+		part, err := form.CreateFormFile("image", "geladeira.jpg")
+		if err != nil {
+			ta.t.Fatal(err)
+		}
+		part.Write(photo)
+	}
+	form.Close()
+	return ta.do(http.MethodPost, "/api/v1/gen", body.Bytes(), token, "Content-Type", form.FormDataContentType())
+}
+
+func TestCachedScansSkipQuota(t *testing.T) {
+	ta := newTestApp(t)
+	ta.gen.scan = func(ctx context.Context, userID string, images []ai.Image) (*scanResult, error) {
+		return &scanResult{Ingredients: []string{"leite", "ovo"}}, nil
+	}
+	user, token := ta.addUser("ana@example.com", model.RoleUser)
+	original := fridgePhoto(360, 320, 0)
+	photo := encodeJPEG(t, original, 90)
+
+	w := ta.postScan(token, photo)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("first scan = %d %s, want 202", w.Code, w.Body)
+	}
+	id := decode[genJobView](t, w).ID
+	ta.redis.Lpop(genQueueKey)
+	ta.gen.process(id)
+	if used := ta.quotaUsed(user, featureAIScans); used != 1 {
+		t.Fatalf("uses after the first scan = %d, want 1", used)
+	}
+
+	// Use up the rest of the month's scans.
+	limit := featureLimit(freePlan, featureAIScans)
+	ta.redis.Set(usageKey(featureAIScans, user.ID.Hex(), time.Now()), strconv.Itoa(limit))
+
+	for name, tt := range map[string]struct {
+		photo []byte
+		want  string
+	}{
+		"same photo":   {photo, scanExactHit},
+		"recompressed": {encodeJPEG(t, original, 50), scanSimilarHit},
+	} {
+		w := ta.postScan(token, tt.photo)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s = %d %s, want 200 from the cache", name, w.Code, w.Body)
+		}
+		job := decode[genJobView](t, w)
+		if job.Status != jobDone || job.Cached != tt.want || string(job.Result) == "" {
+			t.Errorf("%s: job %+v", name, job)
+		}
+	}
+	if used := ta.quotaUsed(user, featureAIScans); used != limit {
+		t.Errorf("uses after cached scans = %d, want %d", used, limit)
+	}
+
+	if w := ta.postScan(token, encodeJPEG(t, fridgePhoto(360, 320, 1), 90)); w.Code != http.StatusTooManyRequests {
+		t.Errorf("new photo over the quota = %d, want 429", w.Code)
+	}
+}
+
+func TestScanCacheMetrics(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("ana@example.com", model.RoleUser)
+	_, adminToken := ta.addUser("admin@example.com", model.RoleAdmin)
+	photo := encodeJPEG(t, fridgePhoto(360, 320, 0), 90)
+	ta.scanCache.store(fingerprintOf(t, photo), `{"ingredients":["leite"]}`)
+
+	ta.postScan(token, photo)
+	ta.postScan(token, photo)
+	ta.postScan(token, encodeJPEG(t, fridgePhoto(360, 320, 0), 50))
+	ta.postScan(token, encodeJPEG(t, fridgePhoto(360, 320, 1), 90))
+
+	if w := ta.do(http.MethodGet, "/api/v1/admin/metrics/scan-cache", nil, token); w.Code != http.StatusForbidden {
+		t.Errorf("metrics for a user = %d, want 403", w.Code)
+	}
+	w := ta.do(http.MethodGet, "/api/v1/admin/metrics/scan-cache", nil, adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("metrics = %d %s", w.Code, w.Body)
+	}
+	metrics := decode[struct {
+		ExactHits   int64   `json:"exact_hits"`
+		SimilarHits int64   `json:"similar_hits"`
+		Misses      int64   `json:"misses"`
+		HitRate     float64 `json:"hit_rate"`
+		TTLSeconds  int64   `json:"ttl_seconds"`
+	}](t, w)
+	if metrics.ExactHits != 2 || metrics.SimilarHits != 1 || metrics.Misses != 1 || metrics.HitRate != 0.75 {
+		t.Errorf("metrics = %+v", metrics)
+	}
+	if metrics.TTLSeconds != int64((7 * 24 * time.Hour).Seconds()) {
+		t.Errorf("ttl = %d s, want the default week", metrics.TTLSeconds)
+	}
+}
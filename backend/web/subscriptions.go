@@ -0,0 +1,330 @@
+package web
+
+import (
+	"cucinia/billing"
+	"cucinia/db"
+	"cucinia/model"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	subscriptionCheckInterval = 10 * time.Minute
+	// Subscriptions that are still waiting for their first payment after
+	// this long are given up.
+	incompleteSubscriptionTimeout = 24 * time.Hour
+
+	maxWebhookSize = 1 << 20
+)
+
+func (a *App) GetPlans(c *gin.Context) {
+	c.JSON(http.StatusOK, billing.Plans())
+}
+
+func (a *App) GetSubscription(c *gin.Context) {
+	subscription, err := a.d.GetCurrentSubscription(currentUser(c).ID.Hex())
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": "Nenhuma assinatura em andamento."})
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+// StartSubscription subscribes the caller to a plan and returns the page
+// where they pay. The first subscription of a user starts with the plan's
+// trial, which grants premium right away. A coupon is redeemed here: it
+// discounts the first paid period and may lengthen the trial, even for
+// users who already had one.
+func (a *App) StartSubscription(c *gin.Context) {
+	user := currentUser(c)
+	userID := user.ID.Hex()
+
+	if !billing.Enabled(a.billing) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Assinaturas indisponíveis no momento."})
+		return
+	}
+
+	var payload struct {
+		Plan   string `json:"plan"`
+		Coupon string `json:"coupon"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+	plan, ok := billing.PlanByID(payload.Plan)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Plano inválido."})
+		return
+	}
+
+	usedTrial, err := a.d.HasUsedTrial(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	trialDays := 0
+	if !usedTrial {
+		trialDays = plan.TrialDays
+	}
+
+	var coupon *model.Coupon
+	var redemption *model.CouponRedemption
+	if code := normalizeCode(payload.Coupon); code != "" {
+		// Checked first so a coupon is not used up by a request that fails.
+		if _, err := a.d.GetCurrentSubscription(userID); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": db.ErrSubscriptionExists.Error()})
+			return
+		}
+
+		coupon, redemption, err = a.d.RedeemCoupon(code, userID, plan.ID)
+		if err != nil {
+			c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+		trialDays = max(trialDays, coupon.TrialDays)
+	}
+	// releaseCoupon gives the redemption back when the subscription does
+	// not start.
+	releaseCoupon := func() {
+		if redemption == nil {
+			return
+		}
+		if err := a.d.ReleaseCouponRedemption(redemption.ID.Hex()); err != nil {
+			log.Println("erro devolvendo cupom:", err)
+		}
+	}
+
+	now := time.Now().UTC()
+	startAt := now
+	subscription := &model.Subscription{
+		UserID: userID,
+		PlanID: plan.ID,
+		Status: model.SubscriptionIncomplete,
+	}
+	if coupon != nil {
+		subscription.Coupon = coupon.Code
+		subscription.PercentOff = coupon.PercentOff
+	}
+	if trialDays > 0 {
+		trialEnd := now.AddDate(0, 0, trialDays)
+		subscription.Status = model.SubscriptionTrialing
+		subscription.TrialEndsAt = &trialEnd
+		subscription.CurrentPeriodStart = &now
+		subscription.CurrentPeriodEnd = &trialEnd
+		startAt = trialEnd
+	}
+
+	if err := a.d.CreateSubscription(subscription); err != nil {
+		releaseCoupon()
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	subscriptionID := subscription.ID.Hex()
+
+	checkout, err := a.billing.Checkout(c.Request.Context(), billing.CheckoutRequest{
+		SubscriptionID: subscriptionID,
+		Email:          user.Email,
+		Plan:           plan,
+		StartAt:        startAt,
+		PercentOff:     subscription.PercentOff,
+		SuccessURL:     appURL() + "/assinatura/sucesso",
+		CancelURL:      appURL() + "/assinatura",
+	})
+	if err != nil {
+		log.Println("erro iniciando pagamento:", err)
+		a.d.EndSubscription(subscriptionID, model.SubscriptionExpired)
+		releaseCoupon()
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Falha ao iniciar o pagamento."})
+		return
+	}
+	if err := a.d.SetSubscriptionExternalID(subscriptionID, checkout.ExternalID); err != nil {
+		log.Println("erro salvando a referência do pagamento:", err)
+	}
+	subscription.ExternalID = checkout.ExternalID
+	if redemption != nil {
+		if err := a.d.SetRedemptionSubscription(redemption.ID.Hex(), subscriptionID); err != nil {
+			log.Println("erro associando cupom à assinatura:", err)
+		}
+	}
+
+	if subscription.Status == model.SubscriptionTrialing {
+		if err := a.setPremium(c, userID, true); err != nil {
+			log.Println("erro liberando o período de teste:", err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"subscription": subscription, "checkout_url": checkout.URL})
+}
+
+// CancelSubscription stops renewals. Premium stays until the end of the
+// period already paid.
+func (a *App) CancelSubscription(c *gin.Context) {
+	subscription, err := a.d.GetCurrentSubscription(currentUser(c).ID.Hex())
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": "Nenhuma assinatura em andamento."})
+		return
+	}
+
+	if subscription.ExternalID != "" {
+		if err := a.billing.Cancel(c.Request.Context(), subscription.ExternalID); err != nil {
+			log.Println("erro cancelando no provedor de pagamento:", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Falha ao cancelar a assinatura."})
+			return
+		}
+	}
+
+	updated, err := a.d.CancelSubscription(subscription.ID.Hex())
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// BillingWebhook receives payment notifications. Each event is handled
+// once; a failure forgets it so the provider's retry is processed.
+func (a *App) BillingWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	event, err := a.billing.ParseWebhook(c.GetHeader(billing.SignatureHeader), payload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook inválido."})
+		return
+	}
+
+	err = a.d.RecordBillingEvent(event.ID)
+	if errors.Is(err, db.ErrEventProcessed) {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.applyBillingEvent(c, event); err != nil {
+		log.Println("erro processando evento de pagamento", event.ID+":", err)
+		a.d.ForgetBillingEvent(event.ID)
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Evento processado."})
+}
+
+func (a *App) applyBillingEvent(c *gin.Context, event *billing.Event) error {
+	subscription, err := a.d.GetSubscriptionByID(event.Data.SubscriptionID)
+	if err != nil {
+		return err
+	}
+	subscriptionID := subscription.ID.Hex()
+
+	switch event.Type {
+	case billing.EventPaymentSucceeded:
+		start, end := event.Data.PeriodStart, event.Data.PeriodEnd
+		if start == nil || end == nil {
+			return errors.New("pagamento sem período")
+		}
+		if err := a.d.ActivateSubscription(subscriptionID, event.Data.ExternalID, *start, *end); err != nil {
+			return err
+		}
+		if end.After(time.Now()) {
+			return a.setPremium(c, subscription.UserID, true)
+		}
+	case billing.EventPaymentFailed:
+		err := a.d.SetSubscriptionPastDue(subscriptionID)
+		if err != nil && !errors.Is(err, db.ErrInvalidTransition) {
+			return err
+		}
+	case billing.EventSubscriptionCanceled:
+		_, err := a.d.CancelSubscription(subscriptionID)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+	default:
+		log.Println("evento de pagamento ignorado:", event.Type)
+	}
+	return nil
+}
+
+// setPremium updates the premium flag the rest of the API reads. c is nil
+// when a background job makes the change.
+func (a *App) setPremium(c *gin.Context, userID string, premium bool) error {
+	before, err := a.d.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if before.Premium == premium {
+		return nil
+	}
+
+	if err := a.d.SetUserPremium(userID, premium); err != nil {
+		return err
+	}
+	a.auditUserChange(c, model.AuditPremium, before)
+	a.invalidateUserCache(userID)
+	a.invalidateUsersCache()
+	return nil
+}
+
+func (a *App) subscriptionLoop() {
+	ticker := time.NewTicker(subscriptionCheckInterval)
+	defer ticker.Stop()
+	for {
+		a.expireSubscriptions()
+		a.expirePremiumGrants()
+		<-ticker.C
+	}
+}
+
+// expireSubscriptions ends subscriptions whose paid period or trial is
+// over and takes premium away from their users, unless premium days
+// granted by a promotion are still running.
+func (a *App) expireSubscriptions() {
+	now := time.Now().UTC()
+	subscriptions, err := a.d.GetDueSubscriptions(now, now.Add(-incompleteSubscriptionTimeout))
+	if err != nil {
+		log.Println("erro buscando assinaturas vencidas:", err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		status := model.SubscriptionExpired
+		if subscription.CancelAtPeriodEnd {
+			status = model.SubscriptionCanceled
+		}
+
+		if err := a.d.EndSubscription(subscription.ID.Hex(), status); err != nil {
+			if !errors.Is(err, db.ErrInvalidTransition) {
+				log.Println("erro encerrando assinatura:", err)
+			}
+			continue
+		}
+		if subscription.Status == model.SubscriptionIncomplete {
+			continue
+		}
+
+		user, err := a.d.GetUserByID(subscription.UserID)
+		if err == nil && user.PremiumUntil != nil && user.PremiumUntil.After(now) {
+			continue
+		}
+		if err == nil {
+			err = a.setPremium(nil, subscription.UserID, false)
+		}
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			log.Println("erro removendo premium:", err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"context"
+	"cucinia/billing"
+	"cucinia/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newBillingTestApp runs the API behind a real HTTP server so the local
+// provider delivers its webhooks to it, the way it does in development.
+func newBillingTestApp(t *testing.T) (*testApp, *billing.Local) {
+	local := billing.NewLocal("whsec_test", "")
+	ta := newTestAppWith(t, local, nil)
+	srv := httptest.NewServer(ta.router)
+	t.Cleanup(srv.Close)
+	local.WebhookURL = srv.URL + "/api/v1/billing/webhook"
+	return ta, local
+}
+
+// startPaidSubscription subscribes a user who already had their trial, so
+// the subscription waits for its first payment.
+func startPaidSubscription(t *testing.T, ta *testApp) (*model.User, *model.Subscription) {
+	t.Helper()
+	user, token := ta.addUser("ana@example.com", model.RoleUser)
+	trialEnd := time.Now().AddDate(0, -1, 0)
+	ta.db.subscriptions["old"] = &model.Subscription{ID: primitive.NewObjectID(), UserID: user.ID.Hex(), Status: model.SubscriptionExpired, TrialEndsAt: &trialEnd}
+
+	w := ta.do(http.MethodPost, "/api/v1/users/me/subscription", map[string]string{"plan": "monthly"}, token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("start subscription = %d %s", w.Code, w.Body)
+	}
+	subscription := decode[struct {
+		Subscription model.Subscription `json:"subscription"`
+	}](t, w).Subscription
+	if subscription.Status != model.SubscriptionIncomplete {
+		t.Fatalf("status = %q, want incomplete", subscription.Status)
+	}
+	if ta.db.user(user.ID.Hex()).Premium {
+		t.Fatal("premium granted before any payment")
+	}
+	return user, &subscription
+}
+
+func TestSubscriptionActivatesOnlyOnSignedWebhook(t *testing.T) {
+	ta, local := newBillingTestApp(t)
+	user, subscription := startPaidSubscription(t, ta)
+	stored, _ := ta.db.GetSubscriptionByID(subscription.ID.Hex())
+
+	start := time.Now().UTC()
+	event := billing.PaymentSucceeded(subscription.ID.Hex(), stored.ExternalID, start, start.AddDate(0, 1, 0))
+	payload, _ := json.Marshal(event)
+
+	for name, signature := range map[string]string{
+		"unsigned":     "",
+		"wrong secret": billing.Sign("not-the-secret", payload, time.Now()),
+		"stale":        billing.Sign("whsec_test", payload, time.Now().Add(-time.Hour)),
+	} {
+		w := ta.do(http.MethodPost, "/api/v1/billing/webhook", payload, "", billing.SignatureHeader, signature)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s webhook = %d, want 400", name, w.Code)
+		}
+		if ta.db.user(user.ID.Hex()).Premium {
+			t.Fatalf("%s webhook granted premium", name)
+		}
+	}
+
+	if err := local.Deliver(context.Background(), event); err != nil {
+		t.Fatal("signed webhook:", err)
+	}
+	if !ta.db.user(user.ID.Hex()).Premium {
+		t.Error("signed payment did not grant premium")
+	}
+	stored, _ = ta.db.GetSubscriptionByID(subscription.ID.Hex())
+	if stored.Status != model.SubscriptionActive {
+		t.Errorf("status = %q, want active", stored.Status)
+	}
+
+	// A replayed delivery is acknowledged but not applied twice.
+	if err := local.Deliver(context.Background(), event); err != nil {
+		t.Error("replayed webhook:", err)
+	}
+}
+
+func TestLocalAutoPayDeliversSignedWebhook(t *testing.T) {
+	ta, local := newBillingTestApp(t)
+	local.AutoPay = true
+	user, _ := ta.addUser("bia@example.com", model.RoleUser)
+	token, _ := ta.createSession(user)
+	trialEnd := time.Now().AddDate(0, -1, 0)
+	ta.db.subscriptions["old"] = &model.Subscription{ID: primitive.NewObjectID(), UserID: user.ID.Hex(), Status: model.SubscriptionExpired, TrialEndsAt: &trialEnd}
+
+	if w := ta.do(http.MethodPost, "/api/v1/users/me/subscription", map[string]string{"plan": "monthly"}, token); w.Code != http.StatusCreated {
+		t.Fatalf("start subscription = %d %s", w.Code, w.Body)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !ta.db.user(user.ID.Hex()).Premium {
+		if time.Now().After(deadline) {
+			t.Fatal("the local provider's payment never arrived")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubscriptionsUnavailableWithoutProvider(t *testing.T) {
+	ta := newTestApp(t)
+	user, token := ta.addUser("caio@example.com", model.RoleUser)
+
+	w := ta.do(http.MethodPost, "/api/v1/users/me/subscription", map[string]string{"plan": "monthly"}, token)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("start subscription = %d, want 503", w.Code)
+	}
+	if ta.db.user(user.ID.Hex()).Premium {
+		t.Error("premium granted without a payment provider")
+	}
+
+	payload := []byte(`{"id":"evt_1","type":"payment.succeeded"}`)
+	w = ta.do(http.MethodPost, "/api/v1/billing/webhook", payload, "", billing.SignatureHeader, billing.Sign("", payload, time.Now()))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("webhook = %d, want 400", w.Code)
+	}
+}
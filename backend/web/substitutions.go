@@ -0,0 +1,337 @@
+package web
+
+import (
+	"context"
+	"cucinia/ai"
+	"cucinia/model"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxSubstitutionRatio keeps typos such as 75 for 0.75 out of the
+	// knowledge base.
+	maxSubstitutionRatio = 10
+	substitutionTimeout  = 30 * time.Second
+)
+
+// checkAlternatives validates alternatives and normalizes them: names
+// trimmed, restrictions completed with the ones their names imply, sources
+// set. Repeated names are left out.
+func checkAlternatives(alternatives []model.Alternative, source string) ([]model.Alternative, error) {
+	checked := []model.Alternative{}
+	for _, alternative := range alternatives {
+		alternative.Name = strings.TrimSpace(alternative.Name)
+		alternative.Notes = strings.TrimSpace(alternative.Notes)
+		switch {
+		case alternative.Name == "" || len(alternative.Name) > 80:
+			return nil, errors.New("o nome do substituto deve ter de 1 a 80 caracteres")
+		case alternative.Ratio <= 0 || alternative.Ratio > maxSubstitutionRatio:
+			return nil, fmt.Errorf("a proporção de '%s' deve ser maior que 0 e no máximo %d", alternative.Name, maxSubstitutionRatio)
+		}
+		for _, restriction := range alternative.Restriction {
+			if !slices.Contains(restrictions, restriction) {
+				return nil, fmt.Errorf("a restrição '%s' não existe", restriction)
+			}
+		}
+		if hasAlternative(checked, alternative.Name) {
+			continue
+		}
+		alternative.Restriction = recipeRestrictions(alternative.Restriction, []string{alternative.Name})
+		if !slices.Contains([]string{model.SourceSeed, model.SourceAdmin, model.SourceAI}, alternative.Source) {
+			alternative.Source = source
+		}
+		checked = append(checked, alternative)
+	}
+	return checked, nil
+}
+
+func hasAlternative(alternatives []model.Alternative, name string) bool {
+	return slices.ContainsFunc(alternatives, func(alternative model.Alternative) bool {
+		return strings.EqualFold(alternative.Name, name)
+	})
+}
+
+func (a *App) GetSubstitutions(c *gin.Context) {
+	substitutions, err := a.d.GetSubstitutions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, substitutions)
+}
+
+func (a *App) CreateSubstitution(c *gin.Context) {
+	var substitution model.Substitution
+	if err := c.ShouldBindJSON(&substitution); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	alternatives, err := checkAlternatives(substitution.Alternatives, model.SourceAdmin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	substitution.Alternatives = alternatives
+
+	if err := a.d.CreateSubstitution(&substitution); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.audit(c, model.AuditCreate, model.EntitySubstitution, substitution.ID.Hex(), nil, substitution)
+
+	c.JSON(http.StatusCreated, substitution)
+}
+
+// UpdateSubstitution replaces the alternatives of an ingredient. Sources
+// sent back as they were listed are kept, so the origin of each
+// alternative survives edits.
+func (a *App) UpdateSubstitution(c *gin.Context) {
+	id := c.Param("id")
+
+	var payload struct {
+		Alternatives []model.Alternative `json:"alternatives" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+	alternatives, err := checkAlternatives(payload.Alternatives, model.SourceAdmin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := a.d.GetSubstitutionByID(id)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	substitution, err := a.d.UpdateSubstitution(id, alternatives)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.audit(c, model.AuditUpdate, model.EntitySubstitution, id, before, substitution)
+
+	c.JSON(http.StatusOK, substitution)
+}
+
+func (a *App) DeleteSubstitution(c *gin.Context) {
+	id := c.Param("id")
+
+	before, err := a.d.GetSubstitutionByID(id)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	if err := a.d.DeleteSubstitution(id); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.audit(c, model.AuditDelete, model.EntitySubstitution, id, before, nil)
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// EnrichSubstitution asks the AI provider for alternatives the knowledge
+// base does not have yet and adds the valid ones, marked as coming from
+// it. Admins review them like any other entry.
+func (a *App) EnrichSubstitution(c *gin.Context) {
+	id := c.Param("id")
+
+	before, err := a.d.GetSubstitutionByID(id)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	req := ai.SubstitutionRequest{
+		Ingredient:   before.Ingredient,
+		Restrictions: impliedRestrictions(before.Ingredient),
+		Unit:         before.Ingredient,
+	}
+	for _, alternative := range before.Alternatives {
+		req.Known = append(req.Known, alternative.Name)
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), substitutionTimeout)
+	defer cancel()
+	answer, err := a.ai.SuggestSubstitutions(ctx, req)
+	if err != nil && ctx.Err() == nil {
+		recordPromptOutcome(a.rdb, ai.TaskSubstitution, answer.Prompt, outcomeError, 1)
+	}
+	switch {
+	case errors.Is(err, ai.ErrNotConfigured):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Sugestões da IA indisponíveis."})
+		return
+	case err != nil:
+		log.Println("erro sugerindo substituições:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Falha ao consultar a IA."})
+		return
+	}
+
+	suggested, err := ai.ParseSubstitutions(answer.Text)
+	if err != nil {
+		recordPromptOutcome(a.rdb, ai.TaskSubstitution, answer.Prompt, outcomeInvalid, 1)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "A IA não sugeriu substituições válidas: " + err.Error()})
+		return
+	}
+
+	alternatives := slices.Clone(before.Alternatives)
+	added := 0
+	for _, suggestion := range suggested {
+		checked, err := checkAlternatives([]model.Alternative{{
+			Name:        suggestion.Name,
+			Ratio:       suggestion.Ratio,
+			Notes:       suggestion.Notes,
+			Restriction: suggestion.Restriction,
+		}}, model.SourceAI)
+		if err != nil {
+			log.Println("substituição sugerida recusada:", err)
+			continue
+		}
+		if len(checked) == 0 || hasAlternative(alternatives, checked[0].Name) || strings.EqualFold(checked[0].Name, before.Ingredient) {
+			continue
+		}
+		checked[0].PromptVersion = answer.Prompt
+		alternatives = append(alternatives, checked[0])
+		added++
+	}
+	recordPromptOutcome(a.rdb, ai.TaskSubstitution, answer.Prompt, outcomeValid, 1)
+	recordPromptOutcome(a.rdb, ai.TaskSubstitution, answer.Prompt, outcomeAdded, int64(added))
+
+	substitution := before
+	if added > 0 {
+		substitution, err = a.d.UpdateSubstitution(id, alternatives)
+		if err != nil {
+			c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+		a.audit(c, model.AuditUpdate, model.EntitySubstitution, id, before, substitution)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"substitution": substitution, "added": added})
+}
+
+// substitutionOption is an alternative as offered for a recipe.
+type substitutionOption struct {
+	model.Alternative
+	InPantry bool `json:"in_pantry,omitempty"`
+}
+
+type recipeSubstitution struct {
+	Ingredient string `json:"ingredient"`
+	// Missing and Breaks tell, for the caller, why the ingredient needs
+	// replacing: it is not in their pantry, or it breaks some of their
+	// restrictions.
+	Missing      bool                 `json:"missing,omitempty"`
+	Breaks       []string             `json:"breaks,omitempty"`
+	Alternatives []substitutionOption `json:"alternatives"`
+}
+
+// GetRecipeSubstitutions lists the alternatives to the ingredients of a
+// recipe. With for=me it only lists the ingredients the caller lacks or
+// cannot eat, leaves out alternatives that break their restrictions and
+// puts the ones in their pantry first.
+func (a *App) GetRecipeSubstitutions(c *gin.Context) {
+	var user *model.User
+	switch c.Query("for") {
+	case "":
+	case "me":
+		if user = currentUser(c); user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Autenticação necessária."})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parâmetro 'for' inválido."})
+		return
+	}
+
+	recipe, err := a.d.GetRecipeByID(c.Param("id"))
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	if !isPublished(recipe) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receita não encontrada."})
+		return
+	}
+	if recipeForTier(recipe, a.accessTier(c)).Locked {
+		abortUpgradeRequired(c, featurePremiumRecipes)
+		return
+	}
+
+	substitutions, err := a.d.GetSubstitutionsFor(recipe.Ingredients)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	known := map[string]*model.Substitution{}
+	for _, substitution := range substitutions {
+		known[substitution.Ingredient] = substitution
+	}
+
+	pantry := map[string]bool{}
+	var userRestrictions []string
+	if user != nil {
+		for _, ingredient := range user.Ingredients {
+			pantry[strings.ToLower(strings.TrimSpace(ingredient))] = true
+		}
+		userRestrictions = user.Restriction
+	}
+
+	result := []recipeSubstitution{}
+	for _, ingredient := range recipe.Ingredients {
+		key := strings.ToLower(strings.TrimSpace(ingredient))
+		entry := recipeSubstitution{Ingredient: ingredient, Alternatives: []substitutionOption{}}
+		if user != nil {
+			entry.Missing = !pantry[key]
+			implied := recipeRestrictions(nil, []string{ingredient})
+			for _, restriction := range userRestrictions {
+				if slices.Contains(implied, restriction) {
+					entry.Breaks = append(entry.Breaks, restriction)
+				}
+			}
+			if !entry.Missing && len(entry.Breaks) == 0 {
+				continue
+			}
+		}
+
+		if substitution := known[key]; substitution != nil {
+			for _, alternative := range substitution.Alternatives {
+				broken := recipeRestrictions(alternative.Restriction, []string{alternative.Name})
+				if breaksAny(broken, userRestrictions) != "" {
+					continue
+				}
+				entry.Alternatives = append(entry.Alternatives, substitutionOption{
+					Alternative: alternative,
+					InPantry:    pantry[strings.ToLower(alternative.Name)],
+				})
+			}
+			sort.SliceStable(entry.Alternatives, func(i, j int) bool {
+				return entry.Alternatives[i].InPantry && !entry.Alternatives[j].InPantry
+			})
+		}
+		if user == nil && len(entry.Alternatives) == 0 {
+			continue
+		}
+		result = append(result, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipe_id": recipe.ID.Hex(), "substitutions": result})
+}
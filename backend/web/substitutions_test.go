@@ -0,0 +1,309 @@
+package web
+
+import (
+	"context"
+	"cucinia/ai"
+	"cucinia/billing"
+	"cucinia/model"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// substitutionAI answers SuggestSubstitutions with suggest and everything
+// else like ai.Fake.
+type substitutionAI struct {
+	ai.Fake
+	suggest func(ctx context.Context, req ai.SubstitutionRequest) (ai.Answer, error)
+}
+
+func (p substitutionAI) SuggestSubstitutions(ctx context.Context, req ai.SubstitutionRequest) (ai.Answer, error) {
+	return p.suggest(ctx, req)
+}
+
+func (ta *testApp) addSubstitution(ingredient string, alternatives ...model.Alternative) *model.Substitution {
+	ta.t.Helper()
+	substitution := &model.Substitution{Ingredient: ingredient, Alternatives: alternatives}
+	if err := ta.db.CreateSubstitution(substitution); err != nil {
+		ta.t.Fatal(err)
+	}
+	return substitution
+}
+
+func (ta *testApp) promptOutcome(task, version, outcome string) string {
+	if !ta.redis.Exists(promptMetricsPrefix + task) {
+		return ""
+	}
+	return ta.redis.HGet(promptMetricsPrefix+task, version+":"+outcome)
+}
+
+func TestSubstitutionCRUD(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("admin@example.com", model.RoleAdmin)
+	_, editorToken := ta.addUser("editor@example.com", model.RoleEditor)
+
+	create := map[string]any{
+		"ingredient": " Manteiga ",
+		"alternatives": []map[string]any{
+			{"name": " Óleo ", "ratio": 0.75},
+			{"name": "óleo", "ratio": 1},
+			{"name": "manteiga de garrafa", "ratio": 1},
+		},
+	}
+	if w := ta.do(http.MethodPost, "/api/v1/admin/substitutions", create, editorToken); w.Code != http.StatusForbidden {
+		t.Errorf("create by an editor = %d, want 403", w.Code)
+	}
+	w := ta.do(http.MethodPost, "/api/v1/admin/substitutions", create, token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+	created := decode[model.Substitution](t, w)
+	if created.Ingredient != "manteiga" || len(created.Alternatives) != 2 {
+		t.Fatalf("created %+v, want manteiga with the repeated óleo left out", created)
+	}
+	oil, clarified := created.Alternatives[0], created.Alternatives[1]
+	if oil.Name != "Óleo" || oil.Ratio != 0.75 || oil.Source != model.SourceAdmin || len(oil.Restriction) != 0 {
+		t.Errorf("óleo = %+v", oil)
+	}
+	if clarified.Source != model.SourceAdmin || len(clarified.Restriction) != 2 {
+		t.Errorf("manteiga de garrafa = %+v, want the restrictions of butter", clarified)
+	}
+	if w := ta.do(http.MethodPost, "/api/v1/admin/substitutions", create, token); w.Code != http.StatusBadRequest {
+		t.Errorf("second substitution for manteiga = %d, want 400", w.Code)
+	}
+
+	id := created.ID.Hex()
+	for name, alternatives := range map[string][]map[string]any{
+		"no name":             {{"name": " ", "ratio": 1}},
+		"ratio of 0":          {{"name": "óleo", "ratio": 0}},
+		"ratio typo":          {{"name": "óleo", "ratio": 75}},
+		"unknown restriction": {{"name": "óleo", "ratio": 1, "restriction": []string{"kosher"}}},
+	} {
+		if w := ta.do(http.MethodPatch, "/api/v1/admin/substitutions/"+id, map[string]any{"alternatives": alternatives}, token); w.Code != http.StatusBadRequest {
+			t.Errorf("update with %s = %d, want 400", name, w.Code)
+		}
+	}
+
+	w = ta.do(http.MethodPatch, "/api/v1/admin/substitutions/"+id, map[string]any{"alternatives": []map[string]any{
+		{"name": "Óleo", "ratio": 0.8, "source": model.SourceSeed},
+		{"name": "purê de maçã", "ratio": 0.5, "source": "qualquer"},
+	}}, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update = %d %s", w.Code, w.Body)
+	}
+	updated := decode[model.Substitution](t, w)
+	if len(updated.Alternatives) != 2 || updated.Alternatives[0].Source != model.SourceSeed || updated.Alternatives[1].Source != model.SourceAdmin {
+		t.Errorf("updated alternatives = %+v, want the seed source kept and unknown ones set to admin", updated.Alternatives)
+	}
+
+	missing := primitive.NewObjectID().Hex()
+	if w := ta.do(http.MethodPatch, "/api/v1/admin/substitutions/"+missing, map[string]any{"alternatives": []map[string]any{}}, token); w.Code != http.StatusNotFound {
+		t.Errorf("update of a missing substitution = %d, want 404", w.Code)
+	}
+	if w := ta.do(http.MethodDelete, "/api/v1/admin/substitutions/"+id, nil, token); w.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d %s", w.Code, w.Body)
+	}
+	if w := ta.do(http.MethodDelete, "/api/v1/admin/substitutions/"+id, nil, token); w.Code != http.StatusNotFound {
+		t.Errorf("second delete = %d, want 404", w.Code)
+	}
+	w = ta.do(http.MethodGet, "/api/v1/admin/substitutions", nil, token)
+	if list := decode[[]model.Substitution](t, w); w.Code != http.StatusOK || len(list) != 0 {
+		t.Errorf("list after delete = %d %v", w.Code, list)
+	}
+
+	var actions []string
+	for _, entry := range ta.db.audit {
+		if entry.Entity == model.EntitySubstitution && entry.EntityID == id {
+			actions = append(actions, entry.Action)
+		}
+	}
+	if len(actions) != 3 || actions[0] != model.AuditCreate || actions[1] != model.AuditUpdate || actions[2] != model.AuditDelete {
+		t.Errorf("audit actions = %v", actions)
+	}
+}
+
+func TestEnrichSubstitutionAddsNewValidSuggestions(t *testing.T) {
+	var asked ai.SubstitutionRequest
+	suggestions := []ai.SuggestedAlternative{
+		{Name: "óleo", Ratio: 1, Restriction: []string{}},
+		{Name: "Manteiga", Ratio: 1, Restriction: []string{}},
+		{Name: "purê de abóbora", Ratio: 0.5, Notes: "Deixa a massa úmida.", Restriction: []string{}},
+		{Name: "Purê de abóbora", Ratio: 1, Restriction: []string{}},
+		{Name: "", Ratio: 1, Restriction: []string{}},
+		{Name: "azeite", Ratio: 50, Restriction: []string{}},
+		{Name: "banha", Ratio: 1, Restriction: []string{"halal"}},
+	}
+	provider := substitutionAI{suggest: func(ctx context.Context, req ai.SubstitutionRequest) (ai.Answer, error) {
+		asked = req
+		text, err := json.Marshal(suggestions)
+		return ai.Answer{Text: string(text), Prompt: "v1"}, err
+	}}
+	ta := newTestAppWith(t, billing.None{}, provider)
+	_, token := ta.addUser("admin@example.com", model.RoleAdmin)
+	substitution := ta.addSubstitution("manteiga", model.Alternative{Name: "Óleo", Ratio: 0.75, Restriction: []string{}, Source: model.SourceSeed})
+
+	w := ta.do(http.MethodPost, "/api/v1/admin/substitutions/"+substitution.ID.Hex()+"/enrich", nil, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("enrich = %d %s", w.Code, w.Body)
+	}
+	if asked.Ingredient != "manteiga" || len(asked.Known) != 1 || asked.Known[0] != "Óleo" {
+		t.Errorf("provider asked %+v, want manteiga with Óleo known", asked)
+	}
+	result := decode[struct {
+		Substitution model.Substitution `json:"substitution"`
+		Added        int                `json:"added"`
+	}](t, w)
+	alternatives := result.Substitution.Alternatives
+	if result.Added != 1 || len(alternatives) != 2 {
+		t.Fatalf("added %d, alternatives %+v", result.Added, alternatives)
+	}
+	if kept := alternatives[0]; kept.Name != "Óleo" || kept.Source != model.SourceSeed {
+		t.Errorf("known alternative changed to %+v", kept)
+	}
+	if added := alternatives[1]; added.Name != "purê de abóbora" || added.Ratio != 0.5 || added.Source != model.SourceAI || added.PromptVersion != "v1" {
+		t.Errorf("added %+v, want the pumpkin marked as suggested by the AI", added)
+	}
+	if stored, _ := ta.db.GetSubstitutionByID(substitution.ID.Hex()); len(stored.Alternatives) != 2 {
+		t.Errorf("stored alternatives = %+v", stored.Alternatives)
+	}
+	if valid, added := ta.promptOutcome(ai.TaskSubstitution, "v1", outcomeValid), ta.promptOutcome(ai.TaskSubstitution, "v1", outcomeAdded); valid != "1" || added != "1" {
+		t.Errorf("prompt outcomes valid=%q added=%q, want 1 and 1", valid, added)
+	}
+}
+
+func TestEnrichSubstitutionWithFakeAI(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("admin@example.com", model.RoleAdmin)
+	substitution := ta.addSubstitution("ovo")
+	path := "/api/v1/admin/substitutions/" + substitution.ID.Hex() + "/enrich"
+
+	enrich := func() int {
+		t.Helper()
+		w := ta.do(http.MethodPost, path, nil, token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("enrich = %d %s", w.Code, w.Body)
+		}
+		return decode[struct {
+			Added int `json:"added"`
+		}](t, w).Added
+	}
+	if added := enrich(); added != 1 {
+		t.Fatalf("first enrich added %d, want 1", added)
+	}
+	audited := len(ta.db.audit)
+	if added := enrich(); added != 0 {
+		t.Errorf("second enrich added %d, want nothing already known", added)
+	}
+	if len(ta.db.audit) != audited {
+		t.Error("an enrich that added nothing was audited")
+	}
+	if stored, _ := ta.db.GetSubstitutionByID(substitution.ID.Hex()); len(stored.Alternatives) != 1 || stored.Alternatives[0].Source != model.SourceAI {
+		t.Errorf("stored alternatives = %+v", stored.Alternatives)
+	}
+}
+
+func TestEnrichSubstitutionProviderFailures(t *testing.T) {
+	tests := map[string]struct {
+		answer  ai.Answer
+		err     error
+		want    int
+		outcome string
+	}{
+		"not configured": {ai.Answer{}, ai.ErrNotConfigured, http.StatusServiceUnavailable, ""},
+		"timeout":        {ai.Answer{Prompt: "v1"}, context.DeadlineExceeded, http.StatusBadGateway, outcomeError},
+		"provider error": {ai.Answer{Prompt: "v1"}, errors.New("500 do provedor"), http.StatusBadGateway, outcomeError},
+		"not json":       {ai.Answer{Text: "Use óleo.", Prompt: "v1"}, nil, http.StatusBadGateway, outcomeInvalid},
+		"extra fields":   {ai.Answer{Text: `[{"name":"óleo","ratio":1,"notes":"","restriction":[],"nota":10}]`, Prompt: "v1"}, nil, http.StatusBadGateway, outcomeInvalid},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			provider := substitutionAI{suggest: func(ctx context.Context, req ai.SubstitutionRequest) (ai.Answer, error) {
+				return tt.answer, tt.err
+			}}
+			ta := newTestAppWith(t, billing.None{}, provider)
+			_, token := ta.addUser("admin@example.com", model.RoleAdmin)
+			substitution := ta.addSubstitution("manteiga", model.Alternative{Name: "óleo", Ratio: 0.75, Restriction: []string{}, Source: model.SourceSeed})
+
+			if w := ta.do(http.MethodPost, "/api/v1/admin/substitutions/"+substitution.ID.Hex()+"/enrich", nil, token); w.Code != tt.want {
+				t.Errorf("enrich = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if stored, _ := ta.db.GetSubstitutionByID(substitution.ID.Hex()); len(stored.Alternatives) != 1 {
+				t.Errorf("alternatives changed to %+v", stored.Alternatives)
+			}
+			if tt.outcome != "" && ta.promptOutcome(ai.TaskSubstitution, "v1", tt.outcome) != "1" {
+				t.Errorf("%s outcome not recorded", tt.outcome)
+			}
+		})
+	}
+
+	ta := newTestApp(t)
+	_, token := ta.addUser("admin@example.com", model.RoleAdmin)
+	if w := ta.do(http.MethodPost, "/api/v1/admin/substitutions/"+primitive.NewObjectID().Hex()+"/enrich", nil, token); w.Code != http.StatusNotFound {
+		t.Errorf("enrich of a missing substitution = %d, want 404", w.Code)
+	}
+}
+
+func TestRecipeSubstitutionsForMe(t *testing.T) {
+	ta := newTestApp(t)
+	recipe := &model.Recipe{ID: primitive.NewObjectID(), Name: "Bolo", Status: model.RecipePublished, Ingredients: []string{"Manteiga", "farinha de trigo", "ovo"}}
+	ta.db.recipes[recipe.ID.Hex()] = recipe
+	ta.addSubstitution("manteiga",
+		model.Alternative{Name: "ghee", Ratio: 1, Restriction: []string{restrictionDairy}},
+		model.Alternative{Name: "margarina", Ratio: 1, Restriction: []string{}},
+		model.Alternative{Name: "óleo", Ratio: 0.75, Restriction: []string{}},
+	)
+	ta.addSubstitution("farinha de trigo", model.Alternative{Name: "farinha de arroz", Ratio: 1, Restriction: []string{}})
+	user, token := ta.addUser("ana@example.com", model.RoleUser)
+	ta.db.updateUser(user.ID.Hex(), func(user *model.User) {
+		user.Restriction = []string{restrictionDairy}
+		user.Ingredients = []string{"Ovo", "óleo"}
+	})
+
+	type result struct {
+		Substitutions []struct {
+			Ingredient   string   `json:"ingredient"`
+			Missing      bool     `json:"missing"`
+			Breaks       []string `json:"breaks"`
+			Alternatives []struct {
+				Name     string `json:"name"`
+				InPantry bool   `json:"in_pantry"`
+			} `json:"alternatives"`
+		} `json:"substitutions"`
+	}
+	path := "/api/v1/recipes/" + recipe.ID.Hex() + "/substitutions"
+
+	w := ta.do(http.MethodGet, path, nil, "")
+	all := decode[result](t, w)
+	if w.Code != http.StatusOK || len(all.Substitutions) != 2 || len(all.Substitutions[0].Alternatives) != 3 {
+		t.Fatalf("substitutions for anyone = %d %+v, want every known alternative", w.Code, all)
+	}
+
+	w = ta.do(http.MethodGet, path+"?for=me", nil, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("for=me = %d %s", w.Code, w.Body)
+	}
+	mine := decode[result](t, w).Substitutions
+	if len(mine) != 2 {
+		t.Fatalf("for=me listed %+v, want butter and flour but not the egg in the pantry", mine)
+	}
+	butter, flour := mine[0], mine[1]
+	if butter.Ingredient != "Manteiga" || !butter.Missing || len(butter.Breaks) != 1 || butter.Breaks[0] != restrictionDairy {
+		t.Errorf("butter = %+v, want missing and breaking laticinio", butter)
+	}
+	if names := len(butter.Alternatives); names != 2 || butter.Alternatives[0].Name != "óleo" || !butter.Alternatives[0].InPantry || butter.Alternatives[1].Name != "margarina" {
+		t.Errorf("butter alternatives = %+v, want óleo from the pantry first and no ghee", butter.Alternatives)
+	}
+	if flour.Ingredient != "farinha de trigo" || !flour.Missing || len(flour.Breaks) != 0 || len(flour.Alternatives) != 1 {
+		t.Errorf("flour = %+v", flour)
+	}
+
+	if w := ta.do(http.MethodGet, path+"?for=me", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("for=me without a session = %d, want 401", w.Code)
+	}
+	if w := ta.do(http.MethodGet, path+"?for=everyone", nil, token); w.Code != http.StatusBadRequest {
+		t.Errorf("for=everyone = %d, want 400", w.Code)
+	}
+}
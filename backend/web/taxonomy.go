@@ -0,0 +1,169 @@
+package web
+
+import (
+	"cucinia/model"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (a *App) GetTaxonomies(c *gin.Context) {
+	val, err := a.rdb.Get("taxonomies").Result()
+	if err == nil {
+		var taxonomies model.Taxonomies
+		if err := json.Unmarshal([]byte(val), &taxonomies); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, taxonomies)
+		return
+	}
+
+	cuisines, err := a.d.GetCuisines()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	mealTypes, err := a.d.GetMealTypes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	taxonomies := model.Taxonomies{Cuisines: cuisines, MealTypes: mealTypes}
+
+	taxonomiesJSON, err := json.Marshal(taxonomies)
+	if err == nil {
+		a.rdb.Set("taxonomies", taxonomiesJSON, 0)
+	}
+
+	c.JSON(http.StatusOK, taxonomies)
+}
+
+func (a *App) CreateCuisine(c *gin.Context) {
+	var cuisine model.Cuisine
+	if err := c.ShouldBindJSON(&cuisine); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.d.CreateCuisine(&cuisine); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.rdb.Del("taxonomies")
+	a.audit(c, model.AuditCreate, model.EntityCuisine, cuisine.ID.Hex(), nil, cuisine)
+
+	c.JSON(http.StatusCreated, cuisine)
+}
+
+func (a *App) UpdateCuisine(c *gin.Context) {
+	id := c.Param("id")
+	var cuisine model.Cuisine
+	if err := c.ShouldBindJSON(&cuisine); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := a.d.GetCuisineByID(id)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.d.UpdateCuisine(id, &cuisine); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.rdb.Del("taxonomies")
+	after, _ := a.d.GetCuisineByID(id)
+	a.audit(c, model.AuditUpdate, model.EntityCuisine, id, before, after)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Culinária atualizada."})
+}
+
+func (a *App) DeleteCuisine(c *gin.Context) {
+	id := c.Param("id")
+
+	before, err := a.d.GetCuisineByID(id)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	if err := a.d.DeleteCuisine(id); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.rdb.Del("taxonomies")
+	a.audit(c, model.AuditDelete, model.EntityCuisine, id, before, nil)
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+func (a *App) CreateMealType(c *gin.Context) {
+	var mealType model.MealType
+	if err := c.ShouldBindJSON(&mealType); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.d.CreateMealType(&mealType); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.rdb.Del("taxonomies")
+	a.audit(c, model.AuditCreate, model.EntityMealType, mealType.ID.Hex(), nil, mealType)
+
+	c.JSON(http.StatusCreated, mealType)
+}
+
+func (a *App) UpdateMealType(c *gin.Context) {
+	id := c.Param("id")
+	var mealType model.MealType
+	if err := c.ShouldBindJSON(&mealType); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := a.d.GetMealTypeByID(id)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.d.UpdateMealType(id, &mealType); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.rdb.Del("taxonomies")
+	after, _ := a.d.GetMealTypeByID(id)
+	a.audit(c, model.AuditUpdate, model.EntityMealType, id, before, after)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tipo de refeição atualizado."})
+}
+
+func (a *App) DeleteMealType(c *gin.Context) {
+	id := c.Param("id")
+
+	before, err := a.d.GetMealTypeByID(id)
+	if err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	if err := a.d.DeleteMealType(id); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	a.rdb.Del("taxonomies")
+	a.audit(c, model.AuditDelete, model.EntityMealType, id, before, nil)
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
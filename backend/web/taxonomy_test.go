@@ -0,0 +1,207 @@
+package web
+
+import (
+	"cucinia/model"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTaxonomyChangesAreAudited(t *testing.T) {
+	ta := newTestApp(t)
+	admin, token := ta.addUser("admin@example.com", model.RoleAdmin)
+
+	for _, tt := range []struct {
+		entity, path string
+		create       map[string]any
+	}{
+		{model.EntityCuisine, "/api/v1/admin/cuisines", map[string]any{"slug": "japonesa", "labels": map[string]string{"pt-BR": "Japonesa"}}},
+		{model.EntityMealType, "/api/v1/admin/meal-types", map[string]any{"code": 6, "slug": "ceia", "labels": map[string]string{"pt-BR": "Ceia"}}},
+	} {
+		ta.db.audit = nil
+
+		w := ta.do(http.MethodPost, tt.path, tt.create, token)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create %s = %d %s", tt.entity, w.Code, w.Body)
+		}
+		id := decode[struct {
+			ID string `json:"id"`
+		}](t, w).ID
+		if w := ta.do(http.MethodPatch, tt.path+"/"+id, map[string]any{"labels": map[string]string{"pt-BR": "Nova"}, "order": 9}, token); w.Code != http.StatusOK {
+			t.Fatalf("update %s = %d %s", tt.entity, w.Code, w.Body)
+		}
+		if w := ta.do(http.MethodDelete, tt.path+"/"+id, nil, token); w.Code != http.StatusNoContent {
+			t.Fatalf("delete %s = %d %s", tt.entity, w.Code, w.Body)
+		}
+
+		if len(ta.db.audit) != 3 {
+			t.Fatalf("%s: %d audit entries, want 3", tt.entity, len(ta.db.audit))
+		}
+		for i, action := range []string{model.AuditCreate, model.AuditUpdate, model.AuditDelete} {
+			entry := ta.db.audit[i]
+			if entry.Action != action || entry.Entity != tt.entity || entry.EntityID != id || entry.ActorID != admin.ID.Hex() {
+				t.Errorf("%s entry %d = %s %s %s by %s", tt.entity, i, entry.Action, entry.Entity, entry.EntityID, entry.ActorID)
+			}
+		}
+		create, update, remove := ta.db.audit[0], ta.db.audit[1], ta.db.audit[2]
+		if create.Before != nil || create.After["slug"] != tt.create["slug"] {
+			t.Errorf("%s create snapshots = %v / %v", tt.entity, create.Before, create.After)
+		}
+		if update.Before["order"] == update.After["order"] || update.After["labels"].(map[string]interface{})["pt-BR"] != "Nova" {
+			t.Errorf("%s update snapshots = %v / %v", tt.entity, update.Before, update.After)
+		}
+		if remove.Before["slug"] != tt.create["slug"] || remove.After != nil {
+			t.Errorf("%s delete snapshots = %v / %v", tt.entity, remove.Before, remove.After)
+		}
+	}
+}
+
+func TestTaxonomyDuplicatesAndDeletesInUse(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("admin@example.com", model.RoleAdmin)
+
+	for _, tt := range []struct {
+		path       string
+		create     map[string]any
+		duplicates []map[string]any
+		use        func(recipe *model.Recipe)
+	}{
+		{
+			path:   "/api/v1/admin/cuisines",
+			create: map[string]any{"slug": "japonesa"},
+			duplicates: []map[string]any{
+				{"slug": "japonesa"},
+				{"slug": " Japonesa "},
+			},
+			use: func(recipe *model.Recipe) { recipe.Cuisine = "japonesa" },
+		},
+		{
+			path:   "/api/v1/admin/meal-types",
+			create: map[string]any{"code": 6, "slug": "ceia"},
+			duplicates: []map[string]any{
+				{"code": 6, "slug": "ceia-tardia"},
+				{"code": 7, "slug": "Ceia"},
+			},
+			use: func(recipe *model.Recipe) { recipe.TypeOf = 6 },
+		},
+	} {
+		w := ta.do(http.MethodPost, tt.path, tt.create, token)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create in %s = %d %s", tt.path, w.Code, w.Body)
+		}
+		id := decode[struct {
+			ID string `json:"id"`
+		}](t, w).ID
+
+		for _, duplicate := range tt.duplicates {
+			if w := ta.do(http.MethodPost, tt.path, duplicate, token); w.Code != http.StatusBadRequest {
+				t.Errorf("create %v in %s = %d, want 400", duplicate, tt.path, w.Code)
+			}
+		}
+
+		recipe := &model.Recipe{ID: primitive.NewObjectID(), Name: "Bolo", Status: model.RecipePublished}
+		tt.use(recipe)
+		ta.db.recipes[recipe.ID.Hex()] = recipe
+		if w := ta.do(http.MethodDelete, tt.path+"/"+id, nil, token); w.Code != http.StatusBadRequest {
+			t.Errorf("delete of an entry in use in %s = %d, want 400", tt.path, w.Code)
+		}
+
+		delete(ta.db.recipes, recipe.ID.Hex())
+		if w := ta.do(http.MethodDelete, tt.path+"/"+id, nil, token); w.Code != http.StatusNoContent {
+			t.Errorf("delete of an unused entry in %s = %d %s", tt.path, w.Code, w.Body)
+		}
+		if w := ta.do(http.MethodDelete, tt.path+"/"+id, nil, token); w.Code != http.StatusNotFound {
+			t.Errorf("second delete in %s = %d, want 404", tt.path, w.Code)
+		}
+	}
+}
+
+func TestTaxonomyWritesInvalidateCache(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("admin@example.com", model.RoleAdmin)
+
+	taxonomies := func() model.Taxonomies {
+		t.Helper()
+		w := ta.do(http.MethodGet, "/api/v1/taxonomies", nil, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("taxonomies = %d %s", w.Code, w.Body)
+		}
+		if !ta.redis.Exists("taxonomies") {
+			t.Fatal("taxonomies not cached")
+		}
+		return decode[model.Taxonomies](t, w)
+	}
+	write := func(method, path string, body any) string {
+		t.Helper()
+		taxonomies()
+		w := ta.do(method, path, body, token)
+		if w.Code >= http.StatusBadRequest {
+			t.Fatalf("%s %s = %d %s", method, path, w.Code, w.Body)
+		}
+		if ta.redis.Exists("taxonomies") {
+			t.Errorf("%s %s left the taxonomies cached", method, path)
+		}
+		if w.Code == http.StatusNoContent {
+			return ""
+		}
+		return decode[struct {
+			ID string `json:"id"`
+		}](t, w).ID
+	}
+
+	cuisine := write(http.MethodPost, "/api/v1/admin/cuisines", map[string]any{"slug": "japonesa", "order": 1})
+	write(http.MethodPatch, "/api/v1/admin/cuisines/"+cuisine, map[string]any{"order": 2})
+	if got := taxonomies().Cuisines; len(got) != 1 || got[0].Order != 2 {
+		t.Errorf("cuisines after update = %+v", got)
+	}
+	mealType := write(http.MethodPost, "/api/v1/admin/meal-types", map[string]any{"code": 6, "slug": "ceia"})
+	write(http.MethodPatch, "/api/v1/admin/meal-types/"+mealType, map[string]any{"icon": "🌜"})
+	if got := taxonomies().MealTypes; len(got) != 1 || got[0].Icon != "🌜" {
+		t.Errorf("meal types after update = %+v", got)
+	}
+
+	write(http.MethodDelete, "/api/v1/admin/cuisines/"+cuisine, nil)
+	write(http.MethodDelete, "/api/v1/admin/meal-types/"+mealType, nil)
+	if got := taxonomies(); len(got.Cuisines) != 0 || len(got.MealTypes) != 0 {
+		t.Errorf("taxonomies after delete = %+v", got)
+	}
+}
+
+func TestRecipesRejectUnknownTaxonomies(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.addUser("editor@example.com", model.RoleEditor)
+	ta.db.cuisines = []*model.Cuisine{{ID: primitive.NewObjectID(), Slug: "italiana"}}
+	ta.db.mealTypes = []*model.MealType{{ID: primitive.NewObjectID(), Code: 2, Slug: "almoco"}}
+
+	recipe := func(cuisine string, typeOf int) map[string]any {
+		return map[string]any{"name": "Lasanha", "cuisine": cuisine, "type_of": typeOf, "ingredients": []string{"massa"}}
+	}
+	for name, body := range map[string]map[string]any{
+		"unknown cuisine":   recipe("marciana", 2),
+		"unknown meal type": recipe("italiana", 9),
+		"no cuisine":        recipe("", 2),
+	} {
+		if w := ta.do(http.MethodPost, "/api/v1/recipes", body, token); w.Code != http.StatusBadRequest {
+			t.Errorf("create with %s = %d, want 400", name, w.Code)
+		}
+	}
+
+	w := ta.do(http.MethodPost, "/api/v1/recipes", recipe("italiana", 2), token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+	id := decode[model.Recipe](t, w).ID.Hex()
+
+	for name, body := range map[string]map[string]any{
+		"unknown cuisine":   recipe("marciana", 2),
+		"unknown meal type": recipe("italiana", 9),
+	} {
+		if w := ta.do(http.MethodPatch, "/api/v1/recipes/"+id, body, token, "If-Match", `"1"`); w.Code != http.StatusBadRequest {
+			t.Errorf("update with %s = %d, want 400", name, w.Code)
+		}
+	}
+	if stored, _ := ta.db.GetRecipeByID(id); stored.Version != 1 || stored.Cuisine != "italiana" {
+		t.Errorf("rejected updates changed the recipe to %+v", stored)
+	}
+}
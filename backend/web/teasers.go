@@ -0,0 +1,78 @@
+package web
+
+import (
+	"cucinia/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tierFree    = "free"
+	tierPremium = "premium"
+)
+
+const teaserDescriptionLength = 140
+
+// accessTier tells which version of premium recipes the caller gets. It
+// comes from the authenticated user, never from the request: editors and
+// plans that include premium recipes see them in full. Routes that use it
+// run optionalAuth.
+func (a *App) accessTier(c *gin.Context) string {
+	user := currentUser(c)
+	if isEditor(user) || (user != nil && a.hasFeature(user, featurePremiumRecipes)) {
+		return tierPremium
+	}
+	return tierFree
+}
+
+// recipeForTier returns recipe as the tier may see it: premium recipes are
+// reduced to a teaser with the name, images and the start of the
+// description for the free tier.
+func recipeForTier(recipe *model.Recipe, tier string) *model.Recipe {
+	if recipe == nil || !recipe.Premium || tier == tierPremium {
+		return recipe
+	}
+
+	return &model.Recipe{
+		ID:          recipe.ID,
+		Name:        recipe.Name,
+		Description: excerpt(recipe.Description, teaserDescriptionLength),
+		Image:       recipe.Image,
+		Images:      recipe.Images,
+		Ingredients: []string{},
+		Restriction: []string{},
+		Premium:     true,
+		Percentage:  recipe.Percentage,
+		Status:      recipe.Status,
+		Locked:      true,
+	}
+}
+
+func recipesForTier(recipes []*model.Recipe, tier string) []*model.Recipe {
+	result := make([]*model.Recipe, 0, len(recipes))
+	for _, recipe := range recipes {
+		result = append(result, recipeForTier(recipe, tier))
+	}
+	return result
+}
+
+// excerpt cuts text at the last word that fits in limit characters.
+func excerpt(text string, limit int) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= limit {
+		return string(runes)
+	}
+
+	cut := string(runes[:limit])
+	if i := strings.LastIndexAny(cut, " \n\t"); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// recipeListKey is the cache key of a recipe list for a tier. Every list
+// key starts with "recipes:" so invalidateRecipeListsCache drops them all.
+func recipeListKey(tier string, parts ...string) string {
+	return strings.Join(append([]string{"recipes", tier}, parts...), ":")
+}
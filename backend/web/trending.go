@@ -0,0 +1,423 @@
+package web
+
+import (
+	"cucinia/db"
+	"cucinia/model"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	eventView = "view"
+	eventLike = "like"
+	eventCook = "cook"
+
+	statsFlushInterval   = 5 * time.Minute
+	rankingCacheDuration = time.Minute
+)
+
+var eventWeights = map[string]float64{
+	eventView: 1,
+	eventLike: 3,
+	eventCook: 5,
+}
+
+// trendingWindow describes how a trending ranking is assembled from time
+// buckets: how many buckets to merge, how long each one is and how fast
+// older buckets lose weight.
+type trendingWindow struct {
+	bucket   string
+	size     time.Duration
+	count    int
+	halfLife float64
+	layout   string
+}
+
+var trendingWindows = map[string]trendingWindow{
+	"day":  {bucket: "h", size: time.Hour, count: 24, halfLife: 6, layout: "2006010215"},
+	"week": {bucket: "d", size: 24 * time.Hour, count: 7, halfLife: 2, layout: "20060102"},
+}
+
+type recipeEvent struct {
+	recipeID string
+	kind     string
+	at       time.Time
+	delta    float64
+	// subject, when set, makes the event count only once per subject,
+	// recipe and hour.
+	subject string
+}
+
+type rankedRecipe struct {
+	*model.Recipe
+	Score float64 `json:"score"`
+}
+
+// recipeStats counts recipe events in Redis. Handlers only push onto a
+// buffered channel, so tracking never adds a round trip to the request.
+type recipeStats struct {
+	d      db.DB
+	rdb    *redis.Client
+	events chan recipeEvent
+}
+
+func newRecipeStats(d db.DB, rdb *redis.Client) *recipeStats {
+	return &recipeStats{
+		d:      d,
+		rdb:    rdb,
+		events: make(chan recipeEvent, 1024),
+	}
+}
+
+func (s *recipeStats) start() {
+	s.restore()
+	go s.consume()
+	go s.flushLoop()
+}
+
+func (s *recipeStats) track(recipeID, kind string) {
+	s.push(recipeEvent{recipeID: recipeID, kind: kind, at: time.Now().UTC(), delta: 1})
+}
+
+// trackOnce counts the event at most once per subject, recipe and hour.
+func (s *recipeStats) trackOnce(recipeID, kind, subject string) {
+	s.push(recipeEvent{recipeID: recipeID, kind: kind, at: time.Now().UTC(), delta: 1, subject: subject})
+}
+
+// untrack takes back an event that happened at the given time, e.g. a like
+// that was undone, from the totals and from its bucket if still counted.
+func (s *recipeStats) untrack(recipeID, kind string, at time.Time) {
+	s.push(recipeEvent{recipeID: recipeID, kind: kind, at: at.UTC(), delta: -1})
+}
+
+func (s *recipeStats) push(event recipeEvent) {
+	select {
+	case s.events <- event:
+	default:
+		log.Println("fila de eventos cheia, evento descartado:", event.kind, event.recipeID)
+	}
+}
+
+// forget drops the counters of a deleted recipe, in Redis and in Mongo so
+// restore does not bring them back. It also leaves the time buckets that
+// have not expired and the cached rankings, which would otherwise keep
+// taking a place in the top N.
+func (s *recipeStats) forget(recipeID string) {
+	now := time.Now().UTC()
+	pipe := s.rdb.Pipeline()
+	for kind := range eventWeights {
+		pipe.ZRem(totalKey(kind), recipeID)
+		for _, window := range trendingWindows {
+			// Buckets live for count+1 periods, see record.
+			for age := 0; age <= window.count; age++ {
+				pipe.ZRem(bucketKey(kind, window, now.Add(-time.Duration(age)*window.size)), recipeID)
+			}
+		}
+	}
+	for _, window := range trendingWindows {
+		pipe.ZRem(trendingKey(window), recipeID)
+	}
+	pipe.ZRem(popularKey, recipeID)
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("erro removendo contadores da receita:", err)
+	}
+	if err := s.d.DeleteRecipeStats(recipeID); err != nil {
+		log.Println("erro removendo estatísticas da receita:", err)
+	}
+}
+
+func (s *recipeStats) consume() {
+	for event := range s.events {
+		batch := []recipeEvent{event}
+	drain:
+		for len(batch) < 100 {
+			select {
+			case next := <-s.events:
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		s.record(batch)
+	}
+}
+
+// countOnceScript marks KEYS[1] as seen for ARGV[1] ms and, only if it
+// was not seen yet, adds ARGV[3] to member ARGV[2] of the total KEYS[2]
+// and of the buckets KEYS[3..], which expire after ARGV[4..] ms.
+var countOnceScript = redis.NewScript(`
+if not redis.call("SET", KEYS[1], 1, "NX", "PX", ARGV[1]) then
+	return 0
+end
+redis.call("ZINCRBY", KEYS[2], ARGV[3], ARGV[2])
+for i = 3, #KEYS do
+	redis.call("ZINCRBY", KEYS[i], ARGV[3], ARGV[2])
+	redis.call("PEXPIRE", KEYS[i], ARGV[i + 1])
+end
+return 1
+`)
+
+func (s *recipeStats) record(batch []recipeEvent) {
+	pipe := s.rdb.Pipeline()
+	for _, event := range batch {
+		var buckets []string
+		var ttls []time.Duration
+		for _, window := range trendingWindows {
+			if time.Since(event.at) >= window.size*time.Duration(window.count) {
+				continue
+			}
+			buckets = append(buckets, bucketKey(event.kind, window, event.at))
+			ttls = append(ttls, window.size*time.Duration(window.count+1))
+		}
+
+		if event.subject == "" {
+			pipe.ZIncrBy(totalKey(event.kind), event.delta, event.recipeID)
+			for i, key := range buckets {
+				pipe.ZIncrBy(key, event.delta, event.recipeID)
+				pipe.PExpire(key, ttls[i])
+			}
+			continue
+		}
+
+		size := trendingWindows["day"].size
+		seenFor := max(time.Until(event.at.Truncate(size).Add(size)), time.Second)
+		keys := append([]string{seenKey(event.kind, event.subject, event.recipeID, event.at), totalKey(event.kind)}, buckets...)
+		args := []interface{}{seenFor.Milliseconds(), event.recipeID, event.delta}
+		for _, ttl := range ttls {
+			args = append(args, ttl.Milliseconds())
+		}
+		countOnceScript.Eval(pipe, keys, args...)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("erro gravando eventos de receitas:", err)
+	}
+}
+
+func (s *recipeStats) flushLoop() {
+	ticker := time.NewTicker(statsFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.flush(); err != nil {
+			log.Println("erro persistindo estatísticas das receitas:", err)
+		}
+	}
+}
+
+func (s *recipeStats) flush() error {
+	byRecipe := map[string]*model.RecipeStats{}
+	now := time.Now().UTC()
+
+	for kind := range eventWeights {
+		members, err := s.rdb.ZRangeWithScores(totalKey(kind), 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			id, _ := member.Member.(string)
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				continue
+			}
+			stats, ok := byRecipe[id]
+			if !ok {
+				stats = &model.RecipeStats{RecipeID: objID, UpdatedAt: now}
+				byRecipe[id] = stats
+			}
+			count := int64(member.Score)
+			switch kind {
+			case eventView:
+				stats.Views = count
+			case eventLike:
+				stats.Likes = count
+			case eventCook:
+				stats.Cooks = count
+			}
+		}
+	}
+
+	stats := make([]*model.RecipeStats, 0, len(byRecipe))
+	for _, s := range byRecipe {
+		stats = append(stats, s)
+	}
+	return s.d.SaveRecipeStats(stats)
+}
+
+// restore seeds the all-time counters from Mongo when Redis was flushed
+// or restarted without persistence.
+func (s *recipeStats) restore() {
+	exists, err := s.rdb.Exists(totalKey(eventView), totalKey(eventLike), totalKey(eventCook)).Result()
+	if err != nil || exists > 0 {
+		return
+	}
+
+	stats, err := s.d.GetRecipeStats()
+	if err != nil {
+		log.Println("erro carregando estatísticas das receitas:", err)
+		return
+	}
+
+	pipe := s.rdb.Pipeline()
+	for _, st := range stats {
+		id := st.RecipeID.Hex()
+		pipe.ZAdd(totalKey(eventView), redis.Z{Score: float64(st.Views), Member: id})
+		pipe.ZAdd(totalKey(eventLike), redis.Z{Score: float64(st.Likes), Member: id})
+		pipe.ZAdd(totalKey(eventCook), redis.Z{Score: float64(st.Cooks), Member: id})
+	}
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("erro restaurando estatísticas das receitas:", err)
+	}
+}
+
+func (s *recipeStats) trending(window trendingWindow, limit int64) ([]redis.Z, error) {
+	now := time.Now().UTC()
+
+	return s.ranking(trendingKey(window), limit, func() ([]string, []float64) {
+		var keys []string
+		var weights []float64
+		for kind, weight := range eventWeights {
+			for age := 0; age < window.count; age++ {
+				at := now.Add(-time.Duration(age) * window.size)
+				keys = append(keys, bucketKey(kind, window, at))
+				weights = append(weights, weight*math.Pow(0.5, float64(age)/window.halfLife))
+			}
+		}
+		return keys, weights
+	})
+}
+
+func (s *recipeStats) popular(limit int64) ([]redis.Z, error) {
+	return s.ranking(popularKey, limit, func() ([]string, []float64) {
+		var keys []string
+		var weights []float64
+		for kind, weight := range eventWeights {
+			keys = append(keys, totalKey(kind))
+			weights = append(weights, weight)
+		}
+		return keys, weights
+	})
+}
+
+func (s *recipeStats) ranking(dest string, limit int64, sources func() ([]string, []float64)) ([]redis.Z, error) {
+	exists, err := s.rdb.Exists(dest).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if exists == 0 {
+		keys, weights := sources()
+		pipe := s.rdb.TxPipeline()
+		pipe.ZUnionStore(dest, redis.ZStore{Weights: weights, Aggregate: "SUM"}, keys...)
+		pipe.Expire(dest, rankingCacheDuration)
+		if _, err := pipe.Exec(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.rdb.ZRevRangeWithScores(dest, 0, limit-1).Result()
+}
+
+// popularKey caches the all-time ranking.
+const popularKey = "ranking:popular"
+
+// trendingKey caches the trending ranking of window.
+func trendingKey(window trendingWindow) string {
+	return "ranking:trending:" + window.bucket
+}
+
+func totalKey(kind string) string {
+	return "stats:" + kind + ":total"
+}
+
+func bucketKey(kind string, window trendingWindow, at time.Time) string {
+	return "stats:" + kind + ":" + window.bucket + ":" + at.UTC().Truncate(window.size).Format(window.layout)
+}
+
+// seenKey marks that subject already counted an event of kind on the
+// recipe in the hour of at.
+func seenKey(kind, subject, recipeID string, at time.Time) string {
+	hour := trendingWindows["day"]
+	return "stats:seen:" + kind + ":" + subject + ":" + recipeID + ":" + at.UTC().Truncate(hour.size).Format(hour.layout)
+}
+
+// trackOnce counts the event at most once per caller, recipe and hour, so
+// repeating a request cannot push a recipe up the rankings. Callers are
+// told apart by user, or by IP when anonymous.
+func (a *App) trackOnce(c *gin.Context, recipeID, kind string) {
+	subject := "ip:" + c.ClientIP()
+	if user := currentUser(c); user != nil {
+		subject = "user:" + user.ID.Hex()
+	}
+	a.stats.trackOnce(recipeID, kind, subject)
+}
+
+func (a *App) GetTrendingRecipes(c *gin.Context) {
+	window, ok := trendingWindows[c.DefaultQuery("window", "day")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Janela inválida, use day ou week."})
+		return
+	}
+
+	ranking, err := a.stats.trending(window, rankingLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, a.hydrateRanking(ranking, a.accessTier(c)))
+}
+
+func (a *App) GetPopularRecipes(c *gin.Context) {
+	ranking, err := a.stats.popular(rankingLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, a.hydrateRanking(ranking, a.accessTier(c)))
+}
+
+func (a *App) CookRecipe(c *gin.Context) {
+	id := c.Param("id")
+
+	recipe, err := a.getRecipeByIDWithCache(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !isPublished(recipe) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receita não encontrada."})
+		return
+	}
+
+	a.trackOnce(c, id, eventCook)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Preparo registrado."})
+}
+
+func (a *App) hydrateRanking(ranking []redis.Z, tier string) []rankedRecipe {
+	recipes := make([]rankedRecipe, 0, len(ranking))
+	for _, z := range ranking {
+		id, _ := z.Member.(string)
+		recipe, err := a.getRecipeByIDWithCache(id)
+		if err != nil || !isPublished(recipe) {
+			continue
+		}
+		recipes = append(recipes, rankedRecipe{Recipe: recipeForTier(recipe, tier), Score: z.Score})
+	}
+	return recipes
+}
+
+func rankingLimit(c *gin.Context) int64 {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 64)
+	if err != nil || limit <= 0 || limit > 100 {
+		return 20
+	}
+	return limit
+}
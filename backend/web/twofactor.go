@@ -0,0 +1,366 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"cucinia/db"
+	"cucinia/model"
+	"cucinia/totp"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer = "Cucinia"
+
+	twoFactorEnrollDuration    = 10 * time.Minute
+	twoFactorChallengeDuration = 5 * time.Minute
+	twoFactorChallengeAttempts = 5
+
+	// Signed-in users get twoFactorFailuresBeforeLockout wrong codes per
+	// twoFactorLockout before the routes that check codes lock them out.
+	twoFactorFailuresBeforeLockout = 5
+	twoFactorLockout               = 15 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+func twoFactorEnrollKey(userID string) string {
+	return "2fa:enroll:" + userID
+}
+
+func twoFactorChallengeKey(challenge string) string {
+	sum := sha256.Sum256([]byte(challenge))
+	return "2fa:challenge:" + hex.EncodeToString(sum[:])
+}
+
+func twoFactorFailuresKey(userID string) string {
+	return "2fa:failures:" + userID
+}
+
+func twoFactorUsedKey(userID string, step int64) string {
+	return "2fa:used:" + userID + ":" + strconv.FormatInt(step, 10)
+}
+
+// newRecoveryCodes returns the codes to show the user once and the hashes
+// to store. The codes carry 50 random bits, so a plain SHA-256 is enough.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := recoveryCodeEncoding.EncodeToString(b)[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkTOTP validates a code and remembers its time step, so a code seen
+// once (e.g. by someone looking over the user's shoulder) is not accepted
+// again.
+func (a *App) checkTOTP(user *model.User, secret, code string) bool {
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+
+	fresh, err := a.rdb.SetNX(twoFactorUsedKey(user.ID.Hex(), step), 1, time.Duration(2*totp.Skew+1)*totp.Period).Result()
+	if err != nil {
+		log.Println("erro registrando código de verificação:", err)
+		return false
+	}
+	return fresh
+}
+
+// twoFactorLocked answers 429 once user got too many codes wrong on the
+// routes of a signed-in session, where a stolen session could otherwise
+// guess codes until one works.
+func (a *App) twoFactorLocked(c *gin.Context, user *model.User) bool {
+	key := twoFactorFailuresKey(user.ID.Hex())
+	failures, err := a.rdb.Get(key).Int()
+	if err != nil || failures < twoFactorFailuresBeforeLockout {
+		return false
+	}
+
+	if ttl, err := a.rdb.PTTL(key).Result(); err == nil && ttl > 0 {
+		setRetryAfter(c, ttl)
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Muitos códigos inválidos, tente novamente mais tarde."})
+	return true
+}
+
+func (a *App) recordTwoFactorFailure(user *model.User) {
+	key := twoFactorFailuresKey(user.ID.Hex())
+	failures, err := a.rdb.Incr(key).Result()
+	if err != nil {
+		log.Println("erro registrando código inválido:", err)
+		return
+	}
+	if failures == 1 {
+		a.rdb.Expire(key, twoFactorLockout)
+	}
+}
+
+func (a *App) clearTwoFactorFailures(user *model.User) {
+	a.rdb.Del(twoFactorFailuresKey(user.ID.Hex()))
+}
+
+func (a *App) createTwoFactorChallenge(user *model.User) (string, error) {
+	challenge, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	key := twoFactorChallengeKey(challenge)
+	pipe := a.rdb.TxPipeline()
+	pipe.HSet(key, "user", user.ID.Hex())
+	pipe.HSet(key, "attempts", 0)
+	pipe.Expire(key, twoFactorChallengeDuration)
+	if _, err := pipe.Exec(); err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+func (a *App) LoginTwoFactor(c *gin.Context) {
+	var payload struct {
+		Challenge    string `json:"challenge"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Challenge == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	key := twoFactorChallengeKey(payload.Challenge)
+	userID, err := a.rdb.HGet(key, "user").Result()
+	if err == redis.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Verificação expirada, faça login novamente."})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := a.d.GetUserByID(userID)
+	if err != nil || !user.TOTPEnabled {
+		a.rdb.Del(key)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Verificação expirada, faça login novamente."})
+		return
+	}
+
+	if wait := a.loginLockedFor(user.Email, c.ClientIP()); wait > 0 {
+		setRetryAfter(c, wait)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Muitas tentativas de login, tente novamente mais tarde."})
+		return
+	}
+
+	valid := false
+	switch {
+	case payload.RecoveryCode != "":
+		err := a.d.UseUserRecoveryCode(userID, hashRecoveryCode(payload.RecoveryCode))
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		valid = err == nil
+	case payload.Code != "":
+		valid = a.checkTOTP(user, user.TOTPSecret, payload.Code)
+	}
+
+	if !valid {
+		if wait := a.recordLoginFailure(user.Email, c.ClientIP()); wait > 0 {
+			setRetryAfter(c, wait)
+		}
+		if attempts, _ := a.rdb.HIncrBy(key, "attempts", 1).Result(); attempts >= twoFactorChallengeAttempts {
+			a.rdb.Del(key)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Código inválido."})
+		return
+	}
+
+	a.rdb.Del(key)
+	a.completeLogin(c, user)
+}
+
+func (a *App) EnrollTwoFactor(c *gin.Context) {
+	user := currentUser(c)
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Verificação em duas etapas já está ativa."})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The secret only reaches the user document once a first code proves
+	// the authenticator app was set up.
+	if err := a.rdb.Set(twoFactorEnrollKey(user.ID.Hex()), secret, twoFactorEnrollDuration).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+func (a *App) ConfirmTwoFactor(c *gin.Context) {
+	user := currentUser(c)
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	secret, err := a.rdb.Get(twoFactorEnrollKey(user.ID.Hex())).Result()
+	if err == redis.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cadastro expirado, comece novamente."})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if a.twoFactorLocked(c, user) {
+		return
+	}
+	if !a.checkTOTP(user, secret, payload.Code) {
+		a.recordTwoFactorFailure(user)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Código inválido."})
+		return
+	}
+	a.clearTwoFactorFailures(user)
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.d.EnableUserTOTP(user.ID.Hex(), secret, hashes); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	a.rdb.Del(twoFactorEnrollKey(user.ID.Hex()))
+	a.invalidateUserCache(user.ID.Hex())
+	a.invalidateUsersCache()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Verificação em duas etapas ativada. Guarde os códigos de recuperação.",
+		"recovery_codes": codes,
+	})
+}
+
+func (a *App) DisableTwoFactor(c *gin.Context) {
+	user := currentUser(c)
+	if isPrivileged(user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "A verificação em duas etapas é obrigatória para editores e administradores."})
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Verificação em duas etapas não está ativa."})
+		return
+	}
+
+	var payload struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	if a.twoFactorLocked(c, user) {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password)) != nil ||
+		!a.checkTOTP(user, user.TOTPSecret, payload.Code) {
+		a.recordTwoFactorFailure(user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Senha ou código inválido."})
+		return
+	}
+	a.clearTwoFactorFailures(user)
+
+	if err := a.d.DisableUserTOTP(user.ID.Hex()); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+	a.invalidateUserCache(user.ID.Hex())
+	a.invalidateUsersCache()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verificação em duas etapas desativada."})
+}
+
+func (a *App) RegenerateRecoveryCodes(c *gin.Context) {
+	user := currentUser(c)
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Verificação em duas etapas não está ativa."})
+		return
+	}
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido."})
+		return
+	}
+
+	if a.twoFactorLocked(c, user) {
+		return
+	}
+	if !a.checkTOTP(user, user.TOTPSecret, payload.Code) {
+		a.recordTwoFactorFailure(user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Código inválido."})
+		return
+	}
+	a.clearTwoFactorFailures(user)
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.d.SetUserRecoveryCodes(user.ID.Hex(), hashes); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
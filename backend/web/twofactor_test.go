@@ -0,0 +1,51 @@
+package web
+
+import (
+	"cucinia/model"
+	"cucinia/totp"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTwoFactorRoutesLockOutWrongCodes(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "auth=100/1m")
+	ta := newTestApp(t)
+	secret, _ := totp.GenerateSecret()
+	user, token := ta.addUser("ana@example.com", model.RoleUser)
+	ta.db.updateUser(user.ID.Hex(), func(u *model.User) { u.TOTPEnabled, u.TOTPSecret = true, secret })
+
+	for i := 0; i < twoFactorFailuresBeforeLockout; i++ {
+		w := ta.do(http.MethodPost, "/api/v1/users/me/2fa/recovery-codes", map[string]string{"code": "000000"}, token)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d = %d, want 401", i+1, w.Code)
+		}
+	}
+
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+	w := ta.do(http.MethodPost, "/api/v1/users/me/2fa/recovery-codes", map[string]string{"code": code}, token)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("right code after %d wrong ones = %d, want 429 with Retry-After", twoFactorFailuresBeforeLockout, w.Code)
+	}
+
+	ta.redis.FastForward(twoFactorLockout)
+	if w := ta.do(http.MethodPost, "/api/v1/users/me/2fa/recovery-codes", map[string]string{"code": code}, token); w.Code != http.StatusOK {
+		t.Fatalf("right code after the lockout = %d %s, want 200", w.Code, w.Body)
+	}
+	if w := ta.do(http.MethodPost, "/api/v1/users/me/2fa/recovery-codes", map[string]string{"code": code}, token); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code = %d, want 401", w.Code)
+	}
+}
+
+func TestTwoFactorRoutesAreRateLimited(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "auth=3/1m")
+	ta := newTestApp(t)
+	_, token := ta.addUser("ana@example.com", model.RoleUser)
+
+	for i := 0; i < 4; i++ {
+		w := ta.do(http.MethodPost, "/api/v1/users/me/2fa/disable", map[string]string{}, token)
+		if i == 3 && w.Code != http.StatusTooManyRequests {
+			t.Errorf("request %d = %d, want 429", i+1, w.Code)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package web
+
+import (
+	"cucinia/model"
+	"time"
+)
+
+// Users never leave the API as model.User: responses and the Redis cache
+// go through one of these views, none of which carries the password hash,
+// the TOTP secret or recovery codes.
+
+// publicUser is what anyone may see about another user.
+type publicUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// selfUser is what users see about themselves.
+type selfUser struct {
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	Email         string           `json:"email"`
+	Ingredients   []string         `json:"ingredients"`
+	Restriction   []string         `json:"restriction"`
+	LikedRecipes  []string         `json:"liked_recipes"`
+	Premium       bool             `json:"premium"`
+	Role          string           `json:"role"`
+	EmailVerified bool             `json:"email_verified"`
+	TOTPEnabled   bool             `json:"totp_enabled"`
+	Identities    []model.Identity `json:"identities"`
+
+	ErasureScheduledFor *time.Time `json:"erasure_scheduled_for,omitempty"`
+	PremiumUntil        *time.Time `json:"premium_until,omitempty"`
+}
+
+// adminUser adds account security details for administrators. It is also
+// the form kept in the user cache, since every other view derives from it.
+type adminUser struct {
+	selfUser
+	HasPassword   bool `json:"has_password"`
+	RecoveryCodes int  `json:"recovery_codes"`
+}
+
+func newAdminUser(user *model.User) adminUser {
+	self := selfUser{
+		ID:            user.ID.Hex(),
+		Name:          user.Name,
+		Email:         user.Email,
+		Ingredients:   user.Ingredients,
+		Restriction:   user.Restriction,
+		LikedRecipes:  user.LikedRecipes,
+		Premium:       user.Premium,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+		TOTPEnabled:   user.TOTPEnabled,
+		Identities:    user.Identities,
+
+		ErasureScheduledFor: user.ErasureScheduledFor,
+		PremiumUntil:        user.PremiumUntil,
+	}
+	if self.Ingredients == nil {
+		self.Ingredients = []string{}
+	}
+	if self.Restriction == nil {
+		self.Restriction = []string{}
+	}
+	if self.LikedRecipes == nil {
+		self.LikedRecipes = []string{}
+	}
+	if self.Identities == nil {
+		self.Identities = []model.Identity{}
+	}
+
+	return adminUser{
+		selfUser:      self,
+		HasPassword:   user.Password != "",
+		RecoveryCodes: len(user.RecoveryCodes),
+	}
+}
+
+func newSelfUser(user *model.User) selfUser {
+	return newAdminUser(user).selfUser
+}
+
+func newAdminUsers(users []*model.User) []adminUser {
+	views := make([]adminUser, 0, len(users))
+	for _, user := range users {
+		views = append(views, newAdminUser(user))
+	}
+	return views
+}
+
+func (u selfUser) public() publicUser {
+	return publicUser{ID: u.ID, Name: u.Name, Role: u.Role}
+}
+
+// viewFor picks what viewer may see of user: everything for admins, the
+// self view for the user themselves and the public view for anyone else.
+func viewFor(viewer *model.User, user adminUser) interface{} {
+	switch {
+	case viewer != nil && viewer.Role == model.RoleAdmin:
+		return user
+	case viewer != nil && viewer.ID.Hex() == user.ID:
+		return user.selfUser
+	default:
+		return user.public()
+	}
+}
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"cucinia/model"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUserEndpointsNeverExposePassword(t *testing.T) {
+	ta := newTestApp(t)
+	w := ta.do(http.MethodPost, "/api/v1/register", map[string]any{
+		"name": "Ana", "email": "ana@example.com", "password": "Senha-forte-123",
+		"role": model.RoleAdmin, "premium": true, "email_verified": true,
+	}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register = %d %s", w.Code, w.Body)
+	}
+	responses := map[string]string{"register": w.Body.String()}
+
+	user, err := ta.db.GetUserByEmail("ana@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role == model.RoleAdmin || user.Premium || user.EmailVerified {
+		t.Errorf("register let the client set role %q, premium %v, verified %v", user.Role, user.Premium, user.EmailVerified)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("Senha-forte-123")) != nil {
+		t.Fatal("password not stored as a bcrypt hash")
+	}
+	ta.db.SetUserEmailVerified(user.ID.Hex())
+
+	w = ta.do(http.MethodPost, "/api/v1/login", map[string]string{"email": "ana@example.com", "password": "Senha-forte-123"}, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("login = %d %s", w.Code, w.Body)
+	}
+	responses["login"] = w.Body.String()
+	token := decode[struct {
+		Token string `json:"token"`
+	}](t, w).Token
+
+	_, adminToken := ta.addUser("admin@example.com", model.RoleAdmin)
+
+	// The "cached" requests are answered from what the first ones stored.
+	for _, request := range []struct{ name, path, token string }{
+		{"me", "/api/v1/users/me", token},
+		{"export", "/api/v1/users/me/export", token},
+		{"public user", "/api/v1/users/" + user.ID.Hex(), ""},
+		{"own user", "/api/v1/users/" + user.ID.Hex(), token},
+		{"admin user", "/api/v1/users/" + user.ID.Hex(), adminToken},
+		{"admin users", "/api/v1/users", adminToken},
+		{"cached users", "/api/v1/users", adminToken},
+		{"cached user", "/api/v1/users/" + user.ID.Hex(), adminToken},
+	} {
+		w := ta.do(http.MethodGet, request.path, nil, request.token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s = %d %s", request.name, w.Code, w.Body)
+		}
+		responses[request.name] = w.Body.String()
+	}
+
+	for name, body := range responses {
+		if strings.Contains(body, `"password"`) || strings.Contains(body, "$2a$") {
+			t.Errorf("%s exposes the password: %s", name, body)
+		}
+	}
+}
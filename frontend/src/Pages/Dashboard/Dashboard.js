@@ -11,6 +11,7 @@ import { ErrorToast, AddedToast, ResponseToast, ImageRequiredToast, showUnsuppor
 import handleRemoveIngredient from '../../Utils/RemoveIngredientAction';
 import handleAddIngredient from '../../Utils/AddIngredientAction';
 import handleAddIngredientByAI from '../../Utils/AddIngredientByAIAction';
+import scanFridge from '../../Utils/ScanFridge';
 import fetchRecipesByIngredients from '../../Utils/FetchRecipesByIngredients';
 
 function Dashboard() {
@@ -26,7 +27,7 @@ function Dashboard() {
   const [searchQuery, setSearchQuery] = useState('');
   const [selectedMealType, setSelectedMealType] = useState("Todos os tipos de receitas");
 
-  const [selectedFile, setSelectedFile] = useState(null);
+  const [selectedFiles, setSelectedFiles] = useState([]);
   const [response, setResponse] = useState(null);
   const [loadingRecipes, setLoadingRecipes] = useState(true);
   const [loading, setLoading] = useState(false);
@@ -77,42 +78,32 @@ function Dashboard() {
   const handleSubmit = async (event) => {
     event.preventDefault();
 
-    if (!selectedFile) {
+    if (selectedFiles.length === 0) {
       setShowImageRequiredToast(true);
       return;
     }
 
-    const allowedExtensions = ["jpg", "jpeg"];
-    const fileExtension = selectedFile.name.split(".").pop().toLowerCase();
-    if (!allowedExtensions.includes(fileExtension)) {
+    const allowedExtensions = ["jpg", "jpeg", "png", "webp", "heic", "heif"];
+    const unsupported = selectedFiles.some((file) => !allowedExtensions.includes(file.name.split(".").pop().toLowerCase()));
+    if (unsupported) {
       setShowUnsupportedFormatToast(true);
       return;
     }
 
     setLoading(true);
-    const formData = new FormData(event.target);
-    formData.append('image', selectedFile); 
+    const formData = new FormData();
+    selectedFiles.forEach((file) => formData.append('image', file));
   
     try {
-      const response = await fetch('/api/v1/gen', {
-        method: 'POST',
-        body: formData
-      });
-      const data = await response.json();
-      const partsArray = data?.Candidates?.[0]?.Content?.Parts || [];
-      setResponse(partsArray);
+      const data = await scanFridge(formData);
+      const found = data?.ingredients || [];
+      setResponse([found.length > 0 ? found.join(',') : 'Não existem alimentos.']);
       setShowResponseToast(true);
-  
-      partsArray.forEach(async (part) => {
-        const ingredients = part
-          .split(',')
-          .map((ingredient) => ingredient.trim().charAt(0).toUpperCase() + ingredient.trim().slice(1));
-      
-        console.log(ingredients);
-        
-        ingredients.forEach((ingredient) => {
-          handleAddIngredientByAIWrapper(user, allowedIngredients, setShowAddedToast, setShowErrorToast, setNewIngredient, setUser, ingredient);
-        });
+
+      const ingredients = found.map((ingredient) => ingredient.charAt(0).toUpperCase() + ingredient.slice(1));
+
+      ingredients.forEach((ingredient) => {
+        handleAddIngredientByAIWrapper(user, allowedIngredients, setShowAddedToast, setShowErrorToast, setNewIngredient, setUser, ingredient);
       });
     } catch (error) {
       console.error('Error:', error);
@@ -214,10 +205,10 @@ function Dashboard() {
       const response = await fetch('/api/v1/unlike-recipe', {
         method: 'POST',
         headers: {
-          'Content-Type': 'application/json'
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${localStorage.getItem('token')}`,
         },
         body: JSON.stringify({
-          email: user.email,
           recipe_id: recipeId
         })
       });
@@ -249,10 +240,10 @@ function Dashboard() {
       const response = await fetch('/api/v1/like-recipe', {
         method: 'POST',
         headers: {
-          'Content-Type': 'application/json'
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${localStorage.getItem('token')}`,
         },
         body: JSON.stringify({
-          email: user.email,
           recipe_id: recipeId
         })
       });
@@ -404,10 +395,10 @@ function Dashboard() {
           <form id="uploadForm" className="w-full" onSubmit={handleSubmit}>
             <label htmlFor="dropzone-file" className="flex flex-col items-center justify-center w-full h-32 border-2 border-gray-300 border-dashed rounded-lg cursor-pointer bg-base-200 dark:hover:bg-base-100 dark:bg-base-200 hover:bg-base-100 dark:bg-base-200">
               <div className="flex flex-col items-center justify-center pt-5 pb-6">
-                {selectedFile ? (
+                {selectedFiles.length > 0 ? (
                   <div className="flex flex-col items-center justify-center">
-                    <p className="mt-1 mb-2 text-sm text-gray-500 text-center dark:text-gray-400 font-semibold">Selected file:</p>
-                    <p className="mb-2 text-sm text-gray-500 text-center dark:text-gray-400">{selectedFile.name}</p>
+                    <p className="mt-1 mb-2 text-sm text-gray-500 text-center dark:text-gray-400 font-semibold">Selected files:</p>
+                    <p className="mb-2 text-sm text-gray-500 text-center dark:text-gray-400">{selectedFiles.map((file) => file.name).join(', ')}</p>
                   </div>
                 ) : (
                   <div className="flex flex-col items-center justify-center">
@@ -415,7 +406,7 @@ function Dashboard() {
                       <path stroke="currentColor" strokeLinecap="round" strokeLinejoin="round" strokeWidth="2" d="M13 13h3a3 3 0 0 0 0-6h-.025A5.56 5.56 0 0 0 16 6.5 5.5 5.5 0 0 0 5.207 5.021C5.137 5.017 5.071 5 5 5a4 4 0 0 0 0 8h2.167M10 15V6m0 0L8 8m2-2 2 2"/>
                     </svg>
                     <p className="mb-2 text-sm text-gray-500 dark:text-gray-400"><span className="font-semibold">Click to upload</span> or drag and drop</p>
-                    <p className="text-xs text-gray-500 dark:text-gray-400">SVG, PNG, JPG or GIF (MAX. 800x400px)</p>
+                    <p className="text-xs text-gray-500 dark:text-gray-400">JPG, PNG, WEBP or HEIC (up to 3 photos, 10 MB each)</p>
                   </div>
                 )}
               </div>
@@ -424,7 +415,9 @@ function Dashboard() {
                 type="file" 
                 name="image" 
                 className="hidden" 
-                onChange={(e) => setSelectedFile(e.target.files[0])}
+                accept="image/jpeg,image/png,image/webp,image/heic,.heic,.heif"
+                multiple
+                onChange={(e) => setSelectedFiles(Array.from(e.target.files).slice(0, 3))}
               />
             </label>
             <div className="flex flex-col items-center justify-center">
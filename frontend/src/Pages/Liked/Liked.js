@@ -106,10 +106,10 @@ function Liked() {
       const response = await fetch('/api/v1/unlike-recipe', {
         method: 'POST',
         headers: {
-          'Content-Type': 'application/json'
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${localStorage.getItem('token')}`,
         },
         body: JSON.stringify({
-          email: user.email,
           recipe_id: recipeId
         })
       });
@@ -141,10 +141,10 @@ function Liked() {
       const response = await fetch('/api/v1/like-recipe', {
         method: 'POST',
         headers: {
-          'Content-Type': 'application/json'
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${localStorage.getItem('token')}`,
         },
         body: JSON.stringify({
-          email: user.email,
           recipe_id: recipeId
         })
       });
@@ -1,5 +1,5 @@
 import React, { useState, useEffect } from 'react';
-import { useNavigate } from 'react-router-dom';
+import { useNavigate, useSearchParams } from 'react-router-dom';
 import styled from 'styled-components';
 import loginPhoto from './photo.jpeg';
 import backgroundImg from './5.jpg';
@@ -32,6 +32,8 @@ function Register() {
   });
   const [registrationSuccess, setRegistrationSuccess] = useState(false);
   const navigate = useNavigate();
+  const [searchParams] = useSearchParams();
+  const referralCode = searchParams.get('ref') || '';
 
   useEffect(() => {
     const token = localStorage.getItem('token');
@@ -55,7 +57,7 @@ function Register() {
       headers: {
         'Content-Type': 'application/json'
       },
-      body: JSON.stringify(formData)
+      body: JSON.stringify({ ...formData, referral_code: referralCode })
     })
     .then(response => {
       if (response.ok) {
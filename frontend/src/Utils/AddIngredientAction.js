@@ -16,9 +16,9 @@ const handleAddIngredient = async (user, newIngredient, allowedIngredients, setS
               method: 'POST',
               headers: {
                 'Content-Type': 'application/json',
+                Authorization: `Bearer ${localStorage.getItem('token')}`,
               },
               body: JSON.stringify({
-                email: user.email,
                 ingredient: newIngredient,
               }),
             });
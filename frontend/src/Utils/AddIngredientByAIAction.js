@@ -18,9 +18,9 @@ const handleAddIngredientByAI = async (user, allowedIngredients, setShowAddedToa
                         method: 'POST',
                         headers: {
                             'Content-Type': 'application/json',
+                            Authorization: `Bearer ${localStorage.getItem('token')}`,
                         },
                         body: JSON.stringify({
-                            email: user.email,
                             ingredient: ingredient,
                         }),
                     });
@@ -2,7 +2,9 @@ const fetchLikedRecipes = async (user, setLoadingRecipes, setRecipes) => {
     setLoadingRecipes(true);
 
     try {
-        const response = await fetch(`/api/v1/users/liked-recipes?email=${user.email}`);
+        const response = await fetch('/api/v1/users/liked-recipes', {
+            headers: { Authorization: `Bearer ${localStorage.getItem('token')}` },
+        });
         const data = await response.json();
         
         console.log(data);
@@ -1,8 +1,7 @@
 const fetchRecipesByIngredients = async (user, setLoadingRecipes, setRecipes) => {
     setLoadingRecipes(true);
     const userIngredients = user ? user.ingredients.join(',') : '';
-    const localStorageKey = 'storedRecipes';
-    const userPremium = user ? user.premium : false;
+    const localStorageKey = user && user.premium ? 'storedPremiumRecipes' : 'storedRecipes';
 
     let storedRecipes = JSON.parse(localStorage.getItem(localStorageKey)) || {};
 
@@ -26,7 +25,11 @@ const fetchRecipesByIngredients = async (user, setLoadingRecipes, setRecipes) =>
     localStorage.setItem('storedRequests', JSON.stringify(storedRequests));
 
     try {
-        const response = await fetch(`/api/v1/recipes/by-multiple-criteria?ingredient=${userIngredients}&premium=${userPremium}`);
+        const response = await fetch(`/api/v1/recipes/by-multiple-criteria?ingredient=${userIngredients}`, {
+            headers: {
+                Authorization: `Bearer ${localStorage.getItem('token')}`,
+            },
+        });
         const data = await response.json();
         
         if (Array.isArray(data)) {
@@ -4,9 +4,9 @@ const handleRemoveIngredient = async (user, ingredientToRemove, setUser) => {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
+          Authorization: `Bearer ${localStorage.getItem('token')}`,
         },
         body: JSON.stringify({
-          email: user.email,
           ingredient: ingredientToRemove,
         }),
       });
@@ -0,0 +1,33 @@
+const finishedStatuses = ['done', 'failed', 'canceled'];
+
+// scanFridge queues the photo and waits for the job to finish. It resolves
+// to the scan result, `{ ingredients: [...] }`. Photos already scanned come
+// back finished right away.
+const scanFridge = async (formData) => {
+    const headers = { Authorization: `Bearer ${localStorage.getItem('token')}` };
+
+    const response = await fetch('/api/v1/gen', {
+        method: 'POST',
+        headers,
+        body: formData
+    });
+    let job = await response.json();
+    if (!response.ok) {
+        throw new Error(job.error);
+    }
+
+    while (!finishedStatuses.includes(job.status)) {
+        const poll = await fetch(`/api/v1/gen/${job.id}?wait=20`, { headers });
+        job = await poll.json();
+        if (!poll.ok) {
+            throw new Error(job.error);
+        }
+    }
+
+    if (job.status !== 'done') {
+        throw new Error(job.error || 'A análise da imagem não foi concluída.');
+    }
+    return job.result;
+};
+
+export default scanFridge;